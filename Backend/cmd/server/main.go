@@ -13,6 +13,8 @@ import (
 	"filevault-backend/internal/config"
 	"filevault-backend/internal/database"
 	"filevault-backend/internal/handlers"
+	"filevault-backend/internal/jobs"
+	"filevault-backend/internal/metrics"
 	"filevault-backend/internal/middleware"
 	"filevault-backend/internal/services"
 	"filevault-backend/internal/storage"
@@ -67,6 +69,45 @@ func healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, healthStatus)
 }
 
+// readinessCheck godoc
+// @Summary Readiness check endpoint
+// @Description Reports whether the service can reach its database and, when TLS is enabled, when the served certificate expires
+// @Tags health
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{} "Service ready"
+// @Failure 503 {object} map[string]interface{} "Service not ready"
+// @Router /health/ready [get]
+func readinessCheck(db *database.Database, cfg *config.Config, expiry *certExpiry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := http.StatusOK
+		readyStatus := gin.H{
+			"status":    "ready",
+			"timestamp": time.Now().UTC(),
+			"database":  "connected",
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		if err := db.Ping(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			readyStatus["status"] = "not_ready"
+			readyStatus["database"] = "unreachable"
+		}
+
+		if cfg.TLSEnabled {
+			tlsStatus := gin.H{"enabled": true}
+			if notAfter := expiry.NotAfter(); !notAfter.IsZero() {
+				tlsStatus["certificate_expires_at"] = notAfter.UTC()
+				tlsStatus["certificate_expires_in_days"] = int(time.Until(notAfter).Hours() / 24)
+			}
+			readyStatus["tls"] = tlsStatus
+		}
+
+		c.JSON(status, readyStatus)
+	}
+}
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -88,6 +129,11 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "promote-admin" {
+		promoteAdmin(services.NewUserService(db.DB, cfg), os.Args[2:])
+		return
+	}
+
 	// Initialize storage
 	minioStorage, err := storage.NewMinIOStorage(cfg)
 	if err != nil {
@@ -97,30 +143,135 @@ func main() {
 	// Initialize rate limiting service
 	rateLimitService := services.NewRateLimitService(cfg)
 	defer rateLimitService.Close()
+	publicRateLimitService := services.NewPublicRateLimitService(cfg)
+	defer publicRateLimitService.Close()
+	enumerationGuard := services.NewEnumerationGuard(cfg)
+
+	// Background jobs; queues and job types are registered before the runner starts.
+	// Deferred first so it waits for running jobs after the context below is cancelled.
+	jobRunner := jobs.NewRunner(db.DB)
+	defer jobRunner.Wait()
+	deletionQueue := services.NewDeletionQueue(db.DB, minioStorage, jobRunner)
+	var thumbnailQueue *services.ThumbnailQueue
+	if cfg.ThumbnailsEnabled {
+		thumbnailQueue = services.NewThumbnailQueue(db.DB, minioStorage, jobRunner)
+	}
+
+	// Background workers stop when the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
 	// Initialize services
 	userService := services.NewUserService(db.DB, cfg)
-	fileService := services.NewFileService(db.DB, minioStorage)
+	adminService := services.NewAdminService(db.DB, cfg, minioStorage)
+	auditService := services.NewAuditService(db.DB)
+	announcementService := services.NewAnnouncementService(db.DB)
+	hotlinkService := services.NewHotlinkService(cfg)
+	adminNotifier := services.NewAdminNotifier(cfg.AdminWebhookURL)
+	fileService := services.NewFileService(db.DB, cfg, minioStorage, deletionQueue, thumbnailQueue, userService, services.NewGeoIPResolver(cfg.GeoIPLookupURL), db.FuzzySearchAvailable)
+	storageRecalculator := services.NewStorageRecalculator(db.DB, userService, jobRunner)
+	jobRunner.Start(backgroundCtx)
+
+	userService.StartBandwidthResetWorker(backgroundCtx)
+	userService.StartActivityRetentionWorker(backgroundCtx)
+	userService.StartQuotaOverageWorker(backgroundCtx)
+	if cfg.TrialEnabled {
+		userService.StartTrialExpiryWorker(backgroundCtx, adminNotifier)
+	}
+	rateLimitService.StartOverrideRefreshWorker(backgroundCtx, userService.GetRateLimitOverrides)
+	if err := fileService.RecoverUploadSessions(backgroundCtx); err != nil {
+		log.Printf("Warning: Failed to recover upload sessions: %v", err)
+	}
+	fileService.StartUploadSessionCleanupWorker(backgroundCtx)
+	fileService.StartPublicExpiryWorker(backgroundCtx)
+	fileService.StartStorageTieringWorker(backgroundCtx)
+	fileService.StartIntegrityScrubWorker(backgroundCtx)
+	adminService.StartDailySnapshotWorker(backgroundCtx)
+	if cfg.StorageEventsARN != "" {
+		if err := minioStorage.EnableUploadNotifications(context.Background(), cfg.StorageEventsARN); err != nil {
+			log.Printf("Warning: failed to enable storage event notifications: %v", err)
+		}
+	}
+	if cfg.ShardObjectKeys {
+		fileService.StartObjectKeyMigration(backgroundCtx)
+	}
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(userService)
-	fileHandler := handlers.NewFileHandler(fileService, userService)
-	adminHandler := handlers.NewAdminHandler(userService, fileService)
+	userHandler := handlers.NewUserHandler(userService, auditService)
+	fileHandler := handlers.NewFileHandler(fileService, userService, hotlinkService, enumerationGuard, cfg.PublicCacheMaxAgeSeconds, cfg.InstanceName, cfg.PublicBaseURL)
+	adminHandler := handlers.NewAdminHandler(userService, fileService, adminService, auditService, rateLimitService, storageRecalculator)
+	uploadRequestHandler := handlers.NewUploadRequestHandler(fileService, userService)
+	collectionHandler := handlers.NewCollectionHandler(fileService)
+	folderHandler := handlers.NewFolderHandler(fileService)
+	storageEventsHandler := handlers.NewStorageEventsHandler(fileService, cfg.StorageEventsSecret)
+	abuseReportHandler := handlers.NewAbuseReportHandler(fileService, auditService, adminNotifier)
+	metaHandler := handlers.NewMetaHandler(services.NewMetaService(cfg, db.FuzzySearchAvailable, announcementService))
+	announcementHandler := handlers.NewAnnouncementHandler(announcementService, auditService)
+	jobHandler := handlers.NewJobHandler(jobRunner)
+	webDAVHandler := handlers.NewWebDAVHandler(fileService, userService)
 
 	// Setup router
 	router := gin.New()
+	// Forwarded client IPs are only believed from configured proxies; gin trusts every peer by default
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
 	router.Use(middleware.RequestLogger())
-	router.Use(middleware.CORS())
+	router.Use(middleware.CORS(cfg))
 	router.Use(gin.Recovery())
 
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Health check
+	expiry := &certExpiry{}
 	router.GET("/health", healthCheck)
+	router.GET("/health/ready", readinessCheck(db, cfg, expiry))
+
+	// Metrics (Prometheus text format), for scrapers holding the metrics token. They're
+	// also served on the internal METRICS_ADDR listener below.
+	if cfg.MetricsToken != "" {
+		router.GET("/metrics", middleware.RequireBearerToken(cfg.MetricsToken), gin.WrapH(metrics.Handler()))
+	}
 
 	// Share routes (clean URLs for sharing - at root level)
-	router.GET("/share/:id", fileHandler.ShareFileDownload)
+	router.GET("/share/:id", fileHandler.SharePage, middleware.HotlinkProtection(hotlinkService), fileHandler.ShareFileDownload)
+	router.HEAD("/share/:id", middleware.HotlinkProtection(hotlinkService), fileHandler.ShareFileDownload)
+	router.GET("/dl", middleware.RateLimit(rateLimitService), fileHandler.ServeDownloadLink)
+	router.POST("/share/:id/report", middleware.RateLimit(rateLimitService), abuseReportHandler.ReportSharedFile)
+
+	// WebDAV mount (read-only, signed in with a user's WebDAV token). Rate limited by IP
+	// before authentication so tokens can't be guessed at full speed.
+	if cfg.WebDAVEnabled {
+		for _, method := range handlers.WebDAVMethods {
+			router.Handle(method, handlers.WebDAVPrefix+"/*path", middleware.RateLimit(rateLimitService), middleware.RequireWebDAVAuth(userService, cfg.InstanceName), webDAVHandler.Serve)
+		}
+	}
+
+	// Shared collection routes (clean URLs for sharing, rate limited)
+	sharedCollections := router.Group("/c")
+	sharedCollections.Use(middleware.RateLimit(rateLimitService))
+	{
+		sharedCollections.GET("/:share_id", collectionHandler.GetSharedCollection)
+		sharedCollections.GET("/:share_id/files/:file_id", middleware.HotlinkProtection(hotlinkService), collectionHandler.DownloadCollectionFile)
+	}
+
+	// Shared folder routes (clean URLs for sharing, rate limited)
+	sharedFolders := router.Group("/f")
+	sharedFolders.Use(middleware.RateLimit(rateLimitService))
+	{
+		sharedFolders.GET("/:share_id", folderHandler.GetSharedFolder)
+		sharedFolders.GET("/:share_id/files/:file_id", middleware.HotlinkProtection(hotlinkService), folderHandler.DownloadSharedFolderFile)
+	}
+
+	// Upload request routes (anonymous uploads into a user's vault, rate limited)
+	uploadRequests := router.Group("/request")
+	uploadRequests.Use(middleware.RateLimit(rateLimitService))
+	{
+		uploadRequests.GET("/:id", uploadRequestHandler.GetPublicUploadRequest)
+		uploadRequests.POST("/:id/upload", uploadRequestHandler.UploadToRequest)
+		uploadRequests.POST("/:id/complete", uploadRequestHandler.CompleteRequestUpload)
+	}
 
 	// API routes
 	api := router.Group("/api/v1")
@@ -132,17 +283,29 @@ func main() {
 			})
 		})
 
+		api.GET("/meta", middleware.RateLimit(publicRateLimitService), metaHandler.GetMeta)
+
 		// Public routes (no auth required, but rate limited)
 		public := api.Group("/public")
-		public.Use(middleware.RateLimit(rateLimitService))
+		public.Use(middleware.RateLimit(publicRateLimitService))
 		{
 			public.GET("/files/:id", fileHandler.GetPublicFile)
-			public.GET("/files/:id/download", fileHandler.DownloadPublicFile)
+			public.GET("/files/:id/download", middleware.HotlinkProtection(hotlinkService), fileHandler.DownloadPublicFile)
+			public.GET("/files/:id/raw", middleware.HotlinkProtection(hotlinkService), fileHandler.RawPublicFile)
+			public.HEAD("/files/:id/raw", middleware.HotlinkProtection(hotlinkService), fileHandler.RawPublicFile)
+			public.GET("/download-ticket", fileHandler.GetDownloadTicket)
+			public.POST("/files/:id/report", abuseReportHandler.ReportPublicFile)
+		}
+
+		// Internal routes (called by infrastructure, authenticated by shared secret)
+		if cfg.StorageEventsSecret != "" {
+			api.POST("/internal/storage-events", storageEventsHandler.ReceiveEvents)
 		}
 
 		// Protected routes (auth required)
 		protected := api.Group("/")
-		protected.Use(middleware.RequireAuth(cfg))
+		protected.Use(middleware.RequireAuth(cfg, userService))
+		protected.Use(middleware.AuditImpersonation(auditService))
 		protected.Use(middleware.RateLimit(rateLimitService))
 		{
 			// User routes
@@ -151,26 +314,80 @@ func main() {
 				user.GET("/profile", userHandler.GetProfile)
 				user.GET("/storage", userHandler.GetStorageInfo)
 				user.GET("/storage/statistics", userHandler.GetStorageStatistics)
+				user.GET("/activity", userHandler.GetActivity)
+				user.GET("/preferences", userHandler.GetPreferences)
+				user.PATCH("/preferences", userHandler.UpdatePreferences)
+				user.GET("/announcements", announcementHandler.GetActiveAnnouncements)
+				user.POST("/announcements/:id/dismiss", announcementHandler.DismissAnnouncement)
+				user.POST("/upload-requests", uploadRequestHandler.CreateUploadRequest)
+				user.GET("/upload-requests", uploadRequestHandler.ListUploadRequests)
+				user.DELETE("/upload-requests/:id", uploadRequestHandler.DeleteUploadRequest)
+				if cfg.WebDAVEnabled {
+					user.POST("/webdav", userHandler.EnableWebDAV)
+					user.DELETE("/webdav", userHandler.DisableWebDAV)
+				}
 			}
 
 			// File routes
 			files := protected.Group("/files")
 			{
 				files.POST("/upload-url", fileHandler.GenerateUploadURL)
+				files.POST("/upload-post-url", fileHandler.GenerateUploadPostURL)
 				files.POST("/complete", fileHandler.CompleteUpload)
+				files.GET("/upload-sessions/:id", fileHandler.GetUploadSession)
+				files.POST("/uploads/:session_id/renew", fileHandler.RenewUploadSession)
+				files.POST("/multipart", fileHandler.StartMultipartUpload)
+				files.GET("/multipart/:upload_id/part/:number", fileHandler.GetMultipartPartURL)
+				files.POST("/multipart/:upload_id/part/:number", fileHandler.ReportMultipartPart)
+				files.POST("/multipart/:upload_id/complete", fileHandler.CompleteMultipartUpload)
+				files.POST("/quota-check", fileHandler.CheckUploadQuota)
+				files.POST("/check-hashes", fileHandler.CheckHashes)
 				files.POST("/batch/prepare", fileHandler.BatchPrepareUpload)
 				files.POST("/batch/complete", fileHandler.BatchCompleteUpload)
+				files.POST("/batch/:batch_id/uploads/:upload_id/renew", fileHandler.RenewBatchUpload)
+				files.POST("/batch/:batch_id/abort", fileHandler.AbortBatchUpload)
 				files.GET("", fileHandler.ListFiles)
+				files.GET("/search", fileHandler.SearchFiles)
+				files.GET("/shared-with-me", fileHandler.ListSharedWithMe)
+				files.POST("/share-links/lookup", fileHandler.LookupShareLinks)
+				files.GET("/:id", fileHandler.GetFile)
 				files.GET("/:id/download", fileHandler.DownloadFile)
+				files.GET("/:id/thumbnail", fileHandler.GetThumbnail)
 				files.GET("/:id/share-link", fileHandler.GetShareLink)
+				files.GET("/:id/public-link", fileHandler.GetPublicDownloadLink)
+				files.GET("/:id/public-stats", fileHandler.GetPublicStats)
+				files.POST("/:id/verify", fileHandler.VerifyFile)
 				files.DELETE("/:id", fileHandler.DeleteFile)
 				files.PATCH("/:id/public", fileHandler.TogglePublic)
+				files.PATCH("/:id/lock", fileHandler.SetFileLock)
+				files.PATCH("/:id/metadata", fileHandler.UpdateFileMetadata)
+				files.POST("/:id/access", fileHandler.GrantFileAccess)
+				files.DELETE("/:id/access/:user_id", fileHandler.RevokeFileAccess)
+			}
+
+			// Collection routes
+			collections := protected.Group("/collections")
+			{
+				collections.POST("", collectionHandler.CreateCollection)
+				collections.GET("", collectionHandler.ListCollections)
+				collections.POST("/:id/files", collectionHandler.AddToCollection)
+				collections.DELETE("/:id/files/:file_id", collectionHandler.RemoveFromCollection)
+				collections.PUT("/:id/order", collectionHandler.ReorderCollection)
+				collections.POST("/:id/share", collectionHandler.ShareCollection)
+			}
+
+			// Folder routes (folders are synced relative paths; see FolderHandler for IDs)
+			folders := protected.Group("/folders")
+			{
+				folders.GET("/:id/download", folderHandler.DownloadFolder)
+				folders.POST("/:id/share-link", folderHandler.ShareFolder)
+				folders.DELETE("/:id/share-link", folderHandler.UnshareFolder)
 			}
 		}
 
 		// Admin routes (admin auth required)
 		admin := api.Group("/admin")
-		admin.Use(middleware.RequireAuth(cfg))
+		admin.Use(middleware.RequireAuth(cfg, userService))
 		admin.Use(middleware.RequireAdmin())
 		admin.Use(middleware.RateLimit(rateLimitService))
 		{
@@ -178,7 +395,33 @@ func main() {
 			admin.DELETE("/users/:id", adminHandler.DeleteUser)
 			admin.PATCH("/users/:id/role", adminHandler.UpdateUserRole)
 			admin.PATCH("/users/:id/quota", adminHandler.UpdateUserQuota)
+			admin.PATCH("/users/:id/file-count-quota", adminHandler.UpdateUserFileCountQuota)
+			admin.PATCH("/users/:id/bandwidth", adminHandler.UpdateUserBandwidth)
+			admin.PATCH("/users/:id/rate-limit", adminHandler.UpdateUserRateLimit)
+			admin.POST("/users/:id/recalculate-storage", adminHandler.RecalculateUserStorage)
+			admin.GET("/users/:id/storage-breakdown", adminHandler.GetUserStorageBreakdown)
+			admin.POST("/users/:id/impersonate", adminHandler.ImpersonateUser)
 			admin.GET("/stats", adminHandler.GetStats)
+			admin.GET("/stats/storage-trend", adminHandler.GetStorageTrendReport)
+			admin.GET("/stats/top", adminHandler.GetTopFiles)
+			admin.GET("/snapshot", adminHandler.GetSystemSnapshot)
+			admin.GET("/jobs", jobHandler.GetJobStats)
+			admin.GET("/rate-limit/stats", adminHandler.GetRateLimitStats)
+			admin.GET("/storage/orphans", adminHandler.GetStorageOrphans)
+			admin.POST("/maintenance/verify", adminHandler.VerifyIntegrity)
+			admin.GET("/maintenance/integrity-issues", adminHandler.ListIntegrityIssues)
+			admin.POST("/maintenance/recalculate-all-storage", adminHandler.RecalculateAllStorage)
+			admin.GET("/maintenance/recalculate-all-storage/:id", adminHandler.GetStorageRecalculation)
+			admin.GET("/files/legal-holds", adminHandler.ListLegalHolds)
+			admin.PATCH("/files/:id/legal-hold", adminHandler.SetLegalHold)
+			admin.POST("/banned-hashes", adminHandler.BanHash)
+			admin.DELETE("/banned-hashes/:hash", adminHandler.UnbanHash)
+			admin.GET("/reports", abuseReportHandler.ListReports)
+			admin.POST("/reports/:id/resolve", abuseReportHandler.ResolveReport)
+			admin.GET("/announcements", announcementHandler.ListAnnouncements)
+			admin.POST("/announcements", announcementHandler.CreateAnnouncement)
+			admin.PATCH("/announcements/:id", announcementHandler.UpdateAnnouncement)
+			admin.DELETE("/announcements/:id", announcementHandler.DeleteAnnouncement)
 		}
 	}
 
@@ -187,10 +430,79 @@ func main() {
 		Handler: router,
 	}
 
+	// Internal listener serving only metrics, without a token
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:    cfg.MetricsAddr,
+			Handler: metricsMux,
+		}
+
+		go func() {
+			log.Printf("Metrics listener starting on %s", cfg.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics listener: %v", err)
+			}
+		}()
+	} else if cfg.MetricsToken == "" {
+		log.Println("Metrics are not served: set METRICS_TOKEN or METRICS_ADDR to enable /metrics")
+	}
+
+	// Plain HTTP listener used for health checks, HTTPS redirects and ACME challenges when TLS is enabled
+	var httpServer *http.Server
+
+	if cfg.TLSEnabled {
+		wrapHTTP, err := configureTLS(cfg, server, expiry)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+
+		httpServer = &http.Server{
+			Addr:    fmt.Sprintf(":%s", cfg.HTTPPort),
+			Handler: wrapHTTP(httpFallbackHandler(cfg, router)),
+		}
+
+		go func() {
+			log.Printf("HTTP listener starting on :%s (health checks and HTTPS redirect)", cfg.HTTPPort)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start HTTP listener: %v", err)
+			}
+		}()
+
+		go func() {
+			log.Printf("🚀 Server starting on https://localhost:%s", cfg.ServerPort)
+			// Autocert supplies certificates through TLSConfig, so no files are passed in that mode
+			certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+			if cfg.TLSAutoCertDomain != "" {
+				certFile, keyFile = "", ""
+			}
+			if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+		}()
+	} else {
+		go func() {
+			log.Printf("🚀 Server starting on http://localhost:%s", cfg.ServerPort)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+		}()
+	}
+
+	// SIGHUP re-reads the environment (and .env) and applies new rate limits without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		log.Printf("🚀 Server starting on http://localhost:%s", cfg.ServerPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+		for range reload {
+			newCfg, err := config.Load()
+			if err != nil {
+				log.Printf("Failed to reload configuration: %v", err)
+				continue
+			}
+			rateLimitService.Reconfigure(newCfg)
+			publicRateLimitService.Reconfigure(newCfg)
 		}
 	}()
 
@@ -203,6 +515,17 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("HTTP listener forced to shutdown: %v", err)
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Printf("Metrics listener forced to shutdown: %v", err)
+		}
+	}
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"filevault-backend/internal/services"
+)
+
+// promoteAdmin implements `promote-admin <user-id>`, which makes the first super admin
+// of an instance. On an instance that has admins but no super admin it only promotes
+// one of them; it refuses once a super admin exists. Promote others through the admin API.
+func promoteAdmin(userService *services.UserService, args []string) {
+	if len(args) != 1 || args[0] == "" {
+		log.Fatalf("Usage: promote-admin <user-id>")
+	}
+
+	if err := userService.BootstrapAdmin(args[0]); err != nil {
+		if errors.Is(err, services.ErrAdminAlreadyExists) {
+			log.Fatalf("Refusing to promote %s: a super admin already exists, or %s isn't one of the existing admins. Use PATCH /api/v1/admin/users/:id/role instead.", args[0], args[0])
+		}
+		log.Fatalf("Failed to promote %s: %v", args[0], err)
+	}
+
+	log.Printf("Promoted %s to super admin", args[0])
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"filevault-backend/internal/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certExpiry tracks the expiry of the certificate currently being served
+// so the readiness endpoint can report it
+type certExpiry struct {
+	mu       sync.RWMutex
+	notAfter time.Time
+}
+
+func (e *certExpiry) record(cert *tls.Certificate) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return
+		}
+		leaf = parsed
+	}
+
+	e.mu.Lock()
+	e.notAfter = leaf.NotAfter
+	e.mu.Unlock()
+}
+
+// NotAfter returns the expiry of the last served certificate, or the zero time if none is known yet
+func (e *certExpiry) NotAfter() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.notAfter
+}
+
+// configureTLS prepares the HTTPS server. With TLSAutoCertDomain set, certificates are
+// provisioned from Let's Encrypt and the returned wrapper answers ACME HTTP-01 challenges
+// on the plain HTTP listener; otherwise the static cert/key pair is loaded once to learn its expiry.
+func configureTLS(cfg *config.Config, server *http.Server, expiry *certExpiry) (func(http.Handler) http.Handler, error) {
+	if cfg.TLSAutoCertDomain != "" {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutoCertDomain),
+			Cache:      autocert.DirCache(cfg.TLSAutoCertDir),
+		}
+
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := manager.GetCertificate(hello)
+			if err == nil {
+				expiry.record(cert)
+			}
+			return cert, err
+		}
+		server.TLSConfig = tlsConfig
+
+		return manager.HTTPHandler, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	expiry.record(&cert)
+
+	return func(h http.Handler) http.Handler { return h }, nil
+}
+
+// httpFallbackHandler serves health checks over plain HTTP and, when enabled,
+// redirects every other request to the HTTPS listener
+func httpFallbackHandler(cfg *config.Config, router http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/health", router)
+	mux.Handle("/health/ready", router)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.TLSRedirectHTTP {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if cfg.ServerPort != "443" {
+			host = net.JoinHostPort(host, cfg.ServerPort)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	return mux
+}
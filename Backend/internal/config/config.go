@@ -2,10 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/joho/godotenv"
 )
@@ -21,27 +23,187 @@ type Config struct {
 	ServerPort string
 	GinMode    string
 
+	// Deployment identity reported to clients by /api/v1/meta
+	InstanceName  string // Name the frontend shows for this deployment
+	PublicBaseURL string // URL users reach the deployment at (empty if unknown)
+
+	// Browser origins allowed to call the API with credentials. "*" lets any other
+	// origin call it without credentials; with none, browsers can't call it cross-origin.
+	CORSAllowedOrigins []string
+
+	// Proxy IPs or CIDRs whose X-Forwarded-For and X-Real-IP headers are believed. With
+	// none, the client IP is always the connection's peer address.
+	TrustedProxies []string
+
+	// TLS Configuration
+	TLSEnabled        bool   // Serve HTTPS directly instead of relying on a terminating proxy
+	TLSCertFile       string // Path to PEM certificate (ignored when TLSAutoCertDomain is set)
+	TLSKeyFile        string // Path to PEM private key (ignored when TLSAutoCertDomain is set)
+	TLSAutoCertDomain string // Provision certificates from Let's Encrypt for this domain
+	TLSAutoCertDir    string // Directory used to cache Let's Encrypt certificates
+	TLSRedirectHTTP   bool   // Redirect plain HTTP to HTTPS (health checks stay on HTTP)
+	HTTPPort          string // Plain HTTP port used for redirects and ACME challenges
+
+	// /metrics is served on the main listener to requests bearing MetricsToken, and
+	// without a token on MetricsAddr (e.g. 127.0.0.1:9090), an internal listener. With
+	// neither set, metrics aren't served.
+	MetricsToken string
+	MetricsAddr  string
+
 	ClerkSecretKey string
 
+	// Where request roles come from. When true they're read from the users table (cached
+	// for CacheTTLSeconds); when false from the session token's metadata.role claim, which
+	// needs "metadata": "{{user.public_metadata}}" in the Clerk session token template.
+	FetchRoleFromDB bool
+
+	// Clerk user IDs made admins when they sign in, so a fresh instance can get its
+	// first admin. Only regular users are promoted; remove IDs once set up.
+	InitialAdminUserIDs []string
+
+	// Admin Impersonation Configuration
+	ImpersonationEnabled bool   // Allow admins to mint short-lived tokens acting as another user
+	ImpersonationSecret  string // HMAC secret used to sign impersonation tokens
+
 	// MinIO Configuration
 	MinIOEndpoint  string
 	MinIOAccessKey string
 	MinIOSecretKey string
 	MinIOBucket    string
 	MinIOUseSSL    bool
+	MinIORegion    string // Region presigned URLs are signed for; AWS S3 rejects mismatches
+	MinIOPathStyle bool   // Address buckets as endpoint/bucket instead of bucket.endpoint
+
+	// Public files are served from CDNBaseURL instead of MinIO when set. The CDN must
+	// front the content bucket and forward query strings, which carry the presigned
+	// signature and expiry.
+	CDNBaseURL string
+
+	// Store content under files/{hash[0:2]}/{hash} instead of the bucket root;
+	// existing objects are migrated in the background when enabled
+	ShardObjectKeys bool
+
+	// Uploads land under StagingPrefix and are copied to their content-addressed key on
+	// completion. StagingBucket keeps them out of the content bucket when set.
+	StagingPrefix string
+	StagingBucket string
+
+	// Storage event notifications (optional): MinIO posts object-created events for the
+	// staging area to our webhook, authenticated with StorageEventsSecret
+	StorageEventsARN    string // ARN of the webhook target configured on the MinIO server
+	StorageEventsSecret string // Shared secret MinIO sends as its auth token
+
+	// Storage tiering (optional): content nobody has downloaded for TieringColdAfterDays
+	// is rewritten under TieringStorageClass by a nightly job. The class must be readable
+	// without a restore step, e.g. a MinIO tier or S3 STANDARD_IA.
+	TieringEnabled       bool
+	TieringColdAfterDays int
+	TieringStorageClass  string
+
+	// Integrity scrubbing (optional): stored content is re-read and re-hashed in the
+	// background, at most IntegrityScrubMBPerSecond, to catch bit rot and partial writes
+	IntegrityScrubEnabled     bool
+	IntegrityScrubMBPerSecond int64 // Also caps admin-triggered and user verification (0 = unthrottled)
+	VerifyRateLimitPerHour    int   // Files a user may verify on demand per hour (0 disables the limit)
+
+	// HMAC secret for the completion tokens issued with upload URLs. A random key is used
+	// when empty, which only works with a single instance.
+	UploadTokenSecret string
+
+	// HMAC secret for the client IP hashes kept with download events and abuse reports.
+	// A random key is used when empty, so hashes don't match across restarts or instances.
+	IPHashSecret string
+
+	// Upload URLs can be renewed while an upload is in progress, up to this long after
+	// it was prepared. Staged objects are removed after a day, so at most 86400.
+	UploadSessionMaxLifetimeSeconds int
+
+	// Verify uploads server-side with SHA-256 and a second hash (BLAKE2b-256) and require
+	// the second hash before linking a new upload to stored content
+	CollisionDetectionEnabled bool
 
 	// Storage Configuration
 	DefaultStorageQuotaMB int64 // Default storage quota in MB
 	MaxStorageQuotaMB     int64 // Maximum storage quota in MB (for admins)
+	QuotaGracePercent     int   // Uploads may exceed the quota by this percentage during the grace period (0 disables)
+	QuotaGraceDays        int   // How long a user may stay over quota before uploads are blocked
+	TrialEnabled          bool  // Give new users a larger quota for their first 30 days
+	TrialStorageQuotaMB   int64 // Storage quota in MB during the trial
+	DailySnapshotEnabled  bool  // Record storage totals every day at midnight UTC for the storage trend report
+	StorageCapacityMB     int64 // Total storage available to the deployment, for capacity projections (0 = unknown)
+	DefaultFileCountQuota int   // Most files a new user may have
+
+	// How a user's files that share content count toward their storage usage:
+	// QuotaModeDeduplicated or QuotaModeLogical
+	QuotaMode string
+
+	// Bandwidth Configuration
+	DefaultBandwidthQuotaMB int64 // Default monthly download bandwidth in MB (0 = unlimited)
+	ProxyBandwidthCapMB     int64 // Monthly cap in MB on bytes streamed through the server per file owner (0 = no cap)
+
+	// Largest synced folder, in MB, that can be downloaded as one zip archive
+	FolderDownloadMaxMB int64
+
+	// Serve a read-only WebDAV mount of the files of users who opt in, at /dav/
+	WebDAVEnabled bool
+
+	// Generate preview thumbnails for uploaded images in the background
+	ThumbnailsEnabled bool
+
+	// Search Configuration
+	EnableFuzzySearch bool // Create a pg_trgm trigram index for typo-tolerant filename search
+
+	// Cache Configuration
+	CacheEnabled    bool // Cache user rows, share link lookups and public file info in memory (disable for debugging)
+	CacheTTLSeconds int  // How long cached entries are served before being reloaded; also how long other replicas can serve a stale entry
+
+	// Analytics Configuration
+	GeoIPLookupURL        string // ip-api compatible lookup URL with a %s placeholder for the IP (empty disables)
+	ActivityRetentionDays int    // Activity feed entries older than this are pruned (0 keeps them forever)
+
+	// Abuse Reporting Configuration
+	AbuseReportThreshold int    // Admins are notified when a file reaches this many open reports
+	AdminWebhookURL      string // Receives JSON alerts for admins, e.g. a chat incoming webhook (empty disables)
+
+	// Hotlink Protection Configuration (public download routes only)
+	HotlinkAllowedHosts        []string // Referrer/origin hosts allowed to link downloads (empty disables the check)
+	HotlinkAllowEmptyReferrer  bool     // Allow downloads that send no Referer or Origin
+	DownloadRateLimitPerMinute int      // Per-IP public download limit, separate from the API limiter (0 disables)
+	DownloadSigningSecret      string   // When set, public downloads require a signed ticket
+	PublicCacheMaxAgeSeconds   int      // Cache-Control max-age for share link downloads, for CDNs in front of /share
+	PrivateURLTTLSeconds       int      // How long presigned URLs for private files stay valid
+	PrivateURLMaxTTLSeconds    int      // Longest TTL a client may request with ?ttl= on private downloads
+	MediaURLTTLSeconds         int      // Default TTL for private audio and video, so players can buffer and seek
+	DownloadLinkSecret         string   // HMAC secret for expiring /dl download links (empty disables them)
 
 	// Rate Limiting Configuration
 	RateLimitEnabled   bool    // Enable/disable rate limiting
 	RateLimitPerSecond float64 // Requests per second
 	RateLimitBurstSize int     // Burst capacity
+
+	// Public Route Protection Configuration
+	PublicRateLimitPerSecond  float64 // Per-IP requests per second for /public routes
+	PublicRateLimitBurstSize  int     // Burst capacity for /public routes
+	PublicNotFoundThreshold   int     // 404s per IP per minute on public file lookups before it's flagged as enumeration
+	PublicInfoRequiresShareID bool    // Public file info is looked up by share ID instead of file UUID
 }
 
+// Quota modes: how a user's files that share content count toward their storage usage
+const (
+	// QuotaModeDeduplicated counts each piece of content a user holds once, however many
+	// of their files point at it
+	QuotaModeDeduplicated = "deduplicated"
+	// QuotaModeLogical counts every file at its full size
+	QuotaModeLogical = "logical"
+)
+
+var (
+	processEnvOnce sync.Once
+	processEnv     map[string]bool
+)
+
 func Load() (*Config, error) {
-	_ = godotenv.Load()
+	loadDotEnv()
 
 	config := &Config{
 		DBHost:         getEnv("DB_HOST", "localhost"),
@@ -52,22 +214,125 @@ func Load() (*Config, error) {
 		DBSSLMode:      getEnv("DB_SSL_MODE", "disable"),
 		ServerPort:     getEnv("PORT", getEnv("SERVER_PORT", "8080")), // Railway uses PORT
 		GinMode:        getEnv("GIN_MODE", "debug"),
+		InstanceName:   getEnv("INSTANCE_NAME", "FileVault"),
+		PublicBaseURL:  getEnv("PUBLIC_BASE_URL", ""),
 		ClerkSecretKey: getEnv("CLERK_SECRET_KEY", ""),
 
+		CORSAllowedOrigins: parseOriginList(getEnv("CORS_ALLOWED_ORIGINS", "")),
+		TrustedProxies:     parseIDList(getEnv("TRUSTED_PROXIES", "")),
+
+		FetchRoleFromDB: getEnv("FETCH_ROLE_FROM_DB", "true") == "true",
+
+		InitialAdminUserIDs: parseIDList(getEnv("INITIAL_ADMIN_USER_IDS", "")),
+
+		// Admin Impersonation Configuration
+		ImpersonationEnabled: getEnv("IMPERSONATION_ENABLED", "false") == "true",
+		ImpersonationSecret:  getEnv("IMPERSONATION_SECRET", ""),
+
+		// TLS Configuration
+		TLSEnabled:        getEnv("TLS_ENABLED", "false") == "true",
+		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+		TLSAutoCertDomain: getEnv("TLS_AUTOCERT_DOMAIN", ""),
+		TLSAutoCertDir:    getEnv("TLS_AUTOCERT_DIR", "certs"),
+		TLSRedirectHTTP:   getEnv("TLS_REDIRECT_HTTP", "true") == "true",
+		HTTPPort:          getEnv("HTTP_PORT", "80"),
+
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+		MetricsAddr:  getEnv("METRICS_ADDR", ""),
+
 		MinIOEndpoint:  getEnv("MINIO_ENDPOINT", "localhost:9000"),
 		MinIOAccessKey: getEnv("MINIO_ACCESS_KEY", "minioadmin"),
 		MinIOSecretKey: getEnv("MINIO_SECRET_KEY", "minioadmin123"),
 		MinIOBucket:    getEnv("MINIO_BUCKET", "files"),
 		MinIOUseSSL:    getEnv("MINIO_USE_SSL", "false") == "true",
+		MinIORegion:    getEnv("MINIO_REGION", "us-east-1"),
+		MinIOPathStyle: getEnv("MINIO_PATH_STYLE", "false") == "true",
+
+		CDNBaseURL: getEnv("CDN_BASE_URL", ""),
+
+		ShardObjectKeys: getEnv("SHARD_OBJECT_KEYS", "false") == "true",
+
+		StagingPrefix: getEnv("STAGING_PREFIX", "staging/"),
+		StagingBucket: getEnv("STAGING_BUCKET", ""),
+
+		TieringEnabled:       getEnv("TIERING_ENABLED", "false") == "true",
+		TieringColdAfterDays: parseInt(getEnv("TIERING_COLD_AFTER_DAYS", "30")),
+		TieringStorageClass:  getEnv("TIERING_STORAGE_CLASS", ""),
+
+		IntegrityScrubEnabled:     getEnv("INTEGRITY_SCRUB_ENABLED", "false") == "true",
+		IntegrityScrubMBPerSecond: parseInt64(getEnv("INTEGRITY_SCRUB_MB_PER_SECOND", "10")),
+		VerifyRateLimitPerHour:    parseInt(getEnv("VERIFY_RATE_LIMIT_PER_HOUR", "10")),
+
+		UploadTokenSecret: getEnv("UPLOAD_TOKEN_SECRET", ""),
+		IPHashSecret:      getEnv("IP_HASH_SECRET", ""),
+
+		UploadSessionMaxLifetimeSeconds: parseInt(getEnv("UPLOAD_SESSION_MAX_LIFETIME_SECONDS", "21600")),
+
+		CollisionDetectionEnabled: getEnv("COLLISION_DETECTION_ENABLED", "true") == "true",
+
+		StorageEventsARN:    getEnv("STORAGE_EVENTS_ARN", ""),
+		StorageEventsSecret: getEnv("STORAGE_EVENTS_SECRET", ""),
 
 		// Storage Configuration
 		DefaultStorageQuotaMB: parseInt64(getEnv("DEFAULT_STORAGE_QUOTA_MB", "100")),
 		MaxStorageQuotaMB:     parseInt64(getEnv("MAX_STORAGE_QUOTA_MB", "10240")), // 10GB max
+		QuotaGracePercent:     parseInt(getEnv("QUOTA_GRACE_PERCENT", "5")),
+		QuotaGraceDays:        parseInt(getEnv("QUOTA_GRACE_DAYS", "7")),
+		QuotaMode:             strings.ToLower(getEnv("QUOTA_MODE", QuotaModeDeduplicated)),
+		TrialEnabled:          getEnv("TRIAL_ENABLED", "false") == "true",
+		TrialStorageQuotaMB:   parseInt64(getEnv("TRIAL_STORAGE_QUOTA_MB", "1024")),
+		DailySnapshotEnabled:  getEnv("DAILY_SNAPSHOT_ENABLED", "true") == "true",
+		StorageCapacityMB:     parseInt64(getEnv("STORAGE_CAPACITY_MB", "0")),
+		DefaultFileCountQuota: parseInt(getEnv("DEFAULT_FILE_COUNT_QUOTA", "1000")),
+
+		// Bandwidth Configuration
+		DefaultBandwidthQuotaMB: parseInt64(getEnv("DEFAULT_BANDWIDTH_QUOTA_MB", "10240")), // 10GB per month
+		ProxyBandwidthCapMB:     parseInt64(getEnv("PROXY_BANDWIDTH_CAP_MB", "0")),
+		FolderDownloadMaxMB:     parseInt64(getEnv("FOLDER_DOWNLOAD_MAX_MB", "2048")),
+
+		// WebDAV Configuration
+		WebDAVEnabled: getEnv("WEBDAV_ENABLED", "false") == "true",
+
+		// Thumbnail Configuration
+		ThumbnailsEnabled: getEnv("THUMBNAILS_ENABLED", "false") == "true",
+
+		// Search Configuration
+		EnableFuzzySearch: getEnv("ENABLE_FUZZY_SEARCH", "true") == "true",
+
+		// Cache Configuration
+		CacheEnabled:    getEnv("CACHE_ENABLED", "true") == "true",
+		CacheTTLSeconds: parseInt(getEnv("CACHE_TTL_SECONDS", "30")),
+
+		// Analytics Configuration
+		GeoIPLookupURL:        getEnv("GEOIP_LOOKUP_URL", ""),
+		ActivityRetentionDays: parseInt(getEnv("ACTIVITY_RETENTION_DAYS", "90")),
+
+		// Abuse Reporting Configuration
+		AbuseReportThreshold: parseInt(getEnv("ABUSE_REPORT_THRESHOLD", "3")),
+		AdminWebhookURL:      getEnv("ADMIN_WEBHOOK_URL", ""),
+
+		// Hotlink Protection Configuration
+		HotlinkAllowedHosts:        parseList(getEnv("HOTLINK_ALLOWED_HOSTS", "")),
+		HotlinkAllowEmptyReferrer:  getEnv("HOTLINK_ALLOW_EMPTY_REFERRER", "true") == "true",
+		DownloadRateLimitPerMinute: parseInt(getEnv("DOWNLOAD_RATE_LIMIT_PER_MINUTE", "30")),
+		DownloadSigningSecret:      getEnv("DOWNLOAD_SIGNING_SECRET", ""),
+		PublicCacheMaxAgeSeconds:   parseInt(getEnv("PUBLIC_CACHE_MAX_AGE_SECONDS", "300")),
+		PrivateURLTTLSeconds:       parseInt(getEnv("PRIVATE_URL_TTL", "60")),
+		PrivateURLMaxTTLSeconds:    parseInt(getEnv("PRIVATE_URL_MAX_TTL_SECONDS", "21600")),
+		MediaURLTTLSeconds:         parseInt(getEnv("MEDIA_URL_TTL_SECONDS", "14400")),
+		DownloadLinkSecret:         getEnv("DOWNLOAD_LINK_SECRET", ""),
 
 		// Rate Limiting Configuration
 		RateLimitEnabled:   getEnv("RATE_LIMIT_ENABLED", "true") == "true",
 		RateLimitPerSecond: parseFloat64(getEnv("RATE_LIMIT_PER_SECOND", "2.0")),
 		RateLimitBurstSize: parseInt(getEnv("RATE_LIMIT_BURST_SIZE", "5")),
+
+		// Public Route Protection Configuration
+		PublicRateLimitPerSecond:  parseFloat64(getEnv("PUBLIC_RATE_LIMIT_PER_SECOND", "0.5")),
+		PublicRateLimitBurstSize:  parseInt(getEnv("PUBLIC_RATE_LIMIT_BURST_SIZE", "10")),
+		PublicNotFoundThreshold:   parseInt(getEnv("PUBLIC_NOT_FOUND_THRESHOLD", "20")),
+		PublicInfoRequiresShareID: getEnv("PUBLIC_INFO_REQUIRES_SHARE_ID", "false") == "true",
 	}
 
 	// Handle Railway DATABASE_URL
@@ -77,6 +342,99 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if config.TLSEnabled && config.TLSAutoCertDomain == "" && (config.TLSCertFile == "" || config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_ENABLED requires TLS_CERT_FILE and TLS_KEY_FILE, or TLS_AUTOCERT_DOMAIN")
+	}
+
+	// An empty prefix would put every object, including final content, under the staging expiry rule
+	if config.StagingPrefix == "" || !strings.HasSuffix(config.StagingPrefix, "/") || config.StagingPrefix == "files/" {
+		return nil, fmt.Errorf("STAGING_PREFIX must be a non-empty prefix ending in \"/\" other than \"files/\"")
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP address or CIDR range", proxy)
+		}
+	}
+
+	if config.CDNBaseURL != "" {
+		if u, err := url.Parse(config.CDNBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("CDN_BASE_URL must be an absolute http(s) URL")
+		}
+	}
+
+	if config.TieringEnabled && (config.TieringStorageClass == "" || config.TieringColdAfterDays <= 0) {
+		return nil, fmt.Errorf("TIERING_ENABLED requires TIERING_STORAGE_CLASS and a positive TIERING_COLD_AFTER_DAYS")
+	}
+
+	if config.IntegrityScrubMBPerSecond < 0 {
+		return nil, fmt.Errorf("INTEGRITY_SCRUB_MB_PER_SECOND must not be negative")
+	}
+
+	// Unparseable numbers read as 0, which would give new users no storage at all
+	if config.DefaultStorageQuotaMB < 1 || config.DefaultStorageQuotaMB > config.MaxStorageQuotaMB {
+		return nil, fmt.Errorf("DEFAULT_STORAGE_QUOTA_MB must be between 1 and MAX_STORAGE_QUOTA_MB")
+	}
+	if config.DefaultFileCountQuota < 1 {
+		return nil, fmt.Errorf("DEFAULT_FILE_COUNT_QUOTA must be at least 1")
+	}
+	if config.QuotaMode != QuotaModeDeduplicated && config.QuotaMode != QuotaModeLogical {
+		return nil, fmt.Errorf("QUOTA_MODE must be %q or %q", QuotaModeDeduplicated, QuotaModeLogical)
+	}
+
+	if config.DefaultBandwidthQuotaMB < 0 || config.ProxyBandwidthCapMB < 0 {
+		return nil, fmt.Errorf("DEFAULT_BANDWIDTH_QUOTA_MB and PROXY_BANDWIDTH_CAP_MB must not be negative")
+	}
+	if config.FolderDownloadMaxMB < 1 {
+		return nil, fmt.Errorf("FOLDER_DOWNLOAD_MAX_MB must be at least 1")
+	}
+
+	// A zero rate or burst, including from an unparseable value, rejects every request
+	if config.RateLimitEnabled && (config.RateLimitPerSecond <= 0 || config.RateLimitBurstSize < 1) {
+		return nil, fmt.Errorf("RATE_LIMIT_ENABLED requires a positive RATE_LIMIT_PER_SECOND and RATE_LIMIT_BURST_SIZE")
+	}
+	if config.RateLimitEnabled && (config.PublicRateLimitPerSecond <= 0 || config.PublicRateLimitBurstSize < 1) {
+		return nil, fmt.Errorf("RATE_LIMIT_ENABLED requires a positive PUBLIC_RATE_LIMIT_PER_SECOND and PUBLIC_RATE_LIMIT_BURST_SIZE")
+	}
+
+	if config.TrialEnabled && (config.TrialStorageQuotaMB <= 0 || config.TrialStorageQuotaMB > config.MaxStorageQuotaMB) {
+		return nil, fmt.Errorf("TRIAL_STORAGE_QUOTA_MB must be between 1 and MAX_STORAGE_QUOTA_MB")
+	}
+
+	if config.StorageEventsARN != "" && config.StorageEventsSecret == "" {
+		return nil, fmt.Errorf("STORAGE_EVENTS_ARN requires STORAGE_EVENTS_SECRET")
+	}
+
+	if config.UploadTokenSecret != "" && len(config.UploadTokenSecret) < 32 {
+		return nil, fmt.Errorf("UPLOAD_TOKEN_SECRET must be at least 32 characters")
+	}
+
+	if config.MetricsToken != "" && len(config.MetricsToken) < 32 {
+		return nil, fmt.Errorf("METRICS_TOKEN must be at least 32 characters")
+	}
+
+	if config.IPHashSecret != "" && len(config.IPHashSecret) < 32 {
+		return nil, fmt.Errorf("IP_HASH_SECRET must be at least 32 characters")
+	}
+
+	if config.UploadSessionMaxLifetimeSeconds < 3600 || config.UploadSessionMaxLifetimeSeconds > 86400 {
+		return nil, fmt.Errorf("UPLOAD_SESSION_MAX_LIFETIME_SECONDS must be between 3600 and 86400")
+	}
+
+	// Presigned URLs can't be valid for more than 7 days
+	if config.PrivateURLTTLSeconds < 1 || config.PrivateURLMaxTTLSeconds > 7*24*3600 ||
+		config.PrivateURLTTLSeconds > config.PrivateURLMaxTTLSeconds || config.MediaURLTTLSeconds < 1 || config.MediaURLTTLSeconds > config.PrivateURLMaxTTLSeconds {
+		return nil, fmt.Errorf("PRIVATE_URL_TTL and MEDIA_URL_TTL_SECONDS must be between 1 and PRIVATE_URL_MAX_TTL_SECONDS, which must not exceed 604800")
+	}
+
+	if config.DownloadLinkSecret != "" && len(config.DownloadLinkSecret) < 32 {
+		return nil, fmt.Errorf("DOWNLOAD_LINK_SECRET must be at least 32 characters")
+	}
+
+	if config.ImpersonationEnabled && len(config.ImpersonationSecret) < 32 {
+		return nil, fmt.Errorf("IMPERSONATION_ENABLED requires an IMPERSONATION_SECRET of at least 32 characters")
+	}
+
 	return config, nil
 }
 
@@ -138,9 +496,64 @@ func parseInt64(value string) int64 {
 	return 0
 }
 
+// parseList splits a comma-separated value into trimmed, lower-cased entries
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseOriginList splits a comma-separated list of origins, dropping trailing slashes so
+// they compare equal to the Origin header browsers send
+func parseOriginList(value string) []string {
+	origins := parseList(value)
+	for i, origin := range origins {
+		origins[i] = strings.TrimRight(origin, "/")
+	}
+	return origins
+}
+
+// parseIDList splits a comma-separated value into trimmed entries, keeping their case
+func parseIDList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func parseFloat64(value string) float64 {
 	if f, err := strconv.ParseFloat(value, 64); err == nil {
 		return f
 	}
 	return 0.0
 }
+
+// loadDotEnv applies .env below real environment variables. Unlike godotenv.Load it
+// re-applies the file on every call, so reloading the config picks up edits to it.
+func loadDotEnv() {
+	processEnvOnce.Do(func() {
+		processEnv = make(map[string]bool)
+		for _, entry := range os.Environ() {
+			if key, _, ok := strings.Cut(entry, "="); ok {
+				processEnv[key] = true
+			}
+		}
+	})
+
+	values, err := godotenv.Read()
+	if err != nil {
+		return
+	}
+	for key, value := range values {
+		if !processEnv[key] {
+			os.Setenv(key, value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCORSAllowedOriginsDefaultsToNone(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.CORSAllowedOrigins) != 0 {
+		t.Errorf("CORSAllowedOrigins = %v, want none when unset", cfg.CORSAllowedOrigins)
+	}
+}
+
+func TestLoadRejectsUnusableLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// The setting the error should name
+		want string
+	}{
+		{"zero rate", map[string]string{"RATE_LIMIT_PER_SECOND": "0"}, "RATE_LIMIT_PER_SECOND"},
+		{"unparseable rate", map[string]string{"RATE_LIMIT_PER_SECOND": "abc"}, "RATE_LIMIT_PER_SECOND"},
+		{"negative rate", map[string]string{"RATE_LIMIT_PER_SECOND": "-5"}, "RATE_LIMIT_PER_SECOND"},
+		{"zero burst", map[string]string{"RATE_LIMIT_BURST_SIZE": "0"}, "RATE_LIMIT_BURST_SIZE"},
+		{"unparseable burst", map[string]string{"RATE_LIMIT_BURST_SIZE": "lots"}, "RATE_LIMIT_BURST_SIZE"},
+		{"zero public rate", map[string]string{"PUBLIC_RATE_LIMIT_PER_SECOND": "0"}, "PUBLIC_RATE_LIMIT_PER_SECOND"},
+		{"zero public burst", map[string]string{"PUBLIC_RATE_LIMIT_BURST_SIZE": "0"}, "PUBLIC_RATE_LIMIT_BURST_SIZE"},
+		{"zero storage quota", map[string]string{"DEFAULT_STORAGE_QUOTA_MB": "0"}, "DEFAULT_STORAGE_QUOTA_MB"},
+		{"unparseable storage quota", map[string]string{"DEFAULT_STORAGE_QUOTA_MB": "100MB"}, "DEFAULT_STORAGE_QUOTA_MB"},
+		{"storage quota above max", map[string]string{"DEFAULT_STORAGE_QUOTA_MB": "2048", "MAX_STORAGE_QUOTA_MB": "1024"}, "DEFAULT_STORAGE_QUOTA_MB"},
+		{"negative bandwidth quota", map[string]string{"DEFAULT_BANDWIDTH_QUOTA_MB": "-1"}, "DEFAULT_BANDWIDTH_QUOTA_MB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RATE_LIMIT_ENABLED", "true")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load()
+			if err == nil {
+				t.Fatalf("Load() with %v succeeded, want an error", tt.env)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %q, want it to name %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAllowsZeroRatesWhenRateLimitingIsOff(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	t.Setenv("RATE_LIMIT_PER_SECOND", "0")
+	t.Setenv("RATE_LIMIT_BURST_SIZE", "0")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v, want unused rate limits ignored", err)
+	}
+	if cfg.RateLimitEnabled {
+		t.Error("RateLimitEnabled = true, want false")
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"filevault-backend/internal/config"
 	"filevault-backend/internal/models"
 	"fmt"
@@ -13,10 +14,16 @@ import (
 )
 
 type Database struct {
-	DB *gorm.DB
+	DB  *gorm.DB
+	cfg *config.Config
+
+	// FuzzySearchAvailable is set by AutoMigrate when the pg_trgm index exists
+	FuzzySearchAvailable bool
 }
 
-func Connect(cfg *config.Config) (*Database, error) {
+// gormConfig stamps CreatedAt and UpdatedAt in UTC whatever the server's zone, so
+// instances in different zones write consistent timestamps
+func gormConfig(cfg *config.Config) *gorm.Config {
 	gormLogger := logger.Default
 	if cfg.GinMode == "release" {
 		gormLogger = logger.Default.LogMode(logger.Silent)
@@ -24,12 +31,16 @@ func Connect(cfg *config.Config) (*Database, error) {
 		gormLogger = logger.Default.LogMode(logger.Info)
 	}
 
-	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL()), &gorm.Config{
+	return &gorm.Config{
 		Logger: gormLogger,
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
-	})
+	}
+}
+
+func Connect(cfg *config.Config) (*Database, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL()), gormConfig(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -48,26 +59,101 @@ func Connect(cfg *config.Config) (*Database, error) {
 	}
 
 	log.Println("Database connection established successfully")
-	return &Database{DB: db}, nil
+	return &Database{DB: db, cfg: cfg}, nil
 }
 
 func (d *Database) AutoMigrate() error {
 	log.Println("Running database migrations...")
 
+	// Checked before migrating, since migrating adds the column
+	trackingDownloads := d.DB.Migrator().HasColumn(&models.UserFile{}, "last_download_at")
+
 	err := d.DB.AutoMigrate(
 		&models.User{},
 		&models.FileHash{},
 		&models.UserFile{},
 		&models.ShareLink{},
+		&models.FailedDeletion{},
+		&models.UserActivity{},
+		&models.UploadRequest{},
+		&models.BandwidthEvent{},
+		&models.UserBandwidth{},
+		&models.BannedHash{},
+		&models.FileDownloadEvent{},
+		&models.UploadSession{},
+		&models.FileCollection{},
+		&models.FileCollectionItem{},
+		&models.FolderShareLink{},
+		&models.AuditLog{},
+		&models.AbuseReport{},
+		&models.FileAccess{},
+		&models.BatchUpload{},
+		&models.BatchUploadFile{},
+		&models.DailyStorageSnapshot{},
+		&models.IntegrityIssue{},
+		&models.IntegrityScrubCursor{},
+		&models.Announcement{},
+		&models.AnnouncementDismissal{},
+		&models.Job{},
+		&models.StorageRecalculation{},
+		&models.UsedUploadToken{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if !trackingDownloads {
+		if err := d.backfillLastDownloadAt(); err != nil {
+			return err
+		}
+	}
+
+	if d.cfg.EnableFuzzySearch {
+		d.FuzzySearchAvailable = d.setupTrigramIndex()
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
 
+// backfillLastDownloadAt dates the last download of files downloaded before downloads
+// were timestamped to the migration, so storage tiering doesn't take all of them for
+// cold content on its first run
+func (d *Database) backfillLastDownloadAt() error {
+	result := d.DB.Exec("UPDATE user_files SET last_download_at = NOW() WHERE last_download_at IS NULL AND download_count > 0")
+	if result.Error != nil {
+		return fmt.Errorf("failed to backfill last download times: %w", result.Error)
+	}
+	log.Printf("Backfilled last download time of %d previously downloaded files", result.RowsAffected)
+	return nil
+}
+
+// setupTrigramIndex enables pg_trgm and indexes filenames for fuzzy search. Managed
+// databases may not allow the extension, in which case search falls back to ILIKE.
+func (d *Database) setupTrigramIndex() bool {
+	if err := d.DB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("pg_trgm extension unavailable, fuzzy search will fall back to ILIKE: %v", err)
+		return false
+	}
+
+	err := d.DB.Exec("CREATE INDEX IF NOT EXISTS idx_user_files_filename_trgm ON user_files USING GIN (filename gin_trgm_ops)").Error
+	if err != nil {
+		log.Printf("Failed to create trigram index, fuzzy search will fall back to ILIKE: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// Ping verifies the database connection is still alive
+func (d *Database) Ping(ctx context.Context) error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 func (d *Database) Close() error {
 	sqlDB, err := d.DB.DB()
 	if err != nil {
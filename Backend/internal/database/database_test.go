@@ -0,0 +1,60 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// withLocalZone runs the test as if the server's zone weren't UTC
+func withLocalZone(t *testing.T) {
+	t.Helper()
+
+	previous := time.Local
+	time.Local = time.FixedZone("UTC+9", 9*60*60)
+	t.Cleanup(func() { time.Local = previous })
+}
+
+func TestGormConfigUsesUTC(t *testing.T) {
+	withLocalZone(t)
+
+	if now := gormConfig(&config.Config{GinMode: "release"}).NowFunc(); now.Location() != time.UTC {
+		t.Errorf("NowFunc() location = %v, want UTC", now.Location())
+	}
+}
+
+func TestCreatedAtIsUTC(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	withLocalZone(t)
+
+	db, err := gorm.Open(postgres.Open(dsn), gormConfig(&config.Config{GinMode: "release"}))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	user := models.User{ID: "utc-user-" + uuid.New().String()}
+	if err := tx.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if user.CreatedAt.Location() != time.UTC {
+		t.Errorf("CreatedAt location = %v, want UTC", user.CreatedAt.Location())
+	}
+	if user.UpdatedAt.Location() != time.UTC {
+		t.Errorf("UpdatedAt location = %v, want UTC", user.UpdatedAt.Location())
+	}
+}
@@ -10,6 +10,9 @@ const (
 	ErrTokenVerificationFailed = "TOKEN_VERIFICATION_FAILED"
 	ErrInsufficientPermissions = "INSUFFICIENT_PERMISSIONS"
 	ErrAdminAccessRequired     = "ADMIN_ACCESS_REQUIRED"
+	ErrSuperAdminRequired      = "SUPER_ADMIN_REQUIRED"
+	ErrImpersonationDisabled   = "IMPERSONATION_DISABLED"
+	ErrImpersonationFailed     = "IMPERSONATION_FAILED"
 
 	// User-related errors
 	ErrUserNotFound     = "USER_NOT_FOUND"
@@ -18,29 +21,69 @@ const (
 	ErrUserUpdateFailed = "USER_UPDATE_FAILED"
 
 	// File-related errors
-	ErrFileNotFound     = "FILE_NOT_FOUND"
-	ErrFileUploadFailed = "FILE_UPLOAD_FAILED"
-	ErrFileDeleteFailed = "FILE_DELETE_FAILED"
-	ErrFileAccessDenied = "FILE_ACCESS_DENIED"
-	ErrFileToggleFailed = "FILE_TOGGLE_FAILED"
-	ErrShareLinkFailed  = "SHARE_LINK_FAILED"
-	ErrInvalidFileID    = "INVALID_FILE_ID"
-	ErrInvalidShareID   = "INVALID_SHARE_ID"
+	ErrFileNotFound       = "FILE_NOT_FOUND"
+	ErrFileUploadFailed   = "FILE_UPLOAD_FAILED"
+	ErrFileDeleteFailed   = "FILE_DELETE_FAILED"
+	ErrFileAccessDenied   = "FILE_ACCESS_DENIED"
+	ErrFileToggleFailed   = "FILE_TOGGLE_FAILED"
+	ErrShareLinkFailed    = "SHARE_LINK_FAILED"
+	ErrInvalidFileID      = "INVALID_FILE_ID"
+	ErrInvalidShareID     = "INVALID_SHARE_ID"
+	ErrContentBanned      = "CONTENT_BANNED"
+	ErrHashMismatch       = "HASH_MISMATCH"
+	ErrFileLegalHold      = "FILE_LEGAL_HOLD"
+	ErrFileLocked         = "FILE_LOCKED"
+	ErrDuplicateUpload    = "DUPLICATE_UPLOAD"
+	ErrBatchNotFound      = "BATCH_NOT_FOUND"
+	ErrUploadTokenInvalid = "UPLOAD_TOKEN_INVALID"
+	ErrUploadExpired      = "UPLOAD_EXPIRED"
+	ErrFolderNotFound     = "FOLDER_NOT_FOUND"
+	ErrFolderTooLarge     = "FOLDER_TOO_LARGE"
+	ErrThumbnailNotFound  = "THUMBNAIL_NOT_FOUND"
+
+	// Download link errors
+	ErrDownloadLinksDisabled = "DOWNLOAD_LINKS_DISABLED"
+	ErrDownloadLinkInvalid   = "DOWNLOAD_LINK_INVALID"
+
+	// Collection errors
+	ErrCollectionNotFound = "COLLECTION_NOT_FOUND"
+
+	// Folder share errors
+	ErrFolderShareNotFound = "FOLDER_SHARE_NOT_FOUND"
+
+	// Abuse report errors
+	ErrAbuseReportNotFound = "ABUSE_REPORT_NOT_FOUND"
+
+	// Announcement errors
+	ErrAnnouncementNotFound = "ANNOUNCEMENT_NOT_FOUND"
+
+	// Upload request errors
+	ErrUploadRequestNotFound = "UPLOAD_REQUEST_NOT_FOUND"
+	ErrUploadRequestClosed   = "UPLOAD_REQUEST_CLOSED"
+	ErrUploadRequestRejected = "UPLOAD_REQUEST_REJECTED"
 
 	// Storage-related errors
-	ErrStorageQuotaExceeded = "STORAGE_QUOTA_EXCEEDED"
-	ErrStorageInfoFailed    = "STORAGE_INFO_FAILED"
-	ErrStorageStatsFailed   = "STORAGE_STATS_FAILED"
+	ErrStorageQuotaExceeded   = "STORAGE_QUOTA_EXCEEDED"
+	ErrFileCountQuotaExceeded = "FILE_COUNT_QUOTA_EXCEEDED"
+	ErrBandwidthQuotaExceeded = "BANDWIDTH_QUOTA_EXCEEDED"
+	ErrProxyBandwidthExceeded = "PROXY_BANDWIDTH_EXCEEDED"
+	ErrStorageInfoFailed      = "STORAGE_INFO_FAILED"
+	ErrStorageStatsFailed     = "STORAGE_STATS_FAILED"
+	ErrRecalculationNotFound  = "RECALCULATION_NOT_FOUND"
 
 	// Validation errors
 	ErrInvalidInput     = "INVALID_INPUT"
 	ErrValidationFailed = "VALIDATION_FAILED"
 	ErrInvalidRole      = "INVALID_ROLE"
 	ErrInvalidQuota     = "INVALID_QUOTA"
+	ErrQuotaBelowUsage  = "QUOTA_BELOW_USAGE"
 	ErrRequiredField    = "REQUIRED_FIELD"
 
 	// Rate limiting errors
-	ErrRateLimitExceeded = "RATE_LIMIT_EXCEEDED"
+	ErrRateLimitExceeded     = "RATE_LIMIT_EXCEEDED"
+	ErrDownloadRateLimited   = "DOWNLOAD_RATE_LIMITED"
+	ErrHotlinkForbidden      = "HOTLINK_FORBIDDEN"
+	ErrDownloadTicketInvalid = "DOWNLOAD_TICKET_INVALID"
 
 	// Server errors
 	ErrInternalServer     = "INTERNAL_SERVER_ERROR"
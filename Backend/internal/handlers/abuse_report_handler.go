@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AbuseReportHandler struct {
+	fileService  *services.FileService
+	auditService *services.AuditService
+	notifier     *services.AdminNotifier
+}
+
+func NewAbuseReportHandler(fileService *services.FileService, auditService *services.AuditService, notifier *services.AdminNotifier) *AbuseReportHandler {
+	return &AbuseReportHandler{
+		fileService:  fileService,
+		auditService: auditService,
+		notifier:     notifier,
+	}
+}
+
+type abuseReportRequest struct {
+	Category string `json:"category" binding:"required"`
+	Details  string `json:"details" binding:"max=2000"`
+}
+
+// ReportPublicFile godoc
+// @Summary Report public file
+// @Description Reports a public file for abuse. Repeat reports from the same address on the same day are ignored.
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param id path string true "File ID"
+// @Param request body object{category=string,details=string} true "Report (category: illegal, malware, copyright, harassment, spam, other)"
+// @Success 202 {object} map[string]interface{} "Report received"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Router /public/files/{id}/report [post]
+func (h *AbuseReportHandler) ReportPublicFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var req abuseReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	result, err := h.fileService.ReportPublicFile(fileID, req.Category, req.Details, c.ClientIP())
+	h.respondReport(c, result, err)
+}
+
+// ReportSharedFile godoc
+// @Summary Report shared file
+// @Description Reports the file behind a share link for abuse. Repeat reports from the same address on the same day are ignored.
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param id path string true "Share ID"
+// @Param request body object{category=string,details=string} true "Report (category: illegal, malware, copyright, harassment, spam, other)"
+// @Success 202 {object} map[string]interface{} "Report received"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 404 {object} map[string]interface{} "Share link not found"
+// @Router /share/{id}/report [post]
+func (h *AbuseReportHandler) ReportSharedFile(c *gin.Context) {
+	var req abuseReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	result, err := h.fileService.ReportSharedFile(c.Param("id"), req.Category, req.Details, c.ClientIP())
+	h.respondReport(c, result, err)
+}
+
+func (h *AbuseReportHandler) respondReport(c *gin.Context, result *services.AbuseReportResult, err error) {
+	switch {
+	case stderrors.Is(err, services.ErrReportedFileNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	case stderrors.Is(err, services.ErrInvalidReportCategory):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid report category", fmt.Sprintf("category must be one of %v", services.AbuseReportCategories)))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to submit report", err.Error()))
+		return
+	}
+
+	if result.ThresholdReached {
+		h.notifier.Notify("abuse_report_threshold",
+			fmt.Sprintf("A public file has received %d abuse reports and needs review", result.OpenReports),
+			map[string]interface{}{
+				"path":         c.Request.URL.Path,
+				"open_reports": result.OpenReports,
+			})
+	}
+
+	// Duplicates get the same response so reporters can't probe the dedup window
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Report received",
+	})
+}
+
+// ListReports godoc
+// @Summary List abuse reports (Admin only)
+// @Description Returns abuse reports, oldest first, with the reported file's details
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "Filter by status" Enums(open, dismissed, made_private, deleted) default(open)
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(50) maximum(100)
+// @Success 200 {object} map[string]interface{} "Abuse reports with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/reports [get]
+func (h *AbuseReportHandler) ListReports(c *gin.Context) {
+	paging, ok := parsePagination(c, adminPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	reports, total, err := h.fileService.ListAbuseReports(c.DefaultQuery("status", "open"), offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list reports", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reports":    reports,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// ResolveReport godoc
+// @Summary Resolve abuse report (Admin only)
+// @Description Dismisses a report, makes the reported file private or deletes it. Every open report for the file is resolved with it.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Report ID"
+// @Param request body object{action=string} true "Action: dismiss, make_private or delete"
+// @Param force query bool false "Delete the file even if its owner locked it"
+// @Success 200 {object} map[string]interface{} "Report resolved"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "Report not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/reports/{id}/resolve [post]
+func (h *AbuseReportHandler) ResolveReport(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid report ID"))
+		return
+	}
+
+	var req struct {
+		Action string `json:"action" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	force := c.Query("force") == "true"
+	fileID, err := h.fileService.ResolveAbuseReport(c.Request.Context(), reportID, req.Action, admin.ID, force)
+	switch {
+	case stderrors.Is(err, services.ErrAbuseReportNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrAbuseReportNotFound, "Report not found"))
+		return
+	case stderrors.Is(err, services.ErrInvalidReportAction):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("action must be dismiss, make_private or delete"))
+		return
+	case stderrors.Is(err, services.ErrFileUnderLegalHold):
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "Reported file is under legal hold and can't be deleted"))
+		return
+	case stderrors.Is(err, services.ErrFileLocked):
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "Reported file is locked by its owner; resolve with force=true to delete it anyway"))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to resolve report", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditAbuseReportResolved, reportID.String(),
+		fmt.Sprintf("action=%s file_id=%s force=%t", req.Action, fileID, force)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Report resolved",
+	})
+}
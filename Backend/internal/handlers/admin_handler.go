@@ -1,26 +1,41 @@
 package handlers
 
 import (
+	stderrors "errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type AdminHandler struct {
-	userService *services.UserService
-	fileService *services.FileService
+	userService  *services.UserService
+	fileService  *services.FileService
+	adminService *services.AdminService
+	auditService *services.AuditService
+
+	rateLimitService    *services.RateLimitService
+	storageRecalculator *services.StorageRecalculator
 }
 
-func NewAdminHandler(userService *services.UserService, fileService *services.FileService) *AdminHandler {
+func NewAdminHandler(userService *services.UserService, fileService *services.FileService, adminService *services.AdminService, auditService *services.AuditService, rateLimitService *services.RateLimitService, storageRecalculator *services.StorageRecalculator) *AdminHandler {
 	return &AdminHandler{
-		userService: userService,
-		fileService: fileService,
+		userService:         userService,
+		fileService:         fileService,
+		adminService:        adminService,
+		auditService:        auditService,
+		rateLimitService:    rateLimitService,
+		storageRecalculator: storageRecalculator,
 	}
 }
 
@@ -34,27 +49,17 @@ func NewAdminHandler(userService *services.UserService, fileService *services.Fi
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(50) maximum(100)
 // @Success 200 {object} map[string]interface{} "List of users with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /admin/users [get]
 func (h *AdminHandler) ListUsers(c *gin.Context) {
-	// Parse pagination parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-
-	// Validate pagination parameters
-	if page < 1 {
-		page = 1
-	}
-	if limit < 1 {
-		limit = 50
-	}
-	if limit > 100 {
-		limit = 100 // Max 100 items per page
+	paging, ok := parsePagination(c, adminPagination)
+	if !ok {
+		return
 	}
-
-	offset := (page - 1) * limit
+	offset, limit := paging.Offset(), paging.Limit
 
 	users, total, err := h.userService.ListUsers(offset, limit)
 	if err != nil {
@@ -63,13 +68,8 @@ func (h *AdminHandler) ListUsers(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"users": users,
-		"pagination": gin.H{
-			"page":        page,
-			"limit":       limit,
-			"total":       total,
-			"total_pages": (total + int64(limit) - 1) / int64(limit),
-		},
+		"users":      users,
+		"pagination": paging.Meta(total),
 	})
 }
 
@@ -96,7 +96,7 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	}
 
 	if err := h.userService.DeleteUser(userID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if stderrors.Is(err, services.ErrUserNotFound) {
 			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
 		} else {
 			c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserDeleteFailed, "Failed to delete user", err.Error()))
@@ -104,6 +104,8 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	h.auditService.Record(auditEntry(c, services.AuditUserDeleted, userID, ""))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User deleted successfully",
 	})
@@ -111,7 +113,7 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 
 // UpdateUserRole godoc
 // @Summary Update user role (Admin only)
-// @Description Updates a user's role (user or admin)
+// @Description Updates a user's role (user, admin or super). Only super admins can grant the super role or change a super admin's role.
 // @Tags admin
 // @Accept json
 // @Produce json
@@ -122,9 +124,16 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "User not found"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /admin/users/{id}/role [patch]
 func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
 	userID := c.Param("id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
@@ -147,16 +156,29 @@ func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
 		role = models.UserRoleUser
 	case "admin":
 		role = models.UserRoleAdmin
+	case "super":
+		role = models.UserRoleSuper
 	default:
-		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidRole, "Invalid role. Must be 'user' or 'admin'"))
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidRole, "Invalid role. Must be 'user', 'admin' or 'super'"))
 		return
 	}
 
-	if err := h.userService.UpdateUserRole(userID, role); err != nil {
+	// Only super admins may grant the super role or change a super admin's role
+	if err := h.userService.UpdateUserRole(userID, role, admin.Role); err != nil {
+		if stderrors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+			return
+		}
+		if stderrors.Is(err, services.ErrSuperAdminRequired) {
+			c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrSuperAdminRequired, "Super admin access required"))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update user role", err.Error()))
 		return
 	}
 
+	h.auditService.Record(auditEntry(c, services.AuditUserRoleChanged, userID, "role="+string(role)))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User role updated successfully",
 	})
@@ -175,6 +197,8 @@ func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 409 {object} map[string]interface{} "Quota is less than the user's current usage"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /admin/users/{id}/quota [patch]
 func (h *AdminHandler) UpdateUserQuota(c *gin.Context) {
@@ -199,16 +223,637 @@ func (h *AdminHandler) UpdateUserQuota(c *gin.Context) {
 	}
 
 	if err := h.userService.UpdateStorageQuota(userID, req.QuotaMB); err != nil {
+		if stderrors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+			return
+		}
+		if stderrors.Is(err, services.ErrQuotaBelowUsage) {
+			c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrQuotaBelowUsage, "Quota cannot be less than the user's current usage", err.Error()))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update storage quota", err.Error()))
 		return
 	}
 
+	h.auditService.Record(auditEntry(c, services.AuditUserQuotaChanged, userID, fmt.Sprintf("quota_mb=%d", req.QuotaMB)))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "User storage quota updated successfully",
 		"quota_mb": req.QuotaMB,
 	})
 }
 
+// RecalculateUserStorage godoc
+// @Summary Recalculate a user's storage usage (Admin only)
+// @Description Recomputes the user's storage usage from their files under the configured quota mode, plus what their in-progress uploads have reserved, and stores it. Safe to repeat.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} services.StorageUsageChange "Usage before and after recalculating"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/users/{id}/recalculate-storage [post]
+func (h *AdminHandler) RecalculateUserStorage(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
+		return
+	}
+
+	change, err := h.userService.RecalculateStorageUsed(c.Request.Context(), userID)
+	if stderrors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to recalculate storage usage", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditStorageRecalculated, userID,
+		fmt.Sprintf("quota_mode=%s before=%d after=%d", change.QuotaMode, change.Before, change.After)))
+
+	c.JSON(http.StatusOK, change)
+}
+
+// UpdateUserFileCountQuota godoc
+// @Summary Update user file count quota (Admin only)
+// @Description Sets how many files a user may have. Existing files over the new quota are kept; only new uploads are blocked.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body object{quota=int} true "File count quota update request"
+// @Success 200 {object} map[string]interface{} "User file count quota updated successfully"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/users/{id}/file-count-quota [patch]
+func (h *AdminHandler) UpdateUserFileCountQuota(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
+		return
+	}
+
+	var req struct {
+		Quota int `json:"quota" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	if req.Quota <= 0 {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidQuota, "Quota must be greater than 0"))
+		return
+	}
+
+	if err := h.userService.UpdateFileCountQuota(userID, req.Quota); err != nil {
+		if stderrors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update file count quota", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditUserFileCountChanged, userID, fmt.Sprintf("quota=%d", req.Quota)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User file count quota updated successfully",
+		"quota":   req.Quota,
+	})
+}
+
+// UpdateUserBandwidth godoc
+// @Summary Update user bandwidth quota (Admin only)
+// @Description Updates a user's monthly download bandwidth quota in MB (0 = unlimited)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body object{quota_mb=int64} true "Bandwidth quota update request"
+// @Success 200 {object} map[string]interface{} "User bandwidth quota updated successfully"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/users/{id}/bandwidth [patch]
+func (h *AdminHandler) UpdateUserBandwidth(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
+		return
+	}
+
+	var req struct {
+		QuotaMB *int64 `json:"quota_mb" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	if *req.QuotaMB < 0 {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidQuota, "Quota must be 0 (unlimited) or greater"))
+		return
+	}
+
+	if err := h.userService.UpdateBandwidthQuota(userID, *req.QuotaMB); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update bandwidth quota", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditUserBandwidthChanged, userID, fmt.Sprintf("quota_mb=%d", *req.QuotaMB)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "User bandwidth quota updated successfully",
+		"quota_mb": *req.QuotaMB,
+	})
+}
+
+// UpdateUserRateLimit godoc
+// @Summary Update user rate limit override (Admin only)
+// @Description Sets a user's API rate limit in requests per second and burst size. Send nulls to remove the override and fall back to the configured defaults.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body object{per_second=number,burst_size=int} true "Rate limit override (nulls remove it)"
+// @Success 200 {object} map[string]interface{} "User rate limit updated successfully"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/users/{id}/rate-limit [patch]
+func (h *AdminHandler) UpdateUserRateLimit(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
+		return
+	}
+
+	var req struct {
+		PerSecond *float64 `json:"per_second"`
+		BurstSize *int     `json:"burst_size"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	var override *services.RateLimitOverride
+	switch {
+	case req.PerSecond == nil && req.BurstSize == nil:
+		// Remove the override
+	case req.PerSecond == nil || req.BurstSize == nil:
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("per_second and burst_size must both be set or both be null"))
+		return
+	case *req.PerSecond <= 0 || *req.BurstSize < 1:
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("per_second must be greater than 0 and burst_size at least 1"))
+		return
+	default:
+		override = &services.RateLimitOverride{PerSecond: *req.PerSecond, BurstSize: *req.BurstSize}
+	}
+
+	if err := h.userService.UpdateRateLimitOverride(userID, override); err != nil {
+		if stderrors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update rate limit", err.Error()))
+		return
+	}
+
+	h.rateLimitService.SetOverride(userID, override)
+
+	details := "override=none"
+	if override != nil {
+		details = fmt.Sprintf("per_second=%g burst_size=%d", override.PerSecond, override.BurstSize)
+	}
+	h.auditService.Record(auditEntry(c, services.AuditUserRateLimitChanged, userID, details))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "User rate limit updated successfully",
+		"rate_limit": override,
+	})
+}
+
+// GetRateLimitStats godoc
+// @Summary Get rate limit statistics (Admin only)
+// @Description Returns the users and IPs rejected most often by the API rate limiter in the current hour, at most 50
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Rate limit statistics"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Router /admin/rate-limit/stats [get]
+func (h *AdminHandler) GetRateLimitStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"stats": h.rateLimitService.GetStats(),
+	})
+}
+
+// GetUserStorageBreakdown godoc
+// @Summary Get a user's storage breakdown (Admin only)
+// @Description Returns a user's file count and bytes per top-level MIME type, largest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} services.StorageBreakdown "Storage breakdown"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/users/{id}/storage-breakdown [get]
+func (h *AdminHandler) GetUserStorageBreakdown(c *gin.Context) {
+	userID := c.Param("id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("User ID required"))
+		return
+	}
+
+	breakdown, err := h.fileService.ComputeStorageBreakdown(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageStatsFailed, "Failed to compute storage breakdown", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, breakdown)
+}
+
+// SetLegalHold godoc
+// @Summary Set or clear a file's legal hold (Admin only)
+// @Description Places a file under legal hold so nobody can delete it, or releases the hold. Clearing a hold requires a super admin.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param request body object{hold=bool,reason=string} true "Legal hold update (reason is required when placing a hold)"
+// @Success 200 {object} map[string]interface{} "Legal hold updated"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Super admin required to clear a hold"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/files/{id}/legal-hold [patch]
+func (h *AdminHandler) SetLegalHold(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var req struct {
+		Hold   *bool  `json:"hold" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if *req.Hold && reason == "" {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("A reason is required to place a legal hold"))
+		return
+	}
+	if !*req.Hold && admin.Role != models.UserRoleSuper {
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrSuperAdminRequired, "Super admin access required to clear a legal hold"))
+		return
+	}
+
+	userFile, err := h.fileService.SetLegalHold(c.Request.Context(), fileID, *req.Hold, reason, admin.ID)
+	if stderrors.Is(err, services.ErrLegalHoldFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.NotFoundResponse("File"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to update legal hold", err.Error()))
+		return
+	}
+
+	if *req.Hold {
+		h.auditService.Record(auditEntry(c, services.AuditLegalHoldSet, fileID.String(), fmt.Sprintf("reason=%q", reason)))
+	} else {
+		h.auditService.Record(auditEntry(c, services.AuditLegalHoldCleared, fileID.String(),
+			fmt.Sprintf("previous_reason=%q previous_set_by=%s", userFile.LegalHoldReason, userFile.LegalHoldSetBy)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Legal hold updated successfully",
+		"file_id":    fileID,
+		"legal_hold": *req.Hold,
+	})
+}
+
+// ListLegalHolds godoc
+// @Summary List files under legal hold (Admin only)
+// @Description Returns every file under legal hold with who placed the hold and why, most recent first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(50) maximum(100)
+// @Success 200 {object} map[string]interface{} "Files under legal hold with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/files/legal-holds [get]
+func (h *AdminHandler) ListLegalHolds(c *gin.Context) {
+	paging, ok := parsePagination(c, adminPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	holds, total, err := h.fileService.ListLegalHolds(offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list legal holds", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files":      holds,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// VerifyIntegrity godoc
+// @Summary Verify stored content (Admin only)
+// @Description Re-hashes stored objects and records mismatches and missing objects as integrity issues. Mode sample checks a random selection; mode walk checks the next page in hash order after the given cursor and returns next_cursor until the walk is done. Reads are throttled like background scrubbing.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{mode=string,after=string,limit=int} true "Verification request (mode: sample or walk, limit up to 1000)"
+// @Success 200 {object} services.IntegrityVerifyReport "Verification report"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/maintenance/verify [post]
+func (h *AdminHandler) VerifyIntegrity(c *gin.Context) {
+	var req struct {
+		Mode  string `json:"mode" binding:"required,oneof=sample walk"`
+		After string `json:"after"`
+		Limit int    `json:"limit" binding:"omitempty,min=1,max=1000"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+
+	report, err := h.fileService.VerifyIntegrity(c.Request.Context(), req.Mode == "sample", req.After, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to verify stored content", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RecalculateAllStorage godoc
+// @Summary Recalculate every user's storage usage (Admin only)
+// @Description Starts a background run recomputing every user's storage usage the way the per-user recalculation does, in batches. Only one run goes at a time: while one is in progress it's returned with 200 instead of starting another.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.StorageRecalculation "Run already in progress"
+// @Success 202 {object} models.StorageRecalculation "Run started; poll it for progress"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/maintenance/recalculate-all-storage [post]
+func (h *AdminHandler) RecalculateAllStorage(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	run, started, err := h.storageRecalculator.Start(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to start storage recalculation", err.Error()))
+		return
+	}
+	if !started {
+		c.JSON(http.StatusOK, run)
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditStorageRecalcStarted, run.ID.String(),
+		fmt.Sprintf("quota_mode=%s users=%d", run.QuotaMode, run.TotalUsers)))
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// GetStorageRecalculation godoc
+// @Summary Get a storage recalculation run (Admin only)
+// @Description Returns a run started by recalculate-all-storage with its progress
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Run ID"
+// @Success 200 {object} models.StorageRecalculation "Recalculation run"
+// @Failure 400 {object} map[string]interface{} "Invalid run ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "Run not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/maintenance/recalculate-all-storage/{id} [get]
+func (h *AdminHandler) GetStorageRecalculation(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid recalculation ID"))
+		return
+	}
+
+	run, err := h.storageRecalculator.Get(c.Request.Context(), id)
+	if stderrors.Is(err, services.ErrRecalculationNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrRecalculationNotFound, "Storage recalculation not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get storage recalculation", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// ListIntegrityIssues godoc
+// @Summary List integrity issues (Admin only)
+// @Description Returns stored content found corrupted or missing, most recently detected first. Resolved issues are hidden unless requested.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param include_resolved query bool false "Include issues whose content has since verified"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(50) maximum(100)
+// @Success 200 {object} map[string]interface{} "Integrity issues with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/maintenance/integrity-issues [get]
+func (h *AdminHandler) ListIntegrityIssues(c *gin.Context) {
+	includeResolved := c.Query("include_resolved") == "true"
+
+	paging, ok := parsePagination(c, adminPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	issues, total, err := h.fileService.ListIntegrityIssues(includeResolved, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list integrity issues", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"issues":     issues,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// GetSystemSnapshot godoc
+// @Summary Get system snapshot (Admin only)
+// @Description Returns a point-in-time snapshot of system counters, connection pool, storage health and runtime stats for incident response
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.SystemSnapshot "System snapshot"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/snapshot [get]
+func (h *AdminHandler) GetSystemSnapshot(c *gin.Context) {
+	snapshot, err := h.adminService.GetSystemSnapshot(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to collect system snapshot", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// GetStorageTrendReport godoc
+// @Summary Get storage trend report (Admin only)
+// @Description Returns daily storage totals for the last N days, or as many as were recorded, with a linear projection of when storage capacity is reached
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param days query int false "Days of history (1-365)" default(30)
+// @Success 200 {object} map[string]interface{} "Daily snapshots and capacity projection"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/stats/storage-trend [get]
+func (h *AdminHandler) GetStorageTrendReport(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("days must be between 1 and 365"))
+		return
+	}
+
+	snapshots, err := h.adminService.GetStorageTrendReport(days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageStatsFailed, "Failed to get storage trend", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":       days,
+		"snapshots":  snapshots,
+		"projection": h.adminService.ProjectStorageCapacity(snapshots),
+	})
+}
+
+// GetTopFiles godoc
+// @Summary Get largest and most-downloaded files (Admin only)
+// @Description Ranks files by content size or download count, or stored content by how many files reference it. Results may be up to a minute old.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param by query string false "size, downloads or references" default(size)
+// @Param limit query int false "Number of entries (1-100)" default(50)
+// @Param user_id query string false "Only rank this user's files"
+// @Success 200 {object} map[string]interface{} "Top files"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/stats/top [get]
+func (h *AdminHandler) GetTopFiles(c *gin.Context) {
+	by := services.TopFilesDimension(c.DefaultQuery("by", string(services.TopBySize)))
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("limit must be between 1 and 100"))
+		return
+	}
+	userID := c.Query("user_id")
+
+	entries, err := h.adminService.GetTopFiles(by, limit, userID)
+	if stderrors.Is(err, services.ErrInvalidTopFilesDimension) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageStatsFailed, "Failed to get top files", err.Error()))
+		return
+	}
+
+	response := gin.H{
+		"by":    by,
+		"files": entries,
+	}
+	if userID != "" {
+		response["user_id"] = userID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
 // GetStats godoc
 // @Summary Get system statistics (Admin only)
 // @Description Returns system-wide statistics
@@ -232,3 +877,174 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 		},
 	})
 }
+
+// GetStorageOrphans godoc
+// @Summary List orphaned storage objects (Admin only)
+// @Description Returns bucket objects with no file record and object deletions that failed after all retries
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.OrphanReport "Orphaned objects and failed deletions"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/storage/orphans [get]
+func (h *AdminHandler) GetStorageOrphans(c *gin.Context) {
+	report, err := h.fileService.FindOrphanedObjects(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to scan storage", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// BanHash godoc
+// @Summary Ban content hash (Admin only)
+// @Description Blocks future uploads of a file hash and optionally purges existing copies
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{hash=string,reason=string,purge=bool} true "Ban request"
+// @Param force query bool false "Purge files even if their owners locked them"
+// @Success 201 {object} services.BanHashReport "Ban report with affected users"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/banned-hashes [post]
+func (h *AdminHandler) BanHash(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		Hash   string `json:"hash" binding:"required,len=64,hexadecimal"`
+		Reason string `json:"reason" binding:"required"`
+		Purge  bool   `json:"purge"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	force := c.Query("force") == "true"
+	report, err := h.fileService.BanHash(req.Hash, req.Reason, admin.ID, req.Purge, force)
+	if stderrors.Is(err, services.ErrFileUnderLegalHold) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "A file with this content is under legal hold and can't be purged"))
+		return
+	}
+	if stderrors.Is(err, services.ErrFileLocked) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "A file with this content is locked by its owner; purge with force=true to remove it anyway"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to ban hash", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditHashBanned, report.BannedHash.Hash,
+		fmt.Sprintf("reason=%q purged_files=%d force=%t", req.Reason, report.PurgedFiles, force)))
+
+	c.JSON(http.StatusCreated, report)
+}
+
+// UnbanHash godoc
+// @Summary Remove banned content hash (Admin only)
+// @Description Allows a previously banned file hash to be uploaded again
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param hash path string true "SHA256 file hash"
+// @Success 200 {object} map[string]interface{} "Hash unbanned"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "Banned hash not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/banned-hashes/{hash} [delete]
+func (h *AdminHandler) UnbanHash(c *gin.Context) {
+	if err := h.fileService.UnbanHash(c.Param("hash")); err != nil {
+		if stderrors.Is(err, services.ErrBannedHashNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Banned hash not found"))
+		} else {
+			c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to unban hash", err.Error()))
+		}
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditHashUnbanned, c.Param("hash"), ""))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Hash unbanned successfully",
+	})
+}
+
+// ImpersonateUser godoc
+// @Summary Impersonate user (Admin only)
+// @Description Issues a short-lived token that authenticates as the user, for reproducing bugs. Requests made with it are audited.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param request body object{ttl_seconds=int} false "Token lifetime in seconds (max 900)"
+// @Success 200 {object} map[string]interface{} "Impersonation token and expiry"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - impersonation disabled or target not allowed"
+// @Failure 404 {object} map[string]interface{} "User not found"
+// @Router /admin/users/{id}/impersonate [post]
+func (h *AdminHandler) ImpersonateUser(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	targetUserID := c.Param("id")
+
+	var req struct {
+		TTLSeconds int `json:"ttl_seconds" binding:"min=0,max=900"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !stderrors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = services.MaxImpersonationTTL
+	}
+
+	token, err := h.adminService.CreateImpersonationToken(admin.ID, targetUserID, ttl)
+	switch {
+	case stderrors.Is(err, services.ErrImpersonationDisabled):
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrImpersonationDisabled, "Impersonation is disabled"))
+		return
+	case stderrors.Is(err, services.ErrImpersonationNotAllowed):
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrImpersonationFailed, err.Error()))
+		return
+	case stderrors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUserNotFound, "User not found"))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrImpersonationFailed, "Failed to create impersonation token", err.Error()))
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+
+	h.auditService.Record(auditEntry(c, services.AuditImpersonationStarted, targetUserID, "expires_at="+expiresAt.Format(time.RFC3339)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AnnouncementHandler struct {
+	announcementService *services.AnnouncementService
+	auditService        *services.AuditService
+}
+
+func NewAnnouncementHandler(announcementService *services.AnnouncementService, auditService *services.AuditService) *AnnouncementHandler {
+	return &AnnouncementHandler{
+		announcementService: announcementService,
+		auditService:        auditService,
+	}
+}
+
+// ListAnnouncements godoc
+// @Summary List announcements (Admin only)
+// @Description Returns every announcement, including scheduled and ended ones, most recently starting first
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(50) maximum(100)
+// @Success 200 {object} map[string]interface{} "Announcements with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/announcements [get]
+func (h *AnnouncementHandler) ListAnnouncements(c *gin.Context) {
+	paging, ok := parsePagination(c, adminPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	announcements, total, err := h.announcementService.ListAnnouncements(offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list announcements", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"announcements": announcements,
+		"pagination":    paging.Meta(total),
+	})
+}
+
+// CreateAnnouncement godoc
+// @Summary Create announcement (Admin only)
+// @Description Publishes a message to all users, e.g. a maintenance window. It starts immediately unless starts_at is given and stays up until ends_at, if set.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body services.AnnouncementInput true "Announcement (severity: info, warning or critical; defaults to info)"
+// @Success 201 {object} models.Announcement "Created announcement"
+// @Failure 400 {object} map[string]interface{} "Invalid announcement"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/announcements [post]
+func (h *AnnouncementHandler) CreateAnnouncement(c *gin.Context) {
+	admin := middleware.GetUserFromContext(c)
+	if admin == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req services.AnnouncementInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	announcement, err := h.announcementService.CreateAnnouncement(admin.ID, req)
+	if stderrors.Is(err, services.ErrInvalidAnnouncement) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to create announcement", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditAnnouncementCreated, announcement.ID.String(), "severity="+string(announcement.Severity)))
+
+	c.JSON(http.StatusCreated, announcement)
+}
+
+// UpdateAnnouncement godoc
+// @Summary Update announcement (Admin only)
+// @Description Changes the given fields of an announcement; set clear_ends_at to remove its end time
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Announcement ID"
+// @Param request body services.AnnouncementInput true "Fields to change"
+// @Success 200 {object} models.Announcement "Updated announcement"
+// @Failure 400 {object} map[string]interface{} "Invalid announcement"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "Announcement not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/announcements/{id} [patch]
+func (h *AnnouncementHandler) UpdateAnnouncement(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid announcement ID"))
+		return
+	}
+
+	var req services.AnnouncementInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	announcement, err := h.announcementService.UpdateAnnouncement(id, req)
+	switch {
+	case stderrors.Is(err, services.ErrAnnouncementNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrAnnouncementNotFound, "Announcement not found"))
+		return
+	case stderrors.Is(err, services.ErrInvalidAnnouncement):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to update announcement", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditAnnouncementUpdated, id.String(), ""))
+
+	c.JSON(http.StatusOK, announcement)
+}
+
+// DeleteAnnouncement godoc
+// @Summary Delete announcement (Admin only)
+// @Description Removes an announcement and every user's dismissal of it
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Announcement ID"
+// @Success 200 {object} map[string]interface{} "Announcement deleted"
+// @Failure 400 {object} map[string]interface{} "Invalid announcement ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 404 {object} map[string]interface{} "Announcement not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/announcements/{id} [delete]
+func (h *AnnouncementHandler) DeleteAnnouncement(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid announcement ID"))
+		return
+	}
+
+	err = h.announcementService.DeleteAnnouncement(id)
+	if stderrors.Is(err, services.ErrAnnouncementNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrAnnouncementNotFound, "Announcement not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to delete announcement", err.Error()))
+		return
+	}
+
+	h.auditService.Record(auditEntry(c, services.AuditAnnouncementDeleted, id.String(), ""))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Announcement deleted",
+	})
+}
+
+// GetActiveAnnouncements godoc
+// @Summary Get active announcements
+// @Description Returns the announcements currently shown, most severe first, with whether the current user has dismissed each
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Active announcements"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/announcements [get]
+func (h *AnnouncementHandler) GetActiveAnnouncements(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	announcements, err := h.announcementService.ActiveAnnouncements(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get announcements", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"announcements": announcements,
+	})
+}
+
+// DismissAnnouncement godoc
+// @Summary Dismiss announcement
+// @Description Marks an active announcement as dismissed for the current user
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Announcement ID"
+// @Success 200 {object} map[string]interface{} "Announcement dismissed"
+// @Failure 400 {object} map[string]interface{} "Invalid announcement ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Announcement not found or not active"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/announcements/{id}/dismiss [post]
+func (h *AnnouncementHandler) DismissAnnouncement(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid announcement ID"))
+		return
+	}
+
+	err = h.announcementService.DismissAnnouncement(user.ID, id)
+	if stderrors.Is(err, services.ErrAnnouncementNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrAnnouncementNotFound, "Announcement not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to dismiss announcement", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Announcement dismissed",
+	})
+}
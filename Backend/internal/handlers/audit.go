@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEntry builds an audit log entry for the request's user, including the
+// impersonating admin when there is one
+func auditEntry(c *gin.Context, action, targetID, details string) models.AuditLog {
+	entry := models.AuditLog{
+		Action:    action,
+		TargetID:  targetID,
+		Details:   details,
+		IPAddress: c.ClientIP(),
+	}
+	if user := middleware.GetUserFromContext(c); user != nil {
+		entry.ActorID = user.ID
+		entry.ImpersonatedBy = user.ImpersonatedBy
+	}
+	return entry
+}
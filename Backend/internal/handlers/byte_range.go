@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"strconv"
+	"strings"
+)
+
+var errRangeNotSatisfiable = stderrors.New("range not satisfiable")
+
+// parseByteRange applies a Range header to content of the given size and returns the
+// offset and length to serve, and whether that is a partial response. Headers that
+// don't parse, and multi-range requests, are ignored and get the whole content, as
+// RFC 9110 allows.
+func parseByteRange(header string, size int64) (int64, int64, bool, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+
+	// bytes=-N asks for the last N bytes
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, size, false, nil
+		}
+		if suffix <= 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		requested, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || requested < start {
+			return 0, size, false, nil
+		}
+		end = min(end, requested)
+	}
+	return start, end - start + 1, true, nil
+}
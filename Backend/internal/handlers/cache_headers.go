@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setPublicCacheHeaders lets shared caches store a public download, a file with its
+// FileData loaded. Content is addressed by its hash, so the hash is a strong ETag:
+// replacing the file behind a share link changes it even when the new content was
+// stored earlier.
+func setPublicCacheHeaders(c *gin.Context, userFile models.UserFile, maxAge int) {
+	c.Header("ETag", etagFor(userFile.FileData))
+	c.Header("Last-Modified", lastModified(userFile).UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+}
+
+// lastModified is when the file's content last changed for a client: when the content
+// was first stored, or later if the file was pointed at content stored before it
+func lastModified(userFile models.UserFile) time.Time {
+	if userFile.UpdatedAt.After(userFile.FileData.CreatedAt) {
+		return userFile.UpdatedAt
+	}
+	return userFile.FileData.CreatedAt
+}
+
+func etagFor(fileHash models.FileHash) string {
+	return `"` + fileHash.Hash + `"`
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since only when
+// no ETag was sent (RFC 9110 section 13.2.2)
+func notModified(r *http.Request, userFile models.UserFile) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := etagFor(userFile.FileData)
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// Last-Modified has second precision
+		return !lastModified(userFile).Truncate(time.Second).After(since)
+	}
+
+	return false
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLastModifiedDoesNotGoBackWhenRepointed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stored := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repointed := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// A share link moved to content stored long before the switch
+	userFile := models.UserFile{
+		UpdatedAt: repointed,
+		FileData:  models.FileHash{Hash: "abc", CreatedAt: stored},
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	setPublicCacheHeaders(c, userFile, 60)
+	if got, want := w.Header().Get("Last-Modified"), repointed.Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified = %q, want %q", got, want)
+	}
+
+	// A client holding the old content's date must not be told it's still current
+	r := httptest.NewRequest(http.MethodGet, "/share/abc12345", nil)
+	r.Header.Set("If-Modified-Since", stored.Add(24*time.Hour).Format(http.TimeFormat))
+	if notModified(r, userFile) {
+		t.Error("notModified() = true for a date before the file was repointed")
+	}
+
+	r.Header.Set("If-Modified-Since", repointed.Format(http.TimeFormat))
+	if !notModified(r, userFile) {
+		t.Error("notModified() = false for the current Last-Modified")
+	}
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CollectionHandler struct {
+	fileService *services.FileService
+}
+
+func NewCollectionHandler(fileService *services.FileService) *CollectionHandler {
+	return &CollectionHandler{
+		fileService: fileService,
+	}
+}
+
+// CreateCollection godoc
+// @Summary Create collection
+// @Description Creates an empty collection for grouping files under one share link
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{name=string,description=string} true "Collection details"
+// @Success 201 {object} models.FileCollection "Created collection"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /collections [post]
+func (h *CollectionHandler) CreateCollection(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name" binding:"required,max=255"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	collection, err := h.fileService.CreateCollection(user.ID, req.Name, req.Description)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to create collection", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusCreated, collection)
+}
+
+// ListCollections godoc
+// @Summary List collections
+// @Description Returns the current user's collections
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Collections"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /collections [get]
+func (h *CollectionHandler) ListCollections(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	collections, err := h.fileService.ListCollections(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list collections", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+	})
+}
+
+// AddToCollection godoc
+// @Summary Add file to collection
+// @Description Appends one of the user's files to a collection
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Collection ID"
+// @Param request body object{file_id=string} true "File to add"
+// @Success 200 {object} map[string]interface{} "File added"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Collection or file not found"
+// @Router /collections/{id}/files [post]
+func (h *CollectionHandler) AddToCollection(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	collectionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid collection ID"))
+		return
+	}
+
+	var req struct {
+		FileID uuid.UUID `json:"file_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	if err := h.fileService.AddToCollection(user.ID, collectionID, req.FileID); err != nil {
+		h.respondError(c, err, errors.ErrFileNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File added to collection",
+	})
+}
+
+// RemoveFromCollection godoc
+// @Summary Remove file from collection
+// @Description Removes a file from a collection without deleting the file
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Collection ID"
+// @Param file_id path string true "File ID"
+// @Success 200 {object} map[string]interface{} "File removed"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Collection or file not found"
+// @Router /collections/{id}/files/{file_id} [delete]
+func (h *CollectionHandler) RemoveFromCollection(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	collectionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid collection ID"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	if err := h.fileService.RemoveFromCollection(user.ID, collectionID, fileID); err != nil {
+		h.respondError(c, err, errors.ErrFileNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File removed from collection",
+	})
+}
+
+// ReorderCollection godoc
+// @Summary Reorder collection
+// @Description Sets the display order of a collection's files. file_ids must list every file in the collection exactly once.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Collection ID"
+// @Param request body object{file_ids=[]string} true "File IDs in display order"
+// @Success 200 {object} map[string]interface{} "Collection reordered"
+// @Failure 400 {object} map[string]interface{} "File IDs don't match the collection"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Collection not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /collections/{id}/order [put]
+func (h *CollectionHandler) ReorderCollection(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	collectionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid collection ID"))
+		return
+	}
+
+	var req struct {
+		FileIDs []uuid.UUID `json:"file_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	err = h.fileService.ReorderCollectionItems(user.ID, collectionID, req.FileIDs)
+	switch {
+	case stderrors.Is(err, services.ErrCollectionOrderMismatch):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	case stderrors.Is(err, services.ErrCollectionNotFound):
+		h.respondError(c, err, errors.ErrCollectionNotFound)
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to reorder collection", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Collection reordered",
+	})
+}
+
+// ShareCollection godoc
+// @Summary Share collection
+// @Description Makes a collection public and returns its share link
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Collection ID"
+// @Success 200 {object} map[string]interface{} "Share link"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Collection not found"
+// @Router /collections/{id}/share [post]
+func (h *CollectionHandler) ShareCollection(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	collectionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid collection ID"))
+		return
+	}
+
+	shareID, err := h.fileService.ShareCollection(user.ID, collectionID)
+	if err != nil {
+		h.respondError(c, err, errors.ErrShareLinkFailed)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share_id":  shareID,
+		"share_url": "/c/" + shareID,
+	})
+}
+
+// GetSharedCollection godoc
+// @Summary View shared collection
+// @Description Lists the files in a shared collection
+// @Tags collections
+// @Produce json
+// @Param share_id path string true "Collection share ID"
+// @Success 200 {object} services.CollectionResponse "Collection with files"
+// @Failure 404 {object} map[string]interface{} "Collection not found"
+// @Router /c/{share_id} [get]
+func (h *CollectionHandler) GetSharedCollection(c *gin.Context) {
+	collection, err := h.fileService.GetSharedCollection(c.Param("share_id"))
+	if err != nil {
+		h.respondError(c, err, errors.ErrCollectionNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, collection)
+}
+
+// DownloadCollectionFile godoc
+// @Summary Download file from shared collection
+// @Description Redirects to a short-lived download URL for a file in a shared collection
+// @Tags collections
+// @Param share_id path string true "Collection share ID"
+// @Param file_id path string true "File ID"
+// @Success 302 "Redirect to file"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 404 {object} map[string]interface{} "Collection or file not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Router /c/{share_id}/files/{file_id} [get]
+func (h *CollectionHandler) DownloadCollectionFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	downloadURL, err := h.fileService.GetCollectionFileDownloadURL(c.Request.Context(), c.Param("share_id"), fileID)
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	}
+	if err != nil {
+		h.respondError(c, err, errors.ErrFileNotFound)
+		return
+	}
+
+	c.Redirect(http.StatusFound, downloadURL)
+}
+
+// respondError maps collection errors to 404s; anything else is reported with fallbackCode
+func (h *CollectionHandler) respondError(c *gin.Context, err error, fallbackCode string) {
+	if stderrors.Is(err, services.ErrCollectionNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrCollectionNotFound, "Collection not found"))
+		return
+	}
+	c.JSON(http.StatusNotFound, errors.ErrorResponse(fallbackCode, err.Error()))
+}
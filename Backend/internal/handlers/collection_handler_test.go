@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestReorderCollection(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{}, &models.FileCollection{}, &models.FileCollectionItem{})
+	fileService := services.NewFileService(tx, &config.Config{IPHashSecret: "test", UploadTokenSecret: "test"}, nil, nil, nil, nil, nil, false)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	userID := "collection-user-" + uuid.New().String()
+	router.PUT("/collections/:id/order", func(c *gin.Context) {
+		c.Set(middleware.UserContextKey, &middleware.AuthenticatedUser{ID: userID})
+	}, NewCollectionHandler(fileService).ReorderCollection)
+
+	// A collection of five files, in the order they were added
+	const hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if err := tx.Create(&models.FileHash{Hash: hash, MinIOKey: hash, Size: 10, MimeType: "text/plain"}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	collection, err := fileService.CreateCollection(userID, "Trip", "")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	ids := make([]uuid.UUID, 5)
+	for i := range ids {
+		file := models.UserFile{UserID: userID, FileHash: hash, Filename: fmt.Sprintf("photo-%d.jpg", i)}
+		if err := tx.Create(&file).Error; err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		if err := fileService.AddToCollection(userID, collection.ID, file.ID); err != nil {
+			t.Fatalf("AddToCollection() error = %v", err)
+		}
+		ids[i] = file.ID
+	}
+
+	reorder := func(fileIDs []uuid.UUID) int {
+		body, _ := json.Marshal(map[string]interface{}{"file_ids": fileIDs})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/collections/"+collection.ID.String()+"/order", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	order := []uuid.UUID{ids[3], ids[0], ids[4], ids[2], ids[1]}
+	if code := reorder(order); code != http.StatusOK {
+		t.Fatalf("reorder status = %d, want %d", code, http.StatusOK)
+	}
+	checkOrder := func(when string) {
+		t.Helper()
+		files, err := fileService.GetCollectionContents(collection.ID)
+		if err != nil {
+			t.Fatalf("GetCollectionContents() error = %v", err)
+		}
+		if len(files) != len(order) {
+			t.Fatalf("collection has %d files %s, want %d", len(files), when, len(order))
+		}
+		for i, file := range files {
+			if file.ID != order[i] {
+				t.Errorf("file %d is %s %s, want %s", i, file.Filename, when, order[i])
+			}
+		}
+	}
+	checkOrder("after reordering")
+
+	for name, fileIDs := range map[string][]uuid.UUID{
+		"missing":   order[:4],
+		"extra":     append(append([]uuid.UUID{}, order...), uuid.New()),
+		"duplicate": {order[0], order[0], order[1], order[2], order[3]},
+		"foreign":   {order[0], order[1], order[2], order[3], uuid.New()},
+	} {
+		if code := reorder(fileIDs); code != http.StatusBadRequest {
+			t.Errorf("reorder with a %s file status = %d, want %d", name, code, http.StatusBadRequest)
+		}
+	}
+	checkOrder("after rejected reorders")
+}
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"filevault-backend/internal/errors"
 	"filevault-backend/internal/middleware"
@@ -14,29 +20,42 @@ import (
 )
 
 type FileHandler struct {
-	fileService *services.FileService
-	userService *services.UserService
+	fileService       *services.FileService
+	userService       *services.UserService
+	hotlinkService    *services.HotlinkService
+	enumerationGuard  *services.EnumerationGuard
+	publicCacheMaxAge int
+	// Used by the share page; absolute links are left out when publicBaseURL is empty
+	instanceName  string
+	publicBaseURL string
 }
 
-func NewFileHandler(fileService *services.FileService, userService *services.UserService) *FileHandler {
+func NewFileHandler(fileService *services.FileService, userService *services.UserService, hotlinkService *services.HotlinkService, enumerationGuard *services.EnumerationGuard, publicCacheMaxAge int, instanceName, publicBaseURL string) *FileHandler {
 	return &FileHandler{
-		fileService: fileService,
-		userService: userService,
+		fileService:       fileService,
+		userService:       userService,
+		hotlinkService:    hotlinkService,
+		enumerationGuard:  enumerationGuard,
+		publicCacheMaxAge: publicCacheMaxAge,
+		instanceName:      instanceName,
+		publicBaseURL:     strings.TrimSuffix(publicBaseURL, "/"),
 	}
 }
 
 // GenerateUploadURL godoc
 // @Summary Generate upload URL
-// @Description Generates a presigned URL for file upload
+// @Description Generates a presigned URL for file upload. Content that is already stored is linked without an upload. Storage quota is checked against what the file adds to the user's usage under the server's quota_mode (see /meta): under \"deduplicated\" a copy of content the user already holds adds nothing, so it succeeds even at their quota.
 // @Tags files
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param request body object{filename=string,size=int64,mime_type=string,file_hash=string} true "Upload request"
-// @Success 200 {object} map[string]interface{} "Upload URL and metadata"
+// @Param request body object{filename=string,size=int64,mime_type=string,file_hash=string,secondary_hash=string,hash_mode=string,conflict_policy=string,is_public=boolean,dedup_behavior=string} true "Upload request (file_hash is omitted when hash_mode is \"server\"; conflict_policy is keep_both, rename or replace and defaults to keep_both; is_public defaults to the user's default_visibility preference; dedup_behavior is link, skip or ask and defaults to link)"
+// @Success 200 {object} map[string]interface{} "Upload URL and metadata, or under dedup_behavior skip the user's existing file"
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
-// @Failure 402 {object} map[string]interface{} "Storage quota exceeded"
+// @Failure 402 {object} map[string]interface{} "Storage or file count quota exceeded"
+// @Failure 409 {object} map[string]interface{} "File to replace is under legal hold, or under dedup_behavior ask the user's existing_files with this content"
+// @Failure 451 {object} map[string]interface{} "Content is banned"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /files/upload-url [post]
 func (h *FileHandler) GenerateUploadURL(c *gin.Context) {
@@ -47,10 +66,16 @@ func (h *FileHandler) GenerateUploadURL(c *gin.Context) {
 	}
 
 	var req struct {
-		Filename string `json:"filename" binding:"required"`
-		Size     int64  `json:"size" binding:"required"`
-		MimeType string `json:"mime_type"`
-		FileHash string `json:"file_hash" binding:"required"`
+		Filename      string            `json:"filename" binding:"required"`
+		Size          int64             `json:"size" binding:"required,min=1"`
+		MimeType      string            `json:"mime_type"`
+		FileHash      string            `json:"file_hash"`
+		SecondaryHash string            `json:"secondary_hash"`
+		HashMode      services.HashMode `json:"hash_mode"`
+		// Apply to duplicates linked right away and are carried to completion otherwise
+		ConflictPolicy string `json:"conflict_policy"`
+		IsPublic       *bool  `json:"is_public"`
+		DedupBehavior  string `json:"dedup_behavior"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -58,42 +83,91 @@ func (h *FileHandler) GenerateUploadURL(c *gin.Context) {
 		return
 	}
 
+	if req.HashMode != "" && req.HashMode != services.HashModeClient && req.HashMode != services.HashModeServer {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("hash_mode must be \"client\" or \"server\""))
+		return
+	}
+	if req.HashMode != services.HashModeServer && req.FileHash == "" {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrRequiredField, "file_hash is required unless hash_mode is \"server\""))
+		return
+	}
+	policy, err := services.ParseConflictPolicy(req.ConflictPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	dedupBehavior, err := services.ParseDedupBehavior(req.DedupBehavior)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	opts := services.UploadOptions{ConflictPolicy: policy, IsPublic: req.IsPublic, DedupBehavior: dedupBehavior}
+
 	// Ensure user exists in database before checking quota
-	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
+	_, err = h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
 		return
 	}
 
-	// Check storage quota
-	if err := h.userService.CheckStorageQuota(user.ID, req.Size); err != nil {
+	// Quotas are checked by the service, which knows what the content would add to the
+	// user's usage
+	var response *services.PresignedUploadResponse
+	if req.HashMode == services.HashModeServer {
+		response, err = h.fileService.GenerateServerHashUploadURL(c.Request.Context(), user.ID, req.Filename, req.Size, req.MimeType, opts)
+	} else {
+		response, err = h.fileService.GeneratePresignedUploadURL(c.Request.Context(), user.ID, req.Filename, req.FileHash, req.SecondaryHash, req.Size, req.MimeType, opts)
+	}
+	if stderrors.Is(err, services.ErrFileCountQuotaExceeded) {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrFileCountQuotaExceeded, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrStorageQuotaExceeded) {
 		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrStorageQuotaExceeded, err.Error()))
 		return
 	}
-
-	response, err := h.fileService.GeneratePresignedUploadURL(user.ID, req.Filename, req.FileHash, req.Size, req.MimeType)
+	if stderrors.Is(err, services.ErrHashBanned) {
+		c.JSON(http.StatusUnavailableForLegalReasons, errors.ErrorResponse(errors.ErrContentBanned, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrFileUnderLegalHold) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "File to replace is under legal hold"))
+		return
+	}
+	if stderrors.Is(err, services.ErrFileLocked) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "File to replace is locked"))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to generate upload URL", err.Error()))
 		return
 	}
+	if len(response.ExistingFiles) > 0 {
+		conflict := errors.ErrorResponse(errors.ErrDuplicateUpload, "You already have a file with this content")
+		conflict["existing_files"] = response.ExistingFiles
+		c.JSON(http.StatusConflict, conflict)
+		return
+	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// CompleteUpload godoc
-// @Summary Complete file upload
-// @Description Finalizes file upload after successful upload to storage
+// GenerateUploadPostURL godoc
+// @Summary Generate form upload URL
+// @Description Generates a presigned HTML form POST upload. Submit every returned field with the file as the last form field, then call /files/complete.
 // @Tags files
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param request body object{object_key=string,filename=string,mime_type=string,file_hash=string} true "Complete upload request"
-// @Success 200 {object} map[string]interface{} "Upload completion confirmation"
+// @Param request body object{filename=string,size=int64,mime_type=string,file_hash=string,secondary_hash=string} true "Upload request"
+// @Success 200 {object} services.PresignedPostResponse "Form action URL and fields"
 // @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 402 {object} map[string]interface{} "Storage or file count quota exceeded"
+// @Failure 451 {object} map[string]interface{} "Content is banned"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /files/complete [post]
-func (h *FileHandler) CompleteUpload(c *gin.Context) {
+// @Router /files/upload-post-url [post]
+func (h *FileHandler) GenerateUploadPostURL(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
@@ -101,10 +175,11 @@ func (h *FileHandler) CompleteUpload(c *gin.Context) {
 	}
 
 	var req struct {
-		ObjectKey string `json:"object_key" binding:"required"`
-		Filename  string `json:"filename" binding:"required"`
-		MimeType  string `json:"mime_type"`
-		FileHash  string `json:"file_hash" binding:"required"`
+		Filename      string `json:"filename" binding:"required"`
+		Size          int64  `json:"size" binding:"required,min=1"`
+		MimeType      string `json:"mime_type" binding:"required"`
+		FileHash      string `json:"file_hash" binding:"required"`
+		SecondaryHash string `json:"secondary_hash"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -112,237 +187,291 @@ func (h *FileHandler) CompleteUpload(c *gin.Context) {
 		return
 	}
 
-	userFile, err := h.fileService.CompleteFileUpload(user.ID, req.ObjectKey, req.Filename, req.MimeType, req.FileHash)
+	// The signed policy pins Content-Type to this value, so it must be a valid media type
+	if _, _, err := mime.ParseMediaType(req.MimeType); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("mime_type is not a valid media type", err.Error()))
+		return
+	}
+
+	// Ensure user exists in database before checking quota
+	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to complete upload", err.Error()))
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "File uploaded successfully",
-		"file_id": userFile.ID,
-	})
+	response, err := h.fileService.GeneratePresignedPostURL(c.Request.Context(), user.ID, req.Filename, req.FileHash, req.SecondaryHash, req.Size, req.MimeType)
+	if stderrors.Is(err, services.ErrFileCountQuotaExceeded) {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrFileCountQuotaExceeded, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrStorageQuotaExceeded) {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrStorageQuotaExceeded, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrHashBanned) {
+		c.JSON(http.StatusUnavailableForLegalReasons, errors.ErrorResponse(errors.ErrContentBanned, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to generate upload URL", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-// ListFiles godoc
-// @Summary List user files
-// @Description Returns a paginated list of user's files
+// CompleteUpload godoc
+// @Summary Complete file upload
+// @Description Finalizes file upload after successful upload to storage
 // @Tags files
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param page query int false "Page number" default(1)
-// @Param limit query int false "Items per page" default(20) maximum(100)
-// @Success 200 {object} map[string]interface{} "List of files with pagination"
+// @Param request body object{upload_token=string,filename=string,mime_type=string,upload_id=string,conflict_policy=string,is_public=boolean} true "Complete upload request: the upload_token from the upload URL response, or only upload_id for server hash mode. conflict_policy and is_public override those given for the upload URL."
+// @Success 200 {object} map[string]interface{} "Upload completion confirmation with the filename the file was stored under, and its share link when public"
+// @Failure 400 {object} map[string]interface{} "Bad request"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Upload session not found or expired"
+// @Failure 409 {object} map[string]interface{} "File to replace is under legal hold"
+// @Failure 451 {object} map[string]interface{} "Content is banned"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /files [get]
-func (h *FileHandler) ListFiles(c *gin.Context) {
+// @Router /files/complete [post]
+func (h *FileHandler) CompleteUpload(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
 		return
 	}
 
-	// Ensure user exists in database
-	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
-		return
+	var req struct {
+		UploadToken string     `json:"upload_token"`
+		Filename    string     `json:"filename"`
+		MimeType    string     `json:"mime_type"`
+		UploadID    *uuid.UUID `json:"upload_id"`
+		// Unset options keep those given when the upload URL was issued
+		ConflictPolicy services.ConflictPolicy `json:"conflict_policy"`
+		IsPublic       *bool                   `json:"is_public"`
 	}
 
-	// Parse pagination parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-
-	// Validate pagination parameters
-	if page < 1 {
-		page = 1
-	}
-	if limit < 1 {
-		limit = 20
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
 	}
-	if limit > 100 {
-		limit = 100 // Max 100 items per page
+	if req.ConflictPolicy != "" {
+		if _, err := services.ParseConflictPolicy(string(req.ConflictPolicy)); err != nil {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+			return
+		}
 	}
 
-	offset := (page - 1) * limit
+	opts := services.UploadOptions{ConflictPolicy: req.ConflictPolicy, IsPublic: req.IsPublic}
 
-	files, total, err := h.fileService.GetUserFiles(user.ID, offset, limit)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get files", err.Error()))
+	// Server hash mode: the upload session already knows the filename and type
+	if req.UploadID != nil {
+		h.completeServerHashUpload(c, user.ID, *req.UploadID, opts)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"files":    files,
-		"total":    total,
-		"has_more": int64(offset+limit) < total,
-		"pagination": gin.H{
-			"page":        page,
-			"limit":       limit,
-			"total":       total,
-			"total_pages": (total + int64(limit) - 1) / int64(limit),
-		},
-	})
-}
-
-// DownloadFile godoc
-// @Summary Download file
-// @Description Generates a download URL for user's file
-// @Tags files
-// @Accept json
-// @Produce json
-// @Security BearerAuth
-// @Param id path string true "File ID"
-// @Success 200 {object} map[string]interface{} "Download URL"
-// @Failure 400 {object} map[string]interface{} "Invalid file ID"
-// @Failure 401 {object} map[string]interface{} "Unauthorized"
-// @Failure 404 {object} map[string]interface{} "File not found"
-// @Router /files/{id}/download [get]
-func (h *FileHandler) DownloadFile(c *gin.Context) {
-	user := middleware.GetUserFromContext(c)
-	if user == nil {
-		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+	if req.UploadToken == "" || req.Filename == "" {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrRequiredField, "upload_token and filename are required"))
 		return
 	}
 
-	fileID, err := uuid.Parse(c.Param("id"))
+	userFile, dedup, err := h.fileService.CompleteSignedUpload(c.Request.Context(), user.ID, req.UploadToken, req.Filename, req.MimeType, opts)
+	if stderrors.Is(err, services.ErrInvalidUploadToken) {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrUploadTokenInvalid, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrUploadSizeMismatch) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrHashMismatch) {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrHashMismatch, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrFileUnderLegalHold) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "File to replace is under legal hold"))
+		return
+	}
+	if stderrors.Is(err, services.ErrFileLocked) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "File to replace is locked"))
+		return
+	}
+	if stderrors.Is(err, services.ErrHashBanned) {
+		c.JSON(http.StatusUnavailableForLegalReasons, errors.ErrorResponse(errors.ErrContentBanned, err.Error()))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to complete upload", err.Error()))
 		return
 	}
 
-	downloadURL, err := h.fileService.GetFileDownloadURL(user.ID, fileID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+	response := gin.H{
+		"message":   "File uploaded successfully",
+		"file_id":   userFile.ID,
+		"filename":  userFile.Filename,
+		"is_public": userFile.IsPublic,
+	}
+	if shareID := h.fileService.ShareIDFor(*userFile); shareID != "" {
+		response["share_link"] = "/share/" + shareID
+	}
+	if dedup != nil {
+		response["dedup"] = dedup
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *FileHandler) completeServerHashUpload(c *gin.Context, userID string, uploadID uuid.UUID, opts services.UploadOptions) {
+	result, err := h.fileService.CompleteServerHashUpload(c.Request.Context(), userID, uploadID, opts)
+	h.writeServerHashUploadResult(c, result, err)
+}
+
+func (h *FileHandler) writeServerHashUploadResult(c *gin.Context, result *services.ServerHashUploadResult, err error) {
+	switch {
+	case stderrors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, err.Error()))
+		return
+	case stderrors.Is(err, services.ErrUploadSizeMismatch):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	case stderrors.Is(err, services.ErrFileUnderLegalHold):
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "File to replace is under legal hold"))
+		return
+	case stderrors.Is(err, services.ErrFileLocked):
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "File to replace is locked"))
+		return
+	case stderrors.Is(err, services.ErrHashBanned):
+		c.JSON(http.StatusUnavailableForLegalReasons, errors.ErrorResponse(errors.ErrContentBanned, err.Error()))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to complete upload", err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"download_url": downloadURL,
-	})
+	response := gin.H{
+		"message":   "File uploaded successfully",
+		"file_id":   result.File.ID,
+		"filename":  result.File.Filename,
+		"file_hash": result.FileHash,
+		"path":      result.Path,
+		"is_public": result.File.IsPublic,
+	}
+	if shareID := h.fileService.ShareIDFor(*result.File); shareID != "" {
+		response["share_link"] = "/share/" + shareID
+	}
+	if result.Dedup != nil {
+		response["dedup"] = result.Dedup
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-// DeleteFile godoc
-// @Summary Delete file
-// @Description Deletes a user's file
+// GetUploadSession godoc
+// @Summary Get upload session status
+// @Description Reports whether storage has received a server-hashed upload. Sessions whose client never calls complete are completed automatically once storage reports the object.
 // @Tags files
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "File ID"
-// @Success 200 {object} map[string]interface{} "File deleted successfully"
-// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Param id path string true "Upload session ID"
+// @Success 200 {object} models.UploadSession "Upload session"
+// @Failure 400 {object} map[string]interface{} "Invalid upload ID"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
-// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 404 {object} map[string]interface{} "Upload session not found"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /files/{id} [delete]
-func (h *FileHandler) DeleteFile(c *gin.Context) {
+// @Router /files/upload-sessions/{id} [get]
+func (h *FileHandler) GetUploadSession(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
 		return
 	}
 
-	fileID, err := uuid.Parse(c.Param("id"))
+	uploadID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid upload ID"))
 		return
 	}
 
-	if err := h.fileService.DeleteUserFile(user.ID, fileID); err != nil {
-		// Check if it's a "not found" error
-		if strings.Contains(err.Error(), "not found") {
-			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
-		} else {
-			c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileDeleteFailed, "Failed to delete file", err.Error()))
-		}
+	session, err := h.fileService.GetUploadSession(c.Request.Context(), user.ID, uploadID)
+	if stderrors.Is(err, services.ErrUploadSessionNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, err.Error()))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "File deleted successfully",
-	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get upload session", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
 }
 
-// TogglePublic godoc
-// @Summary Toggle file public status
-// @Description Toggles file public status and manages share links
+// RenewUploadSession godoc
+// @Summary Renew upload URL
+// @Description Issues a fresh upload URL for a pending server-hashed upload and extends its deadline, up to UPLOAD_SESSION_MAX_LIFETIME_SECONDS after it was prepared. The deadline moves at most once a minute; renewing again sooner returns a new URL with the same expiry.
 // @Tags files
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "File ID"
-// @Success 200 {object} map[string]interface{} "File public status updated"
-// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Param session_id path string true "Upload session ID"
+// @Success 200 {object} services.RenewedUploadURL "Renewed upload URL"
+// @Failure 400 {object} map[string]interface{} "Invalid upload ID"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
-// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 404 {object} map[string]interface{} "Upload session not found, already uploaded or expired"
+// @Failure 409 {object} map[string]interface{} "Upload reached its maximum lifetime"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /files/{id}/public [patch]
-func (h *FileHandler) TogglePublic(c *gin.Context) {
+// @Router /files/uploads/{session_id}/renew [post]
+func (h *FileHandler) RenewUploadSession(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
 		return
 	}
 
-	fileID, err := uuid.Parse(c.Param("id"))
+	uploadID, err := uuid.Parse(c.Param("session_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid upload ID"))
 		return
 	}
 
-	// First toggle the public status
-	if err := h.fileService.ToggleFilePublic(user.ID, fileID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
-		} else {
-			c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileToggleFailed, "Failed to toggle file public status", err.Error()))
-		}
-		return
-	}
-
-	// Check if file is now public and create/get share link
-	var shareLink string
-	var isPublic bool
-
-	// Get updated file status
-	files, _, err := h.fileService.GetUserFiles(user.ID, 0, 1000) // Get all files to find this one
-	if err == nil {
-		for _, file := range files {
-			if file.ID.String() == fileID.String() {
-				isPublic = file.IsPublic
-				break
-			}
-		}
-	}
-
-	if isPublic {
-		// File is now public, create or get share link
-		shareID, err := h.fileService.CreateOrGetShareLink(user.ID, fileID)
-		if err == nil {
-			shareLink = "/share/" + shareID
-		}
-		// If share link creation fails, we still return success for the toggle
-	} else {
-		// File is now private, delete share link
-		h.fileService.DeleteShareLink(user.ID, fileID)
-	}
-
-	response := gin.H{
-		"message":   "File public status updated",
-		"is_public": isPublic,
-	}
+	renewed, err := h.fileService.RenewUploadSession(c.Request.Context(), user.ID, uploadID)
+	h.writeRenewedUpload(c, renewed, err)
+}
 
-	if shareLink != "" {
-		response["share_link"] = shareLink
+// writeRenewedUpload writes the outcome of renewing an upload URL
+func (h *FileHandler) writeRenewedUpload(c *gin.Context, renewed *services.RenewedUploadURL, err error) {
+	switch {
+	case stderrors.Is(err, services.ErrBatchSessionNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrBatchNotFound, "Batch not found or expired"))
+	case stderrors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, err.Error()))
+	case stderrors.Is(err, services.ErrUploadLifetimeExceeded):
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrUploadExpired, err.Error()))
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to renew upload URL", err.Error()))
+	default:
+		c.JSON(http.StatusOK, renewed)
 	}
-
-	c.JSON(http.StatusOK, response)
 }
 
-// BatchPrepareUpload handles batch file upload preparation
-func (h *FileHandler) BatchPrepareUpload(c *gin.Context) {
+// StartMultipartUpload godoc
+// @Summary Start multipart upload
+// @Description Starts a server-hashed upload sent in parts, for files too large for a single PUT. Request a URL for each part, report each part once uploaded, then complete.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{filename=string,size=int64,mime_type=string,total_parts=int} true "Multipart upload request"
+// @Success 200 {object} services.MultipartUploadResponse "Multipart upload session"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 402 {object} map[string]interface{} "Storage or file count quota exceeded"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/multipart [post]
+func (h *FileHandler) StartMultipartUpload(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
@@ -350,12 +479,10 @@ func (h *FileHandler) BatchPrepareUpload(c *gin.Context) {
 	}
 
 	var req struct {
-		Files []struct {
-			Filename string `json:"filename" binding:"required"`
-			Size     int64  `json:"size" binding:"required"`
-			MimeType string `json:"mime_type"`
-			FileHash string `json:"file_hash" binding:"required"`
-		} `json:"files" binding:"required,min=1,max=10"`
+		Filename   string `json:"filename" binding:"required"`
+		Size       int64  `json:"size" binding:"required,min=1"`
+		MimeType   string `json:"mime_type"`
+		TotalParts int    `json:"total_parts" binding:"required,min=1,max=10000"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -370,63 +497,1106 @@ func (h *FileHandler) BatchPrepareUpload(c *gin.Context) {
 		return
 	}
 
-	// Convert request struct to service struct
-	files := make([]services.BatchFileRequest, len(req.Files))
-	for i, f := range req.Files {
-		files[i] = services.BatchFileRequest{
-			Filename: f.Filename,
-			Size:     f.Size,
-			MimeType: f.MimeType,
-			FileHash: f.FileHash,
-		}
+	if err := h.userService.CheckStorageQuota(user.ID, req.Size); err != nil {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrStorageQuotaExceeded, err.Error()))
+		return
 	}
 
-	response, err := h.fileService.BatchPrepareUpload(user.ID, files)
+	response, err := h.fileService.StartMultipartUpload(c.Request.Context(), user.ID, req.Filename, req.Size, req.MimeType, req.TotalParts)
+	if stderrors.Is(err, services.ErrFileCountQuotaExceeded) {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrFileCountQuotaExceeded, err.Error()))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to prepare batch upload", err.Error()))
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to start multipart upload", err.Error()))
 		return
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// BatchCompleteUpload handles batch file upload completion
-func (h *FileHandler) BatchCompleteUpload(c *gin.Context) {
+// GetMultipartPartURL godoc
+// @Summary Get multipart part upload URL
+// @Description Returns a presigned URL, valid for 15 minutes, for uploading one part of a multipart upload
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param upload_id path string true "Upload session ID"
+// @Param number path int true "Part number (1 to total_parts)"
+// @Success 200 {object} map[string]interface{} "Part upload URL"
+// @Failure 400 {object} map[string]interface{} "Invalid upload ID or part number"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Upload session not found or expired"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/multipart/{upload_id}/part/{number} [get]
+func (h *FileHandler) GetMultipartPartURL(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
 		return
 	}
 
-	var req struct {
-		BatchID          string `json:"batch_id" binding:"required"`
-		CompletedUploads []struct {
-			UploadID string `json:"upload_id" binding:"required"`
-			FileHash string `json:"file_hash" binding:"required"`
-			Filename string `json:"filename" binding:"required"`
-			MimeType string `json:"mime_type"`
-		} `json:"completed_uploads" binding:"required"`
+	uploadID, partNumber, ok := multipartPartParams(c)
+	if !ok {
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+	url, err := h.fileService.GetMultipartPartURL(c.Request.Context(), user.ID, uploadID, partNumber)
+	if !writeMultipartError(c, err) {
 		return
 	}
 
-	// Convert request struct to service struct
-	completedUploads := make([]services.BatchCompletedUpload, len(req.CompletedUploads))
-	for i, upload := range req.CompletedUploads {
-		completedUploads[i] = services.BatchCompletedUpload{
-			UploadID: upload.UploadID,
-			FileHash: upload.FileHash,
-			Filename: upload.Filename,
-			MimeType: upload.MimeType,
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"part_number": partNumber,
+		"upload_url":  url,
+		"expires_at":  time.Now().UTC().Add(15 * time.Minute),
+	})
+}
+
+// ReportMultipartPart godoc
+// @Summary Report uploaded multipart part
+// @Description Records that a part of a multipart upload finished uploading. Completion requires every part to be reported.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param upload_id path string true "Upload session ID"
+// @Param number path int true "Part number (1 to total_parts)"
+// @Success 200 {object} map[string]interface{} "Part recorded"
+// @Failure 400 {object} map[string]interface{} "Invalid upload ID or part number"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Upload session not found or expired"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/multipart/{upload_id}/part/{number} [post]
+func (h *FileHandler) ReportMultipartPart(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	uploadID, partNumber, ok := multipartPartParams(c)
+	if !ok {
+		return
+	}
+
+	err := h.fileService.ReportMultipartPart(c.Request.Context(), user.ID, uploadID, partNumber)
+	if !writeMultipartError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Part recorded",
+		"part_number": partNumber,
+	})
+}
+
+// CompleteMultipartUpload godoc
+// @Summary Complete multipart upload
+// @Description Assembles the parts of a multipart upload once all of them are reported and stored, then hashes and stores the file
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param upload_id path string true "Upload session ID"
+// @Success 200 {object} map[string]interface{} "Upload completion confirmation"
+// @Failure 400 {object} map[string]interface{} "Invalid upload ID, or parts that don't match the declared count and size"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Upload session not found or expired"
+// @Failure 409 {object} map[string]interface{} "Parts missing, or the file to replace is under legal hold"
+// @Failure 451 {object} map[string]interface{} "Content is banned"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/multipart/{upload_id}/complete [post]
+func (h *FileHandler) CompleteMultipartUpload(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid upload ID"))
+		return
+	}
+
+	result, err := h.fileService.CompleteMultipartUpload(c.Request.Context(), user.ID, uploadID)
+	if stderrors.Is(err, services.ErrMultipartIncomplete) {
+		c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileUploadFailed, err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrMultipartMismatch) || stderrors.Is(err, services.ErrUploadSizeMismatch) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	h.writeServerHashUploadResult(c, result, err)
+}
+
+func multipartPartParams(c *gin.Context) (uuid.UUID, int, bool) {
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid upload ID"))
+		return uuid.Nil, 0, false
+	}
+	partNumber, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid part number"))
+		return uuid.Nil, 0, false
+	}
+	return uploadID, partNumber, true
+}
+
+// writeMultipartError responds to a failed multipart call and reports whether err was nil
+func writeMultipartError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case stderrors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, err.Error()))
+	case stderrors.Is(err, services.ErrInvalidPartNumber):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Multipart upload failed", err.Error()))
+	}
+	return false
+}
+
+// ListFiles godoc
+// @Summary List user files
+// @Description Returns a paginated list of user's files, newest first. Sorting by last_accessed lists the files untouched the longest first, treating an upload as an access.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20) maximum(100)
+// @Param category query string false "Filter by MIME category" Enums(image, video, audio, document, archive, other)
+// @Param sort_by query string false "Listing order" Enums(uploaded, last_accessed) default(uploaded)
+// @Param not_accessed_since query string false "Only files not downloaded, streamed or uploaded since this RFC3339 time"
+// @Param metadata.{key} query string false "Only files whose metadata has this value for the key; repeat with other keys to require several"
+// @Success 200 {object} map[string]interface{} "List of files with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid category, sort, timestamp, metadata key or pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files [get]
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	// Ensure user exists in database
+	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	paging, ok := parsePagination(c, userPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	var options services.FileListOptions
+	if category := c.Query("category"); category != "" {
+		if options.Category, ok = services.ParseMimeCategory(category); !ok {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid category. Must be one of image, video, audio, document, archive, other"))
+			return
+		}
+	}
+	if options.SortBy, err = services.ParseFileSort(c.Query("sort_by")); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if sinceParam := c.Query("not_accessed_since"); sinceParam != "" {
+		since, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid not_accessed_since parameter, expected RFC3339 timestamp", err.Error()))
+			return
+		}
+		options.NotAccessedSince = &since
+	}
+	for param, values := range c.Request.URL.Query() {
+		if key, ok := strings.CutPrefix(param, "metadata."); ok {
+			if options.Metadata == nil {
+				options.Metadata = make(map[string]string)
+			}
+			options.Metadata[key] = values[0]
+		}
+	}
+
+	files, total, err := h.fileService.GetUserFiles(c.Request.Context(), user.ID, options, offset, limit)
+	if stderrors.Is(err, services.ErrInvalidMetadata) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get files", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files":      files,
+		"total":      total,
+		"has_more":   int64(offset+limit) < total,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// SearchFiles godoc
+// @Summary Search user files
+// @Description Searches the user's files by name. With fuzzy=true, misspelled queries still match similar filenames.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Search query"
+// @Param fuzzy query bool false "Use typo-tolerant matching"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20) maximum(100)
+// @Success 200 {object} map[string]interface{} "Matching files with pagination"
+// @Failure 400 {object} map[string]interface{} "Missing query or invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/search [get]
+func (h *FileHandler) SearchFiles(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrRequiredField, "Search query q is required"))
+		return
+	}
+
+	paging, ok := parsePagination(c, userPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	var files []services.UserFileResponse
+	var total int64
+	var err error
+	if c.Query("fuzzy") == "true" {
+		files, total, err = h.fileService.FuzzySearchFiles(user.ID, query, offset, limit)
+	} else {
+		files, total, err = h.fileService.SearchFiles(user.ID, query, offset, limit)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to search files", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files":      files,
+		"total":      total,
+		"has_more":   int64(offset+limit) < total,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// GetFile godoc
+// @Summary Get file
+// @Description Returns the details of one of the user's files, or of another user's public file
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Success 200 {object} services.UserFileResponse "File details"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id} [get]
+func (h *FileHandler) GetFile(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	file, err := h.fileService.GetVisibleFile(user.ID, fileID)
+	if stderrors.Is(err, services.ErrUserFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get file", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, file)
+}
+
+// DownloadFile godoc
+// @Summary Download file
+// @Description Generates a download URL for user's file
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param ttl query int false "Seconds the URL of a private file stays valid, capped at the server maximum (default: server setting, longer for audio and video)"
+// @Success 200 {object} map[string]interface{} "Download URL"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID or TTL"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Router /files/{id}/download [get]
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var ttlSeconds int
+	if raw := c.Query("ttl"); raw != "" {
+		ttlSeconds, err = strconv.Atoi(raw)
+		if err != nil || ttlSeconds < 1 {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("ttl must be a positive number of seconds"))
+			return
+		}
+	}
+
+	// Private files get presigned URLs that must never be cached
+	c.Header("Cache-Control", "no-store")
+
+	downloadURL, err := h.fileService.GetFileDownloadURL(c.Request.Context(), user.ID, fileID, ttlSeconds)
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "Monthly bandwidth quota exceeded"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"download_url": downloadURL,
+	})
+}
+
+// GetThumbnail godoc
+// @Summary Get file thumbnail
+// @Description Generates a URL for a preview thumbnail (JPEG, at most 256px on each side) of one of the user's images. Thumbnails are generated in the background after upload when the server has them enabled.
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Success 200 {object} map[string]interface{} "Thumbnail URL"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found, or it has no thumbnail (yet)"
+// @Router /files/{id}/thumbnail [get]
+func (h *FileHandler) GetThumbnail(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+
+	thumbnailURL, err := h.fileService.GetThumbnailURL(c.Request.Context(), user.ID, fileID)
+	if stderrors.Is(err, services.ErrThumbnailNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrThumbnailNotFound, "File has no thumbnail"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"thumbnail_url": thumbnailURL,
+	})
+}
+
+// ListSharedWithMe godoc
+// @Summary List files shared with me
+// @Description Returns files other users have granted the current user access to. Shared files can be downloaded but not deleted or re-shared.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Items per page (default: 20, max: 100)"
+// @Success 200 {object} map[string]interface{} "List of shared files"
+// @Failure 400 {object} map[string]interface{} "Invalid pagination parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/shared-with-me [get]
+func (h *FileHandler) ListSharedWithMe(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	paging, ok := parsePagination(c, userPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	files, total, err := h.fileService.GetSharedWithMeFiles(user.ID, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get shared files", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files":      files,
+		"total":      total,
+		"has_more":   int64(offset+limit) < total,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// GrantFileAccess godoc
+// @Summary Share file with a user
+// @Description Lets another user download one of your files. They can't delete or re-share it.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param request body object{user_id=string} true "User to share with"
+// @Success 200 {object} models.FileAccess "Access grant"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID or user"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Router /files/{id}/access [post]
+func (h *FileHandler) GrantFileAccess(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var req struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	grant, err := h.fileService.GrantFileAccess(user.ID, fileID, req.UserID)
+	if stderrors.Is(err, services.ErrInvalidGrantee) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+
+	c.JSON(http.StatusOK, grant)
+}
+
+// RevokeFileAccess godoc
+// @Summary Stop sharing file with a user
+// @Description Revokes a user's access to one of your files
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param user_id path string true "Grantee user ID"
+// @Success 200 {object} map[string]interface{} "Access revoked"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Access grant not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id}/access/{user_id} [delete]
+func (h *FileHandler) RevokeFileAccess(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	err = h.fileService.RevokeFileAccess(user.ID, fileID, c.Param("user_id"))
+	if stderrors.Is(err, services.ErrFileAccessNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to revoke access", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Access revoked",
+	})
+}
+
+// DeleteFile godoc
+// @Summary Delete file
+// @Description Deletes a user's file
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Success 200 {object} map[string]interface{} "File deleted successfully"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 409 {object} map[string]interface{} "File is locked or under legal hold"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id} [delete]
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	if err := h.fileService.DeleteUserFile(c.Request.Context(), user.ID, fileID); err != nil {
+		if stderrors.Is(err, services.ErrFileUnderLegalHold) {
+			c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLegalHold, "File is under legal hold and can't be deleted"))
+		} else if stderrors.Is(err, services.ErrFileLocked) {
+			c.JSON(http.StatusConflict, errors.ErrorResponse(errors.ErrFileLocked, "File is locked; unlock it before deleting"))
+		} else if stderrors.Is(err, services.ErrUserFileNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		} else {
+			c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileDeleteFailed, "Failed to delete file", err.Error()))
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File deleted successfully",
+	})
+}
+
+// SetFileLock godoc
+// @Summary Lock or unlock a file
+// @Description Locked files can't be deleted or replaced by an upload with conflict_policy replace until they're unlocked
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param request body object{locked=boolean} true "Lock request"
+// @Success 200 {object} map[string]interface{} "File lock updated"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID or request body"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id}/lock [patch]
+func (h *FileHandler) SetFileLock(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var req struct {
+		Locked *bool `json:"locked" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	err = h.fileService.SetFileLock(user.ID, fileID, *req.Locked)
+	if stderrors.Is(err, services.ErrUserFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to update file lock", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File lock updated",
+		"file_id": fileID,
+		"locked":  *req.Locked,
+	})
+}
+
+// File metadata updates are small JSON objects; anything larger is rejected unread
+const maxMetadataRequestBytes = 8 << 10
+
+// UpdateFileMetadata godoc
+// @Summary Update file metadata
+// @Description Merges key/value pairs into a file's custom metadata. Keys set to null are removed and keys not mentioned are left as they are. Keys are 1-64 letters, digits, underscores or hyphens, values are strings, and the stored metadata is capped at 4 KB.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param request body map[string]string true "Metadata to set, with null values for keys to remove"
+// @Success 200 {object} map[string]interface{} "Updated metadata"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID, key, value or size"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 413 {object} map[string]interface{} "Request body too large"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id}/metadata [patch]
+func (h *FileHandler) UpdateFileMetadata(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxMetadataRequestBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+	if len(body) > maxMetadataRequestBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, errors.ValidationErrorResponse("Request body too large"))
+		return
+	}
+
+	// Values other than strings and null fail to decode
+	var update map[string]*string
+	if err := json.Unmarshal(body, &update); err != nil || update == nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Metadata must be a JSON object of string or null values"))
+		return
+	}
+
+	metadata, err := h.fileService.UpdateFileMetadata(user.ID, fileID, update)
+	if stderrors.Is(err, services.ErrInvalidMetadata) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if stderrors.Is(err, services.ErrUserFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to update file metadata", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":  fileID,
+		"metadata": metadata,
+	})
+}
+
+// TogglePublic godoc
+// @Summary Toggle file public status
+// @Description Toggles file public status and manages share links. When making a file public, public_until schedules it to go private again; any later toggle cancels the schedule.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param request body object{public_until=string} false "Optional RFC 3339 time to make the file private again"
+// @Success 200 {object} map[string]interface{} "File public status updated"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID or public_until"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id}/public [patch]
+func (h *FileHandler) TogglePublic(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	var req struct {
+		PublicUntil *time.Time `json:"public_until"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !stderrors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	// First toggle the public status
+	if err := h.fileService.ToggleFilePublic(c.Request.Context(), user.ID, fileID, req.PublicUntil); err != nil {
+		if stderrors.Is(err, services.ErrInvalidPublicUntil) {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		} else if stderrors.Is(err, services.ErrUserFileNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		} else {
+			c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileToggleFailed, "Failed to toggle file public status", err.Error()))
+		}
+		return
+	}
+
+	// Check if file is now public and create/get share link
+	var shareLink string
+	var isPublic bool
+	var publicUntil *time.Time
+
+	// Get updated file status
+	if file, err := h.fileService.GetUserFile(c.Request.Context(), user.ID, fileID); err == nil {
+		isPublic = file.IsPublic
+		publicUntil = file.PublicUntil
+	}
+
+	if isPublic {
+		// File is now public, create or get share link
+		shareID, err := h.fileService.CreateOrGetShareLink(user.ID, fileID)
+		if err == nil {
+			shareLink = "/share/" + shareID
+		}
+		// If share link creation fails, we still return success for the toggle
+	} else {
+		// File is now private, delete share link
+		h.fileService.DeleteShareLink(user.ID, fileID)
+	}
+
+	response := gin.H{
+		"message":   "File public status updated",
+		"is_public": isPublic,
+	}
+
+	if shareLink != "" {
+		response["share_link"] = shareLink
+	}
+	if publicUntil != nil {
+		response["public_until"] = publicUntil
+		response["public_seconds_remaining"] = services.PublicSecondsRemaining(publicUntil)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CheckUploadQuota godoc
+// @Summary Preview upload quota usage
+// @Description Reports whether a set of files would fit in the remaining storage quota, excluding content that is already stored. Creates no records or upload URLs.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{files=[]services.QuotaCheckFile} true "Files to check"
+// @Success 200 {object} services.QuotaCheckResult "Quota check result"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/quota-check [post]
+func (h *FileHandler) CheckUploadQuota(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		Files []struct {
+			Size     int64  `json:"size" binding:"min=0"`
+			FileHash string `json:"file_hash" binding:"required"`
+		} `json:"files" binding:"required,min=1,max=1000,dive"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	files := make([]services.QuotaCheckFile, len(req.Files))
+	for i, f := range req.Files {
+		files[i] = services.QuotaCheckFile{Size: f.Size, FileHash: f.FileHash}
+	}
+
+	result, err := h.fileService.CheckUploadQuota(c.Request.Context(), user.ID, files)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to check quota", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CheckHashes godoc
+// @Summary Check which content is already stored
+// @Description Reports for each hash whether matching content is already stored, so sync clients can skip reading and uploading it. Content only counts as stored when the given size matches too. No other details about stored content are returned.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{files=[]services.HashCheckItem} true "Hashes and sizes to check, at most 1000"
+// @Success 200 {object} map[string]interface{} "Hash check results"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/check-hashes [post]
+func (h *FileHandler) CheckHashes(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		Files []struct {
+			FileHash string `json:"file_hash" binding:"required"`
+			Size     int64  `json:"size" binding:"min=0"`
+		} `json:"files" binding:"required,min=1,max=1000,dive"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	items := make([]services.HashCheckItem, len(req.Files))
+	for i, f := range req.Files {
+		items[i] = services.HashCheckItem{FileHash: f.FileHash, Size: f.Size}
+	}
+
+	results, err := h.fileService.CheckHashes(c.Request.Context(), items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to check hashes", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files": results,
+	})
+}
+
+// BatchPrepareUpload handles batch file upload preparation. Sync clients pass the
+// batch_id from a previous page to keep preparing files in the same session.
+// Duplicates of stored content get an upload token but no URL; like uploaded files,
+// they only become files when passed to BatchCompleteUpload.
+func (h *FileHandler) BatchPrepareUpload(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		BatchID string `json:"batch_id"`
+		Files   []struct {
+			Filename      string `json:"filename" binding:"required"`
+			Size          int64  `json:"size" binding:"required"`
+			MimeType      string `json:"mime_type"`
+			FileHash      string `json:"file_hash" binding:"required"`
+			SecondaryHash string `json:"secondary_hash"`
+			RelativePath  string `json:"relative_path"`
+			IsPublic      *bool  `json:"is_public"`
+			DedupBehavior string `json:"dedup_behavior"`
+		} `json:"files" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+	if len(req.Files) > services.MaxBatchFiles {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(fmt.Sprintf("A batch may contain at most %d files", services.MaxBatchFiles)))
+		return
+	}
+
+	// Ensure user exists in database before checking quota
+	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	// Convert request struct to service struct
+	files := make([]services.BatchFileRequest, len(req.Files))
+	for i, f := range req.Files {
+		dedupBehavior, err := services.ParseDedupBehavior(f.DedupBehavior)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(fmt.Sprintf("files[%d]: %s", i, err)))
+			return
+		}
+		files[i] = services.BatchFileRequest{
+			Filename:      f.Filename,
+			Size:          f.Size,
+			MimeType:      f.MimeType,
+			FileHash:      f.FileHash,
+			SecondaryHash: f.SecondaryHash,
+			RelativePath:  f.RelativePath,
+			IsPublic:      f.IsPublic,
+			DedupBehavior: dedupBehavior,
+		}
+	}
+
+	response, err := h.fileService.BatchPrepareUpload(c.Request.Context(), user.ID, req.BatchID, files)
+	if stderrors.Is(err, services.ErrBatchSessionNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrBatchNotFound, "Batch not found or expired"))
+		return
+	}
+	if stderrors.Is(err, services.ErrInvalidRelativePath) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid relative path", err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to prepare batch upload", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// BatchCompleteUpload handles batch file upload completion
+func (h *FileHandler) BatchCompleteUpload(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		BatchID          string `json:"batch_id" binding:"required"`
+		CompletedUploads []struct {
+			UploadToken string `json:"upload_token" binding:"required"`
+			Filename    string `json:"filename" binding:"required"`
+			MimeType    string `json:"mime_type"`
+			IsPublic    *bool  `json:"is_public"`
+		} `json:"completed_uploads" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	// Convert request struct to service struct
+	completedUploads := make([]services.BatchCompletedUpload, len(req.CompletedUploads))
+	for i, upload := range req.CompletedUploads {
+		completedUploads[i] = services.BatchCompletedUpload{
+			UploadToken: upload.UploadToken,
+			Filename:    upload.Filename,
+			MimeType:    upload.MimeType,
+			IsPublic:    upload.IsPublic,
+		}
+	}
+
+	response, err := h.fileService.BatchCompleteUpload(c.Request.Context(), user.ID, req.BatchID, completedUploads)
+	if stderrors.Is(err, services.ErrBatchSessionNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrBatchNotFound, "Batch not found or expired"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to complete batch upload", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RenewBatchUpload godoc
+// @Summary Renew batch upload URL
+// @Description Issues a fresh upload URL for a file prepared in a batch and not yet completed. URLs last up to 15 minutes, capped by the batch's expiry and UPLOAD_SESSION_MAX_LIFETIME_SECONDS after the file was prepared. The upload token from prepare stays valid.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param batch_id path string true "Batch ID"
+// @Param upload_id path string true "Upload ID from batch prepare"
+// @Success 200 {object} services.RenewedUploadURL "Renewed upload URL"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Batch or upload not found, or already completed"
+// @Failure 409 {object} map[string]interface{} "Upload reached its maximum lifetime"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/batch/{batch_id}/uploads/{upload_id}/renew [post]
+func (h *FileHandler) RenewBatchUpload(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
 	}
 
-	response, err := h.fileService.BatchCompleteUpload(user.ID, req.BatchID, completedUploads)
+	renewed, err := h.fileService.RenewBatchUpload(c.Request.Context(), user.ID, c.Param("batch_id"), c.Param("upload_id"))
+	h.writeRenewedUpload(c, renewed, err)
+}
+
+// AbortBatchUpload godoc
+// @Summary Abort batch upload
+// @Description Discards the files prepared in a batch and not yet completed, along with anything already uploaded for them, and releases the batch's reserved quota. Completed files are kept. Aborting a batch whose files were all completed, or one already aborted, changes nothing and returns its state.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param batch_id path string true "Batch ID"
+// @Success 200 {object} services.BatchAbortResponse "Batch state"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Batch not found or expired"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/batch/{batch_id}/abort [post]
+func (h *FileHandler) AbortBatchUpload(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	response, err := h.fileService.AbortBatchUpload(c.Request.Context(), user.ID, c.Param("batch_id"))
+	if stderrors.Is(err, services.ErrBatchSessionNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrBatchNotFound, "Batch not found or expired"))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to complete batch upload", err.Error()))
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to abort batch", err.Error()))
 		return
 	}
 
@@ -435,24 +1605,24 @@ func (h *FileHandler) BatchCompleteUpload(c *gin.Context) {
 
 // GetPublicFile godoc
 // @Summary Get public file info
-// @Description Returns public file information
+// @Description Returns public file information. Missing and private files get the same 404. When PUBLIC_INFO_REQUIRES_SHARE_ID is set, the ID is a share link ID instead of a file ID.
 // @Tags public
 // @Accept json
 // @Produce json
-// @Param id path string true "File ID"
-// @Success 200 {object} map[string]interface{} "Public file information"
+// @Param id path string true "File ID, or share ID when proof of possession is required"
+// @Success 200 {object} services.PublicFileResponse "Public file information"
 // @Failure 400 {object} map[string]interface{} "Invalid file ID"
 // @Failure 404 {object} map[string]interface{} "Public file not found"
+// @Failure 429 {object} map[string]interface{} "Rate limit exceeded"
 // @Router /public/files/{id} [get]
 func (h *FileHandler) GetPublicFile(c *gin.Context) {
-	fileID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
+	fileInfo, err := h.fileService.FindPublicFile(c.Param("id"))
+	if stderrors.Is(err, services.ErrInvalidPublicFileID) {
 		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
 		return
 	}
-
-	fileInfo, err := h.fileService.GetPublicFileInfo(fileID)
 	if err != nil {
+		h.enumerationGuard.RecordMiss(c.ClientIP())
 		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Public file not found"))
 		return
 	}
@@ -470,6 +1640,7 @@ func (h *FileHandler) GetPublicFile(c *gin.Context) {
 // @Success 200 {object} map[string]interface{} "Download URL"
 // @Failure 400 {object} map[string]interface{} "Invalid file ID"
 // @Failure 404 {object} map[string]interface{} "Public file not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
 // @Router /public/files/{id}/download [get]
 func (h *FileHandler) DownloadPublicFile(c *gin.Context) {
 	fileID, err := uuid.Parse(c.Param("id"))
@@ -478,25 +1649,141 @@ func (h *FileHandler) DownloadPublicFile(c *gin.Context) {
 		return
 	}
 
-	downloadURL, err := h.fileService.GetFileDownloadURL("", fileID) // Empty userID for public access
+	// Every call is counted as a download, so caches must not answer it
+	c.Header("Cache-Control", "no-store")
+
+	downloadURL, err := h.fileService.GetFileDownloadURL(c.Request.Context(), "", fileID, 0) // Empty userID for public access
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Public file not found"))
 		return
 	}
 
+	h.fileService.RecordPublicDownload(fileID, c.ClientIP(), c.Request.Referer())
+
 	c.JSON(http.StatusOK, gin.H{
 		"download_url": downloadURL,
 	})
 }
 
+// RawPublicFile godoc
+// @Summary Stream public file content
+// @Description Streams a public file's bytes so images and media can be embedded in other sites. Byte ranges are supported for media seeking, and only the bytes served count against the owner's bandwidth. Responses carry the same cache headers as share links; conditional and HEAD requests don't count a download. Hotlink protection applies.
+// @Tags public
+// @Param id path string true "File ID"
+// @Param download query string false "Set to 1 to save as an attachment instead of displaying inline"
+// @Param Range header string false "Byte range, e.g. bytes=0-1048575"
+// @Success 200 "File content"
+// @Success 206 "Partial file content"
+// @Success 304 "File unchanged"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 403 {object} map[string]interface{} "Hotlinking from this site is not allowed"
+// @Failure 404 {object} map[string]interface{} "Public file not found"
+// @Failure 416 "Range not satisfiable"
+// @Failure 429 {object} map[string]interface{} "Download rate or monthly bandwidth quota exceeded"
+// @Router /public/files/{id}/raw [get]
+func (h *FileHandler) RawPublicFile(c *gin.Context) {
+	// Embeds are fetched from other origins, and players need to read the range headers
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Access-Control-Expose-Headers", "Accept-Ranges, Content-Length, Content-Range, ETag")
+	c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	raw, err := h.fileService.GetRawPublicFile(c.Request.Context(), fileID, c.Query("download") == "1")
+	if err != nil {
+		h.enumerationGuard.RecordMiss(c.ClientIP())
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Public file not found"))
+		return
+	}
+	fileData := raw.File.FileData
+
+	setPublicCacheHeaders(c, *raw.File, h.publicCacheMaxAge)
+	c.Header("Accept-Ranges", "bytes")
+	if notModified(c.Request, *raw.File) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	offset, length, partial, err := parseByteRange(c.GetHeader("Range"), fileData.Size)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", fileData.Size))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	contentType := raw.ContentType
+	status := http.StatusOK
+	// User content is served from our own origin, so never let it run as a page
+	headers := map[string]string{
+		"Content-Disposition":     raw.ContentDisposition,
+		"Content-Security-Policy": "sandbox",
+		"X-Content-Type-Options":  "nosniff",
+	}
+	if partial {
+		status = http.StatusPartialContent
+		headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fileData.Size)
+	}
+
+	if c.Request.Method == http.MethodHead {
+		for key, value := range headers {
+			c.Header(key, value)
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Length", strconv.FormatInt(length, 10))
+		c.Status(status)
+		return
+	}
+
+	content, err := h.fileService.OpenPublicFileRange(c.Request.Context(), raw.File, offset, length)
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	}
+	if stderrors.Is(err, services.ErrProxyBandwidthExceeded) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrProxyBandwidthExceeded, "This file has exceeded its monthly streaming bandwidth; use the download link instead"))
+		return
+	}
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to read file", err.Error()))
+		return
+	}
+	defer content.Close()
+
+	if offset == 0 {
+		h.fileService.RecordPublicDownload(raw.File.ID, c.ClientIP(), c.Request.Referer())
+	}
+
+	c.DataFromReader(status, length, contentType, content, headers)
+	// Count what actually reached the client, which is less if it disconnected
+	h.fileService.RecordProxiedBytes(raw.File.UserID, int64(max(c.Writer.Size(), 0)))
+}
+
 // ShareFileDownload godoc
 // @Summary Download file via share link
-// @Description Handles file downloads via share links with tracking
+// @Description Handles file downloads via share links with tracking. Clients that ask for text/html get a page with Open Graph and Twitter Card tags for link previews instead, unless download is set. Responses carry ETag (the content hash), Last-Modified and a public Cache-Control so a CDN can cache and revalidate them; conditional and HEAD requests are answered without counting a download.
 // @Tags sharing
 // @Param id path string true "Share ID"
+// @Param download query string false "Set to 1 to download even when asking for text/html"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param If-Modified-Since header string false "Last-Modified from a previous response"
+// @Success 200 "Share page, for clients asking for text/html"
 // @Success 302 "Redirect to file download"
+// @Success 304 "File unchanged"
 // @Failure 400 {object} map[string]interface{} "Invalid share ID"
 // @Failure 404 {object} map[string]interface{} "Share link not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
 // @Router /share/{id} [get]
 func (h *FileHandler) ShareFileDownload(c *gin.Context) {
 	shareID := c.Param("id")
@@ -505,20 +1792,179 @@ func (h *FileHandler) ShareFileDownload(c *gin.Context) {
 		return
 	}
 
-	// Get file by share ID and increment download count
-	userFile, err := h.fileService.GetFileByShareID(shareID)
-	if err != nil {
+	userFile, err := h.fileService.GetSharedFile(shareID)
+	if stderrors.Is(err, services.ErrShareLinkNotFound) {
+		c.Header("Cache-Control", "no-store")
 		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Share link not found or file no longer available"))
 		return
 	}
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to look up share link", err.Error()))
+		return
+	}
+
+	setPublicCacheHeaders(c, *userFile, h.publicCacheMaxAge)
+	if notModified(c.Request, *userFile) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
 	// Get actual MinIO URL for redirect
-	downloadURL := h.fileService.GetPublicFileURL(userFile.FileData.MinIOKey)
+	downloadURL, err := h.fileService.GetSharedFileURL(c.Request.Context(), userFile)
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to generate download URL", err.Error()))
+		return
+	}
+
+	if c.Request.Method == http.MethodHead {
+		c.Header("Location", downloadURL)
+		c.Status(http.StatusFound)
+		return
+	}
+
+	// Increment download count
+	err = h.fileService.RecordShareDownload(userFile, shareID)
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	}
+
+	h.fileService.RecordPublicDownload(userFile.ID, c.ClientIP(), c.Request.Referer())
 
 	// Redirect to actual file with 302 (temporary redirect)
 	c.Redirect(http.StatusFound, downloadURL)
 }
 
+// GetPublicStats godoc
+// @Summary Get public file download stats
+// @Description Returns anonymous download analytics for one of the user's files. Private files return empty stats.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Success 200 {object} services.PublicFileStats "Download statistics"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Router /files/{id}/public-stats [get]
+func (h *FileHandler) GetPublicStats(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	stats, err := h.fileService.GetFilePublicStats(user.ID, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// VerifyFile godoc
+// @Summary Verify file integrity
+// @Description Re-reads the stored content of one of the user's files and checks it still matches its SHA-256 hash. Content is read at the integrity scrub rate, and each user may verify a limited number of files per hour.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Success 200 {object} services.IntegrityCheckResult "Verification result"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 429 {object} map[string]interface{} "Too many verifications"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/{id}/verify [post]
+func (h *FileHandler) VerifyFile(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	result, err := h.fileService.VerifyFile(c.Request.Context(), user.ID, fileID)
+	if stderrors.Is(err, services.ErrIntegrityFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	}
+	if stderrors.Is(err, services.ErrVerifyRateLimited) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrRateLimitExceeded, "Too many verifications. Please try again later."))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to verify file", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDownloadTicket godoc
+// @Summary Get download ticket
+// @Description Signs a short-lived ticket that the share landing page appends to a public download URL as t and sig query parameters. Tickets are only issued for paths to files that are shared right now (a share link, a public file or a file in a shared collection), to pages on allowed hosts, and count toward the per-IP download limit.
+// @Tags public
+// @Produce json
+// @Param path query string true "Download path, e.g. /share/abc12345"
+// @Success 200 {object} services.DownloadTicket "Signed ticket"
+// @Failure 400 {object} map[string]interface{} "Invalid path"
+// @Failure 403 {object} map[string]interface{} "Requested from a site that isn't allowed"
+// @Failure 404 {object} map[string]interface{} "Path doesn't lead to a shared file"
+// @Failure 429 {object} map[string]interface{} "Too many downloads"
+// @Router /public/download-ticket [get]
+func (h *FileHandler) GetDownloadTicket(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	// A ticket is as good as a download, so it's held to the same limits
+	if !h.hotlinkService.AllowDownload(c.ClientIP()) {
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrDownloadRateLimited, "Too many downloads. Please slow down."))
+		return
+	}
+	if err := h.hotlinkService.CheckReferrer(c.Request.Referer(), c.GetHeader("Origin")); err != nil {
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrHotlinkForbidden, err.Error()))
+		return
+	}
+
+	path := c.Query("path")
+	ticket, err := h.hotlinkService.IssueTicket(path)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid download path", err.Error()))
+		return
+	}
+
+	err = h.fileService.CheckSharedDownloadPath(c.Request.Context(), path)
+	if stderrors.Is(err, services.ErrDownloadPathNotShared) {
+		h.enumerationGuard.RecordMiss(c.ClientIP())
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or not shared"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to check download path", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, ticket)
+}
+
 // GetShareLink godoc
 // @Summary Get share link
 // @Description Returns the share link for a public file without toggling visibility
@@ -530,6 +1976,7 @@ func (h *FileHandler) ShareFileDownload(c *gin.Context) {
 // @Success 200 {object} map[string]interface{} "Share link"
 // @Failure 400 {object} map[string]interface{} "Invalid file ID or file not public"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "File not found"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /files/{id}/share-link [get]
 func (h *FileHandler) GetShareLink(c *gin.Context) {
@@ -546,21 +1993,17 @@ func (h *FileHandler) GetShareLink(c *gin.Context) {
 	}
 
 	// Verify file exists and is public
-	files, _, err := h.fileService.GetUserFiles(user.ID, 0, 1000) // Get all files to find this one
+	file, err := h.fileService.GetUserFile(c.Request.Context(), user.ID, fileID)
+	if stderrors.Is(err, services.ErrUserFileNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to verify file", err.Error()))
 		return
 	}
 
-	var isPublic bool
-	for _, file := range files {
-		if file.ID.String() == fileID.String() {
-			isPublic = file.IsPublic
-			break
-		}
-	}
-
-	if !isPublic {
+	if !file.IsPublic {
 		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrFileAccessDenied, "File is not public"))
 		return
 	}
@@ -572,7 +2015,184 @@ func (h *FileHandler) GetShareLink(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"share_link": "/share/" + shareID,
+	}
+	if file.PublicUntil != nil {
+		response["public_until"] = file.PublicUntil
+		response["public_seconds_remaining"] = file.PublicSecondsRemaining
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// LookupShareLinks godoc
+// @Summary Look up share links
+// @Description Returns the share link ID of each of the given files, null for files without one. Files that don't exist or belong to someone else are left out. File listings include share_id already; this is for clients that can't use it.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{file_ids=[]string} true "File IDs, at most 200"
+// @Success 200 {object} map[string]interface{} "Share link IDs by file ID"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /files/share-links/lookup [post]
+func (h *FileHandler) LookupShareLinks(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		FileIDs []uuid.UUID `json:"file_ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+	if len(req.FileIDs) > services.MaxShareLinkLookup {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(fmt.Sprintf("At most %d files can be looked up at once", services.MaxShareLinkLookup)))
+		return
+	}
+
+	shareIDs, err := h.fileService.LookupShareIDs(user.ID, req.FileIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to look up share links", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share_links": shareIDs,
+	})
+}
+
+// GetPublicDownloadLink godoc
+// @Summary Get expiring download link
+// @Description Signs a direct /dl link that anyone can use until it expires, whether or not the file is public. Inline links stream the file for display; other links redirect to a download.
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "File ID"
+// @Param expires_in query int false "Seconds until the link expires (default 86400, max 2592000)"
+// @Param filename query string false "Filename to present instead of the stored one"
+// @Param inline query bool false "Display in the browser instead of downloading"
+// @Success 200 {object} services.DownloadLink "Download link"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID or expiry"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Download links are disabled"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Router /files/{id}/public-link [get]
+func (h *FileHandler) GetPublicDownloadLink(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	options := services.DownloadLinkOptions{
+		Filename:          c.Query("filename"),
+		DispositionInline: c.Query("inline") == "true",
+	}
+	if raw := c.Query("expires_in"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("expires_in must be a positive number of seconds"))
+			return
+		}
+		options.ExpiresIn = time.Duration(seconds) * time.Second
+	}
+
+	link, err := h.fileService.GeneratePublicDownloadLink(user.ID, fileID, options)
+	switch {
+	case stderrors.Is(err, services.ErrDownloadLinksDisabled):
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrDownloadLinksDisabled, "Download links are disabled"))
+		return
+	case stderrors.Is(err, services.ErrInvalidDownloadLinkExpiry):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	case err != nil:
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found or access denied"))
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+// ServeDownloadLink godoc
+// @Summary Download via expiring link
+// @Description Verifies a signed download link, then streams the file (inline links) or redirects to storage (attachment links)
+// @Tags public
+// @Param fid query string true "File ID"
+// @Param exp query int true "Expiry as a Unix timestamp"
+// @Param fn query string true "Filename for Content-Disposition"
+// @Param inline query string false "Set to 1 for inline display"
+// @Param sig query string true "Link signature"
+// @Success 200 "File content"
+// @Success 302 "Redirect to file download"
+// @Failure 403 {object} map[string]interface{} "Link invalid, expired or disabled"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Router /dl [get]
+func (h *FileHandler) ServeDownloadLink(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	link, err := h.fileService.ResolveDownloadLink(services.DownloadLinkParams{
+		FileID:    c.Query("fid"),
+		ExpiresAt: c.Query("exp"),
+		Filename:  c.Query("fn"),
+		Inline:    c.Query("inline"),
+		Signature: c.Query("sig"),
+	})
+	switch {
+	case stderrors.Is(err, services.ErrDownloadLinksDisabled):
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrDownloadLinksDisabled, "Download links are disabled"))
+		return
+	case stderrors.Is(err, services.ErrDownloadLinkInvalid):
+		c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrDownloadLinkInvalid, err.Error()))
+		return
+	case stderrors.Is(err, services.ErrBandwidthQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	case err != nil:
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "File not found"))
+		return
+	}
+
+	if !link.Inline {
+		downloadURL, err := h.fileService.DownloadLinkRedirectURL(c.Request.Context(), link)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to generate download URL", err.Error()))
+			return
+		}
+		c.Redirect(http.StatusFound, downloadURL)
+		return
+	}
+
+	content, err := h.fileService.OpenDownloadLink(c.Request.Context(), link)
+	if stderrors.Is(err, services.ErrProxyBandwidthExceeded) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrProxyBandwidthExceeded, "This file has exceeded its monthly streaming bandwidth; use a download link instead"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to read file", err.Error()))
+		return
+	}
+	defer content.Close()
+
+	// User content is served from our own origin, so never let it run as a page
+	c.DataFromReader(http.StatusOK, link.File.FileData.Size, link.ContentType, content, map[string]string{
+		"Content-Disposition":     link.ContentDisposition,
+		"Content-Security-Policy": "sandbox",
+		"X-Content-Type-Options":  "nosniff",
 	})
+	h.fileService.RecordProxiedBytes(link.File.UserID, int64(max(c.Writer.Size(), 0)))
 }
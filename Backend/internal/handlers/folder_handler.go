@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"io"
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// FolderHandler serves synced folders. Folders aren't stored on their own: a folder ID
+// is the folder's path, e.g. photos/2024, base64url encoded without padding.
+type FolderHandler struct {
+	fileService *services.FileService
+}
+
+func NewFolderHandler(fileService *services.FileService) *FolderHandler {
+	return &FolderHandler{
+		fileService: fileService,
+	}
+}
+
+// DownloadFolder godoc
+// @Summary Download folder as zip
+// @Description Streams a zip of the user's files synced under a folder (see relative_path on batch uploads), including subfolders, with their paths kept below the folder. The total size is limited by the server (FOLDER_DOWNLOAD_MAX_MB) and counts toward the user's monthly and proxied bandwidth.
+// @Tags folders
+// @Produce application/zip
+// @Security BearerAuth
+// @Param id path string true "Folder ID: the folder path, e.g. photos/2024, base64url encoded without padding"
+// @Success 200 {file} binary "Zip archive"
+// @Failure 400 {object} map[string]interface{} "Invalid folder ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "No files under the folder"
+// @Failure 422 {object} map[string]interface{} "Folder too large to download as one archive"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /folders/{id}/download [get]
+func (h *FolderHandler) DownloadFolder(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	folderPath, ok := h.parseFolderID(c)
+	if !ok {
+		return
+	}
+
+	archive, err := h.fileService.PrepareFolderDownload(c.Request.Context(), user.ID, folderPath)
+	switch {
+	case stderrors.Is(err, services.ErrFolderNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFolderNotFound, "No files found in this folder"))
+		return
+	case stderrors.Is(err, services.ErrFolderTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, errors.ErrorResponse(errors.ErrFolderTooLarge, "Folder is too large to download as one archive", err.Error()))
+		return
+	case stderrors.Is(err, services.ErrBandwidthQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "Monthly bandwidth quota exceeded"))
+		return
+	case stderrors.Is(err, services.ErrProxyBandwidthExceeded):
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrProxyBandwidthExceeded, "Monthly streaming bandwidth exceeded; download the files individually instead"))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to prepare folder download", err.Error()))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", archive.ContentDisposition)
+	c.Header("Content-Type", "application/zip")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure part way only truncates the archive; the
+	// service logs it
+	h.fileService.WriteFolderArchive(c.Request.Context(), user.ID, archive, c.Writer)
+	h.fileService.RecordProxiedBytes(user.ID, int64(max(c.Writer.Size(), 0)))
+}
+
+// ShareFolder godoc
+// @Summary Share folder
+// @Description Returns the folder's share link, creating it if needed. The link lists every file under the folder, including ones synced later; private files are left out unless include_private is set. Sharing again updates include_private on the existing link.
+// @Tags folders
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Folder ID: the folder path, e.g. photos/2024, base64url encoded without padding"
+// @Param request body object{include_private=bool} false "Share settings"
+// @Success 200 {object} map[string]interface{} "Folder share link"
+// @Failure 400 {object} map[string]interface{} "Invalid folder ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "No files under the folder"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /folders/{id}/share-link [post]
+func (h *FolderHandler) ShareFolder(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	folderPath, ok := h.parseFolderID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IncludePrivate bool `json:"include_private"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !stderrors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	link, err := h.fileService.ShareFolder(c.Request.Context(), user.ID, folderPath, req.IncludePrivate)
+	if stderrors.Is(err, services.ErrFolderNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFolderNotFound, "No files found in this folder"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to share folder", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share_id":        link.ID,
+		"share_url":       "/f/" + link.ID,
+		"path":            link.Path,
+		"include_private": link.IncludePrivate,
+	})
+}
+
+// UnshareFolder godoc
+// @Summary Revoke folder share link
+// @Description Revokes the folder's share link; files shared on their own stay shared
+// @Tags folders
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Folder ID: the folder path, e.g. photos/2024, base64url encoded without padding"
+// @Success 200 {object} map[string]interface{} "Share link revoked"
+// @Failure 400 {object} map[string]interface{} "Invalid folder ID"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Folder isn't shared"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /folders/{id}/share-link [delete]
+func (h *FolderHandler) UnshareFolder(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	folderPath, ok := h.parseFolderID(c)
+	if !ok {
+		return
+	}
+
+	err := h.fileService.UnshareFolder(c.Request.Context(), user.ID, folderPath)
+	if stderrors.Is(err, services.ErrFolderShareNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFolderShareNotFound, "Folder is not shared"))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to revoke folder share link", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Folder share link revoked"})
+}
+
+// GetSharedFolder godoc
+// @Summary View shared folder
+// @Description Lists the files shown by a folder share link, with their paths below the folder and per-file download links
+// @Tags folders
+// @Produce json
+// @Param share_id path string true "Folder share ID"
+// @Success 200 {object} services.SharedFolderResponse "Folder with files"
+// @Failure 404 {object} map[string]interface{} "Folder share not found"
+// @Router /f/{share_id} [get]
+func (h *FolderHandler) GetSharedFolder(c *gin.Context) {
+	folder, err := h.fileService.GetSharedFolder(c.Request.Context(), c.Param("share_id"))
+	if err != nil {
+		h.respondError(c, err, errors.ErrFolderShareNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// DownloadSharedFolderFile godoc
+// @Summary Download file from shared folder
+// @Description Redirects to a short-lived download URL for a file shown by a folder share link
+// @Tags folders
+// @Param share_id path string true "Folder share ID"
+// @Param file_id path string true "File ID"
+// @Success 302 "Redirect to file"
+// @Failure 400 {object} map[string]interface{} "Invalid file ID"
+// @Failure 404 {object} map[string]interface{} "Folder share or file not found"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Router /f/{share_id}/files/{file_id} [get]
+func (h *FolderHandler) DownloadSharedFolderFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrInvalidFileID, "Invalid file ID"))
+		return
+	}
+
+	downloadURL, err := h.fileService.GetFolderFileDownloadURL(c.Request.Context(), c.Param("share_id"), fileID)
+	if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+		c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "This file has exceeded its monthly download bandwidth"))
+		return
+	}
+	if err != nil {
+		h.respondError(c, err, errors.ErrFileNotFound)
+		return
+	}
+
+	c.Redirect(http.StatusFound, downloadURL)
+}
+
+// parseFolderID reads the folder path from the id route parameter, responding with a
+// 400 if it isn't a valid folder ID
+func (h *FolderHandler) parseFolderID(c *gin.Context) (string, bool) {
+	folderPath, err := services.ParseFolderID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid folder ID"))
+		return "", false
+	}
+	return folderPath, true
+}
+
+// respondError maps folder share errors to 404s; anything else is reported with fallbackCode
+func (h *FolderHandler) respondError(c *gin.Context, err error, fallbackCode string) {
+	if stderrors.Is(err, services.ErrFolderShareNotFound) {
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFolderShareNotFound, "Folder share not found"))
+		return
+	}
+	c.JSON(http.StatusNotFound, errors.ErrorResponse(fallbackCode, err.Error()))
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	runner *jobs.Runner
+}
+
+func NewJobHandler(runner *jobs.Runner) *JobHandler {
+	return &JobHandler{
+		runner: runner,
+	}
+}
+
+// GetJobStats godoc
+// @Summary Get background job queues (Admin only)
+// @Description Returns each background job queue's worker count and pending, running and failed jobs, with the most recent failures. Failed jobs are kept for 7 days.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} jobs.Stats "Queue depths and recent failures"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Forbidden - Admin access required"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /admin/jobs [get]
+func (h *JobHandler) GetJobStats(c *gin.Context) {
+	stats, err := h.runner.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get job queues", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Clients and CDNs may cache the meta response this long. Apart from the critical
+// announcement flag it only changes on restart.
+const metaCacheMaxAge = 300
+
+type MetaHandler struct {
+	metaService *services.MetaService
+}
+
+func NewMetaHandler(metaService *services.MetaService) *MetaHandler {
+	return &MetaHandler{
+		metaService: metaService,
+	}
+}
+
+// GetMeta godoc
+// @Summary Get server capabilities
+// @Description Returns this deployment's name, public URL, upload limits, default quota and enabled features, so one frontend build works against differently configured instances
+// @Tags public
+// @Produce json
+// @Success 200 {object} services.ServerMeta "Server capabilities"
+// @Router /meta [get]
+func (h *MetaHandler) GetMeta(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", metaCacheMaxAge))
+	c.JSON(http.StatusOK, h.metaService.GetMeta())
+}
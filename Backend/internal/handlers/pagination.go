@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"filevault-backend/internal/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// paginationDefaults are an endpoint's page size when the client doesn't ask for one
+// and the largest page it serves; larger requests are capped
+type paginationDefaults struct {
+	Limit    int
+	MaxLimit int
+}
+
+var (
+	// userPagination suits listings in the app, which show a screenful at a time
+	userPagination = paginationDefaults{Limit: 20, MaxLimit: 100}
+	// adminPagination suits admin tables
+	adminPagination = paginationDefaults{Limit: 50, MaxLimit: 100}
+)
+
+// pagination is a validated page request
+type pagination struct {
+	Page  int
+	Limit int
+}
+
+// parsePagination reads the page and limit query parameters. Values that aren't
+// positive integers get a 400 response, after which it returns false.
+func parsePagination(c *gin.Context, defaults paginationDefaults) (pagination, bool) {
+	page, ok := positiveQueryInt(c, "page", 1)
+	if !ok {
+		return pagination{}, false
+	}
+	limit, ok := positiveQueryInt(c, "limit", defaults.Limit)
+	if !ok {
+		return pagination{}, false
+	}
+	return pagination{Page: page, Limit: min(limit, defaults.MaxLimit)}, true
+}
+
+func positiveQueryInt(c *gin.Context, name string, fallback int) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return fallback, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(name+" must be a positive integer"))
+		return 0, false
+	}
+	return value, true
+}
+
+// Offset is the number of items before the page
+func (p pagination) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// Meta renders the pagination block of a list response
+func (p pagination) Meta(total int64) gin.H {
+	return gin.H{
+		"page":        p.Page,
+		"limit":       p.Limit,
+		"total":       total,
+		"total_pages": (total + int64(p.Limit) - 1) / int64(p.Limit),
+		"has_more":    int64(p.Offset()+p.Limit) < total,
+	}
+}
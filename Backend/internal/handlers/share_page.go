@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The share page is the same for every visitor, so caches may keep it briefly
+const sharePageMaxAge = 300
+
+// sharePageTemplate renders a share link for browsers and link unfurlers (Slack,
+// Twitter and the like read the Open Graph and Twitter Card tags). html/template
+// escapes the filename wherever it appears, attributes included.
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Filename}} · {{.SiteName}}</title>
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:title" content="{{.Filename}}">
+<meta property="og:description" content="{{.Description}}">
+{{- if .PageURL}}
+<meta property="og:url" content="{{.PageURL}}">
+{{- end}}
+{{- if .ImageURL}}
+<meta property="og:image" content="{{.ImageURL}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.ImageURL}}">
+{{- else}}
+<meta name="twitter:card" content="summary">
+{{- end}}
+<meta name="twitter:title" content="{{.Filename}}">
+<meta name="twitter:description" content="{{.Description}}">
+</head>
+<body>
+<h1>{{.Filename}}</h1>
+<p>{{.Description}}</p>
+<p><a href="{{.DownloadURL}}" download>Download</a></p>
+</body>
+</html>
+`))
+
+type sharePageData struct {
+	SiteName    string
+	Filename    string
+	Description string
+	PageURL     string
+	ImageURL    string
+	DownloadURL string
+}
+
+// SharePage renders an HTML page for browsers and unfurlers asking for a share link.
+// It runs ahead of hotlink protection since showing the page isn't a download; other
+// clients, and the page's own download link, continue to ShareFileDownload.
+func (h *FileHandler) SharePage(c *gin.Context) {
+	c.Header("Vary", "Accept")
+	if c.Query("download") != "" || !acceptsHTML(c.Request) {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	shareID := c.Param("id")
+	userFile, err := h.fileService.GetSharedFile(shareID)
+	if stderrors.Is(err, services.ErrShareLinkNotFound) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrFileNotFound, "Share link not found or file no longer available"))
+		return
+	}
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to look up share link", err.Error()))
+		return
+	}
+
+	sharePath := "/share/" + url.PathEscape(shareID)
+	data := sharePageData{
+		SiteName:    h.instanceName,
+		Filename:    userFile.Filename,
+		Description: shareDescription(userFile.FileData),
+		DownloadURL: h.ticketedPath(sharePath, url.Values{"download": {"1"}}),
+	}
+	if h.publicBaseURL != "" {
+		data.PageURL = h.publicBaseURL + sharePath
+		// Images preview as themselves; thumbnails are only served to their owners
+		if strings.HasPrefix(userFile.FileData.MimeType, "image/") {
+			data.ImageURL = h.publicBaseURL + h.ticketedPath("/api/v1/public/files/"+userFile.ID.String()+"/raw", nil)
+		}
+	}
+
+	var page strings.Builder
+	if err := sharePageTemplate.Execute(&page, data); err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to render share page", err.Error()))
+		return
+	}
+
+	if h.hotlinkService.TicketsRequired() {
+		// Embedded tickets expire, so the page can't be reused for long
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", sharePageMaxAge))
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// ticketedPath adds a download ticket to a protected path when tickets are required
+func (h *FileHandler) ticketedPath(path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if h.hotlinkService.TicketsRequired() {
+		if ticket, err := h.hotlinkService.IssueTicket(path); err == nil {
+			query.Set("t", strconv.FormatInt(ticket.Timestamp, 10))
+			query.Set("sig", ticket.Signature)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// acceptsHTML reports whether the client explicitly asked for HTML. Clients that
+// accept anything, like curl, keep getting the redirect.
+func acceptsHTML(r *http.Request) bool {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "text/html") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// shareDescription describes a file by its size and type, e.g. "2.4 MB · image/png"
+func shareDescription(fileHash models.FileHash) string {
+	description := formatSize(fileHash.Size)
+	if fileHash.MimeType != "" {
+		description += " · " + fileHash.MimeType
+	}
+	return description
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageEventsHandler receives bucket notifications from MinIO's webhook target
+type StorageEventsHandler struct {
+	fileService *services.FileService
+	secret      string
+}
+
+func NewStorageEventsHandler(fileService *services.FileService, secret string) *StorageEventsHandler {
+	return &StorageEventsHandler{
+		fileService: fileService,
+		secret:      secret,
+	}
+}
+
+// ReceiveEvents godoc
+// @Summary Receive storage events
+// @Description Webhook for MinIO bucket notifications; marks staged uploads as received. Authenticated with the shared storage events secret.
+// @Tags internal
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Storage events secret"
+// @Success 200 {object} map[string]interface{} "Events processed"
+// @Failure 400 {object} map[string]interface{} "Invalid payload"
+// @Failure 401 {object} map[string]interface{} "Invalid secret"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /internal/storage-events [post]
+func (h *StorageEventsHandler) ReceiveEvents(c *gin.Context) {
+	// MinIO sends the target's auth_token either bare or as a bearer token
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if h.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("Invalid storage events secret"))
+		return
+	}
+
+	var payload services.StorageEventPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid event payload", err.Error()))
+		return
+	}
+
+	if err := h.fileService.HandleStorageEvents(c.Request.Context(), payload); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to process storage events", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Events processed",
+	})
+}
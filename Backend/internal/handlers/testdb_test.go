@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testTx connects to the PostgreSQL database named by TEST_DATABASE_URL, migrates the
+// given models and returns a transaction that is rolled back when the test ends. The
+// test is skipped when no database is configured.
+func testTx(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get test database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin test transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadRequestHandler struct {
+	fileService *services.FileService
+	userService *services.UserService
+}
+
+func NewUploadRequestHandler(fileService *services.FileService, userService *services.UserService) *UploadRequestHandler {
+	return &UploadRequestHandler{
+		fileService: fileService,
+		userService: userService,
+	}
+}
+
+// CreateUploadRequest godoc
+// @Summary Create upload request link
+// @Description Creates a public link that lets people without an account upload files into your vault
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{title=string,description=string,max_files=int,max_file_size_bytes=int64,allowed_mime_types=[]string,expires_at=string} true "Upload request settings"
+// @Success 201 {object} models.UploadRequest "Created upload request"
+// @Failure 400 {object} map[string]interface{} "Bad request"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/upload-requests [post]
+func (h *UploadRequestHandler) CreateUploadRequest(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var req struct {
+		Title            string     `json:"title" binding:"required,max=255"`
+		Description      string     `json:"description"`
+		MaxFiles         int        `json:"max_files" binding:"required,min=1,max=1000"`
+		MaxFileSizeBytes int64      `json:"max_file_size_bytes" binding:"min=0"`
+		AllowedMimeTypes []string   `json:"allowed_mime_types"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	// Ensure user exists in database so uploads have an owner with a quota
+	_, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	uploadRequest, err := h.fileService.CreateUploadRequest(user.ID, services.CreateUploadRequestParams{
+		Title:            req.Title,
+		Description:      req.Description,
+		MaxFiles:         req.MaxFiles,
+		MaxFileSizeBytes: req.MaxFileSizeBytes,
+		AllowedMimeTypes: req.AllowedMimeTypes,
+		ExpiresAt:        req.ExpiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Failed to create upload request", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusCreated, uploadRequest)
+}
+
+// ListUploadRequests godoc
+// @Summary List upload request links
+// @Description Returns the current user's upload request links
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "Upload requests"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/upload-requests [get]
+func (h *UploadRequestHandler) ListUploadRequests(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	uploadRequests, err := h.fileService.ListUploadRequests(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to list upload requests", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload_requests": uploadRequests,
+	})
+}
+
+// DeleteUploadRequest godoc
+// @Summary Delete upload request link
+// @Description Deletes an upload request link; files already received stay in the vault
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Upload request ID"
+// @Success 200 {object} map[string]interface{} "Upload request deleted"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]interface{} "Upload request not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/upload-requests/{id} [delete]
+func (h *UploadRequestHandler) DeleteUploadRequest(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	if err := h.fileService.DeleteUploadRequest(user.ID, c.Param("id")); err != nil {
+		if stderrors.Is(err, services.ErrUploadRequestNotFound) {
+			c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUploadRequestNotFound, "Upload request not found"))
+		} else {
+			c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to delete upload request", err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upload request deleted successfully",
+	})
+}
+
+// GetPublicUploadRequest godoc
+// @Summary Get upload request
+// @Description Returns the public details of an upload request link
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload request ID"
+// @Success 200 {object} services.UploadRequestPublicResponse "Upload request details"
+// @Failure 404 {object} map[string]interface{} "Upload request not found"
+// @Router /request/{id} [get]
+func (h *UploadRequestHandler) GetPublicUploadRequest(c *gin.Context) {
+	uploadRequest, err := h.fileService.GetUploadRequest(c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, services.ToUploadRequestPublicResponse(uploadRequest))
+}
+
+// UploadToRequest godoc
+// @Summary Upload via upload request
+// @Description Generates an upload URL for an anonymous upload into the request owner's vault
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload request ID"
+// @Param request body object{filename=string,size=int64,mime_type=string,file_hash=string} true "Upload request"
+// @Success 200 {object} map[string]interface{} "Upload URL, upload token and expiry; the file is always uploaded"
+// @Failure 400 {object} map[string]interface{} "File rejected by request constraints"
+// @Failure 402 {object} map[string]interface{} "Owner storage or file count quota exceeded"
+// @Failure 404 {object} map[string]interface{} "Upload request not found"
+// @Failure 410 {object} map[string]interface{} "Upload request expired or full"
+// @Router /request/{id}/upload [post]
+func (h *UploadRequestHandler) UploadToRequest(c *gin.Context) {
+	var req struct {
+		Filename string `json:"filename" binding:"required"`
+		Size     int64  `json:"size" binding:"required"`
+		MimeType string `json:"mime_type"`
+		FileHash string `json:"file_hash" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	uploadRequest, err := h.fileService.GetUploadRequest(c.Param("id"))
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	// Uploads count against the owner's storage quota
+	if err := h.userService.CheckStorageQuota(uploadRequest.OwnerUserID, req.Size); err != nil {
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrStorageQuotaExceeded, "The recipient does not have enough storage for this file"))
+		return
+	}
+
+	response, err := h.fileService.PrepareUploadRequestFile(c.Request.Context(), uploadRequest.ID, req.Filename, req.FileHash, req.Size, req.MimeType)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	// Only what's needed to upload, so nothing about the owner's vault or whether the
+	// content is already stored reaches anonymous uploaders
+	c.JSON(http.StatusOK, gin.H{
+		"upload_url":   response.UploadURL,
+		"upload_token": response.UploadToken,
+		"expires_at":   response.ExpiresAt,
+	})
+}
+
+// CompleteRequestUpload godoc
+// @Summary Complete upload via upload request
+// @Description Finalizes an anonymous upload after the file was uploaded to storage, using the upload token returned when it was prepared. Each token completes one file.
+// @Tags upload-requests
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload request ID"
+// @Param request body object{upload_token=string,filename=string} true "Complete upload request"
+// @Success 200 {object} map[string]interface{} "Upload completion confirmation"
+// @Failure 400 {object} map[string]interface{} "Invalid or used upload token, or file rejected by request constraints"
+// @Failure 404 {object} map[string]interface{} "Upload request not found"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /request/{id}/complete [post]
+func (h *UploadRequestHandler) CompleteRequestUpload(c *gin.Context) {
+	var req struct {
+		UploadToken string `json:"upload_token" binding:"required"`
+		Filename    string `json:"filename" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+
+	if _, err := h.fileService.CompleteUploadRequestFile(c.Request.Context(), c.Param("id"), req.UploadToken, req.Filename); err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File uploaded successfully",
+	})
+}
+
+func (h *UploadRequestHandler) respondError(c *gin.Context, err error) {
+	switch {
+	case stderrors.Is(err, services.ErrUploadRequestNotFound):
+		c.JSON(http.StatusNotFound, errors.ErrorResponse(errors.ErrUploadRequestNotFound, "Upload request not found"))
+	case stderrors.Is(err, services.ErrUploadRequestClosed):
+		c.JSON(http.StatusGone, errors.ErrorResponse(errors.ErrUploadRequestClosed, "Upload request is no longer accepting files"))
+	case stderrors.Is(err, services.ErrHashBanned):
+		c.JSON(http.StatusUnavailableForLegalReasons, errors.ErrorResponse(errors.ErrContentBanned, err.Error()))
+	case stderrors.Is(err, services.ErrHashMismatch):
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrHashMismatch, err.Error()))
+	case stderrors.Is(err, services.ErrInvalidUploadToken):
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrUploadTokenInvalid, err.Error()))
+	case stderrors.Is(err, services.ErrUploadSizeMismatch):
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+	case stderrors.Is(err, services.ErrFileCountQuotaExceeded):
+		c.JSON(http.StatusPaymentRequired, errors.ErrorResponse(errors.ErrFileCountQuotaExceeded, "The recipient cannot store any more files"))
+	case stderrors.Is(err, services.ErrUploadRequestRejected):
+		c.JSON(http.StatusBadRequest, errors.ErrorResponse(errors.ErrUploadRequestRejected, err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrFileUploadFailed, "Failed to process upload", err.Error()))
+	}
+}
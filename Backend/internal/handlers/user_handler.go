@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"io"
 	"net/http"
+	"time"
 
 	"filevault-backend/internal/errors"
 	"filevault-backend/internal/middleware"
@@ -11,12 +16,14 @@ import (
 )
 
 type UserHandler struct {
-	userService *services.UserService
+	userService  *services.UserService
+	auditService *services.AuditService
 }
 
-func NewUserHandler(userService *services.UserService) *UserHandler {
+func NewUserHandler(userService *services.UserService, auditService *services.AuditService) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:  userService,
+		auditService: auditService,
 	}
 }
 
@@ -48,21 +55,28 @@ func (h *UserHandler) GetProfile(c *gin.Context) {
 	// Update user role in context
 	user.Role = dbUser.Role
 
+	// The user row may be cached; read usage fresh so it reflects recent uploads
+	used, quota, err := h.userService.GetUserStorageInfo(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageInfoFailed, "Failed to get user profile", err.Error()))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"id":            dbUser.ID,
 		"email":         user.Email,
 		"first_name":    user.FirstName,
 		"last_name":     user.LastName,
 		"role":          dbUser.Role,
-		"storage_quota": dbUser.StorageQuota,
-		"storage_used":  dbUser.StorageUsed,
+		"storage_quota": quota,
+		"storage_used":  used,
 		"created_at":    dbUser.CreatedAt,
 	})
 }
 
 // GetStorageInfo godoc
 // @Summary Get storage information
-// @Description Returns the current user's storage usage and quota information
+// @Description Returns the current user's storage usage and quota information, including any over-quota grace period
 // @Tags users
 // @Accept json
 // @Produce json
@@ -84,11 +98,46 @@ func (h *UserHandler) GetStorageInfo(c *gin.Context) {
 		return
 	}
 
+	bandwidthUsed, bandwidthQuota, err := h.userService.GetBandwidthInfo(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageInfoFailed, "Failed to get bandwidth info", err.Error()))
+		return
+	}
+
+	overage, err := h.userService.GetOverageState(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageInfoFailed, "Failed to get storage info", err.Error()))
+		return
+	}
+
+	proxiedBytes, err := h.userService.GetProxiedBytes(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageInfoFailed, "Failed to get bandwidth info", err.Error()))
+		return
+	}
+
+	fileCountUsed, fileCountQuota, err := h.userService.GetFileCountInfo(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrStorageInfoFailed, "Failed to get file count info", err.Error()))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"storage_used":  used,
-		"storage_quota": quota,
-		"storage_free":  quota - used,
-		"usage_percent": float64(used) / float64(quota) * 100,
+		"storage_used":    used,
+		"storage_quota":   quota,
+		"storage_free":    quota - used,
+		"usage_percent":   float64(used) / float64(quota) * 100,
+		"quota_mode":      h.userService.QuotaMode(), // How files sharing content count toward storage_used
+		"bandwidth_used":  bandwidthUsed,
+		"bandwidth_quota": bandwidthQuota, // 0 means unlimited
+		"overage":         overage,
+
+		"file_count_used":  fileCountUsed,
+		"file_count_quota": fileCountQuota,
+
+		// Bytes streamed through the server this month, which the cap applies to
+		"proxied_bytes_this_month": proxiedBytes,
+		"proxy_bandwidth_cap":      h.userService.ProxyBandwidthCap(), // 0 means no cap
 	})
 }
 
@@ -125,3 +174,220 @@ func (h *UserHandler) GetStorageStatistics(c *gin.Context) {
 
 	c.JSON(http.StatusOK, statistics)
 }
+
+// Preference updates are small JSON objects; anything larger is rejected unread
+const maxPreferencesRequestBytes = 8 << 10
+
+// GetPreferences godoc
+// @Summary Get user preferences
+// @Description Returns the current user's settings, with defaults for any not set
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.UserPreferences "User preferences"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/preferences [get]
+func (h *UserHandler) GetPreferences(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	if _, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	preferences, err := h.userService.GetPreferences(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get preferences", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// UpdatePreferences godoc
+// @Summary Update user preferences
+// @Description Updates the given settings and leaves the rest unchanged. Only known keys with allowed values are accepted: default_visibility (private, public), sort_order (uploaded_at_desc, uploaded_at_asc, name_asc, name_desc, size_desc, size_asc), view_mode (list, grid) and notification_opt_outs (upload_request_received, share_link_accessed, quota_warning).
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body services.PreferencesUpdate true "Preferences to change"
+// @Success 200 {object} models.UserPreferences "Updated preferences"
+// @Failure 400 {object} map[string]interface{} "Unknown key or invalid value"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 413 {object} map[string]interface{} "Request body too large"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/preferences [patch]
+func (h *UserHandler) UpdatePreferences(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPreferencesRequestBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid request body", err.Error()))
+		return
+	}
+	if len(body) > maxPreferencesRequestBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, errors.ValidationErrorResponse("Request body too large"))
+		return
+	}
+
+	// Unknown keys are rejected rather than ignored, so typos don't silently do nothing
+	var update services.PreferencesUpdate
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid preferences", err.Error()))
+		return
+	}
+
+	if _, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	preferences, err := h.userService.UpdatePreferences(user.ID, update)
+	if stderrors.Is(err, services.ErrInvalidPreference) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to update preferences", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// GetActivity godoc
+// @Summary Get activity feed
+// @Description Returns the current user's recent file events, newest first
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20) maximum(100)
+// @Param since query string false "Only return events after this RFC3339 timestamp"
+// @Param action query string false "Only return one kind of event" Enums(upload, delete, visibility_change, share, download)
+// @Success 200 {object} map[string]interface{} "Activity feed with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid since, action or pagination parameter"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/activity [get]
+func (h *UserHandler) GetActivity(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	var since *time.Time
+	if sinceParam := c.Query("since"); sinceParam != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid since parameter, expected RFC3339 timestamp", err.Error()))
+			return
+		}
+		since = &parsed
+	}
+
+	paging, ok := parsePagination(c, userPagination)
+	if !ok {
+		return
+	}
+	offset, limit := paging.Offset(), paging.Limit
+
+	activities, total, err := h.userService.GetUserActivityFeed(user.ID, since, offset, limit, c.Query("action"))
+	if stderrors.Is(err, services.ErrInvalidActivityAction) {
+		c.JSON(http.StatusBadRequest, errors.ValidationErrorResponse("Invalid action parameter", err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to get activity", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"activities": activities,
+		"pagination": paging.Meta(total),
+	})
+}
+
+// EnableWebDAV godoc
+// @Summary Enable WebDAV access
+// @Description Opts the user into the read-only WebDAV mount at /dav/ and issues the token to sign in with, as the HTTP Basic password. The token is only shown here; calling again replaces it. Only available when the server enables WebDAV (see features.webdav in /meta).
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "WebDAV token and mount path"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]interface{} "Impersonating admins can't issue tokens"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/webdav [post]
+func (h *UserHandler) EnableWebDAV(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	// The token would outlive the impersonation session and give the admin lasting
+	// access to the user's files
+	if user.ImpersonatedBy != "" {
+		c.JSON(http.StatusForbidden, errors.ForbiddenResponse("WebDAV tokens can't be issued while impersonating a user"))
+		return
+	}
+
+	if _, err := h.userService.GetOrCreateUser(user.ID, user.Email, user.FirstName, user.LastName); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserCreateFailed, "Failed to initialize user", err.Error()))
+		return
+	}
+
+	token, err := h.userService.EnableWebDAV(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to enable WebDAV", err.Error()))
+		return
+	}
+	h.auditService.Record(auditEntry(c, services.AuditWebDAVTokenIssued, user.ID, ""))
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"token": token,
+		"path":  WebDAVPrefix + "/",
+	})
+}
+
+// DisableWebDAV godoc
+// @Summary Disable WebDAV access
+// @Description Opts the user out of WebDAV and revokes their token
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "WebDAV disabled"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /user/webdav [delete]
+func (h *UserHandler) DisableWebDAV(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	if err := h.userService.DisableWebDAV(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, errors.ErrorResponse(errors.ErrUserUpdateFailed, "Failed to disable WebDAV", err.Error()))
+		return
+	}
+	h.auditService.Record(auditEntry(c, services.AuditWebDAVTokenRevoked, user.ID, ""))
+
+	c.JSON(http.StatusOK, gin.H{"message": "WebDAV access disabled"})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"filevault-backend/internal/errors"
+	"filevault-backend/internal/middleware"
+	"filevault-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVPrefix is where the WebDAV mount is served
+const WebDAVPrefix = "/dav"
+
+// webDAVReadMethods are the methods the read-only mount answers
+const webDAVReadMethods = "OPTIONS, GET, HEAD, PROPFIND"
+
+// WebDAVMethods are routed to Serve. Only reads are served; the rest are refused, so
+// clients see a read-only share rather than a missing one.
+var WebDAVMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND",
+	http.MethodPost, http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPPATCH", "LOCK", "UNLOCK",
+}
+
+type WebDAVHandler struct {
+	fileService *services.FileService
+	userService *services.UserService
+	// The WebDAV handler requires a lock system even though LOCK is refused
+	lockSystem webdav.LockSystem
+}
+
+func NewWebDAVHandler(fileService *services.FileService, userService *services.UserService) *WebDAVHandler {
+	return &WebDAVHandler{
+		fileService: fileService,
+		userService: userService,
+		lockSystem:  webdav.NewMemLS(),
+	}
+}
+
+// Serve godoc
+// @Summary WebDAV mount
+// @Description Read-only WebDAV view of the user's files for mounting in Finder, Explorer or a media player. Files synced from a folder appear at their relative_path and the rest at the top level; when several files share a path the most recent is shown. Sign in with HTTP Basic using a token from POST /user/webdav as the password; the username is ignored. GET supports ranged reads, and bytes read count toward monthly and proxied bandwidth. PROPFIND needs a Depth of 0 or 1. Only served when the server enables WebDAV.
+// @Tags webdav
+// @Param path path string true "Path within the mount"
+// @Param Depth header string false "PROPFIND depth, 0 or 1"
+// @Success 200 "File content or OPTIONS response"
+// @Success 206 "Partial file content"
+// @Success 207 "PROPFIND multistatus"
+// @Failure 401 "Missing or invalid WebDAV token"
+// @Failure 403 "PROPFIND with infinite depth"
+// @Failure 404 "Not found"
+// @Failure 405 "Method not allowed on a read-only mount"
+// @Failure 429 {object} map[string]interface{} "Monthly bandwidth quota exceeded"
+// @Router /dav/{path} [get]
+func (h *WebDAVHandler) Serve(c *gin.Context) {
+	user := middleware.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, errors.UnauthorizedResponse("User not found"))
+		return
+	}
+
+	switch c.Request.Method {
+	case http.MethodOptions:
+		c.Header("Allow", webDAVReadMethods)
+		// Class 1 only: without locking, clients mount the share read-only
+		c.Header("DAV", "1")
+		c.Header("MS-Author-Via", "DAV")
+		c.Status(http.StatusOK)
+		return
+	case "PROPFIND":
+		// A missing Depth means infinity, which would walk every file the user has
+		if depth := c.GetHeader("Depth"); depth != "0" && depth != "1" {
+			c.Status(http.StatusForbidden)
+			return
+		}
+	case http.MethodGet:
+		err := h.checkBandwidth(user.ID)
+		if stderrors.Is(err, services.ErrBandwidthQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrBandwidthQuotaExceeded, "Monthly bandwidth quota exceeded"))
+			return
+		}
+		if stderrors.Is(err, services.ErrProxyBandwidthExceeded) {
+			c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrProxyBandwidthExceeded, "Monthly streaming bandwidth exceeded"))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to check bandwidth", err.Error()))
+			return
+		}
+	case http.MethodHead:
+	default:
+		c.Header("Allow", webDAVReadMethods)
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     WebDAVPrefix,
+		FileSystem: h.fileService.WebDAVFileSystem(user.ID),
+		LockSystem: h.lockSystem,
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkBandwidth rejects reads once the user is out of monthly or proxied bandwidth.
+// Sizes aren't known before the path is resolved, so a read that crosses the quota is
+// allowed to finish.
+func (h *WebDAVHandler) checkBandwidth(userID string) error {
+	if err := h.userService.CheckBandwidthQuota(userID, 0); err != nil {
+		return err
+	}
+	return h.userService.CheckProxyBandwidth(userID)
+}
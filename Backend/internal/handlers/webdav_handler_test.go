@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filevault-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func webDAVRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewWebDAVHandler(nil, nil)
+	for _, method := range WebDAVMethods {
+		router.Handle(method, WebDAVPrefix+"/*path", func(c *gin.Context) {
+			c.Set(middleware.UserContextKey, &middleware.AuthenticatedUser{ID: "user-a"})
+		}, handler.Serve)
+	}
+	return router
+}
+
+func TestWebDAVRefusesWrites(t *testing.T) {
+	router := webDAVRouter()
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPPATCH", "LOCK", "UNLOCK"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, WebDAVPrefix+"/notes.txt", nil))
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s status = %d, want %d", method, w.Code, http.StatusMethodNotAllowed)
+		}
+		if got := w.Header().Get("Allow"); got != webDAVReadMethods {
+			t.Errorf("%s Allow = %q, want %q", method, got, webDAVReadMethods)
+		}
+	}
+}
+
+func TestWebDAVOptionsAdvertisesReadOnlyMount(t *testing.T) {
+	w := httptest.NewRecorder()
+	webDAVRouter().ServeHTTP(w, httptest.NewRequest(http.MethodOptions, WebDAVPrefix+"/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("DAV"); got != "1" {
+		t.Errorf("DAV = %q, want class 1 so clients don't try to lock", got)
+	}
+}
+
+func TestWebDAVRefusesInfiniteDepth(t *testing.T) {
+	for _, depth := range []string{"", "infinity"} {
+		req := httptest.NewRequest("PROPFIND", WebDAVPrefix+"/", nil)
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		w := httptest.NewRecorder()
+		webDAVRouter().ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Depth %q status = %d, want %d", depth, w.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package jobs runs background work from named queues kept in the database. Each
+// queue has a fixed number of workers, so a burst of work waits its turn instead of
+// spawning a goroutine per item, and queued work survives a restart.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"filevault-backend/internal/metrics"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Idle workers look for due jobs this often, besides being woken by Enqueue
+	pollInterval = 5 * time.Second
+	// A job is cancelled after running this long and retried like any other failure
+	jobTimeout = 5 * time.Minute
+	// A running job isn't picked up by another worker until its lease passes, which
+	// only happens when the worker that claimed it died
+	jobLease = jobTimeout + time.Minute
+)
+
+var (
+	jobsProcessed = metrics.NewCounterVec(
+		"filevault_jobs_processed_total",
+		"Background job attempts by queue, job type and result (succeeded, retried or failed)",
+		"queue", "type", "result",
+	)
+	jobProcessingTime = metrics.NewCounterVec(
+		"filevault_job_processing_milliseconds_total",
+		"Time spent running background jobs by queue and job type; divide by attempts for the average",
+		"queue", "type",
+	)
+)
+
+// RetryPolicy decides how often a failing job is attempted
+type RetryPolicy struct {
+	MaxAttempts int
+	// Wait before the second attempt, doubled for each one after
+	Backoff time.Duration
+}
+
+// Type is a kind of job and how to run it
+type Type struct {
+	Name  string
+	Queue string
+	Retry RetryPolicy
+	// Run does the work. An error schedules another attempt until the policy runs out.
+	Run func(ctx context.Context, payload json.RawMessage) error
+	// Failed, if set, is called once a job has used up its attempts
+	Failed func(payload json.RawMessage, attempts int, err error)
+}
+
+// Runner holds the queues and job types and runs their workers. Queues and types are
+// registered before Start.
+type Runner struct {
+	db     *gorm.DB
+	queues map[string]*queue
+	types  map[string]Type
+	wg     sync.WaitGroup
+}
+
+type queue struct {
+	name    string
+	workers int
+	// Nudges an idle worker when a job is enqueued
+	wake chan struct{}
+}
+
+func NewRunner(db *gorm.DB) *Runner {
+	return &Runner{
+		db:     db,
+		queues: make(map[string]*queue),
+		types:  make(map[string]Type),
+	}
+}
+
+// AddQueue creates a queue whose jobs run on at most workers goroutines at a time
+func (r *Runner) AddQueue(name string, workers int) {
+	r.queues[name] = &queue{name: name, workers: workers, wake: make(chan struct{}, 1)}
+}
+
+// Register adds a job type to its queue, which must already exist
+func (r *Runner) Register(jobType Type) {
+	if _, ok := r.queues[jobType.Queue]; !ok {
+		panic(fmt.Sprintf("jobs: queue %q for job type %q is not registered", jobType.Queue, jobType.Name))
+	}
+	r.types[jobType.Name] = jobType
+}
+
+// Enqueue stores a job for the type's queue to run as soon as a worker is free
+func (r *Runner) Enqueue(ctx context.Context, typeName string, payload any) error {
+	if err := r.EnqueueTx(r.db.WithContext(ctx), typeName, payload); err != nil {
+		return err
+	}
+	r.queues[r.types[typeName].Queue].nudge()
+	return nil
+}
+
+// EnqueueTx stores a job in tx, so it only runs if tx commits. Idle workers pick it up
+// on their next poll.
+func (r *Runner) EnqueueTx(tx *gorm.DB, typeName string, payload any) error {
+	jobType, ok := r.types[typeName]
+	if !ok {
+		return fmt.Errorf("unknown job type %q", typeName)
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	job := models.Job{
+		Queue:   jobType.Queue,
+		Type:    typeName,
+		Payload: string(encoded),
+		Status:  models.JobPending,
+		RunAt:   time.Now().UTC(),
+	}
+	if err := tx.Create(&job).Error; err != nil {
+		return fmt.Errorf("failed to enqueue %s job: %w", typeName, err)
+	}
+	return nil
+}
+
+// Start runs every queue's workers until ctx is cancelled, along with the loop that
+// reports queue depths and prunes old failures
+func (r *Runner) Start(ctx context.Context) {
+	for _, q := range r.queues {
+		for i := 0; i < q.workers; i++ {
+			r.wg.Add(1)
+			go r.work(ctx, q)
+		}
+	}
+
+	r.wg.Add(1)
+	go r.maintain(ctx)
+}
+
+// Wait blocks until the workers have stopped after Start's context is cancelled. Jobs
+// already running are allowed to finish.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+func (q *queue) nudge() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Runner) work(ctx context.Context, q *queue) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := r.claim(ctx, q.name)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("job_claim_failed", slog.String("queue", q.name), slog.Any("error", err))
+		}
+		if job != nil {
+			// Another worker may be idle while more jobs are due
+			q.nudge()
+			r.run(ctx, *job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes the queue's next due job, or a running one whose worker was lost,
+// skipping jobs other workers hold. It returns nil when none is due.
+func (r *Runner) claim(ctx context.Context, queueName string) (*models.Job, error) {
+	now := time.Now().UTC()
+	var jobs []models.Job
+	err := r.db.WithContext(ctx).Raw(`
+		UPDATE jobs SET status = ?, attempts = attempts + 1, locked_until = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE queue = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?))
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.JobRunning, now.Add(jobLease), now,
+		queueName, models.JobPending, now, models.JobRunning, now).
+		Scan(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// run attempts a claimed job and records the outcome. Shutting down doesn't cut a
+// job short; it runs to completion or its timeout.
+func (r *Runner) run(ctx context.Context, job models.Job) {
+	jobType, ok := r.types[job.Type]
+	if !ok {
+		r.finish(job, fmt.Errorf("unknown job type %q", job.Type), false)
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobTimeout)
+	started := time.Now()
+	err := jobType.Run(runCtx, json.RawMessage(job.Payload))
+	cancel()
+	jobProcessingTime.Add(uint64(time.Since(started).Milliseconds()), job.Queue, job.Type)
+
+	retry := err != nil && job.Attempts < jobType.Retry.MaxAttempts
+	r.finish(job, err, retry)
+
+	if err != nil && !retry && jobType.Failed != nil {
+		jobType.Failed(json.RawMessage(job.Payload), job.Attempts, err)
+	}
+}
+
+// finish deletes a succeeded job, schedules another attempt or marks it failed
+func (r *Runner) finish(job models.Job, err error, retry bool) {
+	var result string
+	var dbErr error
+	switch {
+	case err == nil:
+		result = "succeeded"
+		dbErr = r.db.Delete(&job).Error
+	case retry:
+		result = "retried"
+		backoff := r.types[job.Type].Retry.Backoff << (job.Attempts - 1)
+		dbErr = r.db.Model(&job).Updates(map[string]interface{}{
+			"status":       models.JobPending,
+			"run_at":       time.Now().UTC().Add(backoff),
+			"locked_until": nil,
+			"last_error":   err.Error(),
+		}).Error
+	default:
+		result = "failed"
+		dbErr = r.db.Model(&job).Updates(map[string]interface{}{
+			"status":       models.JobFailed,
+			"locked_until": nil,
+			"last_error":   err.Error(),
+		}).Error
+		slog.Error("job_failed", slog.String("queue", job.Queue), slog.String("type", job.Type),
+			slog.String("job_id", job.ID.String()), slog.Int("attempts", job.Attempts), slog.Any("error", err))
+	}
+	jobsProcessed.Inc(job.Queue, job.Type, result)
+
+	if dbErr != nil {
+		// The lease runs out and the job is attempted again
+		slog.Error("job_update_failed", slog.String("job_id", job.ID.String()), slog.Any("error", dbErr))
+	}
+}
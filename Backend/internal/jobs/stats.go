@@ -0,0 +1,153 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"filevault-backend/internal/metrics"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Queue depth metrics are refreshed this often
+	statsInterval = 15 * time.Second
+	// Failed jobs are kept this long for admin review, and pruned this often
+	failedJobRetention     = 7 * 24 * time.Hour
+	failedJobPruneInterval = time.Hour
+	// Failures listed by Stats
+	recentFailureLimit = 20
+)
+
+var queueDepth = metrics.NewGaugeVec(
+	"filevault_job_queue_depth",
+	"Background jobs by queue and status (pending, running or failed)",
+	"queue", "status",
+)
+
+// QueueStats counts one queue's jobs by status
+type QueueStats struct {
+	Queue   string `json:"queue"`
+	Workers int    `json:"workers"`
+	// Includes jobs waiting out a retry backoff
+	Pending int64 `json:"pending"`
+	Running int64 `json:"running"`
+	Failed  int64 `json:"failed"`
+}
+
+// FailedJob is a job that used up its attempts
+type FailedJob struct {
+	ID        uuid.UUID `json:"id"`
+	Queue     string    `json:"queue"`
+	Type      string    `json:"type"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// Stats is the state of every queue and its most recent failures
+type Stats struct {
+	Queues         []QueueStats `json:"queues"`
+	RecentFailures []FailedJob  `json:"recent_failures"`
+}
+
+// Stats counts each queue's jobs and lists the most recent failures
+func (r *Runner) Stats(ctx context.Context) (*Stats, error) {
+	var counts []struct {
+		Queue  string
+		Status models.JobStatus
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Model(&models.Job{}).
+		Select("queue, status, COUNT(*) AS count").
+		Group("queue, status").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	byQueue := make(map[string]*QueueStats, len(r.queues))
+	for name, q := range r.queues {
+		byQueue[name] = &QueueStats{Queue: name, Workers: q.workers}
+	}
+	for _, count := range counts {
+		// Jobs left from a queue that no longer exists are still worth seeing
+		stats, ok := byQueue[count.Queue]
+		if !ok {
+			stats = &QueueStats{Queue: count.Queue}
+			byQueue[count.Queue] = stats
+		}
+		switch count.Status {
+		case models.JobPending:
+			stats.Pending = count.Count
+		case models.JobRunning:
+			stats.Running = count.Count
+		case models.JobFailed:
+			stats.Failed = count.Count
+		}
+	}
+
+	result := &Stats{
+		Queues:         make([]QueueStats, 0, len(byQueue)),
+		RecentFailures: []FailedJob{},
+	}
+	for _, stats := range byQueue {
+		result.Queues = append(result.Queues, *stats)
+	}
+	sort.Slice(result.Queues, func(i, j int) bool {
+		return result.Queues[i].Queue < result.Queues[j].Queue
+	})
+
+	err = r.db.WithContext(ctx).Model(&models.Job{}).
+		Select("id, queue, type, attempts, last_error, updated_at AS failed_at").
+		Where("status = ?", models.JobFailed).
+		Order("updated_at DESC").
+		Limit(recentFailureLimit).
+		Scan(&result.RecentFailures).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failed jobs: %w", err)
+	}
+
+	return result, nil
+}
+
+// maintain refreshes the queue depth metrics and deletes failures past retention
+func (r *Runner) maintain(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := r.Stats(ctx)
+		if err != nil {
+			slog.Warn("job_stats_failed", slog.Any("error", err))
+			continue
+		}
+		for _, q := range stats.Queues {
+			queueDepth.Set(q.Pending, q.Queue, string(models.JobPending))
+			queueDepth.Set(q.Running, q.Queue, string(models.JobRunning))
+			queueDepth.Set(q.Failed, q.Queue, string(models.JobFailed))
+		}
+
+		if time.Since(lastPrune) < failedJobPruneInterval {
+			continue
+		}
+		lastPrune = time.Now()
+		cutoff := time.Now().UTC().Add(-failedJobRetention)
+		if err := r.db.WithContext(ctx).Where("status = ? AND updated_at < ?", models.JobFailed, cutoff).Delete(&models.Job{}).Error; err != nil {
+			slog.Warn("failed_job_prune_failed", slog.Any("error", err))
+		}
+	}
+}
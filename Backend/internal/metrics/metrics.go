@@ -0,0 +1,172 @@
+// Package metrics keeps in-process counters and serves them in the Prometheus
+// text exposition format so they can be scraped without extra dependencies.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var registry = struct {
+	mu       sync.Mutex
+	counters []*CounterVec
+	gauges   []*GaugeVec
+}{}
+
+// CounterVec is a monotonically increasing counter partitioned by label values
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec creates and registers a counter. It is meant to be called from
+// package-level var declarations.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]uint64),
+	}
+
+	registry.mu.Lock()
+	registry.counters = append(registry.counters, c)
+	registry.mu.Unlock()
+
+	return c
+}
+
+// Inc adds one to the series identified by labelValues, which must match the
+// label names the counter was created with
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the series identified by labelValues
+func (c *CounterVec) Add(delta uint64, labelValues ...string) {
+	if len(labelValues) != len(c.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\x00")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Total returns the sum of every series of the counter
+func (c *CounterVec) Total() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total uint64
+	for _, value := range c.values {
+		total += value
+	}
+	return total
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", c.name)
+
+	c.mu.Lock()
+	writeSeries(b, c.name, c.labelNames, c.values)
+	c.mu.Unlock()
+}
+
+// GaugeVec is a value that can go up and down, partitioned by label values
+type GaugeVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+// NewGaugeVec creates and registers a gauge. It is meant to be called from
+// package-level var declarations.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     make(map[string]int64),
+	}
+
+	registry.mu.Lock()
+	registry.gauges = append(registry.gauges, g)
+	registry.mu.Unlock()
+
+	return g
+}
+
+// Set sets the series identified by labelValues to value
+func (g *GaugeVec) Set(value int64, labelValues ...string) {
+	if len(labelValues) != len(g.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", g.name, len(g.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\x00")
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", g.name)
+
+	g.mu.Lock()
+	writeSeries(b, g.name, g.labelNames, g.values)
+	g.mu.Unlock()
+}
+
+// writeSeries writes one line per series, sorted by label values
+func writeSeries[V uint64 | int64](b *strings.Builder, name string, labelNames []string, values map[string]V) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(name)
+		if len(labelNames) > 0 {
+			labelValues := strings.Split(key, "\x00")
+			pairs := make([]string, len(labelNames))
+			for i, labelName := range labelNames {
+				pairs[i] = fmt.Sprintf("%s=%q", labelName, labelValues[i])
+			}
+			b.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		fmt.Fprintf(b, " %d\n", values[key])
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+
+		registry.mu.Lock()
+		for _, c := range registry.counters {
+			c.write(&b)
+		}
+		for _, g := range registry.gauges {
+			g.write(&b)
+		}
+		registry.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
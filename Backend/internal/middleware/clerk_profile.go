@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+	"github.com/clerk/clerk-sdk-go/v2/user"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Profiles change rarely, so each user is looked up at most this often
+	clerkProfileTTL = 5 * time.Minute
+	// A failed lookup leaves the profile empty for this long, so an unreachable Clerk
+	// API isn't called again on every request
+	clerkProfileFailureTTL = 30 * time.Second
+	// Profile details are best-effort; a slow Clerk API mustn't hold up the request
+	clerkProfileTimeout = 3 * time.Second
+	// Once the cache holds this many profiles, expired ones are swept and, failing
+	// that, it starts over empty
+	maxClerkProfiles = 10000
+)
+
+// clerkProfile is the part of a Clerk user the API responds with
+type clerkProfile struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+type clerkProfileEntry struct {
+	profile   clerkProfile
+	expiresAt time.Time
+}
+
+// clerkProfileCache caches profiles by user ID
+type clerkProfileCache struct {
+	mu      sync.Mutex
+	entries map[string]clerkProfileEntry
+
+	// Concurrent misses for the same user share one lookup
+	loads singleflight.Group
+}
+
+var clerkProfiles = &clerkProfileCache{entries: make(map[string]clerkProfileEntry)}
+
+// fillClerkProfile sets the user's email and name from the Clerk User API. Session
+// tokens don't carry them. On failure the fields stay empty and the request continues.
+func fillClerkProfile(ctx context.Context, authUser *AuthenticatedUser) {
+	applyClerkProfile(authUser, clerkProfiles.getOrLoad(ctx, authUser.ID))
+}
+
+func (c *clerkProfileCache) get(userID string) (clerkProfile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(c.entries, userID)
+		return clerkProfile{}, false
+	}
+	return entry.profile, ok
+}
+
+func (c *clerkProfileCache) store(userID string, profile clerkProfile, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxClerkProfiles {
+		for id, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		if len(c.entries) >= maxClerkProfiles {
+			c.entries = make(map[string]clerkProfileEntry)
+		}
+	}
+	c.entries[userID] = clerkProfileEntry{profile: profile, expiresAt: now.Add(ttl)}
+}
+
+// getOrLoad returns the cached profile for the user, or looks it up and caches it
+func (c *clerkProfileCache) getOrLoad(ctx context.Context, userID string) clerkProfile {
+	if profile, ok := c.get(userID); ok {
+		return profile
+	}
+
+	loaded, _, _ := c.loads.Do(userID, func() (interface{}, error) {
+		// The lookup is shared, so one request giving up mustn't fail the others
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), clerkProfileTimeout)
+		defer cancel()
+
+		clerkUser, err := user.Get(ctx, userID)
+		if err != nil {
+			log.Printf("Failed to fetch Clerk profile for user %s: %v", userID, err)
+			c.store(userID, clerkProfile{}, clerkProfileFailureTTL)
+			return clerkProfile{}, nil
+		}
+
+		profile := profileFromClerkUser(clerkUser)
+		c.store(userID, profile, clerkProfileTTL)
+		return profile, nil
+	})
+	return loaded.(clerkProfile)
+}
+
+// profileFromClerkUser picks the primary email address, falling back to the first one
+func profileFromClerkUser(clerkUser *clerk.User) clerkProfile {
+	var profile clerkProfile
+	if clerkUser.FirstName != nil {
+		profile.FirstName = *clerkUser.FirstName
+	}
+	if clerkUser.LastName != nil {
+		profile.LastName = *clerkUser.LastName
+	}
+	for _, address := range clerkUser.EmailAddresses {
+		if address == nil {
+			continue
+		}
+		if profile.Email == "" {
+			profile.Email = address.EmailAddress
+		}
+		if clerkUser.PrimaryEmailAddressID != nil && address.ID == *clerkUser.PrimaryEmailAddressID {
+			profile.Email = address.EmailAddress
+			break
+		}
+	}
+	return profile
+}
+
+func applyClerkProfile(authUser *AuthenticatedUser, profile clerkProfile) {
+	authUser.Email = profile.Email
+	authUser.FirstName = profile.FirstName
+	authUser.LastName = profile.LastName
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+)
+
+// fakeClerkUsers serves the Clerk User API with handler and counts its requests
+func fakeClerkUsers(t *testing.T, handler http.HandlerFunc) *atomic.Int32 {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := clerk.GetBackend()
+	clerk.SetBackend(clerk.NewBackend(&clerk.BackendConfig{URL: clerk.String(server.URL), Key: clerk.String("sk_test_key")}))
+	t.Cleanup(func() { clerk.SetBackend(previous) })
+	return &requests
+}
+
+func newClerkProfileCache() *clerkProfileCache {
+	return &clerkProfileCache{entries: make(map[string]clerkProfileEntry)}
+}
+
+func TestClerkProfileMissesShareOneLookup(t *testing.T) {
+	requests := fakeClerkUsers(t, func(w http.ResponseWriter, r *http.Request) {
+		// Slow enough that every caller misses before the lookup finishes
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"user_1","first_name":"Ada","email_addresses":[{"id":"email_1","email_address":"ada@example.com"}],"primary_email_address_id":"email_1"}`)
+	})
+	cache := newClerkProfileCache()
+
+	var wg sync.WaitGroup
+	profiles := make([]clerkProfile, 10)
+	for i := range profiles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			profiles[i] = cache.getOrLoad(context.Background(), "user_1")
+		}()
+	}
+	wg.Wait()
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Clerk API called %d times, want 1", got)
+	}
+	for _, profile := range profiles {
+		if profile.Email != "ada@example.com" || profile.FirstName != "Ada" {
+			t.Fatalf("profile = %+v, want Ada's", profile)
+		}
+	}
+}
+
+func TestClerkProfileCachesFailures(t *testing.T) {
+	requests := fakeClerkUsers(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	cache := newClerkProfileCache()
+
+	for range 3 {
+		if profile := cache.getOrLoad(context.Background(), "user_1"); profile != (clerkProfile{}) {
+			t.Fatalf("profile = %+v after a failed lookup, want it empty", profile)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Clerk API called %d times while the failure is cached, want 1", got)
+	}
+
+	// Once the failure expires the lookup is tried again
+	cache.store("user_1", clerkProfile{}, -time.Second)
+	cache.getOrLoad(context.Background(), "user_1")
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Clerk API called %d times after the failure expired, want 2", got)
+	}
+}
+
+func TestClerkProfileCacheSweepsExpiredEntries(t *testing.T) {
+	cache := newClerkProfileCache()
+	for i := range maxClerkProfiles {
+		cache.store(fmt.Sprintf("expired_%d", i), clerkProfile{}, -time.Second)
+	}
+
+	cache.store("user_1", clerkProfile{Email: "ada@example.com"}, time.Minute)
+
+	if len(cache.entries) != 1 {
+		t.Errorf("cache holds %d entries, want only the live one", len(cache.entries))
+	}
+	if _, ok := cache.get("expired_0"); ok {
+		t.Error("expired entry is still served")
+	}
+}
@@ -1,14 +1,19 @@
 package middleware
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"filevault-backend/internal/config"
 	"filevault-backend/internal/errors"
+	"filevault-backend/internal/metrics"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/services"
 
@@ -37,19 +42,38 @@ type AuthenticatedUser struct {
 	FirstName string
 	LastName  string
 	Role      models.UserRole
+
+	// ImpersonatedBy is the admin ID when the request uses an impersonation token
+	ImpersonatedBy string
 }
 
 const UserContextKey = "user"
 
-// CORS middleware
-func CORS() gin.HandlerFunc {
+// Browsers cap preflight caching at two hours (Chrome) or less
+const corsPreflightMaxAge = 2 * time.Hour
+
+// CORS middleware. Listed origins are echoed back exactly and may send credentials.
+// A "*" entry lets any other origin read responses, but never with credentials, so a
+// wildcard can't expose one user's session to arbitrary sites. Other origins get no
+// CORS headers.
+func CORS(cfg *config.Config) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin := c.GetHeader("Origin"); origin != "" {
+			switch originMatch(cfg.CORSAllowedOrigins, origin) {
+			case originListed:
+				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+				c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			case originWildcard:
+				c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+		}
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
-		if c.Request.Method == "OPTIONS" {
+		// Only preflights are answered here; WebDAV clients send plain OPTIONS to /dav/
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Writer.Header().Set("Access-Control-Max-Age", fmt.Sprintf("%d", int(corsPreflightMaxAge.Seconds())))
 			c.AbortWithStatus(204)
 			return
 		}
@@ -58,8 +82,77 @@ func CORS() gin.HandlerFunc {
 	})
 }
 
+type originMatchKind int
+
+const (
+	originDenied originMatchKind = iota
+	originWildcard
+	originListed
+)
+
+// originMatch reports how the Origin header matches the allowed origins. An exact
+// entry wins over a wildcard so listed origins keep their credentials.
+func originMatch(allowed []string, origin string) originMatchKind {
+	origin = strings.ToLower(origin)
+	match := originDenied
+	for _, entry := range allowed {
+		if entry == origin {
+			return originListed
+		}
+		if entry == "*" {
+			match = originWildcard
+		}
+	}
+	return match
+}
+
+// sessionRoleClaims are the custom session token claims the role is read from when
+// roles don't come from the database
+type sessionRoleClaims struct {
+	Metadata struct {
+		Role models.UserRole `json:"role"`
+	} `json:"metadata"`
+}
+
+// resolveRole finds the role for a verified session, from the database or the token's
+// metadata claim depending on config. Unknown roles fall back to the default.
+func resolveRole(cfg *config.Config, userService *services.UserService, claims *clerk.SessionClaims) (models.UserRole, error) {
+	var role models.UserRole
+	if cfg.FetchRoleFromDB {
+		var err error
+		if role, err = userService.GetUserRole(claims.Subject); err != nil {
+			return "", err
+		}
+	} else if custom, ok := claims.Custom.(*sessionRoleClaims); ok {
+		role = custom.Metadata.Role
+	}
+
+	switch role {
+	case models.UserRoleAdmin, models.UserRoleSuper:
+		return role, nil
+	default:
+		return models.UserRoleUser, nil
+	}
+}
+
+// verifyParams returns the parameters for verifying a session token, asking for the
+// custom role claim when roles come from the token
+func verifyParams(cfg *config.Config, sessionToken string, jwk *clerk.JSONWebKey) *jwt.VerifyParams {
+	params := &jwt.VerifyParams{
+		Token:  sessionToken,
+		JWK:    jwk,
+		Leeway: time.Minute, // 1 minute leeway for clock skew
+	}
+	if !cfg.FetchRoleFromDB {
+		params.CustomClaimsConstructor = func(context.Context) any {
+			return &sessionRoleClaims{}
+		}
+	}
+	return params
+}
+
 // RequireAuth middleware validates Clerk JWT tokens using proper verification
-func RequireAuth(cfg *config.Config) gin.HandlerFunc {
+func RequireAuth(cfg *config.Config, userService *services.UserService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		// Get the session token from Authorization header or __session cookie
 		sessionToken := getSessionToken(c.Request)
@@ -69,6 +162,20 @@ func RequireAuth(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		// Admin impersonation tokens are signed by us rather than Clerk
+		if cfg.ImpersonationEnabled {
+			if claims, err := services.VerifyImpersonationToken(cfg.ImpersonationSecret, sessionToken); err == nil {
+				log.Printf("WARNING: admin %s is impersonating user %s: %s %s", claims.ImpersonatedBy, claims.Subject, c.Request.Method, c.Request.URL.Path)
+				c.Set(UserContextKey, &AuthenticatedUser{
+					ID:             claims.Subject,
+					Role:           models.UserRoleUser,
+					ImpersonatedBy: claims.ImpersonatedBy,
+				})
+				c.Next()
+				return
+			}
+		}
+
 		// Decode the session JWT to find the key ID
 		unsafeClaims, err := jwt.Decode(c.Request.Context(), &jwt.DecodeParams{
 			Token: sessionToken,
@@ -91,32 +198,82 @@ func RequireAuth(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		// Verify the session with 1 minute leeway for clock skew
-		claims, err := jwt.Verify(c.Request.Context(), &jwt.VerifyParams{
-			Token:  sessionToken,
-			JWK:    jwk,
-			Leeway: time.Minute, // 1 minute leeway for clock skew
-		})
+		claims, err := jwt.Verify(c.Request.Context(), verifyParams(cfg, sessionToken, jwk))
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, errors.ErrorResponse(errors.ErrTokenVerificationFailed, "Token verification failed"))
 			c.Abort()
 			return
 		}
 
+		role, err := resolveRole(cfg, userService, claims)
+		if err != nil {
+			log.Printf("Failed to resolve role for user %s: %v", claims.Subject, err)
+			c.JSON(http.StatusInternalServerError, errors.InternalServerErrorResponse("Failed to verify user role"))
+			c.Abort()
+			return
+		}
+
 		// Create authenticated user context
-		// Note: We'll get user details from the database or user API if needed
 		user := &AuthenticatedUser{
-			ID:        claims.Subject,
-			Email:     "", // We'll fetch this from Clerk User API if needed
-			FirstName: "",
-			LastName:  "",
-			Role:      models.UserRoleUser, // Default role, will be updated from DB
+			ID:   claims.Subject,
+			Role: role,
 		}
+		fillClerkProfile(c.Request.Context(), user)
 
 		c.Set(UserContextKey, user)
 		c.Next()
 	})
 }
 
+// RequireWebDAVAuth authenticates WebDAV clients by HTTP Basic auth, with a WebDAV
+// token as the password. The username is ignored.
+func RequireWebDAVAuth(userService *services.UserService, realm string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		_, token, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		dbUser, err := userService.AuthenticateWebDAV(token)
+		if stderrors.Is(err, services.ErrInvalidWebDAVToken) {
+			c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to authenticate WebDAV request: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.Set(UserContextKey, &AuthenticatedUser{ID: dbUser.ID, Role: dbUser.Role})
+		c.Next()
+	})
+}
+
+// AuditImpersonation records every request made with an impersonation token so the
+// audit log shows what the admin did and on whose behalf
+func AuditImpersonation(auditService *services.AuditService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Next()
+
+		user := GetUserFromContext(c)
+		if user == nil || user.ImpersonatedBy == "" {
+			return
+		}
+
+		auditService.Record(models.AuditLog{
+			ActorID:        user.ID,
+			ImpersonatedBy: user.ImpersonatedBy,
+			Action:         services.AuditImpersonatedRequest,
+			Details:        fmt.Sprintf("%s %s -> %d", c.Request.Method, c.Request.URL.Path, c.Writer.Status()),
+			IPAddress:      c.ClientIP(),
+		})
+	})
+}
+
 // RequireAdmin middleware requires admin role
 func RequireAdmin() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -127,7 +284,7 @@ func RequireAdmin() gin.HandlerFunc {
 			return
 		}
 
-		if user.Role != models.UserRoleAdmin {
+		if !user.Role.IsAdmin() {
 			c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrAdminAccessRequired, "Admin access required"))
 			c.Abort()
 			return
@@ -138,7 +295,7 @@ func RequireAdmin() gin.HandlerFunc {
 }
 
 // OptionalAuth middleware validates auth if present but doesn't require it
-func OptionalAuth(cfg *config.Config) gin.HandlerFunc {
+func OptionalAuth(cfg *config.Config, userService *services.UserService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		// Get the session token from Authorization header or __session cookie
 		sessionToken := getSessionToken(c.Request)
@@ -165,22 +322,24 @@ func OptionalAuth(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		claims, err := jwt.Verify(c.Request.Context(), &jwt.VerifyParams{
-			Token:  sessionToken,
-			JWK:    jwk,
-			Leeway: time.Minute, // 1 minute leeway for clock skew
-		})
+		claims, err := jwt.Verify(c.Request.Context(), verifyParams(cfg, sessionToken, jwk))
 		if err != nil {
 			c.Next()
 			return
 		}
 
+		role, err := resolveRole(cfg, userService, claims)
+		if err != nil {
+			log.Printf("Failed to resolve role for user %s: %v", claims.Subject, err)
+			role = models.UserRoleUser
+		}
+
 		user := &AuthenticatedUser{
 			ID:        claims.Subject,
 			Email:     "", // We'll fetch this from Clerk User API if needed
 			FirstName: "",
 			LastName:  "",
-			Role:      models.UserRoleUser,
+			Role:      role,
 		}
 		c.Set(UserContextKey, user)
 
@@ -242,6 +401,58 @@ func RequestLogger() gin.HandlerFunc {
 	})
 }
 
+var hotlinkViolations = metrics.NewCounterVec(
+	"filevault_hotlink_violations_total",
+	"Public download requests rejected by hotlink protection",
+	"reason",
+)
+
+// HotlinkProtection guards backend-served public download routes. It runs the
+// per-IP download limiter, the referrer/origin allowlist and, when configured,
+// signed ticket verification.
+func HotlinkProtection(hotlinkService *services.HotlinkService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if !hotlinkService.AllowDownload(c.ClientIP()) {
+			hotlinkViolations.Inc("rate_limit")
+			c.Header("Retry-After", "60")
+			c.JSON(http.StatusTooManyRequests, errors.ErrorResponse(errors.ErrDownloadRateLimited, "Too many downloads. Please slow down."))
+			c.Abort()
+			return
+		}
+
+		if err := hotlinkService.CheckReferrer(c.Request.Referer(), c.GetHeader("Origin")); err != nil {
+			hotlinkViolations.Inc("referrer")
+			c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrHotlinkForbidden, err.Error()))
+			c.Abort()
+			return
+		}
+
+		if err := hotlinkService.VerifyTicket(c.Request.URL.Path, c.Query("t"), c.Query("sig")); err != nil {
+			hotlinkViolations.Inc("ticket")
+			c.JSON(http.StatusForbidden, errors.ErrorResponse(errors.ErrDownloadTicketInvalid, err.Error()))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	})
+}
+
+// RequireBearerToken only lets through requests with "Authorization: Bearer token", for
+// endpoints scraped by machines rather than signed-in users
+func RequireBearerToken(token string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.JSON(http.StatusUnauthorized, errors.ErrorResponse(errors.ErrInvalidToken, "Invalid or missing bearer token"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+}
+
 // RateLimit middleware - simple unified rate limiting
 func RateLimit(rateLimitService *services.RateLimitService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -0,0 +1,392 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/services"
+
+	"github.com/clerk/clerk-sdk-go/v2"
+	"github.com/clerk/clerk-sdk-go/v2/jwks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func corsRouter(allowed ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORS(&config.Config{CORSAllowedOrigins: allowed}))
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func corsRequest(router *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/ping", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSListedOriginGetsCredentials(t *testing.T) {
+	w := corsRequest(corsRouter("https://app.example.com", "*"), http.MethodGet, "https://app.example.com")
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the listed origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	router := corsRouter("*")
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		w := corsRequest(router, method, "https://evil.example.net")
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want *", method, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want none for a wildcard match", method, got)
+		}
+	}
+}
+
+func TestCORSUnlistedOriginGetsNoHeaders(t *testing.T) {
+	for name, allowed := range map[string][]string{
+		"unset":    nil,
+		"unlisted": {"https://app.example.com"},
+	} {
+		w := corsRequest(corsRouter(allowed...), http.MethodGet, "https://evil.example.net")
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want none", name, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want none", name, got)
+		}
+	}
+}
+
+func TestCORSPreflightIsAnswered(t *testing.T) {
+	w := corsRequest(corsRouter("https://app.example.com"), http.MethodOptions, "https://app.example.com")
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w.Header().Get("Access-Control-Max-Age") == "" {
+		t.Error("preflight response has no Access-Control-Max-Age")
+	}
+}
+
+func webDAVAuthRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/dav/*path", RequireWebDAVAuth(&services.UserService{}, "FileVault"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestRequireWebDAVAuthRejectsMissingCredentials(t *testing.T) {
+	w := httptest.NewRecorder()
+	webDAVAuthRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dav/", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got == "" {
+		t.Error("no WWW-Authenticate challenge, so clients won't prompt for the token")
+	}
+}
+
+func TestRequireWebDAVAuthRejectsForeignToken(t *testing.T) {
+	// A Clerk session token or any other non-WebDAV secret is never accepted
+	req := httptest.NewRequest(http.MethodGet, "/dav/", nil)
+	req.SetBasicAuth("user", "sess_not-a-webdav-token")
+	w := httptest.NewRecorder()
+	webDAVAuthRouter().ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireBearerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", RequireBearerToken("a-metrics-token-that-is-long-enough"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer not-the-token", http.StatusUnauthorized},
+		{"wrong scheme", "Basic a-metrics-token-that-is-long-enough", http.StatusUnauthorized},
+		{"correct token", "Bearer a-metrics-token-that-is-long-enough", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// proxyRouter is set up the way the server is: forwarded client IPs are only believed
+// from the configured proxies
+func proxyRouter(t *testing.T, trustedProxies []string, rateLimitService *services.RateLimitService) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatalf("SetTrustedProxies() error = %v", err)
+	}
+	router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	if rateLimitService != nil {
+		router.GET("/limited", RateLimit(rateLimitService), func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	return router
+}
+
+func proxiedRequest(router *gin.Engine, path, peer string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = peer + ":40000"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestClientIPIgnoresForwardedHeadersFromUntrustedPeers(t *testing.T) {
+	spoofed := []map[string]string{
+		{"X-Forwarded-For": "203.0.113.7"},
+		{"X-Real-IP": "203.0.113.7"},
+		{"X-Forwarded-For": "203.0.113.7, 10.0.0.5"},
+	}
+
+	for _, trusted := range [][]string{nil, {"10.0.0.0/8"}} {
+		router := proxyRouter(t, trusted, nil)
+		for _, headers := range spoofed {
+			w := proxiedRequest(router, "/ip", "198.51.100.20", headers)
+			if got := w.Body.String(); got != "198.51.100.20" {
+				t.Errorf("trusted %v, headers %v: client IP = %q, want the peer address", trusted, headers, got)
+			}
+		}
+	}
+}
+
+func TestClientIPUsesForwardedHeadersFromTrustedProxies(t *testing.T) {
+	router := proxyRouter(t, []string{"10.0.0.0/8"}, nil)
+
+	for _, headers := range []map[string]string{
+		{"X-Forwarded-For": "203.0.113.7"},
+		{"X-Real-IP": "203.0.113.7"},
+		// Entries added by trusted proxies are skipped; anything before the client is
+		// whatever the client sent
+		{"X-Forwarded-For": "192.0.2.1, 203.0.113.7, 10.0.0.9"},
+	} {
+		w := proxiedRequest(router, "/ip", "10.0.0.5", headers)
+		if got := w.Body.String(); got != "203.0.113.7" {
+			t.Errorf("headers %v: client IP = %q, want the forwarded client", headers, got)
+		}
+	}
+}
+
+func TestRateLimitKeysOnResolvedClientIP(t *testing.T) {
+	rateLimitService := services.NewRateLimitService(&config.Config{RateLimitEnabled: true, RateLimitPerSecond: 0.001, RateLimitBurstSize: 1})
+	defer rateLimitService.Close()
+	router := proxyRouter(t, []string{"10.0.0.0/8"}, rateLimitService)
+
+	// An untrusted peer can't get a fresh allowance by changing its forwarded address
+	if w := proxiedRequest(router, "/limited", "198.51.100.20", map[string]string{"X-Forwarded-For": "203.0.113.1"}); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := proxiedRequest(router, "/limited", "198.51.100.20", map[string]string{"X-Forwarded-For": "203.0.113.2"}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed request status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Clients behind the trusted proxy are limited separately
+	for _, client := range []string{"203.0.113.10", "203.0.113.11"} {
+		if w := proxiedRequest(router, "/limited", "10.0.0.5", map[string]string{"X-Forwarded-For": client}); w.Code != http.StatusOK {
+			t.Errorf("client %s behind the proxy status = %d, want %d", client, w.Code, http.StatusOK)
+		}
+	}
+}
+
+// fakeClerk serves a JWKS the way Clerk does and signs session tokens with its key
+type fakeClerk struct {
+	signer jose.Signer
+}
+
+// newFakeClerk points token verification at a fake Clerk for the rest of the test
+func newFakeClerk(t *testing.T) *fakeClerk {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keySet, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &key.PublicKey, KeyID: "test-key", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to marshal key set: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(keySet)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := ClerkJWKSClient
+	ClerkJWKSClient = jwks.NewClient(&clerk.ClientConfig{BackendConfig: clerk.BackendConfig{
+		HTTPClient: server.Client(),
+		URL:        clerk.String(server.URL),
+		Key:        clerk.String("sk_test_key"),
+	}})
+	t.Cleanup(func() { ClerkJWKSClient = previous })
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test-key"))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return &fakeClerk{signer: signer}
+}
+
+// sessionToken signs a session token for userID carrying role in its metadata claim,
+// and caches an empty profile so the Clerk User API isn't called
+func (f *fakeClerk) sessionToken(t *testing.T, userID string, role models.UserRole) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := josejwt.Claims{
+		Subject:   userID,
+		Issuer:    "https://clerk.example.com",
+		IssuedAt:  josejwt.NewNumericDate(now),
+		NotBefore: josejwt.NewNumericDate(now),
+		Expiry:    josejwt.NewNumericDate(now.Add(time.Minute)),
+	}
+	metadata := map[string]interface{}{"metadata": map[string]string{"role": string(role)}}
+	token, err := josejwt.Signed(f.signer).Claims(claims).Claims(metadata).CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to sign session token: %v", err)
+	}
+	clerkProfiles.store(userID, clerkProfile{}, time.Hour)
+	return token
+}
+
+func adminRouter(cfg *config.Config, userService *services.UserService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/admin/users", RequireAuth(cfg, userService), RequireAdmin(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func adminRequest(router *gin.Engine, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAdminRoutesUseRoleFromDatabase(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+
+	adminID, userID := "admin-"+uuid.New().String(), "user-"+uuid.New().String()
+	for _, user := range []models.User{{ID: adminID, Role: models.UserRoleAdmin}, {ID: userID, Role: models.UserRoleUser}} {
+		if err := tx.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	cfg := &config.Config{FetchRoleFromDB: true}
+	clerkFake := newFakeClerk(t)
+	router := adminRouter(cfg, services.NewUserService(tx, cfg))
+
+	// The token's own claims can't make anyone an admin
+	if code := adminRequest(router, clerkFake.sessionToken(t, adminID, models.UserRoleUser)); code != http.StatusOK {
+		t.Errorf("admin: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := adminRequest(router, clerkFake.sessionToken(t, userID, models.UserRoleAdmin)); code != http.StatusForbidden {
+		t.Errorf("user: status = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestAdminRoutesUseRoleFromTokenMetadata(t *testing.T) {
+	cfg := &config.Config{FetchRoleFromDB: false}
+	clerkFake := newFakeClerk(t)
+	router := adminRouter(cfg, &services.UserService{})
+
+	tests := []struct {
+		name string
+		role models.UserRole
+		want int
+	}{
+		{"admin", models.UserRoleAdmin, http.StatusOK},
+		{"super admin", models.UserRoleSuper, http.StatusOK},
+		{"user", models.UserRoleUser, http.StatusForbidden},
+		{"unknown role", "owner", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := adminRequest(router, clerkFake.sessionToken(t, "clerk-"+uuid.New().String(), tt.role)); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	if code := adminRequest(router, "not-a-jwt"); code != http.StatusUnauthorized {
+		t.Errorf("malformed token: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -1,7 +1,12 @@
 package models
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,13 +14,41 @@ import (
 )
 
 type User struct {
-	ID           string         `json:"id" gorm:"primaryKey;type:varchar(255)"`
-	Role         UserRole       `json:"role" gorm:"type:varchar(20);default:user"`
-	StorageQuota int64          `json:"storage_quota" gorm:"default:10485760"` // 10MB default
-	StorageUsed  int64          `json:"storage_used" gorm:"default:0"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	ID           string   `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	Role         UserRole `json:"role" gorm:"type:varchar(20);default:user"`
+	StorageQuota int64    `json:"storage_quota" gorm:"default:10485760"` // 10MB default
+	StorageUsed  int64    `json:"storage_used" gorm:"default:0"`
+	// Most files the user may have, so tiny files can't exhaust the database
+	FileCountQuota int `json:"file_count_quota" gorm:"default:1000"`
+
+	// StorageQuota reverts to BaseStorageQuota once a signup trial expires
+	BaseStorageQuota    int64      `json:"base_storage_quota" gorm:"default:0"`
+	TrialQuotaExpiresAt *time.Time `json:"trial_quota_expires_at,omitempty"`
+
+	// Monthly download bandwidth; a quota of 0 means unlimited
+	BandwidthQuotaBytes    int64     `json:"bandwidth_quota_bytes" gorm:"default:0"`
+	BandwidthUsedThisMonth int64     `json:"bandwidth_used_this_month" gorm:"default:0"`
+	BandwidthPeriodStart   time.Time `json:"bandwidth_period_start"`
+
+	// Set when usage first went over quota within the grace allowance; uploads are
+	// blocked once the grace period ends or usage passes the grace cap
+	OverageStartedAt *time.Time `json:"overage_started_at,omitempty"`
+	OverageBlocked   bool       `json:"overage_blocked" gorm:"default:false"`
+
+	// Per-user API rate limit set by an admin; nil uses the configured defaults
+	RateLimitPerSecond *float64 `json:"rate_limit_per_second"`
+	RateLimitBurstSize *int     `json:"rate_limit_burst_size"`
+
+	// Settings the frontend keeps server-side so they follow the user across devices
+	Preferences UserPreferences `json:"preferences" gorm:"type:jsonb"`
+
+	// Set while the user has opted into WebDAV access; only the SHA-256 of their token is kept
+	WebDAVTokenHash *string    `json:"-" gorm:"column:webdav_token_hash;type:varchar(64);uniqueIndex"`
+	WebDAVEnabledAt *time.Time `json:"webdav_enabled_at,omitempty" gorm:"column:webdav_enabled_at"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
 	UserFiles []UserFile `json:"user_files" gorm:"foreignKey:UserID"`
 }
@@ -25,14 +58,26 @@ type UserRole string
 const (
 	UserRoleUser  UserRole = "user"
 	UserRoleAdmin UserRole = "admin"
+	// UserRoleSuper has every admin permission plus the ones too sensitive for regular
+	// admins, such as clearing legal holds
+	UserRoleSuper UserRole = "super"
 )
 
+// IsAdmin reports whether the role grants admin access
+func (r UserRole) IsAdmin() bool {
+	return r == UserRoleAdmin || r == UserRoleSuper
+}
+
 type FileHash struct {
 	Hash           string    `json:"hash" gorm:"primaryKey;type:varchar(64)"` // SHA256 hash
+	SecondaryHash  string    `json:"-" gorm:"type:varchar(64)"`               // BLAKE2b-256 hash, proves possession on dedup
+	CollidesWith   string    `json:"-" gorm:"type:varchar(64)"`               // SHA-256 of content stored under its secondary hash because different stored content has the same SHA-256
 	Size           int64     `json:"size"`
 	MimeType       string    `json:"mime_type" gorm:"type:varchar(255)"`
 	ReferenceCount int       `json:"reference_count" gorm:"default:0"`
 	MinIOKey       string    `json:"minio_key" gorm:"type:varchar(255)"`
+	StorageClass   string    `json:"storage_class" gorm:"type:varchar(64)"` // Set once the object is moved to a colder tier
+	ThumbnailKey   string    `json:"-" gorm:"type:varchar(255)"`            // Set once a preview thumbnail has been generated
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 
@@ -41,8 +86,8 @@ type FileHash struct {
 
 type UserFile struct {
 	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	UserID        string         `json:"user_id" gorm:"type:varchar(255);not null;index"`
-	FileHash      string         `json:"file_hash" gorm:"type:varchar(64);not null;index"`
+	UserID        string         `json:"user_id" gorm:"type:varchar(255);not null;index;index:idx_user_files_user_hash,priority:1"`
+	FileHash      string         `json:"file_hash" gorm:"type:varchar(64);not null;index;index:idx_user_files_user_hash,priority:2"` // user_hash backs per-user dedup lookups
 	Filename      string         `json:"filename" gorm:"type:varchar(255);not null"`
 	IsPublic      bool           `json:"is_public" gorm:"default:false"`
 	DownloadCount int            `json:"download_count" gorm:"default:0"`
@@ -50,6 +95,33 @@ type UserFile struct {
 	UpdatedAt     time.Time      `json:"updated_at"`
 	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// Storage tiering treats content as cold once none of its files have been downloaded for a while
+	LastDownloadAt *time.Time `json:"last_download_at,omitempty"`
+	// Last download or stream, including range requests that aren't counted as
+	// downloads. Written at most hourly per file.
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+
+	// Path within the folder tree the file was synced from, e.g. "photos/2024/beach.jpg"
+	RelativePath string `json:"relative_path,omitempty" gorm:"type:text"`
+
+	// A public file goes private again once PublicUntil passes. Every visibility change
+	// bumps the generation, so a reversion scheduled before it no longer applies.
+	PublicUntil              *time.Time `json:"public_until,omitempty" gorm:"index"`
+	PublicScheduleGeneration int        `json:"-" gorm:"default:0"`
+
+	// Locked files can't be deleted or replaced until their owner unlocks them; admins
+	// can override the lock explicitly
+	Locked bool `json:"locked" gorm:"default:false"`
+
+	// Key/value pairs integrations attach to the file; GIN-indexed for containment filters
+	Metadata FileMetadata `json:"metadata,omitempty" gorm:"type:jsonb;index:idx_user_files_metadata,type:gin"`
+
+	// Files under legal hold can't be deleted by anyone until a super admin clears the hold
+	LegalHold       bool       `json:"legal_hold" gorm:"default:false;index"`
+	LegalHoldReason string     `json:"-" gorm:"type:text"`
+	LegalHoldSetBy  string     `json:"-" gorm:"type:varchar(255)"`
+	LegalHoldSetAt  *time.Time `json:"-"`
+
 	User     User     `json:"user" gorm:"foreignKey:UserID"`
 	FileData FileHash `json:"file_data" gorm:"foreignKey:FileHash"`
 }
@@ -64,7 +136,7 @@ func (u *UserFile) BeforeCreate(tx *gorm.DB) error {
 
 // ShareLink represents a clean shareable link for public files
 type ShareLink struct {
-	ID         string         `json:"id" gorm:"primaryKey;type:varchar(8)"` // Short random ID
+	ID         string         `json:"id" gorm:"primaryKey;type:varchar(12)"` // Short random ID
 	UserFileID uuid.UUID      `json:"user_file_id" gorm:"type:uuid;not null;index"`
 	CreatedAt  time.Time      `json:"created_at"`
 	UpdatedAt  time.Time      `json:"updated_at"`
@@ -75,18 +147,529 @@ type ShareLink struct {
 
 func (s *ShareLink) BeforeCreate(tx *gorm.DB) error {
 	if s.ID == "" {
-		s.ID = GenerateRandomID(8)
+		s.ID = GenerateRandomID(ShareIDLength)
 	}
 	s.CreatedAt = time.Now().UTC()
 	return nil
 }
 
-// GenerateRandomID creates a random alphanumeric ID of specified length
+// FileCollection groups several of a user's files under one share link
+type FileCollection struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      string    `json:"user_id" gorm:"type:varchar(255);not null;index"`
+	Name        string    `json:"name" gorm:"type:varchar(255);not null"`
+	Description string    `json:"description" gorm:"type:text"`
+	IsPublic    bool      `json:"is_public" gorm:"default:false"`
+	ShareID     *string   `json:"share_id,omitempty" gorm:"type:varchar(12);uniqueIndex"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// FileCollectionItem places a file in a collection
+type FileCollectionItem struct {
+	CollectionID uuid.UUID `json:"collection_id" gorm:"type:uuid;primaryKey"`
+	UserFileID   uuid.UUID `json:"user_file_id" gorm:"type:uuid;primaryKey;index"`
+	SortOrder    int       `json:"sort_order"`
+}
+
+// FolderShareLink shares a synced folder, everything under the owner's RelativePath
+// prefix, at one link. Private files are left out unless IncludePrivate is set.
+type FolderShareLink struct {
+	ID             string    `json:"id" gorm:"primaryKey;type:varchar(12)"` // Short random ID
+	UserID         string    `json:"user_id" gorm:"type:varchar(255);not null;uniqueIndex:idx_folder_share_links_user_path,priority:1"`
+	Path           string    `json:"path" gorm:"type:text;not null;uniqueIndex:idx_folder_share_links_user_path,priority:2"`
+	IncludePrivate bool      `json:"include_private" gorm:"default:false"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (s *FolderShareLink) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = GenerateRandomID(ShareIDLength)
+	}
+	s.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// UploadRequest is a public link that lets people without an account upload files into the owner's vault
+type UploadRequest struct {
+	ID               string     `json:"id" gorm:"primaryKey;type:varchar(16)"`
+	OwnerUserID      string     `json:"owner_user_id" gorm:"type:varchar(255);not null;index"`
+	Title            string     `json:"title" gorm:"type:varchar(255);not null"`
+	Description      string     `json:"description" gorm:"type:text"`
+	MaxFiles         int        `json:"max_files" gorm:"not null"`
+	MaxFileSizeBytes int64      `json:"max_file_size_bytes"` // 0 means no per-file limit beyond the owner's quota
+	AllowedMimeTypes StringList `json:"allowed_mime_types" gorm:"type:jsonb"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	FilesReceived    int        `json:"files_received" gorm:"default:0"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+func (r *UploadRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = GenerateRandomID(16)
+	}
+	r.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// UserPreferences holds a user's settings as a JSON object. Empty fields fall back to
+// the defaults; only known keys are ever stored.
+type UserPreferences struct {
+	DefaultVisibility   string   `json:"default_visibility,omitempty"`
+	SortOrder           string   `json:"sort_order,omitempty"`
+	ViewMode            string   `json:"view_mode,omitempty"`
+	NotificationOptOuts []string `json:"notification_opt_outs,omitempty"`
+}
+
+func (p UserPreferences) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (p *UserPreferences) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = UserPreferences{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for UserPreferences: %T", value)
+	}
+	return json.Unmarshal(data, p)
+}
+
+// StringList is a list of strings stored as a JSON array
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = StringList{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for StringList: %T", value)
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// IntList is a list of integers stored as a JSON array
+type IntList []int
+
+func (l IntList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]int(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (l *IntList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = IntList{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for IntList: %T", value)
+	}
+	return json.Unmarshal(data, (*[]int)(l))
+}
+
+// FileMetadata is a file's custom key/value metadata, stored as a JSON object
+type FileMetadata map[string]string
+
+func (m FileMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]string(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *FileMetadata) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = FileMetadata{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for FileMetadata: %T", value)
+	}
+	return json.Unmarshal(data, (*map[string]string)(m))
+}
+
+// UserActivity is an entry in a user's activity feed. File details are snapshotted
+// at event time so entries render even after the file is renamed or deleted.
+type UserActivity struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    string         `json:"-" gorm:"type:varchar(255);not null;index:idx_user_activity_feed,priority:1"`
+	Action    ActivityAction `json:"action" gorm:"type:varchar(32);not null"`
+	FileID    *uuid.UUID     `json:"file_id,omitempty" gorm:"type:uuid"`
+	Filename  string         `json:"filename,omitempty" gorm:"type:varchar(255)"`
+	ShareID   string         `json:"share_id,omitempty" gorm:"type:varchar(12)"`
+	Details   string         `json:"details,omitempty" gorm:"type:varchar(255)"`
+	CreatedAt time.Time      `json:"created_at" gorm:"index:idx_user_activity_feed,priority:2,sort:desc"`
+}
+
+type ActivityAction string
+
+const (
+	ActivityUpload           ActivityAction = "upload"
+	ActivityDelete           ActivityAction = "delete"
+	ActivityVisibilityChange ActivityAction = "visibility_change"
+	ActivityShare            ActivityAction = "share"
+	ActivityDownload         ActivityAction = "download"
+	ActivityQuotaOverage     ActivityAction = "quota_overage"
+)
+
+// BandwidthEvent accumulates bytes served on behalf of a user per day
+type BandwidthEvent struct {
+	UserID string    `json:"user_id" gorm:"primaryKey;type:varchar(255)"`
+	Date   time.Time `json:"date" gorm:"primaryKey;type:date"`
+	Bytes  int64     `json:"bytes" gorm:"default:0"`
+}
+
+// UserBandwidth counts the bytes the server streamed itself on behalf of a file owner
+// in one month, as opposed to downloads served by storage through presigned URLs
+type UserBandwidth struct {
+	UserID      string    `json:"user_id" gorm:"primaryKey;type:varchar(255)"`
+	Month       time.Time `json:"month" gorm:"primaryKey;type:date"`
+	BytesServed int64     `json:"bytes_served" gorm:"default:0"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (UserBandwidth) TableName() string {
+	return "user_bandwidth"
+}
+
+// DailyStorageSnapshot records system-wide storage totals once per UTC day for trend reports
+type DailyStorageSnapshot struct {
+	Date       time.Time `json:"date" gorm:"primaryKey;type:date"`
+	TotalBytes int64     `json:"total_bytes"`
+	TotalFiles int64     `json:"total_files"`
+	TotalUsers int64     `json:"total_users"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FailedDeletion records a storage object that could not be removed after all retries
+type FailedDeletion struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ObjectKey string    `json:"object_key" gorm:"type:varchar(255);not null;index"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UsedUploadToken marks an upload completion token as spent, so it can't complete a
+// second file. Rows are pruned once the token would have expired anyway.
+type UsedUploadToken struct {
+	Nonce     string    `gorm:"primaryKey;type:varchar(32)"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// JobStatus is where a background job is in its lifecycle
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	// JobFailed jobs ran out of attempts; they're kept a while for admin review
+	JobFailed JobStatus = "failed"
+)
+
+// Job is a unit of background work waiting in, or running from, a named queue.
+// Succeeded jobs are deleted.
+type Job struct {
+	ID       uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Queue    string    `json:"queue" gorm:"type:varchar(64);not null;index:idx_jobs_claim,priority:1"`
+	Type     string    `json:"type" gorm:"type:varchar(64);not null"`
+	Payload  string    `json:"payload" gorm:"type:jsonb;not null"`
+	Status   JobStatus `json:"status" gorm:"type:varchar(16);not null;index:idx_jobs_claim,priority:2"`
+	Attempts int       `json:"attempts" gorm:"default:0"`
+	// Not run before this; pushed back after each failed attempt
+	RunAt time.Time `json:"run_at" gorm:"index:idx_jobs_claim,priority:3"`
+	// A running job whose lease has passed is assumed lost with its worker and is run again
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty" gorm:"type:text"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// RecalculationStatus is where a storage recalculation run is
+type RecalculationStatus string
+
+const (
+	RecalculationRunning   RecalculationStatus = "running"
+	RecalculationCompleted RecalculationStatus = "completed"
+	RecalculationFailed    RecalculationStatus = "failed"
+)
+
+// StorageRecalculation is a run recomputing every user's storage usage from their files.
+// Users are processed in ID order, and LastUserID is how far the run has got.
+type StorageRecalculation struct {
+	ID        uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StartedBy string              `json:"started_by" gorm:"type:varchar(255)"`
+	QuotaMode string              `json:"quota_mode" gorm:"type:varchar(20)"`
+	Status    RecalculationStatus `json:"status" gorm:"type:varchar(16);not null;index"`
+	// Users when the run started; users who sign up during it are included too
+	TotalUsers     int64      `json:"total_users"`
+	ProcessedUsers int64      `json:"processed_users"`
+	ChangedUsers   int64      `json:"changed_users"`
+	BytesAdjusted  int64      `json:"bytes_adjusted"` // Net change to recorded usage across all users
+	LastUserID     string     `json:"-" gorm:"type:varchar(255)"`
+	Error          string     `json:"error,omitempty" gorm:"type:text"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// IntegrityIssue records stored content that no longer hashes to its file hash or has
+// gone missing from storage. It is resolved when a later check finds the content intact.
+type IntegrityIssue struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	FileHash   string     `json:"file_hash" gorm:"type:varchar(64);not null;index"`
+	ObjectKey  string     `json:"object_key" gorm:"type:varchar(255)"`
+	ActualHash string     `json:"actual_hash,omitempty" gorm:"type:varchar(64)"`
+	Problem    string     `json:"problem" gorm:"type:varchar(32)"`
+	DetectedAt time.Time  `json:"detected_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" gorm:"index"`
+}
+
+// IntegrityScrubCursor remembers how far the background scrubber has walked the
+// stored content, so a restart resumes the pass instead of starting over
+type IntegrityScrubCursor struct {
+	ID            int    `gorm:"primaryKey"`
+	LastHash      string `gorm:"type:varchar(64)"`
+	PassStartedAt time.Time
+	UpdatedAt     time.Time
+}
+
+// FileDownloadEvent records one anonymous download of a public file for link analytics
+type FileDownloadEvent struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserFileID   uuid.UUID `json:"user_file_id" gorm:"type:uuid;not null;index:idx_file_download_events,priority:1"`
+	IPHash       string    `json:"-" gorm:"type:varchar(64)"`
+	ReferrerHost string    `json:"referrer_host" gorm:"type:varchar(255)"`
+	Country      string    `json:"country" gorm:"type:varchar(16)"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index:idx_file_download_events,priority:2"`
+}
+
+type UploadSessionStatus string
+
+const (
+	UploadSessionPending  UploadSessionStatus = "pending"
+	UploadSessionUploaded UploadSessionStatus = "uploaded"
+)
+
+// UploadSession tracks an upload staged for server-side hashing. The declared size
+// is reserved against the user's quota until the upload completes or expires.
+// Status moves to uploaded when storage reports the object was written.
+type UploadSession struct {
+	ID            uuid.UUID           `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        string              `json:"user_id" gorm:"type:varchar(255);not null;index"`
+	ObjectKey     string              `json:"object_key" gorm:"type:varchar(255);not null;index"`
+	Filename      string              `json:"filename" gorm:"type:varchar(255);not null"`
+	MimeType      string              `json:"mime_type" gorm:"type:varchar(255)"`
+	ReservedBytes int64               `json:"reserved_bytes"`
+	Status        UploadSessionStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending';index"`
+	UploadedAt    *time.Time          `json:"uploaded_at,omitempty"`
+	ExpiresAt     time.Time           `json:"expires_at" gorm:"index"`
+	CreatedAt     time.Time           `json:"created_at"`
+	// When the upload URL was last renewed and the deadline extended
+	RenewedAt *time.Time `json:"renewed_at,omitempty"`
+
+	// How a clash with an existing filename is resolved; empty keeps both files
+	ConflictPolicy string `json:"conflict_policy,omitempty" gorm:"type:varchar(20)"`
+	// Visibility requested for the file; nil follows the user's default
+	IsPublic *bool `json:"is_public,omitempty"`
+
+	// Multipart uploads only: the storage upload ID, how many parts the client declared
+	// and which part numbers it has reported as uploaded
+	MultipartUploadID string  `json:"-" gorm:"type:varchar(255)"`
+	TotalParts        int     `json:"total_parts,omitempty"`
+	CompletedParts    IntList `json:"completed_parts,omitempty" gorm:"type:jsonb"`
+}
+
+// AuditLog records a security-relevant action. During impersonation ActorID is the
+// impersonated user and ImpersonatedBy is the admin acting on their behalf.
+type AuditLog struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ActorID        string    `json:"actor_id" gorm:"type:varchar(255);not null;index"`
+	ImpersonatedBy string    `json:"impersonated_by,omitempty" gorm:"type:varchar(255);index"`
+	Action         string    `json:"action" gorm:"type:varchar(64);not null"`
+	TargetID       string    `json:"target_id,omitempty" gorm:"type:varchar(255)"`
+	Details        string    `json:"details,omitempty" gorm:"type:text"`
+	IPAddress      string    `json:"ip_address,omitempty" gorm:"type:varchar(64)"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+}
+
+// BannedHash blocks content from being uploaded, typically after a takedown request
+type BannedHash struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;type:varchar(64)"`
+	Reason    string    `json:"reason" gorm:"type:text"`
+	CreatedBy string    `json:"created_by" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AbuseReportStatus string
+
+const (
+	AbuseReportOpen        AbuseReportStatus = "open"
+	AbuseReportDismissed   AbuseReportStatus = "dismissed"
+	AbuseReportMadePrivate AbuseReportStatus = "made_private"
+	AbuseReportDeleted     AbuseReportStatus = "deleted"
+)
+
+// AbuseReport is an anonymous complaint about a public file. Each reporter IP may
+// report a file once per day; the IP is stored only as a hash.
+type AbuseReport struct {
+	ID             uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserFileID     uuid.UUID         `json:"user_file_id" gorm:"type:uuid;not null;uniqueIndex:idx_abuse_report_dedup,priority:1"`
+	Category       string            `json:"category" gorm:"type:varchar(32);not null"`
+	Details        string            `json:"details,omitempty" gorm:"type:text"`
+	ReporterIPHash string            `json:"-" gorm:"type:varchar(64);not null;uniqueIndex:idx_abuse_report_dedup,priority:2"`
+	ReportDate     string            `json:"-" gorm:"type:varchar(10);not null;uniqueIndex:idx_abuse_report_dedup,priority:3"`
+	Status         AbuseReportStatus `json:"status" gorm:"type:varchar(20);not null;default:'open';index"`
+	ResolvedBy     string            `json:"resolved_by,omitempty" gorm:"type:varchar(255)"`
+	ResolvedAt     *time.Time        `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// AnnouncementSeverity controls how prominently the frontend shows an announcement
+type AnnouncementSeverity string
+
+const (
+	AnnouncementInfo     AnnouncementSeverity = "info"
+	AnnouncementWarning  AnnouncementSeverity = "warning"
+	AnnouncementCritical AnnouncementSeverity = "critical"
+)
+
+// Announcement is an admin message shown to every user between StartsAt and EndsAt;
+// a nil EndsAt keeps it up until it's edited or deleted
+type Announcement struct {
+	ID        uuid.UUID            `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title     string               `json:"title" gorm:"type:varchar(200);not null"`
+	Body      string               `json:"body" gorm:"type:text"`
+	Severity  AnnouncementSeverity `json:"severity" gorm:"type:varchar(20);not null;default:'info'"`
+	StartsAt  time.Time            `json:"starts_at" gorm:"index"`
+	EndsAt    *time.Time           `json:"ends_at,omitempty" gorm:"index"`
+	CreatedBy string               `json:"created_by" gorm:"type:varchar(255)"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+// AnnouncementDismissal records that a user closed an announcement
+type AnnouncementDismissal struct {
+	AnnouncementID uuid.UUID `json:"announcement_id" gorm:"type:uuid;primaryKey"`
+	UserID         string    `json:"user_id" gorm:"type:varchar(255);primaryKey"`
+	DismissedAt    time.Time `json:"dismissed_at"`
+}
+
+// FileAccessRead lets a grantee view and download a file
+const FileAccessRead = "read"
+
+// FileAccess grants another user access to a file. The owner keeps full control;
+// grantees can only read. Revoked grants are kept for history.
+type FileAccess struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserFileID    uuid.UUID  `json:"user_file_id" gorm:"type:uuid;not null;uniqueIndex:idx_file_access_active,where:revoked_at IS NULL"`
+	OwnerUserID   string     `json:"owner_user_id" gorm:"type:varchar(255);not null;index"`
+	GranteeUserID string     `json:"grantee_user_id" gorm:"type:varchar(255);not null;index;uniqueIndex:idx_file_access_active,where:revoked_at IS NULL"`
+	Permissions   StringList `json:"permissions" gorm:"type:jsonb"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// BatchUpload is a batch upload session. Sync clients reuse one session across many
+// prepare/complete calls; ReservedBytes holds quota for prepared files that haven't
+// been completed yet. Like an upload session's, the reservation is counted in the
+// user's storage_used, so other batches and uploads can't overcommit it either.
+type BatchUpload struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID         string    `json:"user_id" gorm:"type:varchar(255);not null;index"`
+	ReservedBytes  int64     `json:"reserved_bytes" gorm:"default:0"`
+	PreparedFiles  int       `json:"prepared_files" gorm:"default:0"`
+	CompletedFiles int       `json:"completed_files" gorm:"default:0"`
+	ExpiresAt      time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// Set once the client aborts the batch; it's kept until it expires so aborting
+	// again reports the same result
+	AbortedAt *time.Time `json:"aborted_at,omitempty"`
+	// False for batches opened before reservations counted in storage_used, until
+	// startup recovery charges them
+	ReservationCharged bool `json:"-" gorm:"default:false"`
+}
+
+// BatchUploadFile is a file prepared in a batch session and waiting to be uploaded
+type BatchUploadFile struct {
+	UploadID     string    `json:"upload_id" gorm:"primaryKey;type:varchar(36)"`
+	BatchID      uuid.UUID `json:"batch_id" gorm:"type:uuid;not null;index"`
+	FileHash     string    `json:"file_hash" gorm:"type:varchar(64);not null"`
+	Size         int64     `json:"size"`
+	RelativePath string    `json:"relative_path,omitempty" gorm:"type:text"`
+	// Set for duplicates of stored content, which are linked rather than uploaded
+	Link      bool      `json:"link" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// ShareIDLength is the length of share link and shared collection IDs. Anyone holding
+// one can download, so it has to be long enough that guessing is hopeless.
+const ShareIDLength = 12
+
+// GenerateRandomID creates a cryptographically random alphanumeric ID of specified length
 func GenerateRandomID(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
+	// Values above the largest multiple of the charset size are redrawn, so every
+	// character is equally likely
+	const limit = math.MaxUint16 + 1 - (math.MaxUint16+1)%len(charset)
+
+	result := make([]byte, 0, length)
+	buf := make([]byte, 2*length)
+	for len(result) < length {
+		rand.Read(buf)
+		for i := 0; i+1 < len(buf) && len(result) < length; i += 2 {
+			if value := int(binary.BigEndian.Uint16(buf[i:])); value < limit {
+				result = append(result, charset[value%len(charset)])
+			}
+		}
 	}
 	return string(result)
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+const idCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func TestGenerateRandomIDIsUniqueAndUniform(t *testing.T) {
+	const count = 10000
+
+	seen := make(map[string]bool, count)
+	counts := make(map[rune]int, len(idCharset))
+	for range count {
+		id := GenerateRandomID(ShareIDLength)
+		if len(id) != ShareIDLength {
+			t.Fatalf("GenerateRandomID() = %q, want %d characters", id, ShareIDLength)
+		}
+		if seen[id] {
+			t.Fatalf("GenerateRandomID() returned %q twice in %d IDs", id, count)
+		}
+		seen[id] = true
+		for _, r := range id {
+			if !strings.ContainsRune(idCharset, r) {
+				t.Fatalf("GenerateRandomID() = %q contains %q, outside the charset", id, r)
+			}
+			counts[r]++
+		}
+	}
+
+	// Chi-squared over 62 characters has 61 degrees of freedom; a fair generator stays
+	// below 140 with overwhelming probability, while a skewed one like a plain
+	// modulo of a single byte lands far above it
+	expected := float64(count*ShareIDLength) / float64(len(idCharset))
+	var chiSquared float64
+	for _, r := range idCharset {
+		diff := float64(counts[r]) - expected
+		chiSquared += diff * diff / expected
+	}
+	if chiSquared > 140 {
+		t.Errorf("character distribution chi-squared = %.1f, want under 140 for a uniform charset", chiSquared)
+	}
+}
+
+func TestShareLinksGetLongIDs(t *testing.T) {
+	link := ShareLink{}
+	if err := link.BeforeCreate(nil); err != nil {
+		t.Fatalf("BeforeCreate() error = %v", err)
+	}
+	if len(link.ID) != 12 {
+		t.Errorf("share link ID = %q, want 12 characters", link.ID)
+	}
+
+	// An ID set explicitly is kept
+	link = ShareLink{ID: "abc12345"}
+	link.BeforeCreate(nil)
+	if link.ID != "abc12345" {
+		t.Errorf("share link ID = %q, want the ID it was created with", link.ID)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrAbuseReportNotFound is returned when an abuse report does not exist
+	ErrAbuseReportNotFound = errors.New("abuse report not found")
+	// ErrReportedFileNotFound is returned when reporting a file that doesn't exist or isn't public
+	ErrReportedFileNotFound = errors.New("file not found or not public")
+	// ErrInvalidReportCategory is returned for an unknown abuse report category
+	ErrInvalidReportCategory = errors.New("invalid report category")
+	// ErrInvalidReportAction is returned when an admin resolves a report with an unknown action
+	ErrInvalidReportAction = errors.New("invalid report action")
+)
+
+// AbuseReportCategories lists the reasons a file can be reported for
+var AbuseReportCategories = []string{"illegal", "malware", "copyright", "harassment", "spam", "other"}
+
+// Admin actions on an abuse report; each resolves every open report for the file
+const (
+	ReportActionDismiss     = "dismiss"
+	ReportActionMakePrivate = "make_private"
+	ReportActionDelete      = "delete"
+)
+
+// AbuseReportResult describes the outcome of filing a report
+type AbuseReportResult struct {
+	Duplicate        bool  // The reporter already reported this file today
+	OpenReports      int64 // Open reports against the file, including this one
+	ThresholdReached bool  // This report brought the file to the notification threshold
+}
+
+// AbuseReportResponse is an abuse report with the reported file's details for the admin
+// queue. The file fields are empty once the file has been deleted.
+type AbuseReportResponse struct {
+	models.AbuseReport
+	Filename string `json:"filename"`
+	OwnerID  string `json:"owner_id"`
+	IsPublic bool   `json:"is_public"`
+}
+
+// ReportPublicFile files an abuse report against a public file
+func (s *FileService) ReportPublicFile(fileID uuid.UUID, category, details, clientIP string) (*AbuseReportResult, error) {
+	var userFile models.UserFile
+	err := s.db.Where("id = ? AND is_public = ?", fileID, true).First(&userFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrReportedFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return s.createAbuseReport(userFile, category, details, clientIP)
+}
+
+// ReportSharedFile files an abuse report against the file behind a share link
+func (s *FileService) ReportSharedFile(shareID, category, details, clientIP string) (*AbuseReportResult, error) {
+	var shareLink models.ShareLink
+	err := s.db.Preload("UserFile").Where("id = ?", shareID).First(&shareLink).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && !shareLink.UserFile.IsPublic) {
+		return nil, ErrReportedFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+
+	return s.createAbuseReport(shareLink.UserFile, category, details, clientIP)
+}
+
+func (s *FileService) createAbuseReport(userFile models.UserFile, category, details, clientIP string) (*AbuseReportResult, error) {
+	if !validReportCategory(category) {
+		return nil, ErrInvalidReportCategory
+	}
+
+	now := time.Now().UTC()
+	report := models.AbuseReport{
+		ID:             uuid.New(),
+		UserFileID:     userFile.ID,
+		Category:       category,
+		Details:        details,
+		ReporterIPHash: s.hashClientIP(clientIP),
+		ReportDate:     now.Format("2006-01-02"),
+		Status:         models.AbuseReportOpen,
+		CreatedAt:      now,
+	}
+
+	// The unique index on file, reporter and day makes repeat reports a no-op
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&report)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create abuse report: %w", result.Error)
+	}
+
+	var open int64
+	err := s.db.Model(&models.AbuseReport{}).
+		Where("user_file_id = ? AND status = ?", userFile.ID, models.AbuseReportOpen).
+		Count(&open).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count abuse reports: %w", err)
+	}
+
+	created := result.RowsAffected > 0
+	return &AbuseReportResult{
+		Duplicate:        !created,
+		OpenReports:      open,
+		ThresholdReached: created && s.cfg.AbuseReportThreshold > 0 && open == int64(s.cfg.AbuseReportThreshold),
+	}, nil
+}
+
+// ListAbuseReports returns abuse reports for the admin queue, oldest first so the
+// longest-waiting reports are handled first. An empty status lists every report.
+func (s *FileService) ListAbuseReports(status string, offset, limit int) ([]AbuseReportResponse, int64, error) {
+	query := s.db.Model(&models.AbuseReport{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count abuse reports: %w", err)
+	}
+
+	// Reports outlive deleted files, so the file is joined rather than required
+	response := make([]AbuseReportResponse, 0)
+	err := query.Select("abuse_reports.*, user_files.filename, user_files.user_id AS owner_id, COALESCE(user_files.is_public, false) AS is_public").
+		Joins("LEFT JOIN user_files ON user_files.id = abuse_reports.user_file_id").
+		Order("abuse_reports.created_at ASC").
+		Offset(offset).
+		Limit(limit).
+		Scan(&response).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list abuse reports: %w", err)
+	}
+
+	return response, total, nil
+}
+
+// ResolveAbuseReport applies an admin action to the reported file and closes every
+// open report against it. Deleting a file its owner locked needs overrideLock. It
+// returns the reported file's ID for auditing.
+func (s *FileService) ResolveAbuseReport(ctx context.Context, reportID uuid.UUID, action, adminID string, overrideLock bool) (uuid.UUID, error) {
+	var report models.AbuseReport
+	err := s.db.WithContext(ctx).Where("id = ?", reportID).First(&report).Error
+	if err == gorm.ErrRecordNotFound {
+		return uuid.Nil, ErrAbuseReportNotFound
+	} else if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get abuse report: %w", err)
+	}
+
+	// The file may already be gone; only dismissing makes sense then
+	var userFile models.UserFile
+	err = s.db.WithContext(ctx).Where("id = ?", report.UserFileID).First(&userFile).Error
+	fileExists := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return uuid.Nil, fmt.Errorf("failed to get reported file: %w", err)
+	}
+
+	var status models.AbuseReportStatus
+	switch action {
+	case ReportActionDismiss:
+		status = models.AbuseReportDismissed
+	case ReportActionMakePrivate:
+		status = models.AbuseReportMadePrivate
+		if fileExists && userFile.IsPublic {
+			if err := s.ToggleFilePublic(ctx, userFile.UserID, userFile.ID, nil); err != nil {
+				return uuid.Nil, err
+			}
+		}
+	case ReportActionDelete:
+		status = models.AbuseReportDeleted
+		// Reports are kept as a record, so the file is deleted before closing them
+		if fileExists {
+			if err := s.deleteUserFile(ctx, userFile.UserID, userFile.ID, overrideLock); err != nil {
+				return uuid.Nil, err
+			}
+		}
+	default:
+		return uuid.Nil, ErrInvalidReportAction
+	}
+
+	now := time.Now().UTC()
+	err = s.db.WithContext(ctx).Model(&models.AbuseReport{}).
+		Where("user_file_id = ? AND (status = ? OR id = ?)", report.UserFileID, models.AbuseReportOpen, report.ID).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"resolved_by": adminID,
+			"resolved_at": now,
+		}).Error
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to resolve abuse reports: %w", err)
+	}
+
+	return report.UserFileID, nil
+}
+
+func validReportCategory(category string) bool {
+	for _, c := range AbuseReportCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashClientIPIsKeyed(t *testing.T) {
+	a := &FileService{ipHashKey: []byte("first-ip-hash-key-0123456789abcdef")}
+	b := &FileService{ipHashKey: []byte("second-ip-hash-key-0123456789abcde")}
+
+	hash := a.hashClientIP("203.0.113.7")
+	if len(hash) != 64 {
+		t.Fatalf("hashClientIP() length = %d, want 64 to fit the stored column", len(hash))
+	}
+	if hash != a.hashClientIP("203.0.113.7") {
+		t.Error("hashClientIP() is not stable for the same key, so report dedup would break")
+	}
+	if hash == b.hashClientIP("203.0.113.7") {
+		t.Error("hashClientIP() ignores the key")
+	}
+
+	plain := sha256.Sum256([]byte("203.0.113.7"))
+	if hash == hex.EncodeToString(plain[:]) {
+		t.Error("hashClientIP() is a plain SHA-256 that can be reversed by enumerating addresses")
+	}
+}
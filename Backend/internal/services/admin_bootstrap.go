@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrAdminAlreadyExists is returned when bootstrapping an admin on an instance that
+// already has one; further admins are promoted through the admin API
+var ErrAdminAlreadyExists = errors.New("an admin already exists")
+
+// ErrSuperAdminRequired is returned when an admin who isn't a super admin changes a
+// super admin's role
+var ErrSuperAdminRequired = errors.New("super admin required")
+
+// isInitialAdmin reports whether the user is listed in INITIAL_ADMIN_USER_IDS
+func (s *UserService) isInitialAdmin(userID string) bool {
+	return slices.Contains(s.cfg.InitialAdminUserIDs, userID)
+}
+
+// applyInitialAdmin promotes a regular user listed in INITIAL_ADMIN_USER_IDS. Users an
+// admin demoted stay demoted only once they're removed from the list.
+func (s *UserService) applyInitialAdmin(user *models.User) error {
+	if user.Role != models.UserRoleUser || !s.isInitialAdmin(user.ID) {
+		return nil
+	}
+
+	if err := s.db.Model(&models.User{}).Where("id = ?", user.ID).Update("role", models.UserRoleAdmin).Error; err != nil {
+		return fmt.Errorf("failed to apply initial admin role: %w", err)
+	}
+	user.Role = models.UserRoleAdmin
+	slog.Warn("initial_admin_granted", slog.String("user_id", user.ID), slog.String("source", "INITIAL_ADMIN_USER_IDS"))
+	return nil
+}
+
+// BootstrapAdmin makes the user the instance's super admin, creating their row if they
+// haven't signed in yet. Only super admins can grant the super role through the API, so
+// this is how the first one is made. It works while no admin exists, or while admins
+// exist but none is a super admin, in which case only one of them can be promoted; it
+// can't be used to take over an instance that is already set up.
+func (s *UserService) BootstrapAdmin(userID string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Serialize with any concurrent bootstrap so only one can see zero admins
+		if err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return fmt.Errorf("failed to lock users: %w", err)
+		}
+
+		var supers, admins int64
+		if err := tx.Model(&models.User{}).Where("role = ?", models.UserRoleSuper).Count(&supers).Error; err != nil {
+			return fmt.Errorf("failed to count super admins: %w", err)
+		}
+		if supers > 0 {
+			return ErrAdminAlreadyExists
+		}
+		if err := tx.Model(&models.User{}).Where("role = ?", models.UserRoleAdmin).Count(&admins).Error; err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+
+		promote := tx.Model(&models.User{}).Where("id = ?", userID)
+		if admins > 0 {
+			promote = promote.Where("role = ?", models.UserRoleAdmin)
+		}
+		result := promote.Update("role", models.UserRoleSuper)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update user role: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+		if admins > 0 {
+			return ErrAdminAlreadyExists
+		}
+
+		user := s.newUser(userID)
+		user.Role = models.UserRoleSuper
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.users.delete(userID)
+	slog.Warn("initial_admin_granted", slog.String("user_id", userID), slog.String("role", string(models.UserRoleSuper)), slog.String("source", "promote-admin"))
+	return nil
+}
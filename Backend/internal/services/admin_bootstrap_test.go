@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+)
+
+// newRoleTestService returns a user service on an empty users table
+func newRoleTestService(t *testing.T, users ...models.User) *UserService {
+	t.Helper()
+
+	tx := testTx(t, &models.User{})
+	if err := tx.Exec("DELETE FROM users").Error; err != nil {
+		t.Fatalf("failed to clear users: %v", err)
+	}
+	for _, user := range users {
+		if err := tx.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create user %s: %v", user.ID, err)
+		}
+	}
+	return NewUserService(tx, &config.Config{})
+}
+
+func userRole(t *testing.T, s *UserService, userID string) models.UserRole {
+	t.Helper()
+
+	var user models.User
+	if err := s.db.Select("role").Where("id = ?", userID).First(&user).Error; err != nil {
+		t.Fatalf("failed to get user %s: %v", userID, err)
+	}
+	return user.Role
+}
+
+func TestBootstrapAdminMakesFirstSuperAdmin(t *testing.T) {
+	s := newRoleTestService(t)
+
+	if err := s.BootstrapAdmin("user-a"); err != nil {
+		t.Fatalf("BootstrapAdmin() error = %v", err)
+	}
+	if role := userRole(t, s, "user-a"); role != models.UserRoleSuper {
+		t.Errorf("role = %q, want %q so someone can grant the super role", role, models.UserRoleSuper)
+	}
+}
+
+func TestBootstrapAdminPromotesOnlyExistingAdminsWithoutSuper(t *testing.T) {
+	s := newRoleTestService(t,
+		models.User{ID: "admin-a", Role: models.UserRoleAdmin},
+		models.User{ID: "user-b", Role: models.UserRoleUser},
+	)
+
+	if err := s.BootstrapAdmin("user-b"); !errors.Is(err, ErrAdminAlreadyExists) {
+		t.Errorf("BootstrapAdmin(user) error = %v, want ErrAdminAlreadyExists", err)
+	}
+	if err := s.BootstrapAdmin("admin-a"); err != nil {
+		t.Fatalf("BootstrapAdmin(admin) error = %v", err)
+	}
+	if role := userRole(t, s, "admin-a"); role != models.UserRoleSuper {
+		t.Errorf("role = %q, want %q", role, models.UserRoleSuper)
+	}
+}
+
+func TestBootstrapAdminRejectsOnceSetUp(t *testing.T) {
+	s := newRoleTestService(t,
+		models.User{ID: "super-a", Role: models.UserRoleSuper},
+		models.User{ID: "admin-b", Role: models.UserRoleAdmin},
+		models.User{ID: "user-c", Role: models.UserRoleUser},
+	)
+
+	for _, userID := range []string{"admin-b", "user-c", "not-signed-in"} {
+		if err := s.BootstrapAdmin(userID); !errors.Is(err, ErrAdminAlreadyExists) {
+			t.Errorf("BootstrapAdmin(%s) error = %v, want ErrAdminAlreadyExists", userID, err)
+		}
+	}
+	if role := userRole(t, s, "admin-b"); role != models.UserRoleAdmin {
+		t.Errorf("admin's role = %q after a refused bootstrap, want %q", role, models.UserRoleAdmin)
+	}
+	if role := userRole(t, s, "user-c"); role != models.UserRoleUser {
+		t.Errorf("user's role = %q after a refused bootstrap, want %q", role, models.UserRoleUser)
+	}
+	var created int64
+	if err := s.db.Model(&models.User{}).Where("id = ?", "not-signed-in").Count(&created).Error; err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	if created != 0 {
+		t.Error("a refused bootstrap created a user row")
+	}
+}
+
+func TestUpdateUserRoleProtectsSuperAdmins(t *testing.T) {
+	s := newRoleTestService(t,
+		models.User{ID: "super-a", Role: models.UserRoleSuper},
+		models.User{ID: "user-b", Role: models.UserRoleUser},
+	)
+
+	if err := s.UpdateUserRole("super-a", models.UserRoleUser, models.UserRoleAdmin); !errors.Is(err, ErrSuperAdminRequired) {
+		t.Errorf("admin demoting a super admin: error = %v, want ErrSuperAdminRequired", err)
+	}
+	if role := userRole(t, s, "super-a"); role != models.UserRoleSuper {
+		t.Errorf("super admin's role = %q after a refused change", role)
+	}
+	if err := s.UpdateUserRole("user-b", models.UserRoleSuper, models.UserRoleAdmin); !errors.Is(err, ErrSuperAdminRequired) {
+		t.Errorf("admin granting super: error = %v, want ErrSuperAdminRequired", err)
+	}
+
+	if err := s.UpdateUserRole("user-b", models.UserRoleAdmin, models.UserRoleAdmin); err != nil {
+		t.Errorf("admin promoting a user: error = %v", err)
+	}
+	if err := s.UpdateUserRole("super-a", models.UserRoleAdmin, models.UserRoleSuper); err != nil {
+		t.Errorf("super admin changing a super admin: error = %v", err)
+	}
+	if err := s.UpdateUserRole("missing", models.UserRoleAdmin, models.UserRoleSuper); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user: error = %v, want ErrUserNotFound", err)
+	}
+}
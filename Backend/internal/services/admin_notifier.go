@@ -0,0 +1,52 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AdminNotifier posts JSON alerts to an operator-configured webhook. It is a no-op
+// when no webhook is configured.
+type AdminNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func NewAdminNotifier(webhookURL string) *AdminNotifier {
+	return &AdminNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify sends an alert in the background; delivery failures are logged
+func (n *AdminNotifier) Notify(event, text string, data map[string]interface{}) {
+	if n.webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"event": event,
+		"text":  text,
+		"data":  data,
+	})
+	if err != nil {
+		log.Printf("Failed to encode admin notification %s: %v", event, err)
+		return
+	}
+
+	go func() {
+		resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to send admin notification %s: %v", event, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Admin webhook rejected notification %s: status %d", event, resp.StatusCode)
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"gorm.io/gorm"
+)
+
+// MaxImpersonationTTL caps how long an impersonation token stays valid
+const MaxImpersonationTTL = 15 * time.Minute
+
+const impersonationIssuer = "filevault-impersonation"
+
+var (
+	// ErrImpersonationDisabled is returned when impersonation is turned off in config
+	ErrImpersonationDisabled = errors.New("impersonation is disabled")
+	// ErrImpersonationNotAllowed is returned for targets that may not be impersonated
+	ErrImpersonationNotAllowed = errors.New("cannot impersonate this user")
+)
+
+// ImpersonationClaims are the claims carried by an impersonation token
+type ImpersonationClaims struct {
+	jwt.Claims
+	ImpersonatedBy string `json:"impersonated_by"`
+}
+
+type AdminService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	storage *storage.MinIOStorage
+
+	// Recent top files reports by dimension, limit and user filter
+	topFiles *ttlCache[[]TopFileEntry]
+}
+
+func NewAdminService(db *gorm.DB, cfg *config.Config, storage *storage.MinIOStorage) *AdminService {
+	return &AdminService{
+		db:       db,
+		cfg:      cfg,
+		storage:  storage,
+		topFiles: newTTLCache[[]TopFileEntry]("top_files", cacheTTL(cfg.CacheEnabled, topFilesCacheSeconds)),
+	}
+}
+
+// CreateImpersonationToken signs a short-lived token that authenticates as targetUserID
+// while recording adminID as the impersonator. TTLs above MaxImpersonationTTL are capped.
+func (s *AdminService) CreateImpersonationToken(adminID, targetUserID string, ttl time.Duration) (string, error) {
+	if !s.cfg.ImpersonationEnabled {
+		return "", ErrImpersonationDisabled
+	}
+	if adminID == targetUserID {
+		return "", fmt.Errorf("%w: admins cannot impersonate themselves", ErrImpersonationNotAllowed)
+	}
+	if ttl <= 0 || ttl > MaxImpersonationTTL {
+		ttl = MaxImpersonationTTL
+	}
+
+	var target models.User
+	err := s.db.Select("id", "role").Where("id = ?", targetUserID).First(&target).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrUserNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	// Impersonating another admin would let one admin act with another's privileges
+	if target.Role.IsAdmin() {
+		return "", fmt.Errorf("%w: target is an admin", ErrImpersonationNotAllowed)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(s.cfg.ImpersonationSecret)}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token signer: %w", err)
+	}
+
+	now := time.Now()
+	claims := ImpersonationClaims{
+		Claims: jwt.Claims{
+			Issuer:   impersonationIssuer,
+			Subject:  targetUserID,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(ttl)),
+		},
+		ImpersonatedBy: adminID,
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign impersonation token: %w", err)
+	}
+
+	return token, nil
+}
+
+// VerifyImpersonationToken checks the signature, issuer and expiry of an impersonation token
+func VerifyImpersonationToken(secret, token string) (*ImpersonationClaims, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims ImpersonationClaims
+	if err := parsed.Claims([]byte(secret), &claims); err != nil {
+		return nil, err
+	}
+
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: impersonationIssuer, Time: time.Now()}, 0); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ImpersonatedBy == "" {
+		return nil, fmt.Errorf("impersonation token is missing required claims")
+	}
+
+	return &claims, nil
+}
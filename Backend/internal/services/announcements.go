@@ -0,0 +1,237 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxAnnouncementTitleLength = 200
+	maxAnnouncementBodyLength  = 5000
+)
+
+var (
+	// ErrAnnouncementNotFound is returned when an announcement does not exist, or for a
+	// user, isn't currently shown
+	ErrAnnouncementNotFound = errors.New("announcement not found")
+	// ErrInvalidAnnouncement is returned when an announcement fails validation
+	ErrInvalidAnnouncement = errors.New("invalid announcement")
+)
+
+// AnnouncementInput is an announcement as written by an admin. On update, nil fields
+// are left as they are; ClearEndsAt removes the end time.
+type AnnouncementInput struct {
+	Title       *string                      `json:"title"`
+	Body        *string                      `json:"body"`
+	Severity    *models.AnnouncementSeverity `json:"severity"`
+	StartsAt    *time.Time                   `json:"starts_at"`
+	EndsAt      *time.Time                   `json:"ends_at"`
+	ClearEndsAt bool                         `json:"clear_ends_at"`
+}
+
+// UserAnnouncement is an active announcement with whether the user has dismissed it
+type UserAnnouncement struct {
+	models.Announcement
+	Dismissed bool `json:"dismissed"`
+}
+
+type AnnouncementService struct {
+	db *gorm.DB
+}
+
+func NewAnnouncementService(db *gorm.DB) *AnnouncementService {
+	return &AnnouncementService{
+		db: db,
+	}
+}
+
+// CreateAnnouncement publishes an announcement; it starts now unless StartsAt says otherwise
+func (s *AnnouncementService) CreateAnnouncement(adminID string, input AnnouncementInput) (*models.Announcement, error) {
+	announcement := models.Announcement{
+		ID:        uuid.New(),
+		Severity:  models.AnnouncementInfo,
+		StartsAt:  time.Now().UTC(),
+		CreatedBy: adminID,
+	}
+	if err := applyAnnouncementInput(&announcement, input); err != nil {
+		return nil, err
+	}
+	if announcement.Title == "" {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidAnnouncement)
+	}
+
+	if err := s.db.Create(&announcement).Error; err != nil {
+		return nil, fmt.Errorf("failed to create announcement: %w", err)
+	}
+	return &announcement, nil
+}
+
+// UpdateAnnouncement changes the given fields of an announcement
+func (s *AnnouncementService) UpdateAnnouncement(id uuid.UUID, input AnnouncementInput) (*models.Announcement, error) {
+	var announcement models.Announcement
+	err := s.db.Where("id = ?", id).First(&announcement).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrAnnouncementNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get announcement: %w", err)
+	}
+
+	if err := applyAnnouncementInput(&announcement, input); err != nil {
+		return nil, err
+	}
+	if announcement.Title == "" {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidAnnouncement)
+	}
+
+	if err := s.db.Save(&announcement).Error; err != nil {
+		return nil, fmt.Errorf("failed to update announcement: %w", err)
+	}
+	return &announcement, nil
+}
+
+// DeleteAnnouncement removes an announcement along with its dismissals
+func (s *AnnouncementService) DeleteAnnouncement(id uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&models.Announcement{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete announcement: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAnnouncementNotFound
+		}
+		if err := tx.Where("announcement_id = ?", id).Delete(&models.AnnouncementDismissal{}).Error; err != nil {
+			return fmt.Errorf("failed to delete announcement dismissals: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListAnnouncements returns every announcement, including scheduled and ended ones,
+// most recently starting first
+func (s *AnnouncementService) ListAnnouncements(offset, limit int) ([]models.Announcement, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.Announcement{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count announcements: %w", err)
+	}
+
+	announcements := []models.Announcement{}
+	if err := s.db.Order("starts_at DESC").Offset(offset).Limit(limit).Find(&announcements).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list announcements: %w", err)
+	}
+	return announcements, total, nil
+}
+
+// ActiveAnnouncements returns the announcements currently shown, most severe and then
+// newest first, with whether the user has dismissed each
+func (s *AnnouncementService) ActiveAnnouncements(userID string) ([]UserAnnouncement, error) {
+	var active []models.Announcement
+	err := s.activeQuery().
+		Order("CASE severity WHEN 'critical' THEN 0 WHEN 'warning' THEN 1 ELSE 2 END").
+		Order("starts_at DESC").
+		Find(&active).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active announcements: %w", err)
+	}
+
+	var dismissedIDs []uuid.UUID
+	if len(active) > 0 {
+		ids := make([]uuid.UUID, len(active))
+		for i, announcement := range active {
+			ids[i] = announcement.ID
+		}
+		err := s.db.Model(&models.AnnouncementDismissal{}).
+			Where("user_id = ? AND announcement_id IN ?", userID, ids).
+			Pluck("announcement_id", &dismissedIDs).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dismissed announcements: %w", err)
+		}
+	}
+	dismissed := make(map[uuid.UUID]bool, len(dismissedIDs))
+	for _, id := range dismissedIDs {
+		dismissed[id] = true
+	}
+
+	announcements := make([]UserAnnouncement, 0, len(active))
+	for _, announcement := range active {
+		announcements = append(announcements, UserAnnouncement{Announcement: announcement, Dismissed: dismissed[announcement.ID]})
+	}
+	return announcements, nil
+}
+
+// DismissAnnouncement hides a currently shown announcement for the user; dismissing
+// twice is harmless
+func (s *AnnouncementService) DismissAnnouncement(userID string, id uuid.UUID) error {
+	var count int64
+	if err := s.activeQuery().Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get announcement: %w", err)
+	}
+	if count == 0 {
+		return ErrAnnouncementNotFound
+	}
+
+	dismissal := models.AnnouncementDismissal{AnnouncementID: id, UserID: userID, DismissedAt: time.Now().UTC()}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&dismissal).Error; err != nil {
+		return fmt.Errorf("failed to dismiss announcement: %w", err)
+	}
+	return nil
+}
+
+// HasActiveCritical reports whether a critical announcement is currently shown.
+// Lookup failures report false.
+func (s *AnnouncementService) HasActiveCritical() bool {
+	var count int64
+	err := s.activeQuery().Where("severity = ?", models.AnnouncementCritical).Count(&count).Error
+	return err == nil && count > 0
+}
+
+func (s *AnnouncementService) activeQuery() *gorm.DB {
+	now := time.Now().UTC()
+	return s.db.Model(&models.Announcement{}).Where("starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", now, now)
+}
+
+// applyAnnouncementInput validates input and copies the fields it sets
+func applyAnnouncementInput(announcement *models.Announcement, input AnnouncementInput) error {
+	if input.Title != nil {
+		title := strings.TrimSpace(*input.Title)
+		if len(title) > maxAnnouncementTitleLength {
+			return fmt.Errorf("%w: title may be at most %d characters", ErrInvalidAnnouncement, maxAnnouncementTitleLength)
+		}
+		announcement.Title = title
+	}
+	if input.Body != nil {
+		if len(*input.Body) > maxAnnouncementBodyLength {
+			return fmt.Errorf("%w: body may be at most %d characters", ErrInvalidAnnouncement, maxAnnouncementBodyLength)
+		}
+		announcement.Body = *input.Body
+	}
+	if input.Severity != nil {
+		switch *input.Severity {
+		case models.AnnouncementInfo, models.AnnouncementWarning, models.AnnouncementCritical:
+			announcement.Severity = *input.Severity
+		default:
+			return fmt.Errorf("%w: severity must be info, warning or critical", ErrInvalidAnnouncement)
+		}
+	}
+	if input.StartsAt != nil {
+		announcement.StartsAt = input.StartsAt.UTC()
+	}
+	if input.ClearEndsAt {
+		announcement.EndsAt = nil
+	} else if input.EndsAt != nil {
+		endsAt := input.EndsAt.UTC()
+		announcement.EndsAt = &endsAt
+	}
+
+	if announcement.EndsAt != nil && !announcement.EndsAt.After(announcement.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidAnnouncement)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit actions
+const (
+	AuditUserDeleted          = "user_deleted"
+	AuditUserRoleChanged      = "user_role_changed"
+	AuditUserQuotaChanged     = "user_quota_changed"
+	AuditUserFileCountChanged = "user_file_count_quota_changed"
+	AuditUserBandwidthChanged = "user_bandwidth_changed"
+	AuditUserRateLimitChanged = "user_rate_limit_changed"
+	AuditStorageRecalculated  = "storage_recalculated"
+	AuditStorageRecalcStarted = "storage_recalculation_started"
+	AuditHashBanned           = "hash_banned"
+	AuditHashUnbanned         = "hash_unbanned"
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonatedRequest  = "impersonated_request"
+	AuditAbuseReportResolved  = "abuse_report_resolved"
+	AuditLegalHoldSet         = "legal_hold_set"
+	AuditLegalHoldCleared     = "legal_hold_cleared"
+	AuditAnnouncementCreated  = "announcement_created"
+	AuditAnnouncementUpdated  = "announcement_updated"
+	AuditAnnouncementDeleted  = "announcement_deleted"
+	AuditWebDAVTokenIssued    = "webdav_token_issued"
+	AuditWebDAVTokenRevoked   = "webdav_token_revoked"
+)
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{
+		db: db,
+	}
+}
+
+// Record appends an entry to the audit log in the background; failures are logged
+// rather than failing the action being audited
+func (s *AuditService) Record(entry models.AuditLog) {
+	entry.ID = uuid.New()
+	entry.CreatedAt = time.Now().UTC()
+
+	go func() {
+		if err := s.db.Create(&entry).Error; err != nil {
+			log.Printf("Failed to record audit entry %s by %s: %v", entry.Action, entry.ActorID, err)
+		}
+	}()
+}
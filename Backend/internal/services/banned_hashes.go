@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrHashBanned is returned when an upload matches a hash on the banned list
+	ErrHashBanned = errors.New("this content has been blocked and cannot be uploaded")
+	// ErrBannedHashNotFound is returned when unbanning a hash that is not on the list
+	ErrBannedHashNotFound = errors.New("banned hash not found")
+)
+
+// BanHashReport summarizes a ban and, when requested, the files purged because of it
+type BanHashReport struct {
+	BannedHash    models.BannedHash `json:"banned_hash"`
+	Purged        bool              `json:"purged"`
+	PurgedFiles   int               `json:"purged_files"`
+	AffectedUsers []string          `json:"affected_users"`
+}
+
+// checkBannedHash is a primary key lookup so it stays cheap on every upload
+func (s *FileService) checkBannedHash(fileHash string) error {
+	var count int64
+	err := s.db.Model(&models.BannedHash{}).Where("hash = ?", strings.ToLower(fileHash)).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check banned hashes: %w", err)
+	}
+	if count > 0 {
+		return ErrHashBanned
+	}
+	return nil
+}
+
+// bannedHashSet returns which of the given hashes are banned
+func (s *FileService) bannedHashSet(fileHashes []string) (map[string]bool, error) {
+	normalized := make([]string, len(fileHashes))
+	for i, hash := range fileHashes {
+		normalized[i] = strings.ToLower(hash)
+	}
+
+	var banned []string
+	err := s.db.Model(&models.BannedHash{}).Where("hash IN ?", normalized).Pluck("hash", &banned).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check banned hashes: %w", err)
+	}
+
+	set := make(map[string]bool, len(banned))
+	for _, hash := range banned {
+		set[hash] = true
+	}
+	return set, nil
+}
+
+// BanHash adds a hash to the banned list. When purge is set, every file with that
+// content is removed along with its share links and the stored object. Files their
+// owners locked are only purged when overrideLocks is set.
+func (s *FileService) BanHash(fileHash, reason, createdBy string, purge, overrideLocks bool) (*BanHashReport, error) {
+	fileHash = strings.ToLower(fileHash)
+	report := &BanHashReport{
+		BannedHash: models.BannedHash{
+			Hash:      fileHash,
+			Reason:    reason,
+			CreatedBy: createdBy,
+		},
+		Purged:        purge,
+		AffectedUsers: []string{},
+	}
+
+	var purgedFiles []models.UserFile
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&report.BannedHash).Error; err != nil {
+			return fmt.Errorf("failed to save banned hash: %w", err)
+		}
+
+		if !purge {
+			return nil
+		}
+
+		if err := tx.Unscoped().Where("file_hash = ?", fileHash).Find(&purgedFiles).Error; err != nil {
+			return fmt.Errorf("failed to find files to purge: %w", err)
+		}
+		if len(purgedFiles) == 0 {
+			return nil
+		}
+		for _, file := range purgedFiles {
+			if file.LegalHold {
+				return ErrFileUnderLegalHold
+			}
+			if file.Locked && !overrideLocks {
+				return ErrFileLocked
+			}
+		}
+
+		fileIDs := make([]uuid.UUID, len(purgedFiles))
+		for i, file := range purgedFiles {
+			fileIDs[i] = file.ID
+		}
+
+		if err := s.deleteFileRelations(tx, fileIDs...); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("file_hash = ?", fileHash).Delete(&models.UserFile{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user files: %w", err)
+		}
+
+		// The content stops counting toward its owners' usage
+		filesByOwner := make(map[string]int)
+		for _, file := range purgedFiles {
+			if !file.DeletedAt.Valid {
+				filesByOwner[file.UserID]++
+			}
+		}
+		for ownerID, files := range filesByOwner {
+			if err := s.releaseStoredFiles(tx, ownerID, fileHash, files); err != nil {
+				return err
+			}
+		}
+
+		var fileHashRecord models.FileHash
+		err := tx.Where("hash = ?", fileHash).First(&fileHashRecord).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get file hash record: %w", err)
+		}
+
+		if err := tx.Delete(&fileHashRecord).Error; err != nil {
+			return fmt.Errorf("failed to delete file hash record: %w", err)
+		}
+		return s.deletionQueue.EnqueueContentTx(tx, fileHashRecord)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range purgedFiles {
+		s.forgetSharedFile(file.ID)
+		if !seen[file.UserID] {
+			seen[file.UserID] = true
+			report.AffectedUsers = append(report.AffectedUsers, file.UserID)
+		}
+		s.RecordActivity(models.UserActivity{
+			UserID:   file.UserID,
+			Action:   models.ActivityDelete,
+			FileID:   &file.ID,
+			Filename: file.Filename,
+			Details:  "removed by administrator",
+		})
+	}
+	report.PurgedFiles = len(purgedFiles)
+
+	return report, nil
+}
+
+// UnbanHash removes a hash from the banned list
+func (s *FileService) UnbanHash(fileHash string) error {
+	result := s.db.Where("hash = ?", strings.ToLower(fileHash)).Delete(&models.BannedHash{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete banned hash: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrBannedHashNotFound
+	}
+	return nil
+}
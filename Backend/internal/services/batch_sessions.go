@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// A batch session stays open this long after its last prepare call
+	batchSessionTTL = 24 * time.Hour
+	// MaxBatchFiles is the most files one batch prepare request may contain
+	MaxBatchFiles = 10
+)
+
+var (
+	// ErrBatchSessionNotFound is returned for unknown, expired or other users' batch IDs
+	ErrBatchSessionNotFound = errors.New("batch upload session not found or expired")
+	// ErrInvalidRelativePath is returned for relative paths that are absolute or leave the synced folder
+	ErrInvalidRelativePath = errors.New("relative path must stay inside the synced folder")
+)
+
+// openBatchSession continues the user's batch session, or starts a new one when
+// batchID is empty
+func (s *FileService) openBatchSession(ctx context.Context, userID, batchID string) (*models.BatchUpload, error) {
+	if batchID == "" {
+		session := models.BatchUpload{
+			ID:                 uuid.New(),
+			UserID:             userID,
+			ExpiresAt:          s.dbNow().Add(batchSessionTTL),
+			ReservationCharged: true,
+		}
+		if err := s.db.WithContext(ctx).Create(&session).Error; err != nil {
+			return nil, fmt.Errorf("failed to create batch session: %w", err)
+		}
+		return &session, nil
+	}
+
+	return s.getBatchSession(ctx, userID, batchID)
+}
+
+func (s *FileService) getBatchSession(ctx context.Context, userID, batchID string) (*models.BatchUpload, error) {
+	id, err := uuid.Parse(batchID)
+	if err != nil {
+		return nil, ErrBatchSessionNotFound
+	}
+
+	var session models.BatchUpload
+	err = s.db.WithContext(ctx).Where("id = ? AND user_id = ? AND expires_at > ? AND aborted_at IS NULL", id, userID, s.dbNow()).
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrBatchSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get batch session: %w", err)
+	}
+	return &session, nil
+}
+
+// recordBatchPrepared stores the files waiting to be uploaded, reserves their size
+// against the session and the user's usage, and extends the session's expiry
+func (s *FileService) recordBatchPrepared(ctx context.Context, session *models.BatchUpload, pending []models.BatchUploadFile, prepared int) error {
+	var reserved int64
+	for _, file := range pending {
+		reserved += file.Size
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The session may have been aborted or expired since it was looked up, in
+		// which case nothing would ever release the reservation
+		result := tx.Model(session).Where("expires_at > ? AND aborted_at IS NULL", s.dbNow()).Updates(map[string]interface{}{
+			"reserved_bytes": gorm.Expr("reserved_bytes + ?", reserved),
+			"prepared_files": gorm.Expr("prepared_files + ?", prepared),
+			"expires_at":     s.dbNow().Add(batchSessionTTL),
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update batch session: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrBatchSessionNotFound
+		}
+
+		if len(pending) > 0 {
+			if err := tx.Create(&pending).Error; err != nil {
+				return fmt.Errorf("failed to record batch files: %w", err)
+			}
+		}
+		return adjustStorageUsed(tx, session.UserID, reserved)
+	})
+}
+
+// recordBatchCompleted releases a completed file's reservation. Completing the file
+// charged what it actually adds to the user's usage.
+func (s *FileService) recordBatchCompleted(ctx context.Context, session *models.BatchUpload, file models.BatchUploadFile) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&file)
+		if result.Error != nil {
+			return fmt.Errorf("failed to release batch file: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		err := tx.Model(session).Updates(map[string]interface{}{
+			"reserved_bytes":  gorm.Expr("reserved_bytes - ?", file.Size),
+			"completed_files": gorm.Expr("completed_files + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return adjustStorageUsed(tx, session.UserID, -file.Size)
+	})
+}
+
+// adjustStorageUsed adds delta bytes, which may be negative, to the user's storage usage
+func adjustStorageUsed(tx *gorm.DB, userID string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	err := tx.Model(&models.User{}).Where("id = ?", userID).
+		Update("storage_used", gorm.Expr("storage_used + ?", delta)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update storage usage: %w", err)
+	}
+	return nil
+}
+
+// BatchAbortResponse is a batch's final state after aborting it
+type BatchAbortResponse struct {
+	BatchID string `json:"batch_id"`
+	// "aborted", or "completed" when every prepared file was already completed
+	Status         string     `json:"status"`
+	PreparedFiles  int        `json:"prepared_files"`
+	CompletedFiles int        `json:"completed_files"`
+	DiscardedFiles int        `json:"discarded_files"`
+	AbortedAt      *time.Time `json:"aborted_at,omitempty"`
+}
+
+// AbortBatchUpload ends a batch the client gave up on. Files prepared and not yet
+// completed are discarded along with their staged objects and the batch's
+// reservation; duplicates were never linked, so they just go. Files already completed
+// are kept. A batch with nothing left to complete, or one aborted before, is left as
+// it is and its state returned.
+func (s *FileService) AbortBatchUpload(ctx context.Context, userID, batchID string) (*BatchAbortResponse, error) {
+	id, err := uuid.Parse(batchID)
+	if err != nil {
+		return nil, ErrBatchSessionNotFound
+	}
+
+	var session models.BatchUpload
+	var discarded []models.BatchUploadFile
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locked so a concurrent prepare can't add files while they're discarded
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, s.dbNow()).
+			First(&session).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrBatchSessionNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to get batch session: %w", err)
+		}
+		if session.AbortedAt != nil {
+			return nil
+		}
+
+		if err := tx.Clauses(clause.Returning{}).Where("batch_id = ?", session.ID).Delete(&discarded).Error; err != nil {
+			return fmt.Errorf("failed to discard batch files: %w", err)
+		}
+		if len(discarded) == 0 {
+			return nil
+		}
+
+		abortedAt := s.dbNow()
+		session.AbortedAt = &abortedAt
+		err = tx.Model(&session).Updates(map[string]interface{}{
+			"aborted_at":     abortedAt,
+			"reserved_bytes": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return adjustStorageUsed(tx, userID, -session.ReservedBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range discarded {
+		if !file.Link {
+			s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: s.storage.StagingKey(userID, file.UploadID)})
+		}
+	}
+	if len(discarded) > 0 {
+		slog.Info("batch_upload_aborted", slog.String("batch_id", session.ID.String()), slog.String("user_id", userID),
+			slog.Int("discarded_files", len(discarded)), slog.Int("completed_files", session.CompletedFiles))
+	}
+
+	response := &BatchAbortResponse{
+		BatchID:        session.ID.String(),
+		Status:         "completed",
+		PreparedFiles:  session.PreparedFiles,
+		CompletedFiles: session.CompletedFiles,
+		AbortedAt:      session.AbortedAt,
+	}
+	if session.AbortedAt != nil {
+		response.Status = "aborted"
+		response.DiscardedFiles = session.PreparedFiles - session.CompletedFiles
+	}
+	return response, nil
+}
+
+// normalizeRelativePath cleans a client-supplied path within a synced folder tree
+func normalizeRelativePath(relativePath string) (string, error) {
+	if relativePath == "" {
+		return "", nil
+	}
+
+	cleaned := path.Clean(strings.ReplaceAll(relativePath, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidRelativePath
+	}
+	return cleaned, nil
+}
+
+// cleanupExpiredBatchSessions drops batch sessions nobody continued and returns their
+// reservations to their users. Their staged uploads expire under the staging prefix's
+// lifecycle rule.
+func (s *FileService) cleanupExpiredBatchSessions() {
+	var expired []models.BatchUpload
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Returning{}).Where("expires_at <= ?", s.dbNow()).Delete(&expired).Error; err != nil {
+			return fmt.Errorf("failed to delete expired batch sessions: %w", err)
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(expired))
+		for i, session := range expired {
+			ids[i] = session.ID
+			if err := adjustStorageUsed(tx, session.UserID, -session.ReservedBytes); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("batch_id IN ?", ids).Delete(&models.BatchUploadFile{}).Error; err != nil {
+			return fmt.Errorf("failed to delete expired batch files: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to clean up expired batch sessions: %v", err)
+		return
+	}
+	if len(expired) > 0 {
+		log.Printf("Deleted %d expired batch upload sessions", len(expired))
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+func storageUsed(t *testing.T, s *FileService, userID string) int64 {
+	t.Helper()
+
+	var user models.User
+	if err := s.db.Select("storage_used").Where("id = ?", userID).First(&user).Error; err != nil {
+		t.Fatalf("failed to get user %s: %v", userID, err)
+	}
+	return user.StorageUsed
+}
+
+func pendingLink(session *models.BatchUpload, size int64) models.BatchUploadFile {
+	return models.BatchUploadFile{UploadID: uuid.New().String(), BatchID: session.ID, FileHash: testSHA256, Size: size, Link: true}
+}
+
+func TestBatchReservationsCountTowardUsage(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.BatchUpload{}, &models.BatchUploadFile{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	userID := "batch-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID, StorageQuota: 1000, StorageUsed: 100}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	first, err := s.openBatchSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("openBatchSession() error = %v", err)
+	}
+	done := pendingLink(first, 30)
+	if err := s.recordBatchPrepared(ctx, first, []models.BatchUploadFile{done, pendingLink(first, 20)}, 2); err != nil {
+		t.Fatalf("recordBatchPrepared() error = %v", err)
+	}
+	if got := storageUsed(t, s, userID); got != 150 {
+		t.Fatalf("storage used after preparing = %d, want 150 so other batches see the reservation", got)
+	}
+
+	if err := s.recordBatchCompleted(ctx, first, done); err != nil {
+		t.Fatalf("recordBatchCompleted() error = %v", err)
+	}
+	if got := storageUsed(t, s, userID); got != 120 {
+		t.Errorf("storage used after completing = %d, want the completed file's reservation released", got)
+	}
+
+	// A second batch's reservation is released when it expires
+	second, err := s.openBatchSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("openBatchSession() error = %v", err)
+	}
+	if err := s.recordBatchPrepared(ctx, second, []models.BatchUploadFile{pendingLink(second, 200)}, 1); err != nil {
+		t.Fatalf("recordBatchPrepared() error = %v", err)
+	}
+	if err := tx.Model(second).Update("expires_at", time.Now().UTC().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("failed to expire batch: %v", err)
+	}
+	s.cleanupExpiredBatchSessions()
+	if got := storageUsed(t, s, userID); got != 120 {
+		t.Errorf("storage used after expiry = %d, want 120", got)
+	}
+
+	if _, err := s.AbortBatchUpload(ctx, userID, first.ID.String()); err != nil {
+		t.Fatalf("AbortBatchUpload() error = %v", err)
+	}
+	if got := storageUsed(t, s, userID); got != 100 {
+		t.Errorf("storage used after aborting = %d, want 100", got)
+	}
+
+	// Preparing into a session that ended meanwhile reserves nothing
+	err = s.recordBatchPrepared(ctx, first, []models.BatchUploadFile{pendingLink(first, 50)}, 1)
+	if !errors.Is(err, ErrBatchSessionNotFound) {
+		t.Errorf("recordBatchPrepared() on an aborted batch error = %v, want ErrBatchSessionNotFound", err)
+	}
+	if got := storageUsed(t, s, userID); got != 100 {
+		t.Errorf("storage used after preparing into an aborted batch = %d, want 100", got)
+	}
+}
+
+func TestBatchPrepareUsesUserQuota(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.BannedHash{}, &models.BatchUpload{}, &models.BatchUploadFile{})
+	cfg := &config.Config{}
+	s := &FileService{db: tx, cfg: cfg, userService: &UserService{db: tx, cfg: cfg}}
+
+	const mb = 1024 * 1024
+	userID := "batch-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID, StorageQuota: 500 * mb, FileCountQuota: 100}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	files := []BatchFileRequest{
+		{FileHash: strings.Repeat("1", 64), Size: 400 * mb},
+		{FileHash: strings.Repeat("2", 64), Size: 200 * mb},
+	}
+	response, err := s.BatchPrepareUpload(context.Background(), userID, "", files)
+	if err != nil {
+		t.Fatalf("BatchPrepareUpload() error = %v", err)
+	}
+
+	check := response.QuotaCheck
+	if check.QuotaAvailable {
+		t.Error("quota_available = true for 600 MB against a 500 MB quota")
+	}
+	if check.UserQuotaBytes != 500*mb || check.StorageUsedBytes != 0 || check.TotalSizeRequired != 600*mb {
+		t.Errorf("quota check = %+v, want the user's 500 MB quota, nothing used and 600 MB required", check)
+	}
+	if check.QuotaExceeded != 100*mb {
+		t.Errorf("quota_exceeded = %d, want %d", check.QuotaExceeded, 100*mb)
+	}
+	for _, file := range response.Files {
+		if file.Status != "quota_exceeded" {
+			t.Errorf("file %s status = %q, want quota_exceeded", file.FileHash, file.Status)
+		}
+	}
+	if got := storageUsed(t, s, userID); got != 0 {
+		t.Errorf("storage used = %d after a batch that didn't fit, want nothing reserved", got)
+	}
+}
+
+func TestBatchCompleteReportsNumericSize(t *testing.T) {
+	// Linking runs its own transaction, so this can't run inside testTx
+	db := testDB(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{}, &models.UserActivity{}, &models.BannedHash{},
+		&models.BatchUpload{}, &models.BatchUploadFile{}, &models.UsedUploadToken{})
+	cfg := &config.Config{}
+	s := &FileService{
+		db:             db,
+		cfg:            cfg,
+		storage:        &storage.MinIOStorage{},
+		userService:    &UserService{db: db, cfg: cfg},
+		uploadTokenKey: []byte("test-upload-token-key-0123456789abcdef"),
+	}
+	ctx := context.Background()
+
+	userID := "batch-user-" + uuid.New().String()
+	sum := sha256.Sum256([]byte(userID))
+	hash := hex.EncodeToString(sum[:])
+	t.Cleanup(func() {
+		db.Where("user_id = ?", userID).Delete(&models.UserActivity{})
+		db.Unscoped().Where("user_id = ?", userID).Delete(&models.UserFile{})
+		db.Where("hash = ?", hash).Delete(&models.FileHash{})
+		db.Where("batch_id IN (?)", db.Model(&models.BatchUpload{}).Select("id").Where("user_id = ?", userID)).Delete(&models.BatchUploadFile{})
+		db.Where("user_id = ?", userID).Delete(&models.BatchUpload{})
+		db.Where("id = ?", userID).Delete(&models.User{})
+	})
+
+	if err := db.Create(&models.User{ID: userID, StorageQuota: 1000, FileCountQuota: 100}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	// Content another user already stored, so the batch file is linked to it
+	if err := db.Create(&models.FileHash{Hash: hash, MinIOKey: hash, Size: 42, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	session, err := s.openBatchSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("openBatchSession() error = %v", err)
+	}
+	batchFile := models.BatchUploadFile{UploadID: uuid.New().String(), BatchID: session.ID, FileHash: hash, Size: 42, Link: true}
+	if err := s.recordBatchPrepared(ctx, session, []models.BatchUploadFile{batchFile}, 1); err != nil {
+		t.Fatalf("recordBatchPrepared() error = %v", err)
+	}
+	token := s.issueLinkToken(userID, s.storage.StagingKey(userID, batchFile.UploadID), hash, UploadOptions{IsPublic: new(bool)})
+
+	response, err := s.BatchCompleteUpload(ctx, userID, session.ID.String(), []BatchCompletedUpload{{UploadToken: token, Filename: "notes.txt"}})
+	if err != nil {
+		t.Fatalf("BatchCompleteUpload() error = %v", err)
+	}
+	if len(response.CompletedFiles) != 1 {
+		t.Fatalf("BatchCompleteUpload() completed %d files, errors %q, want 1", len(response.CompletedFiles), response.Errors)
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	var decoded struct {
+		CompletedFiles []map[string]interface{} `json:"completed_files"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	file := decoded.CompletedFiles[0]
+	if size, ok := file["size"].(float64); !ok || size != 42 {
+		t.Errorf("completed file size = %#v, want the number 42", file["size"])
+	}
+	if file["mime_type"] != "text/plain" || file["is_public"] != false || file["uploaded_at"] == nil {
+		t.Errorf("completed file = %v, want its mime_type, is_public and uploaded_at", file)
+	}
+}
+
+func TestAbandonedBatchLeavesNoDuplicatesBehind(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.BannedHash{}, &models.BatchUpload{}, &models.BatchUploadFile{})
+	cfg := &config.Config{}
+	s := &FileService{
+		db:             tx,
+		cfg:            cfg,
+		storage:        &storage.MinIOStorage{},
+		userService:    &UserService{db: tx, cfg: cfg},
+		uploadTokenKey: []byte("test-upload-token-key-0123456789abcdef"),
+	}
+	ctx := context.Background()
+
+	userID := "batch-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID, StorageQuota: 1000, FileCountQuota: 100}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	// Content another user already stored, so every batch file is a duplicate
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 42, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+
+	checkUntouched := func(when string) {
+		t.Helper()
+		var files int64
+		tx.Model(&models.UserFile{}).Unscoped().Where("user_id = ?", userID).Count(&files)
+		if files != 0 {
+			t.Errorf("%d files %s, want none", files, when)
+		}
+		var fileHash models.FileHash
+		if err := tx.Where("hash = ?", testSHA256).First(&fileHash).Error; err != nil {
+			t.Fatalf("failed to get file hash: %v", err)
+		}
+		if fileHash.ReferenceCount != 1 {
+			t.Errorf("reference count = %d %s, want 1", fileHash.ReferenceCount, when)
+		}
+	}
+
+	prepare := func() string {
+		t.Helper()
+		files := []BatchFileRequest{
+			{Filename: "a.txt", FileHash: testSHA256, Size: 42, MimeType: "text/plain"},
+			{Filename: "b.txt", FileHash: testSHA256, Size: 42, MimeType: "text/plain"},
+		}
+		response, err := s.BatchPrepareUpload(ctx, userID, "", files)
+		if err != nil {
+			t.Fatalf("BatchPrepareUpload() error = %v", err)
+		}
+		for _, file := range response.Files {
+			if file.Status != "duplicate" {
+				t.Fatalf("file status = %q, want duplicate", file.Status)
+			}
+		}
+		checkUntouched("after preparing")
+		return response.BatchID
+	}
+
+	// A batch the client stops continuing expires
+	expired := prepare()
+	if err := tx.Model(&models.BatchUpload{}).Where("id = ?", expired).Update("expires_at", time.Now().UTC().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("failed to expire batch: %v", err)
+	}
+	s.cleanupExpiredBatchSessions()
+	checkUntouched("after the batch expired")
+	if got := storageUsed(t, s, userID); got != 0 {
+		t.Errorf("storage used = %d after the batch expired, want 0", got)
+	}
+
+	// A batch the client aborts
+	aborted := prepare()
+	if _, err := s.AbortBatchUpload(ctx, userID, aborted); err != nil {
+		t.Fatalf("AbortBatchUpload() error = %v", err)
+	}
+	checkUntouched("after the batch was aborted")
+	if got := storageUsed(t, s, userID); got != 0 {
+		t.Errorf("storage used = %d after the batch was aborted, want 0", got)
+	}
+}
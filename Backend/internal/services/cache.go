@@ -0,0 +1,157 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"filevault-backend/internal/metrics"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var cacheRequests = metrics.NewCounterVec(
+	"filevault_cache_requests_total",
+	"In-memory cache lookups by cache and result",
+	"cache", "result",
+)
+
+// Each cache holds at most this many entries; when full, expired entries are swept
+// and, failing that, the cache starts over empty
+const maxCacheEntries = 10000
+
+type cacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// ttlCache is a small in-memory cache whose entries expire after a fixed TTL. A cache
+// with a zero TTL is disabled: lookups always miss and nothing is stored.
+//
+// Invalidation only reaches this process. With several replicas, a change made through
+// one is seen by the others once their entries expire, so the TTL bounds how long a
+// revoked share or changed role can still be served elsewhere.
+type ttlCache[V any] struct {
+	name    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+
+	// Concurrent misses for the same key share one load
+	loads singleflight.Group
+	// Bumped by every invalidation, so a load that started before one isn't stored
+	generation uint64
+}
+
+func newTTLCache[V any](name string, ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{
+		name:    name,
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry[V]),
+	}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	var zero V
+	if c.ttl <= 0 {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		cacheRequests.Inc(c.name, "miss")
+		return zero, false
+	}
+	cacheRequests.Inc(c.name, "hit")
+	return entry.value, true
+}
+
+// getOrLoad returns the cached value for key, or loads and caches it. Callers missing
+// the same key at the same time wait for a single load. Errors aren't cached.
+func (c *ttlCache[V]) getOrLoad(key string, load func() (V, error)) (V, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+	if value, ok := c.get(key); ok {
+		return value, nil
+	}
+
+	loaded, err, _ := c.loads.Do(key, func() (interface{}, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		value, err := load()
+		if err != nil {
+			return value, err
+		}
+
+		c.mu.Lock()
+		if c.generation == generation {
+			c.setLocked(key, value)
+		}
+		c.mu.Unlock()
+		return value, nil
+	})
+	return loaded.(V), err
+}
+
+func (c *ttlCache[V]) set(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(key, value)
+}
+
+func (c *ttlCache[V]) setLocked(key string, value V) {
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[string]cacheEntry[V])
+		}
+	}
+	c.entries[key] = cacheEntry[V]{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *ttlCache[V]) delete(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// deleteFunc removes every entry whose value matches, for invalidations that aren't
+// keyed the same way as the cache
+func (c *ttlCache[V]) deleteFunc(match func(V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, entry := range c.entries {
+		if match(entry.value) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheTTL returns how long cached entries live, or zero when caching is disabled
+func cacheTTL(enabled bool, seconds int) time.Duration {
+	if !enabled {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCacheLoadsOnceForConcurrentMisses(t *testing.T) {
+	cache := newTTLCache[string]("test", time.Minute)
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func() (string, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 20)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = cache.getOrLoad("key", load)
+		}()
+	}
+	// Let the callers pile up behind the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := loads.Load(); got != 1 {
+		t.Errorf("load ran %d times for concurrent misses, want 1", got)
+	}
+	for i, result := range results {
+		if result != "value" {
+			t.Errorf("caller %d got %q, want the loaded value", i, result)
+		}
+	}
+	if value, ok := cache.get("key"); !ok || value != "value" {
+		t.Errorf("get() after load = %q, %v, want the loaded value cached", value, ok)
+	}
+}
+
+func TestCacheDoesNotStoreLoadsOutdatedByInvalidation(t *testing.T) {
+	cache := newTTLCache[string]("test", time.Minute)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.getOrLoad("key", func() (string, error) {
+			close(started)
+			<-release
+			return "stale", nil
+		})
+	}()
+
+	// The file is made private while its old row is being loaded
+	<-started
+	cache.delete("key")
+	close(release)
+	<-done
+
+	if value, ok := cache.get("key"); ok {
+		t.Errorf("get() = %q, want the load that started before the invalidation dropped", value)
+	}
+}
+
+func TestCacheDoesNotStoreErrors(t *testing.T) {
+	cache := newTTLCache[string]("test", time.Minute)
+
+	errLoad := errors.New("database unavailable")
+	if _, err := cache.getOrLoad("key", func() (string, error) { return "", errLoad }); !errors.Is(err, errLoad) {
+		t.Fatalf("getOrLoad() error = %v, want the load error", err)
+	}
+	value, err := cache.getOrLoad("key", func() (string, error) { return "value", nil })
+	if err != nil || value != "value" {
+		t.Errorf("getOrLoad() after a failed load = %q, %v, want a fresh load", value, err)
+	}
+}
+
+func TestDisabledCacheAlwaysLoads(t *testing.T) {
+	cache := newTTLCache[string]("test", cacheTTL(false, 30))
+
+	var loads int
+	for range 3 {
+		cache.getOrLoad("key", func() (string, error) {
+			loads++
+			return "value", nil
+		})
+	}
+	if loads != 3 {
+		t.Errorf("disabled cache loaded %d times for 3 lookups, want 3", loads)
+	}
+}
+
+func TestForgetSharedFileDropsPublicInfo(t *testing.T) {
+	s := &FileService{
+		sharedFiles: newTTLCache[models.UserFile]("shared_files", time.Minute),
+		publicFiles: newTTLCache[PublicFileResponse]("public_files", time.Minute),
+	}
+	fileID := uuid.New()
+	s.sharedFiles.set("abc12345", models.UserFile{ID: fileID, IsPublic: true})
+	s.publicFiles.set(fileID.String(), PublicFileResponse{ID: fileID})
+
+	// e.g. the file is made private
+	s.forgetSharedFile(fileID)
+
+	if _, ok := s.sharedFiles.get("abc12345"); ok {
+		t.Error("share link lookup is still cached after the file was forgotten")
+	}
+	if _, ok := s.publicFiles.get(fileID.String()); ok {
+		t.Error("public file info is still cached after the file was forgotten")
+	}
+}
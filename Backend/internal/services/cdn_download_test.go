@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+)
+
+const testCDNBaseURL = "https://cdn.example.com/files"
+
+var publicTestFile = models.UserFile{
+	Filename: "report.pdf",
+	IsPublic: true,
+	FileData: models.FileHash{MinIOKey: "files/ab/abcdef", MimeType: "application/pdf"},
+}
+
+func newCDNTestService(t *testing.T, cdnBaseURL string) *FileService {
+	t.Helper()
+
+	minioStorage, _ := newFakeStorage(t, nil, func(cfg *config.Config) { cfg.CDNBaseURL = cdnBaseURL })
+	return &FileService{
+		cfg:     &config.Config{CDNBaseURL: cdnBaseURL, PublicCacheMaxAgeSeconds: 300},
+		storage: minioStorage,
+	}
+}
+
+// checkSignedCDNURL checks that a download URL is served from the CDN and expires
+func checkSignedCDNURL(t *testing.T, downloadURL string, expiry time.Duration) {
+	t.Helper()
+
+	if !strings.HasPrefix(downloadURL, testCDNBaseURL+"/files/ab/abcdef?") {
+		t.Fatalf("download URL = %q, want it under the CDN base URL", downloadURL)
+	}
+	parsed, err := url.Parse(downloadURL)
+	if err != nil {
+		t.Fatalf("failed to parse download URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("X-Amz-Signature") == "" {
+		t.Error("CDN download URL is not signed, so it's a permanent link to the object")
+	}
+	if got, want := query.Get("X-Amz-Expires"), strconv.Itoa(int(expiry.Seconds())); got != want {
+		t.Errorf("X-Amz-Expires = %q, want %s", got, want)
+	}
+	if got := query.Get("response-content-disposition"); !strings.Contains(got, "report.pdf") {
+		t.Errorf("response-content-disposition = %q, want the filename", got)
+	}
+}
+
+func TestPublicDownloadURLIsSignedOnTheCDN(t *testing.T) {
+	s := newCDNTestService(t, testCDNBaseURL)
+
+	// The direct download flow for public files
+	downloadURL, err := s.publicDownloadURL(context.Background(), publicTestFile, time.Hour)
+	if err != nil {
+		t.Fatalf("publicDownloadURL() error = %v", err)
+	}
+	checkSignedCDNURL(t, downloadURL, time.Hour)
+
+	// Share links outlive the cached redirect
+	sharedURL, err := s.GetSharedFileURL(context.Background(), &publicTestFile)
+	if err != nil {
+		t.Fatalf("GetSharedFileURL() error = %v", err)
+	}
+	checkSignedCDNURL(t, sharedURL, 300*time.Second+time.Hour)
+}
+
+func TestPublicDownloadURLWithoutCDN(t *testing.T) {
+	s := newCDNTestService(t, "")
+
+	downloadURL, err := s.publicDownloadURL(context.Background(), publicTestFile, time.Hour)
+	if err != nil {
+		t.Fatalf("publicDownloadURL() error = %v", err)
+	}
+	if strings.HasPrefix(downloadURL, testCDNBaseURL) {
+		t.Errorf("download URL = %q, want it presigned on MinIO without a CDN", downloadURL)
+	}
+	if !strings.Contains(downloadURL, "X-Amz-Signature=") {
+		t.Errorf("download URL = %q, want it presigned", downloadURL)
+	}
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrCollectionNotFound is returned when a collection does not exist, isn't owned by the user, or isn't shared
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrCollectionOrderMismatch is returned when a new order doesn't list exactly the collection's files
+	ErrCollectionOrderMismatch = errors.New("file IDs must list every file in the collection exactly once")
+)
+
+// CollectionResponse is a collection with its files in display order
+type CollectionResponse struct {
+	models.FileCollection
+	Files []UserFileResponse `json:"files"`
+}
+
+// CreateCollection creates an empty, private collection
+func (s *FileService) CreateCollection(userID, name, description string) (*models.FileCollection, error) {
+	collection := models.FileCollection{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Name:        name,
+		Description: description,
+	}
+
+	if err := s.db.Create(&collection).Error; err != nil {
+		return nil, fmt.Errorf("failed to create collection: %w", err)
+	}
+
+	return &collection, nil
+}
+
+// ListCollections returns the user's collections, newest first
+func (s *FileService) ListCollections(userID string) ([]models.FileCollection, error) {
+	collections := make([]models.FileCollection, 0)
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&collections).Error; err != nil {
+		return nil, fmt.Errorf("failed to list collections: %w", err)
+	}
+	return collections, nil
+}
+
+// AddToCollection appends one of the user's files to the end of a collection
+func (s *FileService) AddToCollection(userID string, collectionID, fileID uuid.UUID) error {
+	if _, err := s.getOwnedCollection(userID, collectionID); err != nil {
+		return err
+	}
+
+	var userFile models.UserFile
+	if err := s.db.Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error; err != nil {
+		return fmt.Errorf("file not found or access denied: %w", err)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var exists int64
+		if err := tx.Model(&models.FileCollectionItem{}).
+			Where("collection_id = ? AND user_file_id = ?", collectionID, fileID).
+			Count(&exists).Error; err != nil {
+			return fmt.Errorf("failed to check collection membership: %w", err)
+		}
+		if exists > 0 {
+			return nil
+		}
+
+		var maxOrder int
+		if err := tx.Model(&models.FileCollectionItem{}).
+			Where("collection_id = ?", collectionID).
+			Select("COALESCE(MAX(sort_order), 0)").
+			Scan(&maxOrder).Error; err != nil {
+			return fmt.Errorf("failed to get collection order: %w", err)
+		}
+
+		item := models.FileCollectionItem{
+			CollectionID: collectionID,
+			UserFileID:   fileID,
+			SortOrder:    maxOrder + 1,
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return fmt.Errorf("failed to add file to collection: %w", err)
+		}
+		return nil
+	})
+}
+
+// RemoveFromCollection removes a file from a collection; the file itself is kept
+func (s *FileService) RemoveFromCollection(userID string, collectionID, fileID uuid.UUID) error {
+	if _, err := s.getOwnedCollection(userID, collectionID); err != nil {
+		return err
+	}
+
+	result := s.db.Where("collection_id = ? AND user_file_id = ?", collectionID, fileID).Delete(&models.FileCollectionItem{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove file from collection: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("file is not in this collection")
+	}
+	return nil
+}
+
+// ReorderCollectionItems sets the display order of a collection. orderedFileIDs must
+// contain each file in the collection exactly once.
+func (s *FileService) ReorderCollectionItems(userID string, collectionID uuid.UUID, orderedFileIDs []uuid.UUID) error {
+	if _, err := s.getOwnedCollection(userID, collectionID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the items so a concurrent add or remove can't slip in between the check and the update
+		var current []uuid.UUID
+		if err := tx.Model(&models.FileCollectionItem{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("collection_id = ?", collectionID).
+			Pluck("user_file_id", &current).Error; err != nil {
+			return fmt.Errorf("failed to get collection items: %w", err)
+		}
+
+		if len(current) != len(orderedFileIDs) {
+			return ErrCollectionOrderMismatch
+		}
+		remaining := make(map[uuid.UUID]struct{}, len(current))
+		for _, id := range current {
+			remaining[id] = struct{}{}
+		}
+		for _, id := range orderedFileIDs {
+			if _, ok := remaining[id]; !ok {
+				return ErrCollectionOrderMismatch
+			}
+			delete(remaining, id)
+		}
+		if len(orderedFileIDs) == 0 {
+			return nil
+		}
+
+		var caseExpr strings.Builder
+		args := make([]interface{}, 0, len(orderedFileIDs)*2+1)
+		caseExpr.WriteString("CASE user_file_id")
+		for i, id := range orderedFileIDs {
+			caseExpr.WriteString(" WHEN ? THEN CAST(? AS integer)")
+			args = append(args, id, i+1)
+		}
+		caseExpr.WriteString(" END")
+		args = append(args, collectionID)
+
+		err := tx.Exec("UPDATE file_collection_items SET sort_order = "+caseExpr.String()+" WHERE collection_id = ?", args...).Error
+		if err != nil {
+			return fmt.Errorf("failed to reorder collection: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetCollectionContents returns the files in a collection in display order
+func (s *FileService) GetCollectionContents(collectionID uuid.UUID) ([]UserFileResponse, error) {
+	var userFiles []models.UserFile
+	err := s.db.Preload("FileData").
+		Joins("JOIN file_collection_items ON file_collection_items.user_file_id = user_files.id").
+		Where("file_collection_items.collection_id = ?", collectionID).
+		Order("file_collection_items.sort_order ASC").
+		Find(&userFiles).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection contents: %w", err)
+	}
+
+	response := make([]UserFileResponse, 0, len(userFiles))
+	for _, file := range userFiles {
+		response = append(response, toUserFileResponse(file))
+	}
+	return response, nil
+}
+
+// ShareCollection makes a collection public and returns its share ID, creating one if needed
+func (s *FileService) ShareCollection(userID string, collectionID uuid.UUID) (string, error) {
+	collection, err := s.getOwnedCollection(userID, collectionID)
+	if err != nil {
+		return "", err
+	}
+
+	if collection.ShareID != nil {
+		if !collection.IsPublic {
+			if err := s.db.Model(collection).Update("is_public", true).Error; err != nil {
+				return "", fmt.Errorf("failed to share collection: %w", err)
+			}
+		}
+		return *collection.ShareID, nil
+	}
+
+	// Generate unique ID (retry if collision)
+	for attempts := 0; attempts < 10; attempts++ {
+		shareID := models.GenerateRandomID(models.ShareIDLength)
+		err = s.db.Model(collection).Updates(map[string]interface{}{
+			"share_id":  shareID,
+			"is_public": true,
+		}).Error
+		if err == nil {
+			return shareID, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to create collection share link after retries: %w", err)
+}
+
+// GetSharedCollection returns a public collection and its files by share ID
+func (s *FileService) GetSharedCollection(shareID string) (*CollectionResponse, error) {
+	collection, err := s.getSharedCollection(shareID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.GetCollectionContents(collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collection visitors don't need the owner's ID
+	collection.UserID = ""
+
+	return &CollectionResponse{FileCollection: *collection, Files: files}, nil
+}
+
+// GetCollectionFileDownloadURL returns a short-lived download URL for a file in a shared collection
+func (s *FileService) GetCollectionFileDownloadURL(ctx context.Context, shareID string, fileID uuid.UUID) (string, error) {
+	collection, err := s.getSharedCollection(shareID)
+	if err != nil {
+		return "", err
+	}
+
+	var userFile models.UserFile
+	err = s.db.WithContext(ctx).Preload("FileData").
+		Joins("JOIN file_collection_items ON file_collection_items.user_file_id = user_files.id").
+		Where("file_collection_items.collection_id = ? AND user_files.id = ?", collection.ID, fileID).
+		First(&userFile).Error
+	if err != nil {
+		return "", fmt.Errorf("file not found in collection: %w", err)
+	}
+
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, userFile.FileData.Size); err != nil {
+		return "", err
+	}
+
+	// Anyone holding the share gets the short default, even for media: the longer media
+	// TTL is for owners playing their own files
+	downloadURL, err := s.presignedDownloadURL(ctx, userFile, time.Duration(s.cfg.PrivateURLTTLSeconds)*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		s.db.Model(&userFile).Updates(downloadUpdates())
+	}()
+
+	s.recordBandwidth(userFile.UserID, userFile.FileData.Size)
+
+	s.RecordActivity(models.UserActivity{UserID: userFile.UserID, Action: models.ActivityDownload, FileID: &userFile.ID, Filename: userFile.Filename, ShareID: shareID})
+
+	return downloadURL, nil
+}
+
+func (s *FileService) getOwnedCollection(userID string, collectionID uuid.UUID) (*models.FileCollection, error) {
+	var collection models.FileCollection
+	err := s.db.Where("id = ? AND user_id = ?", collectionID, userID).First(&collection).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrCollectionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	return &collection, nil
+}
+
+// ErrDownloadPathNotShared is returned when a download ticket is requested for a path
+// that doesn't lead to a shared file
+var ErrDownloadPathNotShared = errors.New("download path is not shared")
+
+// CheckSharedDownloadPath checks that a protected download path leads to a file that is
+// shared right now, through a share link, as a public file, in a shared collection or
+// under a shared folder, so download tickets can't be collected for paths nobody was given
+func (s *FileService) CheckSharedDownloadPath(ctx context.Context, path string) error {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var err error
+	switch {
+	case len(segments) == 2 && segments[0] == "share":
+		_, err = s.GetSharedFile(segments[1])
+	case len(segments) == 6 && strings.Join(segments[:4], "/") == "api/v1/public/files" && (segments[5] == "download" || segments[5] == "raw"):
+		fileID, parseErr := uuid.Parse(segments[4])
+		if parseErr != nil {
+			return ErrDownloadPathNotShared
+		}
+		_, err = s.GetPublicFileInfo(fileID)
+	case len(segments) == 4 && segments[0] == "c" && segments[2] == "files":
+		fileID, parseErr := uuid.Parse(segments[3])
+		if parseErr != nil {
+			return ErrDownloadPathNotShared
+		}
+		err = s.checkSharedCollectionFile(segments[1], fileID)
+	case len(segments) == 4 && segments[0] == "f" && segments[2] == "files":
+		fileID, parseErr := uuid.Parse(segments[3])
+		if parseErr != nil {
+			return ErrDownloadPathNotShared
+		}
+		var link *models.FolderShareLink
+		if link, err = s.getFolderShare(ctx, segments[1]); err == nil {
+			_, err = s.getSharedFolderFile(ctx, link, fileID)
+		}
+	default:
+		return ErrDownloadPathNotShared
+	}
+
+	if errors.Is(err, ErrShareLinkNotFound) || errors.Is(err, ErrCollectionNotFound) || errors.Is(err, ErrFolderShareNotFound) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDownloadPathNotShared
+	}
+	return err
+}
+
+// checkSharedCollectionFile checks that a file is in the collection shared as shareID
+func (s *FileService) checkSharedCollectionFile(shareID string, fileID uuid.UUID) error {
+	collection, err := s.getSharedCollection(shareID)
+	if err != nil {
+		return err
+	}
+
+	var items int64
+	err = s.db.Model(&models.FileCollectionItem{}).
+		Where("collection_id = ? AND user_file_id = ?", collection.ID, fileID).
+		Count(&items).Error
+	if err != nil {
+		return fmt.Errorf("failed to check collection file: %w", err)
+	}
+	if items == 0 {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+func (s *FileService) getSharedCollection(shareID string) (*models.FileCollection, error) {
+	var collection models.FileCollection
+	err := s.db.Where("share_id = ? AND is_public = ?", shareID, true).First(&collection).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrCollectionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	return &collection, nil
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCancelledRequestAbortsStorageCalls(t *testing.T) {
+	minioStorage, store := newFakeStorage(t, nil)
+	store.SetStall(true)
+	s := &FileService{storage: minioStorage}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// Completion starts by checking the uploaded object, which storage never answers
+	start := time.Now()
+	_, _, err := s.completeTokenUpload(ctx, "user-1", &uploadTokenClaims{ObjectKey: "staging/user-1/upload"}, "a.txt", "text/plain")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("completeTokenUpload() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("completeTokenUpload() returned after %v, want it to stop once the request is cancelled", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"filevault-backend/internal/models"
+)
+
+// DedupBehavior decides what preparing an upload does when the user already has a file
+// with the same content
+type DedupBehavior string
+
+const (
+	// DedupLink adds another entry pointing at the stored content
+	DedupLink DedupBehavior = "link"
+	// DedupSkip returns the user's existing entry instead of creating another
+	DedupSkip DedupBehavior = "skip"
+	// DedupAsk creates nothing and lists the user's existing entries so the client can decide
+	DedupAsk DedupBehavior = "ask"
+)
+
+// ErrInvalidDedupBehavior is returned for a dedup behavior other than the supported ones
+var ErrInvalidDedupBehavior = errors.New(`dedup_behavior must be "link", "skip" or "ask"`)
+
+// ParseDedupBehavior validates a client-supplied dedup behavior; empty means link
+func ParseDedupBehavior(value string) (DedupBehavior, error) {
+	switch behavior := DedupBehavior(value); behavior {
+	case "":
+		return DedupLink, nil
+	case DedupLink, DedupSkip, DedupAsk:
+		return behavior, nil
+	default:
+		return "", ErrInvalidDedupBehavior
+	}
+}
+
+// checksOwnCopies reports whether the behavior needs the user's own files with the hash
+func (b DedupBehavior) checksOwnCopies() bool {
+	return b == DedupSkip || b == DedupAsk
+}
+
+// ownCopies returns the user's files with each of the hashes, newest first. Hashes the
+// user has no files for are left out.
+func (s *FileService) ownCopies(ctx context.Context, userID string, hashes []string) (map[string][]models.UserFile, error) {
+	copies := make(map[string][]models.UserFile)
+	if len(hashes) == 0 {
+		return copies, nil
+	}
+
+	var userFiles []models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").
+		Where("user_id = ? AND file_hash IN ?", userID, hashes).
+		Order("uploaded_at DESC").
+		Find(&userFiles).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing copies: %w", err)
+	}
+
+	for _, userFile := range userFiles {
+		copies[userFile.FileHash] = append(copies[userFile.FileHash], userFile)
+	}
+	return copies, nil
+}
+
+// toUserFileResponses converts files with preloaded FileData to their API representation
+func toUserFileResponses(files []models.UserFile) []UserFileResponse {
+	responses := make([]UserFileResponse, len(files))
+	for i, file := range files {
+		responses[i] = toUserFileResponse(file)
+	}
+	return responses
+}
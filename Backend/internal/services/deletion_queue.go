@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/jobs"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Storage deletions run on their own queue so a backlog of them can't hold up other jobs
+	deletionQueueName   = "storage_deletion"
+	deletionJobType     = "delete_object"
+	deletionWorkers     = 4
+	deletionMaxAttempts = 5
+	deletionBaseBackoff = 2 * time.Second
+)
+
+// DeleteObjectJob removes an object from storage once no file references it
+type DeleteObjectJob struct {
+	ObjectKey string `json:"object_key"`
+}
+
+// DeletionQueue deletes storage objects in the background so HTTP requests only
+// wait for the database transaction. Deletions are persisted as jobs, so ones still
+// queued at shutdown run after the restart. Objects that cannot be deleted after all
+// retries are recorded in the failed_deletions table for admin review.
+type DeletionQueue struct {
+	db      *gorm.DB
+	storage *storage.MinIOStorage
+	runner  *jobs.Runner
+}
+
+// NewDeletionQueue registers storage deletions with the job runner, before it starts
+func NewDeletionQueue(db *gorm.DB, storage *storage.MinIOStorage, runner *jobs.Runner) *DeletionQueue {
+	q := &DeletionQueue{
+		db:      db,
+		storage: storage,
+		runner:  runner,
+	}
+
+	runner.AddQueue(deletionQueueName, deletionWorkers)
+	runner.Register(jobs.Type{
+		Name:   deletionJobType,
+		Queue:  deletionQueueName,
+		Retry:  jobs.RetryPolicy{MaxAttempts: deletionMaxAttempts, Backoff: deletionBaseBackoff},
+		Run:    q.run,
+		Failed: q.failed,
+	})
+	return q
+}
+
+// Enqueue schedules an object deletion. It isn't cancelled with ctx, since the object
+// has to go even if the request that discarded it has ended. If it can't be queued the
+// key is recorded as a failed deletion immediately rather than failing the caller.
+func (q *DeletionQueue) Enqueue(ctx context.Context, job DeleteObjectJob) {
+	if err := q.runner.Enqueue(context.WithoutCancel(ctx), deletionJobType, job); err != nil {
+		q.recordFailure(job, 0, err)
+	}
+}
+
+// EnqueueTx schedules an object deletion as part of tx, so the object is only deleted if
+// the transaction removing its last reference commits, and a crash after the commit
+// can't leave it orphaned.
+func (q *DeletionQueue) EnqueueTx(tx *gorm.DB, job DeleteObjectJob) error {
+	return q.runner.EnqueueTx(tx, deletionJobType, job)
+}
+
+// EnqueueContentTx schedules the deletion of a removed file hash's object and its
+// thumbnail as part of tx
+func (q *DeletionQueue) EnqueueContentTx(tx *gorm.DB, fileHash models.FileHash) error {
+	if err := q.EnqueueTx(tx, DeleteObjectJob{ObjectKey: fileHash.MinIOKey}); err != nil {
+		return err
+	}
+	if fileHash.ThumbnailKey != "" {
+		return q.EnqueueTx(tx, DeleteObjectJob{ObjectKey: fileHash.ThumbnailKey})
+	}
+	return nil
+}
+
+func (q *DeletionQueue) run(ctx context.Context, payload json.RawMessage) error {
+	var job DeleteObjectJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid deletion job: %w", err)
+	}
+
+	// Content-addressed keys are reused when the same content is uploaded again, which
+	// may have happened since the delete that queued this job
+	var references int64
+	if err := q.db.WithContext(ctx).Model(&models.FileHash{}).Where("min_io_key = ? OR thumbnail_key = ?", job.ObjectKey, job.ObjectKey).Count(&references).Error; err != nil {
+		return fmt.Errorf("failed to check object references: %w", err)
+	}
+	if references > 0 {
+		log.Printf("Skipping deletion of %s: it is referenced again", job.ObjectKey)
+		return nil
+	}
+
+	return q.storage.DeleteFile(ctx, job.ObjectKey)
+}
+
+func (q *DeletionQueue) failed(payload json.RawMessage, attempts int, err error) {
+	var job DeleteObjectJob
+	if json.Unmarshal(payload, &job) == nil {
+		q.recordFailure(job, attempts, err)
+	}
+}
+
+func (q *DeletionQueue) recordFailure(job DeleteObjectJob, attempts int, err error) {
+	log.Printf("Failed to delete object %s after %d attempts: %v", job.ObjectKey, attempts, err)
+
+	failed := models.FailedDeletion{
+		ID:        uuid.New(),
+		ObjectKey: job.ObjectKey,
+		Attempts:  attempts,
+		LastError: err.Error(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if dbErr := q.db.Create(&failed).Error; dbErr != nil {
+		log.Printf("Failed to record failed deletion for %s: %v", job.ObjectKey, dbErr)
+	}
+}
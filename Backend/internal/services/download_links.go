@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultDownloadLinkTTL applies when a download link is requested without an expiry
+	DefaultDownloadLinkTTL = 24 * time.Hour
+	// MaxDownloadLinkTTL caps how long a download link stays valid
+	MaxDownloadLinkTTL = 30 * 24 * time.Hour
+
+	// Attachment links redirect to storage with a presigned URL this short-lived
+	downloadLinkRedirectTTL = time.Minute
+)
+
+var (
+	// ErrDownloadLinksDisabled is returned when no download link secret is configured
+	ErrDownloadLinksDisabled = errors.New("download links are disabled")
+	// ErrDownloadLinkInvalid is returned for forged, tampered or expired download links
+	ErrDownloadLinkInvalid = errors.New("download link is invalid or expired")
+	// ErrInvalidDownloadLinkExpiry is returned when the requested expiry is out of range
+	ErrInvalidDownloadLinkExpiry = fmt.Errorf("download link expiry must be between 1 second and %s", MaxDownloadLinkTTL)
+)
+
+// DownloadLinkOptions customizes a public download link
+type DownloadLinkOptions struct {
+	ExpiresIn         time.Duration // Zero uses DefaultDownloadLinkTTL
+	Filename          string        // Overrides the filename in Content-Disposition
+	DispositionInline bool          // Stream for display in the browser instead of redirecting to a download
+}
+
+// DownloadLink is a signed, expiring URL that anyone can use to fetch a file
+type DownloadLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DownloadLinkParams are the query parameters of a /dl request
+type DownloadLinkParams struct {
+	FileID    string
+	ExpiresAt string
+	Filename  string
+	Inline    string
+	Signature string
+}
+
+// ResolvedDownloadLink is a verified download link ready to be served
+type ResolvedDownloadLink struct {
+	File               *models.UserFile
+	Inline             bool
+	ContentDisposition string
+	ContentType        string
+}
+
+// GeneratePublicDownloadLink signs a /dl link for one of the user's files. The link
+// works whether or not the file is public and can't be revoked before it expires,
+// short of deleting the file.
+func (s *FileService) GeneratePublicDownloadLink(userID string, fileID uuid.UUID, options DownloadLinkOptions) (*DownloadLink, error) {
+	if s.cfg.DownloadLinkSecret == "" {
+		return nil, ErrDownloadLinksDisabled
+	}
+
+	if options.ExpiresIn == 0 {
+		options.ExpiresIn = DefaultDownloadLinkTTL
+	}
+	if options.ExpiresIn < time.Second || options.ExpiresIn > MaxDownloadLinkTTL {
+		return nil, ErrInvalidDownloadLinkExpiry
+	}
+
+	var userFile models.UserFile
+	if err := s.db.Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error; err != nil {
+		return nil, fmt.Errorf("file not found or access denied: %w", err)
+	}
+
+	filename := options.Filename
+	if filename == "" {
+		filename = userFile.Filename
+	}
+
+	expiresAt := time.Now().Add(options.ExpiresIn).Truncate(time.Second).UTC()
+	params := DownloadLinkParams{
+		FileID:    fileID.String(),
+		ExpiresAt: strconv.FormatInt(expiresAt.Unix(), 10),
+		Filename:  filename,
+	}
+	if options.DispositionInline {
+		params.Inline = "1"
+	}
+
+	query := url.Values{}
+	query.Set("fid", params.FileID)
+	query.Set("exp", params.ExpiresAt)
+	query.Set("fn", params.Filename)
+	if params.Inline != "" {
+		query.Set("inline", params.Inline)
+	}
+	query.Set("sig", s.signDownloadLink(params))
+
+	return &DownloadLink{
+		URL:       "/dl?" + query.Encode(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ResolveDownloadLink verifies a download link and records the download against the
+// file owner's bandwidth
+func (s *FileService) ResolveDownloadLink(params DownloadLinkParams) (*ResolvedDownloadLink, error) {
+	if s.cfg.DownloadLinkSecret == "" {
+		return nil, ErrDownloadLinksDisabled
+	}
+
+	if !hmac.Equal([]byte(params.Signature), []byte(s.signDownloadLink(params))) {
+		return nil, ErrDownloadLinkInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(params.ExpiresAt, 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiresAt, 0)) {
+		return nil, ErrDownloadLinkInvalid
+	}
+
+	var userFile models.UserFile
+	if err := s.db.Preload("FileData").Where("id = ?", params.FileID).First(&userFile).Error; err != nil {
+		return nil, fmt.Errorf("file not found: %w", err)
+	}
+
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, userFile.FileData.Size); err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&userFile).Updates(downloadUpdates()).Error; err != nil {
+		log.Printf("Failed to increment download count for %s: %v", userFile.ID, err)
+	}
+	s.recordBandwidth(userFile.UserID, userFile.FileData.Size)
+	s.RecordActivity(models.UserActivity{UserID: userFile.UserID, Action: models.ActivityDownload, FileID: &userFile.ID, Filename: userFile.Filename})
+
+	disposition := "attachment"
+	if params.Inline != "" {
+		disposition = "inline"
+	}
+
+	return &ResolvedDownloadLink{
+		File:               &userFile,
+		Inline:             params.Inline != "",
+		ContentDisposition: storage.ContentDisposition(disposition, params.Filename),
+		ContentType:        storage.ContentType(userFile.FileData.MimeType, params.Filename),
+	}, nil
+}
+
+// DownloadLinkRedirectURL presigns a short-lived storage URL that downloads the file
+// under the link's filename
+func (s *FileService) DownloadLinkRedirectURL(ctx context.Context, link *ResolvedDownloadLink) (string, error) {
+	return s.storage.GetFileURL(ctx, s.resolveObjectKey(ctx, link.File.FileData), downloadLinkRedirectTTL, storage.DownloadHeaders{
+		ContentDisposition: link.ContentDisposition,
+		ContentType:        link.ContentType,
+	})
+}
+
+// OpenDownloadLink streams the file's content for inline display
+func (s *FileService) OpenDownloadLink(ctx context.Context, link *ResolvedDownloadLink) (io.ReadCloser, error) {
+	if err := s.userService.CheckProxyBandwidth(link.File.UserID); err != nil {
+		return nil, err
+	}
+	return s.storage.GetObject(ctx, s.resolveObjectKey(ctx, link.File.FileData))
+}
+
+func (s *FileService) signDownloadLink(params DownloadLinkParams) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.DownloadLinkSecret))
+	// The filename goes last: it's the only field that may contain the separator
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", params.FileID, params.ExpiresAt, params.Inline, params.Filename)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckSharedDownloadPathRejectsUnknownPaths(t *testing.T) {
+	s := &FileService{}
+
+	// None of these reach the database: they can't name a shared file
+	paths := []string{
+		"",
+		"/",
+		"/share",
+		"/share/abc12345/extra",
+		"/api/v1/public/files/not-a-uuid/download",
+		"/api/v1/public/files/4a3c2b1d-0000-4000-8000-000000000000/delete",
+		"/c/abc12345/files/not-a-uuid",
+		"/c/abc12345",
+		"/f/abc12345/files/not-a-uuid",
+		"/f/abc12345",
+		"/files/4a3c2b1d-0000-4000-8000-000000000000/download",
+	}
+	for _, path := range paths {
+		if err := s.CheckSharedDownloadPath(context.Background(), path); !errors.Is(err, ErrDownloadPathNotShared) {
+			t.Errorf("CheckSharedDownloadPath(%q) = %v, want ErrDownloadPathNotShared", path, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+)
+
+func ttlTestConfig() *config.Config {
+	return &config.Config{PrivateURLTTLSeconds: 60, PrivateURLMaxTTLSeconds: 21600, MediaURLTTLSeconds: 14400}
+}
+
+func TestPrivateURLTTL(t *testing.T) {
+	s := &FileService{cfg: ttlTestConfig()}
+
+	tests := []struct {
+		name      string
+		mimeType  string
+		requested int
+		want      time.Duration
+	}{
+		{"default", "application/pdf", 0, time.Minute},
+		{"media default", "video/mp4", 0, 4 * time.Hour},
+		{"audio default", "audio/mpeg", 0, 4 * time.Hour},
+		{"requested", "application/pdf", 600, 10 * time.Minute},
+		{"requested beats media default", "video/mp4", 600, 10 * time.Minute},
+		{"clamped to the maximum", "application/pdf", 86400, 6 * time.Hour},
+		{"huge request doesn't overflow", "application/pdf", math.MaxInt, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.privateURLTTL(tt.mimeType, tt.requested); got != tt.want {
+				t.Errorf("privateURLTTL(%q, %d) = %v, want %v", tt.mimeType, tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrivateDownloadURLCarriesTheTTL(t *testing.T) {
+	minioStorage, _ := newFakeStorage(t, nil)
+	s := &FileService{cfg: ttlTestConfig(), storage: minioStorage}
+	userFile := models.UserFile{Filename: "clip.mp4", FileData: models.FileHash{MinIOKey: "files/ab/abcdef", MimeType: "video/mp4"}}
+
+	for requested, want := range map[int]int{0: 14400, 600: 600, math.MaxInt: 21600} {
+		downloadURL, err := s.presignedDownloadURL(context.Background(), userFile, s.privateURLTTL(userFile.FileData.MimeType, requested))
+		if err != nil {
+			t.Fatalf("presignedDownloadURL() error = %v", err)
+		}
+		parsed, err := url.Parse(downloadURL)
+		if err != nil {
+			t.Fatalf("failed to parse download URL: %v", err)
+		}
+		if got := parsed.Query().Get("X-Amz-Expires"); got != strconv.Itoa(want) {
+			t.Errorf("X-Amz-Expires for ttl=%d = %q, want %d", requested, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/metrics"
+)
+
+const (
+	enumerationWindow     = time.Minute
+	enumerationMaxEntries = 100000
+)
+
+var (
+	publicLookupMisses = metrics.NewCounterVec(
+		"filevault_public_lookup_not_found_total",
+		"Public file lookups that found nothing",
+	)
+	// Not labelled by IP, which would give every scanner its own series forever; the
+	// IPs are in the log line written alongside
+	publicEnumerationSuspects = metrics.NewCounterVec(
+		"filevault_public_enumeration_suspected_total",
+		"Minutes in which an IP exceeded the public lookup 404 threshold",
+	)
+)
+
+type missWindow struct {
+	start   time.Time
+	count   int
+	flagged bool
+}
+
+// EnumerationGuard counts failed public file lookups per IP so attempts to discover
+// public files by guessing IDs show up in logs and metrics
+type EnumerationGuard struct {
+	threshold int
+	windows   map[string]*missWindow
+	mu        sync.Mutex
+}
+
+func NewEnumerationGuard(cfg *config.Config) *EnumerationGuard {
+	return &EnumerationGuard{
+		threshold: cfg.PublicNotFoundThreshold,
+		windows:   make(map[string]*missWindow),
+	}
+}
+
+// RecordMiss counts a lookup from clientIP that found nothing and flags the IP
+// once per window when it crosses the threshold
+func (g *EnumerationGuard) RecordMiss(clientIP string) {
+	publicLookupMisses.Inc()
+	if g.threshold <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	window, exists := g.windows[clientIP]
+	if !exists || now.Sub(window.start) >= enumerationWindow {
+		// Drop all state rather than grow without bound under a flood of distinct IPs
+		if !exists && len(g.windows) >= enumerationMaxEntries {
+			g.windows = make(map[string]*missWindow)
+		}
+		window = &missWindow{start: now}
+		g.windows[clientIP] = window
+	}
+	window.count++
+	flag := window.count >= g.threshold && !window.flagged
+	if flag {
+		window.flagged = true
+	}
+	g.mu.Unlock()
+
+	if flag {
+		publicEnumerationSuspects.Inc()
+		log.Printf("Possible public file enumeration from %s: %d lookups not found within %s", clientIP, g.threshold, enumerationWindow)
+	}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/metrics"
+)
+
+func TestEnumerationGuardFlagsOncePerWindowWithoutIPLabel(t *testing.T) {
+	guard := NewEnumerationGuard(&config.Config{PublicNotFoundThreshold: 3})
+	before := publicEnumerationSuspects.Total()
+
+	for i := 0; i < 10; i++ {
+		guard.RecordMiss("198.51.100.23")
+	}
+	guard.RecordMiss("198.51.100.24")
+
+	if got := publicEnumerationSuspects.Total() - before; got != 1 {
+		t.Errorf("suspected enumerations = %d, want 1", got)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	if strings.Contains(string(body), "198.51.100.23") {
+		t.Error("metrics expose the client IP")
+	}
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/storage"
+	"filevault-backend/internal/storage/storagetest"
+)
+
+const fakeStorageBucket = "files"
+
+// newFakeStorage starts a fake S3 endpoint holding objects and returns storage connected
+// to it, with configure applied to the storage configuration
+func newFakeStorage(t *testing.T, objects map[string][]byte, configure ...func(*config.Config)) (*storage.MinIOStorage, *storagetest.Server) {
+	t.Helper()
+
+	store := storagetest.NewServer(t, objects)
+	cfg := &config.Config{
+		MinIOEndpoint:  store.Endpoint,
+		MinIOAccessKey: "test",
+		MinIOSecretKey: "test-secret",
+		MinIOBucket:    fakeStorageBucket,
+		MinIORegion:    "us-east-1",
+		MinIOPathStyle: true,
+		StagingPrefix:  "staging/",
+	}
+	for _, apply := range configure {
+		apply(cfg)
+	}
+	minioStorage, err := storage.NewMinIOStorage(cfg)
+	if err != nil {
+		t.Fatalf("failed to connect to fake storage: %v", err)
+	}
+	return minioStorage, store
+}
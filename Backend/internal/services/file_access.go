@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrFileAccessNotFound is returned when there is no active grant to revoke
+	ErrFileAccessNotFound = errors.New("access grant not found")
+	// ErrInvalidGrantee is returned when access can't be granted to the given user
+	ErrInvalidGrantee = errors.New("cannot grant access to this user")
+)
+
+// SharedFileResponse is a file another user has granted the caller access to
+type SharedFileResponse struct {
+	UserFileResponse
+	SharedByUserID string    `json:"shared_by_user_id"`
+	SharedAt       time.Time `json:"shared_at"`
+	Permissions    []string  `json:"permissions"`
+}
+
+// GrantFileAccess lets another user download one of the owner's files. Granting
+// access that already exists returns the existing grant.
+func (s *FileService) GrantFileAccess(ownerID string, fileID uuid.UUID, granteeID string) (*models.FileAccess, error) {
+	if granteeID == "" || granteeID == ownerID {
+		return nil, ErrInvalidGrantee
+	}
+
+	var userFile models.UserFile
+	if err := s.db.Where("id = ? AND user_id = ?", fileID, ownerID).First(&userFile).Error; err != nil {
+		return nil, fmt.Errorf("file not found or access denied: %w", err)
+	}
+
+	var grantees int64
+	if err := s.db.Model(&models.User{}).Where("id = ?", granteeID).Count(&grantees).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up grantee: %w", err)
+	}
+	if grantees == 0 {
+		return nil, ErrInvalidGrantee
+	}
+
+	var grant models.FileAccess
+	err := s.db.Where("user_file_id = ? AND grantee_user_id = ? AND revoked_at IS NULL", fileID, granteeID).First(&grant).Error
+	if err == nil {
+		return &grant, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("failed to check existing access: %w", err)
+	}
+
+	grant = models.FileAccess{
+		ID:            uuid.New(),
+		UserFileID:    fileID,
+		OwnerUserID:   ownerID,
+		GranteeUserID: granteeID,
+		Permissions:   models.StringList{models.FileAccessRead},
+	}
+	if err := s.db.Create(&grant).Error; err != nil {
+		return nil, fmt.Errorf("failed to grant access: %w", err)
+	}
+
+	return &grant, nil
+}
+
+// RevokeFileAccess ends a user's access to one of the owner's files
+func (s *FileService) RevokeFileAccess(ownerID string, fileID uuid.UUID, granteeID string) error {
+	result := s.db.Model(&models.FileAccess{}).
+		Where("user_file_id = ? AND owner_user_id = ? AND grantee_user_id = ? AND revoked_at IS NULL", fileID, ownerID, granteeID).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke access: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrFileAccessNotFound
+	}
+	return nil
+}
+
+// GetSharedWithMeFiles returns files other users have granted the user access to,
+// most recently shared first. Grants whose file was deleted are left out.
+func (s *FileService) GetSharedWithMeFiles(userID string, offset, limit int) ([]SharedFileResponse, int64, error) {
+	activeGrants := func() *gorm.DB {
+		return s.db.Model(&models.FileAccess{}).
+			Joins("JOIN user_files ON user_files.id = file_accesses.user_file_id AND user_files.user_id = file_accesses.owner_user_id AND user_files.deleted_at IS NULL").
+			Where("file_accesses.grantee_user_id = ? AND file_accesses.revoked_at IS NULL", userID)
+	}
+
+	var total int64
+	if err := activeGrants().Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count shared files: %w", err)
+	}
+
+	var rows []struct {
+		userFileRow
+		OwnerUserID string
+		SharedAt    time.Time
+		Permissions models.StringList
+	}
+	err := activeGrants().
+		Scopes(joinFileHashes).
+		Select(userFileColumns + ", file_accesses.owner_user_id, file_accesses.created_at AS shared_at, file_accesses.permissions").
+		Order("file_accesses.created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get shared files: %w", err)
+	}
+
+	response := make([]SharedFileResponse, len(rows))
+	for i, row := range rows {
+		response[i] = SharedFileResponse{
+			UserFileResponse: row.response(),
+			SharedByUserID:   row.OwnerUserID,
+			SharedAt:         row.SharedAt,
+			Permissions:      row.Permissions,
+		}
+	}
+
+	return response, total, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"filevault-backend/internal/models"
+)
+
+// ErrFileCountQuotaExceeded is returned when a user already has as many files as their
+// file count quota allows
+var ErrFileCountQuotaExceeded = errors.New("file count quota exceeded")
+
+// GetFileCountInfo returns how many files the user has and how many they may have
+func (s *UserService) GetFileCountInfo(userID string) (used int64, quota int, err error) {
+	var user models.User
+	if err := s.db.Select("file_count_quota").Where("id = ?", userID).First(&user).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to get user file count quota: %w", err)
+	}
+	if err := s.db.Model(&models.UserFile{}).Where("user_id = ?", userID).Count(&used).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to count user files: %w", err)
+	}
+	return used, user.FileCountQuota, nil
+}
+
+// CheckFileCountQuota rejects new files once the user has reached their file count
+// quota. Storage quota alone doesn't stop millions of tiny files.
+func (s *UserService) CheckFileCountQuota(userID string) error {
+	used, quota, err := s.GetFileCountInfo(userID)
+	if err != nil {
+		return err
+	}
+	if used >= int64(quota) {
+		return fmt.Errorf("%w: have %d files, quota is %d", ErrFileCountQuotaExceeded, used, quota)
+	}
+	return nil
+}
+
+// UpdateFileCountQuota sets how many files the user may have (admin function)
+func (s *UserService) UpdateFileCountQuota(userID string, quota int) error {
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Update("file_count_quota", quota)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update file count quota: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	s.users.delete(userID)
+	return nil
+}
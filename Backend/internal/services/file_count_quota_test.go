@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+func TestEveryUploadPathChecksFileCountQuota(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.BannedHash{}, &models.UploadRequest{})
+	cfg := &config.Config{}
+	s := &FileService{db: tx, cfg: cfg, storage: &storage.MinIOStorage{}, userService: &UserService{db: tx, cfg: cfg}}
+	ctx := context.Background()
+
+	// The user's one file fills their file count quota, with storage to spare
+	userID := "count-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID, StorageQuota: 1 << 30, FileCountQuota: 1}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 10, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	if err := tx.Create(&models.UserFile{UserID: userID, FileHash: testSHA256, Filename: "only.txt"}).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	request := models.UploadRequest{OwnerUserID: userID, Title: "Documents", MaxFiles: 5}
+	if err := tx.Create(&request).Error; err != nil {
+		t.Fatalf("failed to create upload request: %v", err)
+	}
+
+	const newHash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	paths := map[string]func() error{
+		"upload-url": func() error {
+			_, err := s.GeneratePresignedUploadURL(ctx, userID, "new.txt", newHash, "", 10, "text/plain", UploadOptions{})
+			return err
+		},
+		"upload-url server hash": func() error {
+			_, err := s.GenerateServerHashUploadURL(ctx, userID, "new.txt", 10, "text/plain", UploadOptions{})
+			return err
+		},
+		"upload-post-url": func() error {
+			_, err := s.GeneratePresignedPostURL(ctx, userID, "new.txt", newHash, "", 10, "text/plain")
+			return err
+		},
+		"multipart": func() error {
+			_, err := s.StartMultipartUpload(ctx, userID, "new.txt", 10, "text/plain", 1)
+			return err
+		},
+		"upload request": func() error {
+			_, err := s.PrepareUploadRequestFile(ctx, request.ID, "new.txt", newHash, 10, "text/plain")
+			return err
+		},
+	}
+	for name, prepare := range paths {
+		if err := prepare(); !errors.Is(err, ErrFileCountQuotaExceeded) {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrFileCountQuotaExceeded)
+		}
+	}
+
+	// The rejected upload request file gives its slot back
+	var received models.UploadRequest
+	if err := tx.Where("id = ?", request.ID).First(&received).Error; err != nil {
+		t.Fatalf("failed to get upload request: %v", err)
+	}
+	if received.FilesReceived != 0 {
+		t.Errorf("files received = %d after rejection, want 0", received.FilesReceived)
+	}
+}
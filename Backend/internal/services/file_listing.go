@@ -0,0 +1,93 @@
+package services
+
+import (
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// userFileColumns selects the columns of a UserFileResponse from user_files joined
+// to file_hashes, so listings read each page in one query instead of preloading
+// FileData and hydrating full models
+const userFileColumns = `user_files.id, user_files.filename, file_hashes.size, file_hashes.mime_type,
+	user_files.is_public, user_files.download_count, user_files.relative_path,
+	user_files.uploaded_at, user_files.locked, user_files.public_until`
+
+// joinFileHashes adds the content details a listing needs
+func joinFileHashes(db *gorm.DB) *gorm.DB {
+	return db.Joins("JOIN file_hashes ON file_hashes.hash = user_files.file_hash")
+}
+
+// joinShareLinks adds a public file's share link as share_link.id, NULL when it has none.
+// Deleted links are left out, and a file with several links gets the one ShareIDFor
+// returns so the rows aren't multiplied.
+func joinShareLinks(db *gorm.DB) *gorm.DB {
+	return db.Joins(`LEFT JOIN LATERAL (
+		SELECT share_links.id FROM share_links
+		WHERE share_links.user_file_id = user_files.id AND share_links.deleted_at IS NULL
+		ORDER BY share_links.id
+		LIMIT 1
+	) share_link ON user_files.is_public`)
+}
+
+// userFileRow is one row selected with userFileColumns
+type userFileRow struct {
+	ID            uuid.UUID
+	Filename      string
+	Size          int64
+	MimeType      string
+	IsPublic      bool
+	DownloadCount int
+	RelativePath  string
+	UploadedAt    time.Time
+	Locked        bool
+	PublicUntil   *time.Time
+
+	// Only selected by listUserFiles
+	LastAccessedAt *time.Time
+	Metadata       models.FileMetadata
+	ShareID        *string
+}
+
+// response matches toUserFileResponse for the same file
+func (r userFileRow) response() UserFileResponse {
+	return UserFileResponse{
+		ID:            r.ID,
+		Filename:      r.Filename,
+		Size:          r.Size,
+		MimeType:      r.MimeType,
+		IsPublic:      r.IsPublic,
+		DownloadCount: r.DownloadCount,
+		RelativePath:  r.RelativePath,
+		UploadedAt:    r.UploadedAt,
+		Locked:        r.Locked,
+		ShareID:       r.ShareID,
+
+		LastAccessedAt: r.LastAccessedAt,
+		Metadata:       r.Metadata,
+
+		PublicUntil:            r.PublicUntil,
+		PublicSecondsRemaining: PublicSecondsRemaining(r.PublicUntil),
+	}
+}
+
+// listUserFiles reads a page of the owner's files from a query on user_files that
+// already joins file_hashes, ordered and limited by the caller
+func listUserFiles(query *gorm.DB) ([]UserFileResponse, error) {
+	var rows []userFileRow
+	err := query.Scopes(joinShareLinks).
+		Select(userFileColumns + ", " + lastAccessedColumn + " AS last_accessed_at, user_files.metadata, share_link.id AS share_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]UserFileResponse, len(rows)) // Serializes as [] when empty
+	for i, row := range rows {
+		response[i] = row.response()
+	}
+	return response, nil
+}
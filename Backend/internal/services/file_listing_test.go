@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// checkJSONArray checks that value marshals to an empty JSON array, not null
+func checkJSONArray(t *testing.T, name string, value interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", name, err)
+	}
+	if string(encoded) != "[]" {
+		t.Errorf("%s marshals to %s, want []", name, encoded)
+	}
+}
+
+func TestEmptyFileListsSerializeAsArrays(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{}, &models.BatchUpload{}, &models.BatchUploadFile{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	// A user who hasn't uploaded anything yet
+	userID := "empty-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	files, total, err := s.GetUserFiles(ctx, userID, FileListOptions{}, 0, 20)
+	if err != nil {
+		t.Fatalf("GetUserFiles() error = %v", err)
+	}
+	if total != 0 {
+		t.Errorf("GetUserFiles() total = %d, want 0", total)
+	}
+	checkJSONArray(t, "GetUserFiles()", files)
+
+	found, _, err := s.SearchFiles(userID, "report", 0, 20)
+	if err != nil {
+		t.Fatalf("SearchFiles() error = %v", err)
+	}
+	checkJSONArray(t, "SearchFiles()", found)
+
+	session, err := s.openBatchSession(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("openBatchSession() error = %v", err)
+	}
+	completed, err := s.BatchCompleteUpload(ctx, userID, session.ID.String(), nil)
+	if err != nil {
+		t.Fatalf("BatchCompleteUpload() error = %v", err)
+	}
+	checkJSONArray(t, "completed_files", completed.CompletedFiles)
+}
+
+func TestListUserFilesMatchesToUserFileResponse(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{})
+	ctx := context.Background()
+
+	// Files that set each optional field the listing reads
+	userID, ids := createVault(t, tx, 4)
+	downloaded := time.Now().Add(-48 * time.Hour).Truncate(time.Microsecond)
+	accessed := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	publicUntil := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	updates := []map[string]interface{}{
+		{"last_download_at": downloaded, "download_count": 3},
+		{"last_download_at": downloaded, "last_accessed_at": accessed, "relative_path": "photos/2024/beach.jpg"},
+		{"is_public": true, "public_until": publicUntil, "locked": true},
+		{"metadata": models.FileMetadata{"project": "apollo"}},
+	}
+	for i, update := range updates {
+		if err := tx.Model(&models.UserFile{}).Where("id = ?", ids[i]).Updates(update).Error; err != nil {
+			t.Fatalf("failed to update file %d: %v", i, err)
+		}
+	}
+
+	listed, err := listUserFiles(tx.WithContext(ctx).Model(&models.UserFile{}).
+		Scopes(joinFileHashes).
+		Where("user_files.user_id = ?", userID).
+		Order("user_files.filename"))
+	if err != nil {
+		t.Fatalf("listUserFiles() error = %v", err)
+	}
+
+	var files []models.UserFile
+	if err := tx.Preload("FileData").Where("user_id = ?", userID).Order("filename").Find(&files).Error; err != nil {
+		t.Fatalf("failed to get files: %v", err)
+	}
+	if len(listed) != len(files) {
+		t.Fatalf("listUserFiles() returned %d files, want %d", len(listed), len(files))
+	}
+	for i, file := range files {
+		if want := toUserFileResponse(file); !reflect.DeepEqual(listed[i], want) {
+			t.Errorf("listUserFiles()[%d] = %+v, want %+v", i, listed[i], want)
+		}
+	}
+}
+
+// BenchmarkGetUserFiles lists pages of a 10,000-file vault
+func BenchmarkGetUserFiles(b *testing.B) {
+	tx := testTx(b, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	userID, _ := createVault(b, tx, 10000)
+
+	for b.Loop() {
+		files, total, err := s.GetUserFiles(ctx, userID, FileListOptions{}, 5000, 100)
+		if err != nil {
+			b.Fatalf("GetUserFiles() error = %v", err)
+		}
+		if total != 10000 || len(files) != 100 {
+			b.Fatalf("GetUserFiles() = %d of %d files, want 100 of 10000", len(files), total)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrFileLocked is returned when deleting or replacing a file its owner has locked
+var ErrFileLocked = errors.New("file is locked")
+
+// SetFileLock locks one of the user's files against deletion and replacement, or
+// unlocks it. Locking is a latch for the owner: it has to be cleared before the file
+// can be deleted.
+func (s *FileService) SetFileLock(userID string, fileID uuid.UUID, locked bool) error {
+	result := s.db.Model(&models.UserFile{}).Where("id = ? AND user_id = ?", fileID, userID).Update("locked", locked)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update file lock: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserFileNotFound
+	}
+	return nil
+}
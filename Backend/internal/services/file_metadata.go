@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A file's stored metadata is capped at this many bytes of JSON
+const maxFileMetadataBytes = 4096
+
+// metadataKeyPattern is what metadata keys may look like
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ErrInvalidMetadata is returned for metadata keys outside the allowed pattern or
+// metadata over the size cap
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// validMetadataKey reports whether a key may be stored or filtered on
+func validMetadataKey(key string) bool {
+	return metadataKeyPattern.MatchString(key)
+}
+
+// UpdateFileMetadata merges an update into one of the user's files' metadata. Keys set
+// to nil are removed; keys not mentioned are left as they are.
+func (s *FileService) UpdateFileMetadata(userID string, fileID uuid.UUID, update map[string]*string) (models.FileMetadata, error) {
+	for key := range update {
+		if !validMetadataKey(key) {
+			return nil, fmt.Errorf("%w: key %q must be 1-64 letters, digits, underscores or hyphens", ErrInvalidMetadata, key)
+		}
+	}
+
+	var metadata models.FileMetadata
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Locked so concurrent updates to different keys don't overwrite each other
+		var userFile models.UserFile
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "metadata").
+			Where("id = ? AND user_id = ?", fileID, userID).
+			First(&userFile).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserFileNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to get file metadata: %w", err)
+		}
+
+		metadata = userFile.Metadata
+		if metadata == nil {
+			metadata = models.FileMetadata{}
+		}
+		for key, value := range update {
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = *value
+			}
+		}
+
+		if encoded, err := json.Marshal(metadata); err != nil || len(encoded) > maxFileMetadataBytes {
+			return fmt.Errorf("%w: metadata exceeds %d bytes", ErrInvalidMetadata, maxFileMetadataBytes)
+		}
+
+		if err := tx.Model(&userFile).UpdateColumn("metadata", metadata).Error; err != nil {
+			return fmt.Errorf("failed to save file metadata: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// metadataFilter returns a condition matching files whose metadata contains every
+// pair, which the GIN index on user_files.metadata serves
+func metadataFilter(pairs map[string]string) (string, string, error) {
+	for key := range pairs {
+		if !validMetadataKey(key) {
+			return "", "", fmt.Errorf("%w: key %q must be 1-64 letters, digits, underscores or hyphens", ErrInvalidMetadata, key)
+		}
+	}
+	encoded, err := json.Marshal(pairs)
+	if err != nil {
+		return "", "", err
+	}
+	return "user_files.metadata @> ?::jsonb", string(encoded), nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// fuzzySimilarityThreshold is the pg_trgm word similarity a query needs with some
+// part of a filename to match it. Word similarity ignores the rest of the name, so
+// "docuemnt" scores 0.44 against "document.pdf" where plain similarity scores 0.29.
+const fuzzySimilarityThreshold = 0.3
+
+// SearchFiles finds the user's files whose name contains query (case-insensitive)
+func (s *FileService) SearchFiles(userID, query string, offset, limit int) ([]UserFileResponse, int64, error) {
+	pattern := "%" + escapeLikePattern(query) + "%"
+	return s.searchFiles(s.db, userID, offset, limit, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_files.filename ILIKE ?", pattern).Order("user_files.uploaded_at DESC")
+	})
+}
+
+// FuzzySearchFiles finds the user's files with a part of their name similar to query,
+// so misspellings like "docuemnt" still find "document.pdf". Results are ordered by
+// word similarity. Without pg_trgm it falls back to SearchFiles.
+func (s *FileService) FuzzySearchFiles(userID, query string, offset, limit int) ([]UserFileResponse, int64, error) {
+	if !s.fuzzySearch {
+		return s.SearchFiles(userID, query, offset, limit)
+	}
+
+	var files []UserFileResponse
+	var total int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// SET LOCAL keeps the threshold scoped to this transaction's connection
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL pg_trgm.word_similarity_threshold = %g", fuzzySimilarityThreshold)).Error; err != nil {
+			return fmt.Errorf("failed to set similarity threshold: %w", err)
+		}
+
+		var err error
+		files, total, err = s.searchFiles(tx, userID, offset, limit, func(db *gorm.DB) *gorm.DB {
+			return db.Where("? <% user_files.filename", query).
+				Order(gorm.Expr("word_similarity(?, user_files.filename) DESC", query))
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
+}
+
+func (s *FileService) searchFiles(db *gorm.DB, userID string, offset, limit int, filter func(*gorm.DB) *gorm.DB) ([]UserFileResponse, int64, error) {
+	var total int64
+	if err := filter(db.Model(&models.UserFile{}).Where("user_files.user_id = ?", userID)).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	var userFiles []models.UserFile
+	err := filter(db.Preload("FileData").Where("user_files.user_id = ?", userID)).
+		Offset(offset).
+		Limit(limit).
+		Find(&userFiles).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search files: %w", err)
+	}
+
+	response := make([]UserFileResponse, 0, len(userFiles))
+	for _, file := range userFiles {
+		response = append(response, toUserFileResponse(file))
+	}
+
+	return response, total, nil
+}
+
+// escapeLikePattern escapes LIKE wildcards so user input matches literally
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"testing"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestFuzzySearchFindsMisspelledNames(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{})
+	if err := tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		t.Skipf("pg_trgm is not available: %v", err)
+	}
+	s := &FileService{db: tx, fuzzySearch: true}
+
+	userID := "search-user-" + uuid.New().String()
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 10, MimeType: "application/pdf"}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	for _, filename := range []string{"document.pdf", "holiday.jpg"} {
+		if err := tx.Create(&models.UserFile{UserID: userID, FileHash: testSHA256, Filename: filename}).Error; err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	// Against the whole name "docuemnt" scores 0.29, under the threshold; against the
+	// word "document" it scores 0.44
+	files, total, err := s.FuzzySearchFiles(userID, "docuemnt", 0, 10)
+	if err != nil {
+		t.Fatalf("FuzzySearchFiles() error = %v", err)
+	}
+	if total != 1 || len(files) != 1 || files[0].Filename != "document.pdf" {
+		t.Errorf("FuzzySearchFiles(docuemnt) = %d files, total %d, want only document.pdf", len(files), total)
+	}
+
+	// The substring search is what fuzzy search replaces for misspellings
+	if _, total, err := s.SearchFiles(userID, "docuemnt", 0, 10); err != nil || total != 0 {
+		t.Errorf("SearchFiles(docuemnt) total = %d, %v, want no substring match", total, err)
+	}
+}
+
+func TestFuzzySearchFallsBackToSubstring(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{})
+	s := &FileService{db: tx}
+
+	userID := "search-user-" + uuid.New().String()
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 10, MimeType: "application/pdf"}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	for _, filename := range []string{"document.pdf", "100%_done.txt"} {
+		if err := tx.Create(&models.UserFile{UserID: userID, FileHash: testSHA256, Filename: filename}).Error; err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	files, _, err := s.FuzzySearchFiles(userID, "DOCUMENT", 0, 10)
+	if err != nil {
+		t.Fatalf("FuzzySearchFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "document.pdf" {
+		t.Errorf("FuzzySearchFiles() without pg_trgm = %v, want the case-insensitive substring match", files)
+	}
+
+	// LIKE wildcards in the query match literally
+	if _, total, err := s.SearchFiles(userID, "%_", 0, 10); err != nil || total != 1 {
+		t.Errorf("SearchFiles(%%_) total = %d, %v, want only the name containing them", total, err)
+	}
+}
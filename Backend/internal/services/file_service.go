@@ -2,59 +2,121 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"filevault-backend/internal/config"
 	"filevault-backend/internal/models"
 	"filevault-backend/internal/storage"
 
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/time/rate"
 	"gorm.io/gorm"
 )
 
 type FileService struct {
-	db      *gorm.DB
-	storage *storage.MinIOStorage
+	db            *gorm.DB
+	cfg           *config.Config
+	storage       *storage.MinIOStorage
+	deletionQueue *DeletionQueue
+	thumbnails    *ThumbnailQueue // nil when thumbnails are disabled
+	userService   *UserService
+	geoIP         *GeoIPResolver
+	fuzzySearch   bool
+
+	// keyMigrationActive enables the fallback lookup for objects not yet moved to sharded keys
+	keyMigrationActive atomic.Bool
+
+	// How far the database clock is ahead of ours, in nanoseconds; see dbNow
+	dbClockOffset atomic.Int64
+
+	// Share link ID -> shared file, dropped when the file is deleted, made private or unshared
+	sharedFiles *ttlCache[models.UserFile]
+	// File ID -> public file info, dropped along with sharedFiles entries
+	publicFiles *ttlCache[PublicFileResponse]
+
+	// Signs upload completion tokens
+	uploadTokenKey []byte
+
+	// Keys the client IP hashes stored with download events and abuse reports
+	ipHashKey []byte
+
+	// User ID -> on-demand verification limiter; see allowVerify
+	verifyLimiters   map[string]*rate.Limiter
+	verifyLimitersMu sync.Mutex
 }
 
-func NewFileService(db *gorm.DB, storage *storage.MinIOStorage) *FileService {
+func NewFileService(db *gorm.DB, cfg *config.Config, storage *storage.MinIOStorage, deletionQueue *DeletionQueue, thumbnails *ThumbnailQueue, userService *UserService, geoIP *GeoIPResolver, fuzzySearch bool) *FileService {
 	return &FileService{
-		db:      db,
-		storage: storage,
+		db:            db,
+		cfg:           cfg,
+		storage:       storage,
+		deletionQueue: deletionQueue,
+		thumbnails:    thumbnails,
+		userService:   userService,
+		geoIP:         geoIP,
+		fuzzySearch:   fuzzySearch,
+		sharedFiles:   newTTLCache[models.UserFile]("shared_files", cacheTTL(cfg.CacheEnabled, cfg.CacheTTLSeconds)),
+		publicFiles:   newTTLCache[PublicFileResponse]("public_files", cacheTTL(cfg.CacheEnabled, cfg.CacheTTLSeconds)),
+
+		uploadTokenKey: uploadTokenKey(cfg),
+		ipHashKey:      ipHashKey(cfg),
 	}
 }
 
-// GeneratePresignedUploadURL generates a presigned URL for file upload
-func (s *FileService) GeneratePresignedUploadURL(userID, filename, fileHash string, size int64, mimeType string) (*PresignedUploadResponse, error) {
-	// Check if file already exists (deduplication)
-	var existingFileHash models.FileHash
-	err := s.db.Where("hash = ?", fileHash).First(&existingFileHash).Error
-	if err == nil {
-		// File already exists, just create a UserFile record
-		userFile := models.UserFile{
-			ID:         uuid.New(),
-			UserID:     userID,
-			FileHash:   fileHash,
-			Filename:   filename,
-			IsPublic:   false,
-			UploadedAt: time.Now().UTC(),
-			UpdatedAt:  time.Now().UTC(),
-		}
-
-		// Create UserFile record and increment reference count in a transaction
-		tx := s.db.Begin()
-		if err := tx.Create(&userFile).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to create user file record for duplicate: %w", err)
-		}
+// GeneratePresignedUploadURL generates a presigned URL for file upload. secondaryHash is
+// the client's BLAKE2b-256 of the content; without a matching one, content that is already
+// stored has to be uploaded again rather than linked. Quota is checked against what the
+// file adds to the user's usage under the quota mode, which for content they already
+// hold under deduplicated quotas is nothing, so that succeeds even at their quota.
+func (s *FileService) GeneratePresignedUploadURL(ctx context.Context, userID, filename, fileHash, secondaryHash string, size int64, mimeType string, opts UploadOptions) (*PresignedUploadResponse, error) {
+	if err := s.checkBannedHash(fileHash); err != nil {
+		return nil, err
+	}
 
-		if err := tx.Model(&existingFileHash).Update("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("failed to update reference count for duplicate: %w", err)
+	// The user's own files need no proof of possession, so this comes before the
+	// secondary hash check
+	if opts.DedupBehavior.checksOwnCopies() {
+		ownCopies, err := s.ownCopies(ctx, userID, []string{fileHash})
+		if err != nil {
+			return nil, err
+		}
+		if copies := ownCopies[fileHash]; len(copies) > 0 {
+			response := &PresignedUploadResponse{IsDuplicate: true, HashMode: HashModeClient}
+			if opts.DedupBehavior == DedupSkip {
+				response.Skipped = true
+				response.ExistingFile = &copies[0]
+			} else {
+				response.ExistingFiles = toUserFileResponses(copies)
+			}
+			return response, nil
 		}
+	}
+
+	if err := s.userService.CheckFileCountQuota(userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkStorageFor(ctx, userID, fileHash, size); err != nil {
+		return nil, err
+	}
 
-		if err := tx.Commit().Error; err != nil {
-			return nil, fmt.Errorf("failed to commit duplicate file transaction: %w", err)
+	// Check if file already exists (deduplication)
+	existing, err := s.lookupExistingHashes(ctx, []string{fileHash})
+	if err != nil {
+		return nil, err
+	}
+	if existingFileHash, ok := existing[fileHash]; ok && !opts.alwaysUpload && s.canLinkExisting(existingFileHash, secondaryHash) {
+		// File already exists, just create a UserFile record
+		userFile, dedup, err := s.linkDuplicateUpload(ctx, userID, filename, existingFileHash, opts)
+		if err != nil {
+			return nil, err
 		}
 
 		return &PresignedUploadResponse{
@@ -62,157 +124,335 @@ func (s *FileService) GeneratePresignedUploadURL(userID, filename, fileHash stri
 			ObjectKey:    "",
 			ExpiresAt:    time.Time{},
 			IsDuplicate:  true,
-			ExistingFile: &userFile,
+			ExistingFile: userFile,
+			Dedup:        dedup,
+			HashMode:     HashModeClient,
 		}, nil
-	} else if err != gorm.ErrRecordNotFound {
-		return nil, fmt.Errorf("failed to check for existing file: %w", err)
 	}
 
-	// File doesn't exist, generate upload URL directly to final location
-	finalKey := fileHash // Simple hash-based key
+	// File doesn't exist (or couldn't be proven identical), upload to staging; completion moves it to its final key
+	stagedKey := s.storage.StagingKey(userID, uuid.New().String())
 
 	// Generate presigned URL for upload (expires in 1 hour)
-	uploadURL, err := s.storage.GetUploadURL(context.Background(), finalKey, time.Hour)
+	uploadURL, err := s.storage.GetUploadURL(ctx, stagedKey, time.Hour)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
 	}
 
 	return &PresignedUploadResponse{
 		UploadURL:   uploadURL,
-		ObjectKey:   finalKey,
-		ExpiresAt:   time.Now().Add(time.Hour),
+		UploadToken: s.issueUploadToken(userID, stagedKey, fileHash, size, opts),
+		ExpiresAt:   time.Now().UTC().Add(time.Hour),
 		IsDuplicate: false,
+		HashMode:    HashModeClient,
 	}, nil
 }
 
-// CompleteFileUpload finalizes file upload after successful upload to MinIO
-func (s *FileService) CompleteFileUpload(userID, objectKey, filename, mimeType, fileHash string) (*models.UserFile, error) {
-	ctx := context.Background()
+// linkDuplicateUpload records a new UserFile for content that is already stored,
+// so no upload is needed
+func (s *FileService) linkDuplicateUpload(ctx context.Context, userID, filename string, existingFileHash models.FileHash, opts UploadOptions) (*models.UserFile, *DedupStats, error) {
+	userFile := models.UserFile{
+		ID:         uuid.New(),
+		UserID:     userID,
+		FileHash:   existingFileHash.Hash,
+		Filename:   filename,
+		IsPublic:   s.uploadVisibility(userID, opts.IsPublic),
+		UploadedAt: time.Now().UTC(),
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	// Create UserFile record and increment reference count in a transaction
+	tx := s.db.WithContext(ctx).Begin()
+	dedup, err := dedupStatsFor(tx, userID, existingFileHash)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("failed to compute deduplication stats: %w", err)
+	}
+
+	afterCommit, err := s.createUserFile(tx, &userFile, opts.ConflictPolicy)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Model(&existingFileHash).Update("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("failed to update reference count for duplicate: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to commit duplicate file transaction: %w", err)
+	}
+	afterCommit()
+	s.tagPublicUpload(ctx, &userFile, existingFileHash.MinIOKey)
+
+	s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityUpload, FileID: &userFile.ID, Filename: userFile.Filename})
+
+	return &userFile, dedup, nil
+}
+
+// CompleteFileUpload finalizes file upload after successful upload to MinIO: the staged
+// object is copied to its content-addressed key and then deleted. The returned dedup
+// stats are non-nil when another upload of the same content finished first. The
+// returned file carries the name it was stored under after applying the conflict policy.
+func (s *FileService) CompleteFileUpload(ctx context.Context, userID, objectKey, filename, mimeType, fileHash string, opts UploadOptions) (*models.UserFile, *DedupStats, error) {
+	if !s.isOwnStagedObject(userID, objectKey) {
+		return nil, nil, ErrInvalidObjectKey
+	}
+
+	if err := s.checkBannedHash(fileHash); err != nil {
+		if errors.Is(err, ErrHashBanned) {
+			s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: objectKey})
+		}
+		return nil, nil, err
+	}
 
 	// Get file info from MinIO
 	fileInfo, err := s.storage.GetFileInfo(ctx, objectKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+		return nil, nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	tx := s.db.Begin()
+	// Never trust the declared hash for deduplication: hash what was actually uploaded
+	secondaryHash, err := s.verifyStagedContent(ctx, objectKey, fileHash)
+	if err != nil {
+		if errors.Is(err, ErrHashMismatch) {
+			s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: objectKey})
+		}
+		return nil, nil, err
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
 		}
 	}()
 
+	// Different content with the same SHA-256 is stored independently
+	storedHash, err := s.storedContentHash(tx, fileHash, secondaryHash)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
 	// Get or create FileHash record
 	var fileHashRecord models.FileHash
-	err = tx.Where("hash = ?", fileHash).First(&fileHashRecord).Error
+	var dedup *DedupStats
+	err = tx.Where("hash = ?", storedHash).First(&fileHashRecord).Error
 	if err == gorm.ErrRecordNotFound {
-		// New file, create hash record (file is already at final location)
+		// Move the staged upload to the content-addressed location
+		finalKey := s.objectKeyForHash(storedHash)
+		if err := s.storage.CopyObject(ctx, objectKey, finalKey); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		// New file, create hash record
 		fileHashRecord = models.FileHash{
-			Hash:           fileHash,
+			Hash:           storedHash,
+			SecondaryHash:  secondaryHash,
+			CollidesWith:   collidesWith(storedHash, fileHash),
 			Size:           fileInfo.Size,
 			MimeType:       mimeType,
 			ReferenceCount: 1,
-			MinIOKey:       objectKey, // objectKey is already the final location: files/{hash}
+			MinIOKey:       finalKey,
 			CreatedAt:      time.Now().UTC(),
 			UpdatedAt:      time.Now().UTC(),
 		}
 
 		if err := tx.Create(&fileHashRecord).Error; err != nil {
 			tx.Rollback()
-			return nil, fmt.Errorf("failed to create file hash record: %w", err)
+			return nil, nil, fmt.Errorf("failed to create file hash record: %w", err)
+		}
+		if err := s.thumbnails.EnqueueTx(tx, fileHashRecord); err != nil {
+			tx.Rollback()
+			return nil, nil, err
 		}
 	} else if err != nil {
 		tx.Rollback()
-		return nil, fmt.Errorf("failed to query file hash: %w", err)
+		return nil, nil, fmt.Errorf("failed to query file hash: %w", err)
 	} else {
-		// File already exists - this shouldn't happen since we check for duplicates earlier
-		// But if it does, increment reference count and clean up the duplicate upload
+		// File already exists - either another upload finished first or the client couldn't
+		// prove it had the content
+		if err := backfillSecondaryHash(tx, fileHashRecord, secondaryHash); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		// Increment reference count and clean up the duplicate upload
+		dedup, err = dedupStatsFor(tx, userID, fileHashRecord)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to compute deduplication stats: %w", err)
+		}
+
 		if err := tx.Model(&fileHashRecord).Update("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
 			tx.Rollback()
-			return nil, fmt.Errorf("failed to update reference count: %w", err)
+			return nil, nil, fmt.Errorf("failed to update reference count: %w", err)
 		}
 
-		// Clean up the duplicate file that was just uploaded
-		go func() {
-			if err := s.storage.DeleteFile(context.Background(), objectKey); err != nil {
-				// Log error but don't fail the operation since this is just cleanup
-				fmt.Printf("Warning: failed to delete duplicate file %s: %v\n", objectKey, err)
-			}
-		}()
 	}
 
 	// Create UserFile record
 	userFile := models.UserFile{
 		ID:         uuid.New(),
 		UserID:     userID,
-		FileHash:   fileHash,
+		FileHash:   fileHashRecord.Hash,
 		Filename:   filename,
-		IsPublic:   false,
+		IsPublic:   s.uploadVisibility(userID, opts.IsPublic),
 		UploadedAt: time.Now().UTC(),
 		UpdatedAt:  time.Now().UTC(),
 	}
 
-	if err := tx.Create(&userFile).Error; err != nil {
+	afterCommit, err := s.createUserFile(tx, &userFile, opts.ConflictPolicy)
+	if err != nil {
 		tx.Rollback()
-		return nil, fmt.Errorf("failed to create user file record: %w", err)
+		return nil, nil, err
 	}
 
 	// Commit transaction
 	if err := tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	afterCommit()
+	s.tagPublicUpload(ctx, &userFile, fileHashRecord.MinIOKey)
 
-	return &userFile, nil
+	// The content now lives at its final key (or already did); drop the staged copy
+	s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: objectKey})
+
+	s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityUpload, FileID: &userFile.ID, Filename: userFile.Filename})
+
+	return &userFile, dedup, nil
+}
+
+// tagPublicUpload tags a newly uploaded public file's object the way ToggleFilePublic
+// does. The upload has already succeeded, so a failure is only logged.
+func (s *FileService) tagPublicUpload(ctx context.Context, userFile *models.UserFile, objectKey string) {
+	if !userFile.IsPublic {
+		return
+	}
+	if err := s.storage.SetObjectTags(ctx, objectKey, map[string]string{"public": "true"}); err != nil {
+		slog.Warn("object_tag_update_failed", slog.String("object_key", objectKey), slog.Bool("public", true), slog.Any("error", err))
+	}
 }
 
 // GetUserFiles returns paginated list of user's files
-func (s *FileService) GetUserFiles(userID string, offset, limit int) ([]UserFileResponse, int64, error) {
-	var userFiles []models.UserFile
-	var total int64
+func (s *FileService) GetUserFiles(ctx context.Context, userID string, options FileListOptions, offset, limit int) ([]UserFileResponse, int64, error) {
+	var metadataCondition, metadataPairs string
+	if len(options.Metadata) > 0 {
+		var err error
+		if metadataCondition, metadataPairs, err = metadataFilter(options.Metadata); err != nil {
+			return nil, 0, err
+		}
+	}
 
-	// Count total files
-	if err := s.db.Model(&models.UserFile{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+	scope := func() *gorm.DB {
+		query := s.db.WithContext(ctx).Model(&models.UserFile{}).
+			Scopes(joinFileHashes).
+			Where("user_files.user_id = ?", userID)
+		if options.Category != "" {
+			condition, args := mimeCategoryCondition(options.Category)
+			query = query.Where(condition, args...)
+		}
+		if options.NotAccessedSince != nil {
+			query = query.Where(lastTouchedColumn+" < ?", *options.NotAccessedSince)
+		}
+		if metadataCondition != "" {
+			query = query.Where(metadataCondition, metadataPairs)
+		}
+		return query
+	}
+
+	var total int64
+	if err := scope().Count(&total).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to count user files: %w", err)
 	}
 
-	// Get files with file data
-	err := s.db.Preload("FileData").
-		Where("user_id = ?", userID).
-		Order("uploaded_at DESC").
+	response, err := listUserFiles(scope().
+		Order(options.SortBy.orderBy()).
 		Offset(offset).
-		Limit(limit).
-		Find(&userFiles).Error
-
+		Limit(limit))
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to get user files: %w", err)
 	}
 
-	// Convert to response format
-	response := make([]UserFileResponse, 0) // Initialize as empty slice, not nil
-	for _, file := range userFiles {
-		response = append(response, UserFileResponse{
-			ID:            file.ID,
-			Filename:      file.Filename,
-			Size:          file.FileData.Size,
-			MimeType:      file.FileData.MimeType,
-			IsPublic:      file.IsPublic,
-			DownloadCount: file.DownloadCount,
-			UploadedAt:    file.UploadedAt,
-		})
+	return response, total, nil
+}
+
+// ErrUserFileNotFound is returned when a file doesn't exist or belongs to another user
+var ErrUserFileNotFound = errors.New("file not found")
+
+// ErrShareLinkNotFound is returned for share links that don't exist or whose file is no
+// longer public
+var ErrShareLinkNotFound = errors.New("share link not found or file no longer available")
+
+// GetUserFile returns one of the user's files
+func (s *FileService) GetUserFile(ctx context.Context, userID string, fileID uuid.UUID) (*UserFileResponse, error) {
+	var userFile models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get user file: %w", err)
 	}
 
-	return response, total, nil
+	response := toUserFileResponse(userFile)
+	return &response, nil
+}
+
+// GetVisibleFile returns one of the user's files, or another user's file while it's public
+func (s *FileService) GetVisibleFile(userID string, fileID uuid.UUID) (*UserFileResponse, error) {
+	var userFile models.UserFile
+	err := s.db.Preload("FileData").Where("id = ? AND (user_id = ? OR is_public = ?)", fileID, userID, true).First(&userFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	if userFile.UserID != userID && !stillShared(userFile) {
+		return nil, ErrUserFileNotFound
+	}
+
+	response := toUserFileResponse(userFile)
+	if userFile.UserID != userID {
+		response.Metadata = nil
+	}
+	return &response, nil
 }
 
-// GetFileDownloadURL generates download URL for a file
-func (s *FileService) GetFileDownloadURL(userID string, fileID uuid.UUID) (string, error) {
+// toUserFileResponse converts a UserFile with preloaded FileData to its API representation
+func toUserFileResponse(file models.UserFile) UserFileResponse {
+	return UserFileResponse{
+		ID:            file.ID,
+		Filename:      file.Filename,
+		Size:          file.FileData.Size,
+		MimeType:      file.FileData.MimeType,
+		IsPublic:      file.IsPublic,
+		DownloadCount: file.DownloadCount,
+		RelativePath:  file.RelativePath,
+		UploadedAt:    file.UploadedAt,
+		Locked:        file.Locked,
+
+		LastAccessedAt: lastAccessedAt(file),
+		Metadata:       file.Metadata,
+
+		PublicUntil:            file.PublicUntil,
+		PublicSecondsRemaining: PublicSecondsRemaining(file.PublicUntil),
+	}
+}
+
+// GetFileDownloadURL generates download URL for a file. ttlSeconds asks for a longer
+// lived URL for a private file, 0 for the default.
+func (s *FileService) GetFileDownloadURL(ctx context.Context, userID string, fileID uuid.UUID, ttlSeconds int) (string, error) {
 	var userFile models.UserFile
 
-	query := s.db.Preload("FileData").Where("id = ?", fileID)
+	query := s.db.WithContext(ctx).Preload("FileData").Where("id = ?", fileID)
 
-	// If not the file owner, only allow public files
+	// If not the file owner, only allow public files or files shared with the user
 	if userID != "" {
-		query = query.Where("user_id = ? OR is_public = ?", userID, true)
+		query = query.Where("user_id = ? OR is_public = ? OR id IN (?)", userID, true,
+			s.db.Model(&models.FileAccess{}).Select("user_file_id").Where("grantee_user_id = ? AND revoked_at IS NULL", userID))
 	} else {
 		query = query.Where("is_public = ?", true)
 	}
@@ -222,32 +462,48 @@ func (s *FileService) GetFileDownloadURL(userID string, fileID uuid.UUID) (strin
 		return "", fmt.Errorf("file not found or access denied: %w", err)
 	}
 
-	var downloadURL string
+	// Downloads count against the file owner's monthly bandwidth
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, userFile.FileData.Size); err != nil {
+		return "", err
+	}
 
-	// For public files, return clean public URL; for private files, return presigned URL
+	// Presigned URLs carry the filename. Private files get a short TTL unless the caller
+	// asks for longer (see privateURLTTL); public ones stay usable for an hour, through
+	// the CDN when one is configured.
+	var downloadURL string
 	if userFile.IsPublic {
-		// Return clean public URL (no auth parameters)
-		downloadURL = s.storage.GetPublicFileURL(userFile.FileData.MinIOKey)
+		downloadURL, err = s.publicDownloadURL(ctx, userFile, time.Hour)
 	} else {
-		// Return presigned URL with short TTL for private files (1 minute)
-		downloadURL, err = s.storage.GetFileURL(context.Background(), userFile.FileData.MinIOKey, time.Minute)
-		if err != nil {
-			return "", fmt.Errorf("failed to generate download URL: %w", err)
-		}
+		downloadURL, err = s.presignedDownloadURL(ctx, userFile, s.privateURLTTL(userFile.FileData.MimeType, ttlSeconds))
+	}
+	if err != nil {
+		return "", err
 	}
 
 	// Increment download count
 	go func() {
-		s.db.Model(&userFile).Update("download_count", gorm.Expr("download_count + 1"))
+		s.db.Model(&userFile).Updates(downloadUpdates())
 	}()
 
+	s.recordBandwidth(userFile.UserID, userFile.FileData.Size)
+
+	// Downloads by anyone other than the owner show up in the owner's activity feed
+	if userFile.UserID != userID {
+		s.RecordActivity(models.UserActivity{UserID: userFile.UserID, Action: models.ActivityDownload, FileID: &userFile.ID, Filename: userFile.Filename})
+	}
+
 	return downloadURL, nil
 }
 
 // DeleteUserFile deletes a user's file
-func (s *FileService) DeleteUserFile(userID string, fileID uuid.UUID) error {
-	fmt.Printf("UPDATED DELETION LOGIC: Starting deletion of file %s for user %s\n", fileID, userID)
-	tx := s.db.Begin()
+func (s *FileService) DeleteUserFile(ctx context.Context, userID string, fileID uuid.UUID) error {
+	return s.deleteUserFile(ctx, userID, fileID, false)
+}
+
+// deleteUserFile deletes a user's file, refusing locked files unless overrideLock is set
+func (s *FileService) deleteUserFile(ctx context.Context, userID string, fileID uuid.UUID, overrideLock bool) error {
+	slog.Debug("file_delete_started", slog.String("file_id", fileID.String()), slog.String("user_id", userID))
+	tx := s.db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -260,10 +516,18 @@ func (s *FileService) DeleteUserFile(userID string, fileID uuid.UUID) error {
 	if err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("file not found")
+			return ErrUserFileNotFound
 		}
 		return fmt.Errorf("database error finding file: %w", err)
 	}
+	if userFile.LegalHold {
+		tx.Rollback()
+		return ErrFileUnderLegalHold
+	}
+	if userFile.Locked && !overrideLock {
+		tx.Rollback()
+		return ErrFileLocked
+	}
 
 	// Get file hash record first (before deleting user file)
 	var fileHash models.FileHash
@@ -273,20 +537,21 @@ func (s *FileService) DeleteUserFile(userID string, fileID uuid.UUID) error {
 		return fmt.Errorf("failed to get file hash record: %w", err)
 	}
 
-	// Delete any associated share links first to avoid foreign key constraint violations (hard delete)
-	fmt.Printf("Deleting share links for file: %s\n", fileID)
-	deleteShareLinksResult := tx.Unscoped().Where("user_file_id = ?", fileID).Delete(&models.ShareLink{})
-	if deleteShareLinksResult.Error != nil {
+	// Delete records pointing at the file first to avoid foreign key constraint violations
+	if err := s.deleteFileRelations(tx, fileID); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to delete share links: %w", deleteShareLinksResult.Error)
+		return err
 	}
-	fmt.Printf("Deleted %d share links for file: %s\n", deleteShareLinksResult.RowsAffected, fileID)
 
 	// Delete user file record (hard delete to avoid foreign key issues)
 	if err := tx.Unscoped().Delete(&userFile).Error; err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to delete user file: %w", err)
 	}
+	if err := s.releaseStoredFiles(tx, userID, userFile.FileHash, 1); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Check if there are any other user files still referencing this hash
 	// Use Unscoped to count only non-soft-deleted records, but since we hard deleted above, this should be accurate
@@ -297,34 +562,28 @@ func (s *FileService) DeleteUserFile(userID string, fileID uuid.UUID) error {
 		return fmt.Errorf("failed to count remaining file references: %w", err)
 	}
 
-	fmt.Printf("Remaining references for hash %s: %d\n", userFile.FileHash, remainingRefs)
+	slog.Debug("file_hash_references_remaining", slog.String("hash", userFile.FileHash), slog.Int64("remaining_refs", remainingRefs))
 
 	if remainingRefs == 0 {
-		fmt.Printf("No more references, deleting file hash record for hash: %s\n", userFile.FileHash)
-
 		// Clean up any orphaned soft-deleted records first
-		fmt.Printf("Cleaning up orphaned soft-deleted records for hash: %s\n", userFile.FileHash)
 		cleanupResult := tx.Unscoped().Where("file_hash = ? AND deleted_at IS NOT NULL", userFile.FileHash).Delete(&models.UserFile{})
 		if cleanupResult.Error != nil {
-			fmt.Printf("Warning: failed to cleanup soft-deleted records: %v\n", cleanupResult.Error)
-		} else {
-			fmt.Printf("Cleaned up %d orphaned soft-deleted records\n", cleanupResult.RowsAffected)
-		}
-
-		// No more references, delete from storage and database
-		if err := s.storage.DeleteFile(context.Background(), fileHash.MinIOKey); err != nil {
-			// Log error but don't fail the transaction - storage cleanup can be retried later
-			fmt.Printf("Warning: failed to delete file from storage %s: %v\n", fileHash.MinIOKey, err)
+			slog.Warn("soft_deleted_cleanup_failed", slog.String("hash", userFile.FileHash), slog.Any("error", cleanupResult.Error))
+		} else if cleanupResult.RowsAffected > 0 {
+			slog.Debug("soft_deleted_records_cleaned", slog.String("hash", userFile.FileHash), slog.Int64("count", cleanupResult.RowsAffected))
 		}
 
+		// No more references, delete from database now and from storage after commit
 		if err := tx.Delete(&fileHash).Error; err != nil {
 			tx.Rollback()
-			fmt.Printf("ERROR: Failed to delete file hash record: %v\n", err)
 			return fmt.Errorf("failed to delete file hash record: %w", err)
 		}
-		fmt.Printf("Successfully deleted file hash record for hash: %s\n", userFile.FileHash)
+		if err := s.deletionQueue.EnqueueContentTx(tx, fileHash); err != nil {
+			tx.Rollback()
+			return err
+		}
+		slog.Info("file_hash_deleted", slog.String("hash", userFile.FileHash))
 	} else {
-		fmt.Printf("Still %d references remaining, updating reference count\n", remainingRefs)
 		// Update reference count to match actual count
 		if err := tx.Model(&fileHash).Update("reference_count", remainingRefs).Error; err != nil {
 			tx.Rollback()
@@ -337,56 +596,88 @@ func (s *FileService) DeleteUserFile(userID string, fileID uuid.UUID) error {
 		return fmt.Errorf("failed to commit deletion transaction: %w", err)
 	}
 
+	s.forgetSharedFile(fileID)
+
+	s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityDelete, FileID: &userFile.ID, Filename: userFile.Filename})
+
 	return nil
 }
 
-// ToggleFilePublic toggles public/private status of a file
-func (s *FileService) ToggleFilePublic(userID string, fileID uuid.UUID) error {
+// deleteFileRelations hard-deletes the share links, collection entries and access grants
+// of files about to be deleted, so nothing is left pointing at them. Download events and
+// abuse reports are kept as history.
+func (s *FileService) deleteFileRelations(tx *gorm.DB, fileIDs ...uuid.UUID) error {
+	shareLinks := tx.Unscoped().Where("user_file_id IN ?", fileIDs).Delete(&models.ShareLink{})
+	if shareLinks.Error != nil {
+		return fmt.Errorf("failed to delete share links: %w", shareLinks.Error)
+	}
+	if err := tx.Where("user_file_id IN ?", fileIDs).Delete(&models.FileCollectionItem{}).Error; err != nil {
+		return fmt.Errorf("failed to remove files from collections: %w", err)
+	}
+	grants := tx.Where("user_file_id IN ?", fileIDs).Delete(&models.FileAccess{})
+	if grants.Error != nil {
+		return fmt.Errorf("failed to delete file access grants: %w", grants.Error)
+	}
+
+	slog.Debug("file_relations_deleted", slog.Int("files", len(fileIDs)),
+		slog.Int64("share_links", shareLinks.RowsAffected), slog.Int64("access_grants", grants.RowsAffected))
+	return nil
+}
+
+// ToggleFilePublic toggles public/private status of a file. A non-nil publicUntil makes
+// the file private again at that time; it only applies when making the file public.
+func (s *FileService) ToggleFilePublic(ctx context.Context, userID string, fileID uuid.UUID, publicUntil *time.Time) error {
 	// Get file info with current status
 	var userFile models.UserFile
-	err := s.db.Preload("FileData").Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
-	if err != nil {
-		return fmt.Errorf("file not found: %w", err)
+	err := s.db.WithContext(ctx).Preload("FileData").Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrUserFileNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
 	}
 
 	// Calculate new public status
 	newPublicStatus := !userFile.IsPublic
+	if publicUntil != nil && (!newPublicStatus || !publicUntil.After(time.Now())) {
+		return ErrInvalidPublicUntil
+	}
 
 	// Start transaction for atomic update
-	tx := s.db.Begin()
+	tx := s.db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
 		}
 	}()
 
-	// Update database first
-	err = tx.Model(&userFile).Update("is_public", newPublicStatus).Error
+	// Update database first; bumping the generation cancels any earlier schedule
+	err = tx.Model(&userFile).Updates(map[string]interface{}{
+		"is_public":                  newPublicStatus,
+		"public_until":               publicUntil,
+		"public_schedule_generation": gorm.Expr("public_schedule_generation + 1"),
+	}).Error
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to update database: %w", err)
 	}
 
 	// Update object tags in MinIO
-	ctx := context.Background()
 	if newPublicStatus {
 		// Make public: set tag
 		tags := map[string]string{"public": "true"}
-		fmt.Printf("Setting public tag on object: %s with tags: %v\n", userFile.FileData.MinIOKey, tags)
 		err = s.storage.SetObjectTags(ctx, userFile.FileData.MinIOKey, tags)
 		if err != nil {
-			fmt.Printf("Failed to set tags: %v\n", err)
+			slog.Warn("object_tag_update_failed", slog.String("object_key", userFile.FileData.MinIOKey), slog.Bool("public", true), slog.Any("error", err))
 		} else {
-			fmt.Printf("Successfully set public tag on object: %s\n", userFile.FileData.MinIOKey)
+			slog.Debug("object_tags_set", slog.String("object_key", userFile.FileData.MinIOKey), slog.Any("tags", tags))
 		}
 	} else {
 		// Make private: remove tags
-		fmt.Printf("Removing tags from object: %s\n", userFile.FileData.MinIOKey)
 		err = s.storage.RemoveObjectTags(ctx, userFile.FileData.MinIOKey)
 		if err != nil {
-			fmt.Printf("Failed to remove tags: %v\n", err)
+			slog.Warn("object_tag_update_failed", slog.String("object_key", userFile.FileData.MinIOKey), slog.Bool("public", false), slog.Any("error", err))
 		} else {
-			fmt.Printf("Successfully removed tags from object: %s\n", userFile.FileData.MinIOKey)
+			slog.Debug("object_tags_removed", slog.String("object_key", userFile.FileData.MinIOKey))
 		}
 	}
 
@@ -400,37 +691,113 @@ func (s *FileService) ToggleFilePublic(userID string, fileID uuid.UUID) error {
 	if err := tx.Commit().Error; err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	s.forgetSharedFile(userFile.ID)
+
+	visibility := "private"
+	if newPublicStatus {
+		visibility = "public"
+	}
+	s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityVisibilityChange, FileID: &userFile.ID, Filename: userFile.Filename, Details: visibility})
 
 	return nil
 }
 
+// ErrInvalidPublicFileID is returned when a public file ID is malformed
+var ErrInvalidPublicFileID = errors.New("invalid file ID")
+
+// FindPublicFile looks up public file info by file UUID or, when public info requires
+// proof of possession, by share ID
+func (s *FileService) FindPublicFile(id string) (*PublicFileResponse, error) {
+	if s.cfg.PublicInfoRequiresShareID {
+		return s.GetPublicFileInfoByShareID(id)
+	}
+
+	fileID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidPublicFileID
+	}
+	return s.GetPublicFileInfo(fileID)
+}
+
 // GetPublicFileInfo gets public file info for sharing
 func (s *FileService) GetPublicFileInfo(fileID uuid.UUID) (*PublicFileResponse, error) {
-	var userFile models.UserFile
+	info, err := s.publicFiles.getOrLoad(fileID.String(), func() (PublicFileResponse, error) {
+		info, err := s.lookupPublicFile(func(db *gorm.DB) *gorm.DB {
+			return db.Where("user_files.id = ?", fileID)
+		})
+		if err != nil {
+			return PublicFileResponse{}, err
+		}
+		return *info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
 
-	err := s.db.Preload("FileData").Preload("User").
-		Where("id = ? AND is_public = ?", fileID, true).
-		First(&userFile).Error
+// GetPublicFileInfoByShareID returns public file info for callers that hold a share link
+func (s *FileService) GetPublicFileInfoByShareID(shareID string) (*PublicFileResponse, error) {
+	return s.lookupPublicFile(func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN share_links ON share_links.user_file_id = user_files.id AND share_links.deleted_at IS NULL").
+			Where("share_links.id = ?", shareID)
+	})
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("public file not found: %w", err)
+// lookupPublicFile runs the same single query whether the file is missing or private,
+// so response timing doesn't reveal which one it was
+func (s *FileService) lookupPublicFile(scope func(db *gorm.DB) *gorm.DB) (*PublicFileResponse, error) {
+	var info PublicFileResponse
+	result := s.db.Model(&models.UserFile{}).
+		Select("user_files.id, user_files.filename, file_hashes.size, file_hashes.mime_type").
+		Joins("JOIN file_hashes ON file_hashes.hash = user_files.file_hash").
+		Scopes(scope).
+		Where("user_files.is_public = ?", true).
+		Limit(1).
+		Scan(&info)
+	if result.Error != nil {
+		return nil, fmt.Errorf("public file not found: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("public file not found: %w", gorm.ErrRecordNotFound)
 	}
 
-	return &PublicFileResponse{
-		ID:       fileID,
-		Filename: userFile.Filename,
-		Size:     userFile.FileData.Size,
-		MimeType: userFile.FileData.MimeType,
-	}, nil
+	return &info, nil
 }
 
 // Response types
 type PresignedUploadResponse struct {
 	UploadURL    string           `json:"upload_url"`
-	ObjectKey    string           `json:"object_key"`
+	ObjectKey    string           `json:"object_key,omitempty"`   // Server hash mode only
+	UploadToken  string           `json:"upload_token,omitempty"` // Client hash mode; pass back on completion
 	ExpiresAt    time.Time        `json:"expires_at"`
 	IsDuplicate  bool             `json:"is_duplicate"`
 	ExistingFile *models.UserFile `json:"existing_file,omitempty"`
+	Dedup        *DedupStats      `json:"dedup,omitempty"`
+	HashMode     HashMode         `json:"hash_mode"`
+	UploadID     *uuid.UUID       `json:"upload_id,omitempty"` // Set in server hash mode; pass back on completion
+
+	// Skipped is set under dedup_behavior "skip" when ExistingFile is the user's own
+	// earlier upload rather than a new entry
+	Skipped bool `json:"skipped,omitempty"`
+	// ExistingFiles lists the user's files with this content under dedup_behavior "ask"
+	ExistingFiles []UserFileResponse `json:"existing_files,omitempty"`
+}
+
+// DedupScope tells whether a duplicate matched the user's own files or another user's
+type DedupScope string
+
+const (
+	DedupScopeOwn       DedupScope = "own"
+	DedupScopeCrossUser DedupScope = "cross_user"
+)
+
+// DedupStats describes the storage saved by linking an upload to existing content.
+// It never identifies the other users holding the same content.
+type DedupStats struct {
+	BytesSaved     int64      `json:"bytes_saved"`
+	ReferenceCount int        `json:"reference_count"`
+	Scope          DedupScope `json:"scope"`
 }
 
 type UserFileResponse struct {
@@ -440,7 +807,22 @@ type UserFileResponse struct {
 	MimeType      string    `json:"mime_type"`
 	IsPublic      bool      `json:"is_public"`
 	DownloadCount int       `json:"download_count"`
+	RelativePath  string    `json:"relative_path,omitempty"`
 	UploadedAt    time.Time `json:"uploaded_at"`
+	Locked        bool      `json:"locked"`
+
+	// The public file's share link ID, in the owner's file listings
+	ShareID *string `json:"share_id,omitempty"`
+
+	// When the file was last downloaded or streamed, to within an hour; unset if never
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+
+	// Custom key/value metadata, only shown to the owner
+	Metadata models.FileMetadata `json:"metadata,omitempty"`
+
+	// Set while the file is public until a scheduled time
+	PublicUntil            *time.Time `json:"public_until,omitempty"`
+	PublicSecondsRemaining *int64     `json:"public_seconds_remaining,omitempty"`
 }
 
 type PublicFileResponse struct {
@@ -456,140 +838,190 @@ type BatchFileRequest struct {
 	Size     int64  `json:"size"`
 	MimeType string `json:"mime_type"`
 	FileHash string `json:"file_hash"`
+	// SecondaryHash is the BLAKE2b-256 of the content, required to link existing content
+	SecondaryHash string `json:"secondary_hash,omitempty"`
+	// RelativePath is the file's location within a synced folder, kept on the file
+	RelativePath string `json:"relative_path,omitempty"`
+	// IsPublic overrides the user's default visibility when set
+	IsPublic *bool `json:"is_public,omitempty"`
+	// DedupBehavior decides what happens when the user already has this content
+	DedupBehavior DedupBehavior `json:"dedup_behavior,omitempty"`
 }
 
+// BatchFileResponse is one prepared file. Both "upload_required" and "duplicate" files
+// carry an upload token and only become files once completed; duplicates just skip
+// the upload.
 type BatchFileResponse struct {
 	FileHash     string      `json:"file_hash"`
-	Status       string      `json:"status"` // "upload_required", "duplicate", "quota_exceeded"
+	Status       string      `json:"status"` // "upload_required", "duplicate", "skipped", "conflict", "quota_exceeded", "banned"
 	UploadID     string      `json:"upload_id,omitempty"`
+	UploadToken  string      `json:"upload_token,omitempty"`
 	PresignedURL string      `json:"presigned_url,omitempty"`
 	ExistingFile interface{} `json:"existing_file,omitempty"`
-	Error        string      `json:"error,omitempty"`
+	// The user's files with this content, for "conflict"
+	ExistingFiles []UserFileResponse `json:"existing_files,omitempty"`
+	Dedup         *DedupStats        `json:"dedup,omitempty"`
+	Error         string             `json:"error,omitempty"`
 }
 
 type BatchPrepareResponse struct {
 	BatchID    string              `json:"batch_id"`
+	ExpiresAt  time.Time           `json:"expires_at"`
 	Files      []BatchFileResponse `json:"files"`
 	QuotaCheck BatchQuotaCheck     `json:"quota_check"`
 }
 
 type BatchQuotaCheck struct {
 	TotalSizeRequired int64 `json:"total_size_required"`
+	UserQuotaBytes    int64 `json:"user_quota_bytes"`
+	StorageUsedBytes  int64 `json:"storage_used_bytes"`
+	ReservedBytes     int64 `json:"reserved_bytes"` // Held for earlier pages of the batch that haven't completed, included in storage_used_bytes
 	QuotaAvailable    bool  `json:"quota_available"`
 	QuotaExceeded     int64 `json:"quota_exceeded,omitempty"`
+	// New files the user can still add under their file count quota after this page
+	FilesRemaining int64 `json:"files_remaining"`
 }
 
 type BatchCompletedUpload struct {
-	UploadID string `json:"upload_id"`
-	FileHash string `json:"file_hash"`
-	Filename string `json:"filename"`
-	MimeType string `json:"mime_type"`
+	UploadToken string `json:"upload_token"`
+	Filename    string `json:"filename"`
+	MimeType    string `json:"mime_type"`
+	// IsPublic overrides the visibility chosen when the batch was prepared
+	IsPublic *bool `json:"is_public,omitempty"`
+}
+
+// BatchCompletedFileResult describes one file stored by a batch completion
+type BatchCompletedFileResult struct {
+	ID         uuid.UUID   `json:"id"`
+	Filename   string      `json:"filename"`
+	Size       int64       `json:"size"`
+	MimeType   string      `json:"mime_type"`
+	IsPublic   bool        `json:"is_public"`
+	UploadedAt time.Time   `json:"uploaded_at"`
+	ShareLink  string      `json:"share_link,omitempty"`
+	Dedup      *DedupStats `json:"dedup,omitempty"`
 }
 
 type BatchCompleteResponse struct {
-	BatchID        string        `json:"batch_id"`
-	CompletedFiles []interface{} `json:"completed_files"`
-	Errors         []string      `json:"errors,omitempty"`
+	BatchID        string                     `json:"batch_id"`
+	CompletedFiles []BatchCompletedFileResult `json:"completed_files"`
+	Errors         []string                   `json:"errors,omitempty"`
 }
 
-// BatchPrepareUpload prepares multiple files for upload
-func (s *FileService) BatchPrepareUpload(userID string, files []BatchFileRequest) (*BatchPrepareResponse, error) {
-	batchID := uuid.New().String()
+// BatchPrepareUpload prepares multiple files for upload. An empty batchID starts a
+// new batch session; passing a previous batch_id continues it, so sync clients can
+// prepare a large tree page by page while quota reserved by earlier pages that
+// haven't completed yet still counts.
+func (s *FileService) BatchPrepareUpload(ctx context.Context, userID, batchID string, files []BatchFileRequest) (*BatchPrepareResponse, error) {
+	for i := range files {
+		relativePath, err := normalizeRelativePath(files[i].RelativePath)
+		if err != nil {
+			return nil, err
+		}
+		files[i].RelativePath = relativePath
+	}
 
-	// Calculate total size needed for new uploads
-	var totalSizeRequired int64
-	var duplicateHashes []string
+	session, err := s.openBatchSession(ctx, userID, batchID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Check for duplicates first
-	fileHashes := make([]string, len(files))
+	checkFiles := make([]QuotaCheckFile, len(files))
 	for i, file := range files {
-		fileHashes[i] = file.FileHash
+		checkFiles[i] = QuotaCheckFile{Size: file.Size, FileHash: file.FileHash}
 	}
 
-	var existingHashes []models.FileHash
-	s.db.Where("hash IN ?", fileHashes).Find(&existingHashes)
-
-	existingHashMap := make(map[string]models.FileHash)
-	for _, hash := range existingHashes {
-		existingHashMap[hash.Hash] = hash
-		duplicateHashes = append(duplicateHashes, hash.Hash)
+	plan, err := s.CheckUploadQuota(ctx, userID, checkFiles)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate size for non-duplicates
+	existingHashMap := plan.existingHashes
+
+	var ownCopyHashes []string
 	for _, file := range files {
-		if _, isDuplicate := existingHashMap[file.FileHash]; !isDuplicate {
-			totalSizeRequired += file.Size
+		if file.DedupBehavior.checksOwnCopies() {
+			ownCopyHashes = append(ownCopyHashes, file.FileHash)
 		}
 	}
-
-	// Check quota for new uploads only
-	quotaAvailable := true
+	ownCopies, err := s.ownCopies(ctx, userID, ownCopyHashes)
+	if err != nil {
+		return nil, err
+	}
+	totalSizeRequired := plan.RequiredBytes
+	// Reservations of this and other batches are already part of the usage
+	quotaAvailable := plan.Fits
 	var quotaExceeded int64
-	if totalSizeRequired > 0 {
-		// Get current storage usage
-		var currentUsage int64
-		s.db.Model(&models.FileHash{}).
-			Joins("JOIN user_files ON file_hashes.hash = user_files.file_hash").
-			Where("user_files.user_id = ?", userID).
-			Select("COALESCE(SUM(file_hashes.size), 0)").
-			Scan(&currentUsage)
+	if !quotaAvailable {
+		quotaExceeded = plan.RequiredBytes - plan.AvailableBytes
+	}
 
-		const maxStorage = 10 * 1024 * 1024 * 1024 // 10GB
-		if currentUsage+totalSizeRequired > maxStorage {
-			quotaAvailable = false
-			quotaExceeded = (currentUsage + totalSizeRequired) - maxStorage
-		}
+	// Files prepared in earlier pages of the batch will count once they're completed
+	filesUsed, fileCountQuota, err := s.userService.GetFileCountInfo(userID)
+	if err != nil {
+		return nil, err
+	}
+	var pendingFiles int64
+	if err := s.db.WithContext(ctx).Model(&models.BatchUploadFile{}).Where("batch_id = ?", session.ID).Count(&pendingFiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to count pending batch files: %w", err)
 	}
+	filesRemaining := max(int64(fileCountQuota)-filesUsed-pendingFiles, 0)
 
 	// Prepare response for each file
-	var fileResponses []BatchFileResponse
+	fileResponses := make([]BatchFileResponse, 0, len(files))
+	var pending []models.BatchUploadFile
+	prepared := 0
 
-	for _, file := range files {
-		if existingHash, isDuplicate := existingHashMap[file.FileHash]; isDuplicate {
-			// File is duplicate - create UserFile record
-			userFile := models.UserFile{
-				ID:         uuid.New(),
-				UserID:     userID,
-				FileHash:   file.FileHash,
-				Filename:   file.Filename,
-				IsPublic:   false,
-				UploadedAt: time.Now().UTC(),
-				UpdatedAt:  time.Now().UTC(),
-			}
-
-			// Create UserFile record in transaction
-			tx := s.db.Begin()
-			if err := tx.Create(&userFile).Error; err != nil {
-				tx.Rollback()
+	for i, file := range files {
+		if plan.Files[i].Status == QuotaCheckBanned {
+			fileResponses = append(fileResponses, BatchFileResponse{
+				FileHash: file.FileHash,
+				Status:   "banned",
+				Error:    ErrHashBanned.Error(),
+			})
+		} else if copies := ownCopies[file.FileHash]; len(copies) > 0 && file.DedupBehavior.checksOwnCopies() {
+			if file.DedupBehavior == DedupSkip {
 				fileResponses = append(fileResponses, BatchFileResponse{
-					FileHash: file.FileHash,
-					Status:   "error",
-					Error:    "Failed to link duplicate file",
+					FileHash:     file.FileHash,
+					Status:       "skipped",
+					ExistingFile: toUserFileResponse(copies[0]),
 				})
-				continue
-			}
-
-			// Increment reference count
-			if err := tx.Model(&existingHash).Update("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
-				tx.Rollback()
+			} else {
 				fileResponses = append(fileResponses, BatchFileResponse{
-					FileHash: file.FileHash,
-					Status:   "error",
-					Error:    "Failed to update reference count",
+					FileHash:      file.FileHash,
+					Status:        "conflict",
+					ExistingFiles: toUserFileResponses(copies),
 				})
-				continue
 			}
-
-			tx.Commit()
-
+		} else if filesRemaining == 0 {
 			fileResponses = append(fileResponses, BatchFileResponse{
 				FileHash: file.FileHash,
-				Status:   "duplicate",
-				ExistingFile: map[string]interface{}{
-					"id":       userFile.ID,
-					"filename": file.Filename,
-					"size":     existingHash.Size,
-				},
+				Status:   "quota_exceeded",
+				Error:    "File count quota would be exceeded",
+			})
+		} else if existingHash, isDuplicate := existingHashMap[file.FileHash]; isDuplicate && s.canLinkExisting(existingHash, file.SecondaryHash) &&
+			(plan.Files[i].charge == 0 || quotaAvailable) {
+			// Content is already stored; the file is linked to it when the client
+			// completes it, so an abandoned batch leaves no files behind. What the
+			// link adds to the user's usage is reserved until then.
+			uploadID := uuid.New().String()
+			objectKey := s.storage.StagingKey(userID, uploadID)
+
+			pending = append(pending, models.BatchUploadFile{
+				UploadID:     uploadID,
+				BatchID:      session.ID,
+				FileHash:     file.FileHash,
+				Size:         plan.Files[i].charge,
+				RelativePath: file.RelativePath,
+				Link:         true,
+			})
+			prepared++
+			filesRemaining--
+			fileResponses = append(fileResponses, BatchFileResponse{
+				FileHash:    file.FileHash,
+				Status:      "duplicate",
+				UploadID:    uploadID,
+				UploadToken: s.issueLinkToken(userID, objectKey, file.FileHash, UploadOptions{IsPublic: file.IsPublic}),
 			})
 		} else if !quotaAvailable {
 			// Quota exceeded
@@ -601,9 +1033,9 @@ func (s *FileService) BatchPrepareUpload(userID string, files []BatchFileRequest
 		} else {
 			// Generate upload URL
 			uploadID := uuid.New().String()
-			objectKey := fmt.Sprintf("uploads/%s/%s", userID, uploadID)
+			objectKey := s.storage.StagingKey(userID, uploadID)
 
-			presignedURL, err := s.storage.GetUploadURL(context.Background(), objectKey, 15*time.Minute)
+			presignedURL, err := s.storage.GetUploadURL(ctx, objectKey, batchUploadURLExpiry)
 			if err != nil {
 				fileResponses = append(fileResponses, BatchFileResponse{
 					FileHash: file.FileHash,
@@ -613,46 +1045,106 @@ func (s *FileService) BatchPrepareUpload(userID string, files []BatchFileRequest
 				continue
 			}
 
+			pending = append(pending, models.BatchUploadFile{
+				UploadID:     uploadID,
+				BatchID:      session.ID,
+				FileHash:     file.FileHash,
+				Size:         file.Size,
+				RelativePath: file.RelativePath,
+			})
+			prepared++
+			filesRemaining--
 			fileResponses = append(fileResponses, BatchFileResponse{
 				FileHash:     file.FileHash,
 				Status:       "upload_required",
 				UploadID:     uploadID,
+				UploadToken:  s.issueUploadToken(userID, objectKey, file.FileHash, file.Size, UploadOptions{IsPublic: file.IsPublic}),
 				PresignedURL: presignedURL,
 			})
 		}
 	}
 
+	if err := s.recordBatchPrepared(ctx, session, pending, prepared); err != nil {
+		return nil, err
+	}
+
 	return &BatchPrepareResponse{
-		BatchID: batchID,
-		Files:   fileResponses,
+		BatchID:   session.ID.String(),
+		ExpiresAt: s.dbNow().Add(batchSessionTTL),
+		Files:     fileResponses,
 		QuotaCheck: BatchQuotaCheck{
 			TotalSizeRequired: totalSizeRequired,
+			UserQuotaBytes:    plan.QuotaBytes,
+			StorageUsedBytes:  plan.UsedBytes,
+			ReservedBytes:     session.ReservedBytes,
 			QuotaAvailable:    quotaAvailable,
 			QuotaExceeded:     quotaExceeded,
+			FilesRemaining:    filesRemaining,
 		},
 	}, nil
 }
 
-// BatchCompleteUpload completes multiple file uploads
-func (s *FileService) BatchCompleteUpload(userID, batchID string, completedUploads []BatchCompletedUpload) (*BatchCompleteResponse, error) {
-	var completedFiles []interface{}
+// BatchCompleteUpload completes multiple file uploads prepared in the batch session,
+// linking duplicates to their stored content
+func (s *FileService) BatchCompleteUpload(ctx context.Context, userID, batchID string, completedUploads []BatchCompletedUpload) (*BatchCompleteResponse, error) {
+	session, err := s.getBatchSession(ctx, userID, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	completedFiles := make([]BatchCompletedFileResult, 0, len(completedUploads)) // Serializes as [] when nothing completed
 	var errors []string
 
 	for _, upload := range completedUploads {
-		objectKey := fmt.Sprintf("uploads/%s/%s", userID, upload.UploadID)
+		claims, err := s.verifyUploadToken(userID, upload.UploadToken)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to complete upload for %s: %v", upload.Filename, err))
+			continue
+		}
+
+		uploadID := strings.TrimPrefix(claims.ObjectKey, s.storage.StagingKey(userID, ""))
+		var batchFile models.BatchUploadFile
+		if err := s.db.WithContext(ctx).Where("upload_id = ? AND batch_id = ?", uploadID, session.ID).First(&batchFile).Error; err != nil {
+			errors = append(errors, fmt.Sprintf("Upload %s for %s is not part of this batch", uploadID, upload.Filename))
+			continue
+		}
 
 		// Complete individual file upload
-		userFile, err := s.CompleteFileUpload(userID, objectKey, upload.Filename, upload.MimeType, upload.FileHash)
+		claims.applyOverrides(UploadOptions{IsPublic: upload.IsPublic})
+		userFile, dedup, err := s.completeUploadOnce(ctx, userID, claims, upload.Filename, upload.MimeType)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("Failed to complete upload for %s: %v", upload.Filename, err))
 			continue
 		}
 
-		completedFiles = append(completedFiles, map[string]interface{}{
-			"id":       userFile.ID,
-			"filename": userFile.Filename,
-			"size":     userFile.FileHash,
-		})
+		if batchFile.RelativePath != "" {
+			if err := s.db.WithContext(ctx).Model(userFile).Update("relative_path", batchFile.RelativePath).Error; err != nil {
+				log.Printf("Failed to set relative path for file %s: %v", userFile.ID, err)
+			}
+		}
+		if err := s.recordBatchCompleted(ctx, session, batchFile); err != nil {
+			log.Printf("Failed to update batch session %s: %v", session.ID, err)
+		}
+
+		// The file record doesn't carry its content's size and type
+		var fileHash models.FileHash
+		if err := s.db.WithContext(ctx).Select("size", "mime_type").Where("hash = ?", userFile.FileHash).First(&fileHash).Error; err != nil {
+			log.Printf("Failed to load content details for file %s: %v", userFile.ID, err)
+		}
+
+		completed := BatchCompletedFileResult{
+			ID:         userFile.ID,
+			Filename:   userFile.Filename,
+			Size:       fileHash.Size,
+			MimeType:   fileHash.MimeType,
+			IsPublic:   userFile.IsPublic,
+			UploadedAt: userFile.UploadedAt,
+			Dedup:      dedup,
+		}
+		if shareID := s.ShareIDFor(*userFile); shareID != "" {
+			completed.ShareLink = "/share/" + shareID
+		}
+		completedFiles = append(completedFiles, completed)
 	}
 
 	return &BatchCompleteResponse{
@@ -662,6 +1154,45 @@ func (s *FileService) BatchCompleteUpload(userID, batchID string, completedUploa
 	}, nil
 }
 
+// ShareIDFor returns a public file's share link ID, or "" for private files and on
+// lookup failure
+func (s *FileService) ShareIDFor(userFile models.UserFile) string {
+	if !userFile.IsPublic {
+		return ""
+	}
+	var shareLink models.ShareLink
+	if err := s.db.Select("id").Where("user_file_id = ?", userFile.ID).First(&shareLink).Error; err != nil {
+		return ""
+	}
+	return shareLink.ID
+}
+
+// MaxShareLinkLookup is the most files LookupShareIDs accepts in one call
+const MaxShareLinkLookup = 200
+
+// LookupShareIDs returns the share link ID of each of the user's files, nil for files
+// without one. Files that don't exist or belong to someone else are left out.
+func (s *FileService) LookupShareIDs(userID string, fileIDs []uuid.UUID) (map[uuid.UUID]*string, error) {
+	var rows []struct {
+		ID      uuid.UUID
+		ShareID *string
+	}
+	err := s.db.Model(&models.UserFile{}).
+		Scopes(joinShareLinks).
+		Select("user_files.id, share_link.id AS share_id").
+		Where("user_files.user_id = ? AND user_files.id IN ?", userID, fileIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up share links: %w", err)
+	}
+
+	shareIDs := make(map[uuid.UUID]*string, len(rows))
+	for _, row := range rows {
+		shareIDs[row.ID] = row.ShareID
+	}
+	return shareIDs, nil
+}
+
 // CreateOrGetShareLink creates or retrieves a share link for a public file
 func (s *FileService) CreateOrGetShareLink(userID string, fileID uuid.UUID) (string, error) {
 	// First verify the file exists and is public
@@ -671,35 +1202,14 @@ func (s *FileService) CreateOrGetShareLink(userID string, fileID uuid.UUID) (str
 		return "", fmt.Errorf("file not found or not public: %w", err)
 	}
 
-	// Check if share link already exists
-	var shareLink models.ShareLink
-	err = s.db.Where("user_file_id = ?", fileID).First(&shareLink).Error
-	if err == nil {
-		// Share link exists, return it
-		return shareLink.ID, nil
-	} else if err != gorm.ErrRecordNotFound {
-		return "", fmt.Errorf("failed to check existing share link: %w", err)
+	shareID, created, err := ensureShareLink(s.db, fileID)
+	if err != nil {
+		return "", err
 	}
-
-	// Create new share link
-	shareLink = models.ShareLink{
-		UserFileID: fileID,
+	if created {
+		s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityShare, FileID: &userFile.ID, Filename: userFile.Filename, ShareID: shareID})
 	}
-
-	// Generate unique ID (retry if collision)
-	for attempts := 0; attempts < 10; attempts++ {
-		shareLink.ID = models.GenerateRandomID(8)
-		err = s.db.Create(&shareLink).Error
-		if err == nil {
-			return shareLink.ID, nil
-		}
-		// If it's a unique constraint error, try again with new ID
-		if attempts == 9 {
-			return "", fmt.Errorf("failed to create share link after retries: %w", err)
-		}
-	}
-
-	return "", fmt.Errorf("failed to create share link")
+	return shareID, nil
 }
 
 // DeleteShareLink removes a share link when file becomes private
@@ -716,34 +1226,248 @@ func (s *FileService) DeleteShareLink(userID string, fileID uuid.UUID) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete share link: %w", err)
 	}
+	s.forgetSharedFile(fileID)
 
 	return nil
 }
 
-// GetFileByShareID retrieves file info by share link ID and increments download count
-func (s *FileService) GetFileByShareID(shareID string) (*models.UserFile, error) {
-	var shareLink models.ShareLink
-	err := s.db.Preload("UserFile").Preload("UserFile.FileData").Where("id = ?", shareID).First(&shareLink).Error
+// GetSharedFile retrieves file info by share link ID without recording a download, so
+// conditional and HEAD requests can be answered cheaply
+func (s *FileService) GetSharedFile(shareID string) (*models.UserFile, error) {
+	userFile, err := s.sharedFiles.getOrLoad(shareID, func() (models.UserFile, error) {
+		var shareLink models.ShareLink
+		err := s.db.Preload("UserFile").Preload("UserFile.FileData").Where("id = ?", shareID).First(&shareLink).Error
+		if err == gorm.ErrRecordNotFound {
+			return models.UserFile{}, ErrShareLinkNotFound
+		} else if err != nil {
+			return models.UserFile{}, fmt.Errorf("failed to get share link: %w", err)
+		}
+		if !stillShared(shareLink.UserFile) {
+			return models.UserFile{}, ErrShareLinkNotFound
+		}
+		return shareLink.UserFile, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("share link not found: %w", err)
+		return nil, err
 	}
 
-	// Verify the file is still public
-	if !shareLink.UserFile.IsPublic {
-		return nil, fmt.Errorf("file is no longer public")
+	// Checked again for cached files whose public window has passed since
+	if !stillShared(userFile) {
+		return nil, ErrShareLinkNotFound
 	}
+	return &userFile, nil
+}
 
-	// Increment download count
-	err = s.db.Model(&shareLink.UserFile).Update("download_count", gorm.Expr("download_count + 1")).Error
+// stillShared reports whether a shared file is public and its public window, if it has
+// one, hasn't passed. The expiry worker makes such files private within a minute.
+func stillShared(userFile models.UserFile) bool {
+	return userFile.IsPublic && (userFile.PublicUntil == nil || time.Now().Before(*userFile.PublicUntil))
+}
+
+// forgetSharedFile drops a file's cached share link and public info lookups
+func (s *FileService) forgetSharedFile(fileID uuid.UUID) {
+	s.sharedFiles.deleteFunc(func(userFile models.UserFile) bool {
+		return userFile.ID == fileID
+	})
+	s.publicFiles.delete(fileID.String())
+}
+
+// RecordShareDownload charges a share link download to the owner's bandwidth and
+// increments the file's download count
+func (s *FileService) RecordShareDownload(userFile *models.UserFile, shareID string) error {
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, userFile.FileData.Size); err != nil {
+		return err
+	}
+
+	// Counted in the background so the redirect isn't held up; failures don't fail the download
+	fileID := userFile.ID
+	go func() {
+		if err := s.db.Model(&models.UserFile{ID: fileID}).Updates(downloadUpdates()).Error; err != nil {
+			slog.Warn("download_count_update_failed", slog.String("file_id", fileID.String()), slog.Any("error", err))
+		}
+	}()
+
+	s.recordBandwidth(userFile.UserID, userFile.FileData.Size)
+
+	s.RecordActivity(models.UserActivity{
+		UserID:   userFile.UserID,
+		Action:   models.ActivityDownload,
+		FileID:   &userFile.ID,
+		Filename: userFile.Filename,
+		ShareID:  shareID,
+	})
+
+	return nil
+}
+
+// GetSharedFileURL returns the URL a share link redirects to. It outlives the cached
+// redirect so a CDN never serves a redirect to an expired URL.
+func (s *FileService) GetSharedFileURL(ctx context.Context, userFile *models.UserFile) (string, error) {
+	expiry := time.Duration(s.cfg.PublicCacheMaxAgeSeconds)*time.Second + time.Hour
+	return s.publicDownloadURL(ctx, *userFile, expiry)
+}
+
+// publicDownloadURL serves a public file from the CDN when one fronts the bucket, so
+// direct and share link downloads agree, and from MinIO otherwise. Either way the URL
+// is presigned with the given expiry, so it stops working after the file is made
+// private and can't be reused to skip download tickets and bandwidth accounting.
+func (s *FileService) publicDownloadURL(ctx context.Context, userFile models.UserFile, expiry time.Duration) (string, error) {
+	if s.cfg.CDNBaseURL == "" {
+		return s.presignedDownloadURL(ctx, userFile, expiry)
+	}
+
+	downloadURL, err := s.storage.GetCDNFileURL(ctx, s.resolveObjectKey(ctx, userFile.FileData), expiry, downloadHeaders(userFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate download URL: %w", err)
+	}
+	return downloadURL, nil
+}
+
+// privateURLTTL returns how long a private download URL stays valid: the requested
+// number of seconds capped at the configured maximum, or else the default, which is
+// longer for audio and video so players can buffer and seek past it. The cap applies
+// before converting, so no request can overflow the duration.
+func (s *FileService) privateURLTTL(mimeType string, requestedSeconds int) time.Duration {
+	if requestedSeconds > 0 {
+		return time.Duration(min(requestedSeconds, s.cfg.PrivateURLMaxTTLSeconds)) * time.Second
+	}
+	if strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/") {
+		return time.Duration(s.cfg.MediaURLTTLSeconds) * time.Second
+	}
+	return time.Duration(s.cfg.PrivateURLTTLSeconds) * time.Second
+}
+
+// presignedDownloadURL presigns a download that saves under the user's filename with
+// the stored MIME type, or one derived from the filename when that is generic
+func (s *FileService) presignedDownloadURL(ctx context.Context, userFile models.UserFile, expiry time.Duration) (string, error) {
+	downloadURL, err := s.storage.GetFileURL(ctx, s.resolveObjectKey(ctx, userFile.FileData), expiry, downloadHeaders(userFile))
 	if err != nil {
-		// Log error but don't fail the download
-		fmt.Printf("Warning: failed to increment download count: %v\n", err)
+		return "", fmt.Errorf("failed to generate download URL: %w", err)
 	}
+	return downloadURL, nil
+}
 
-	return &shareLink.UserFile, nil
+func downloadHeaders(userFile models.UserFile) storage.DownloadHeaders {
+	return storage.DownloadHeaders{
+		ContentDisposition: storage.ContentDisposition("attachment", userFile.Filename),
+		ContentType:        storage.ContentType(userFile.FileData.MimeType, userFile.Filename),
+	}
 }
 
-// GetPublicFileURL returns the public URL for a file
-func (s *FileService) GetPublicFileURL(minioKey string) string {
-	return s.storage.GetPublicFileURL(minioKey)
+// OrphanReport lists storage objects that no longer belong to any file
+type OrphanReport struct {
+	OrphanedObjects []OrphanedObject        `json:"orphaned_objects"`
+	FailedDeletions []models.FailedDeletion `json:"failed_deletions"`
+}
+
+type OrphanedObject struct {
+	ObjectKey    string    `json:"object_key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// FindOrphanedObjects lists bucket objects without a FileHash record, together with
+// deletions that the background queue gave up on
+func (s *FileService) FindOrphanedObjects(ctx context.Context) (*OrphanReport, error) {
+	var knownKeys []string
+	if err := s.db.WithContext(ctx).Model(&models.FileHash{}).Pluck("min_io_key", &knownKeys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load file hash keys: %w", err)
+	}
+	var thumbnailKeys []string
+	if err := s.db.WithContext(ctx).Model(&models.FileHash{}).Where("thumbnail_key <> ''").Pluck("thumbnail_key", &thumbnailKeys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load thumbnail keys: %w", err)
+	}
+	knownKeys = append(knownKeys, thumbnailKeys...)
+
+	known := make(map[string]struct{}, len(knownKeys))
+	for _, key := range knownKeys {
+		known[key] = struct{}{}
+	}
+
+	report := &OrphanReport{
+		OrphanedObjects: make([]OrphanedObject, 0),
+		FailedDeletions: make([]models.FailedDeletion, 0),
+	}
+
+	// Objects are checked as they're listed so large buckets aren't held in memory
+	err := s.storage.ListFilesWithCallback(ctx, "", func(object minio.ObjectInfo) error {
+		// Staged uploads are cleaned up by the sweepers and the staging expiry rule
+		if s.storage.IsStagingKey(object.Key) {
+			return nil
+		}
+		if _, ok := known[object.Key]; !ok {
+			report.OrphanedObjects = append(report.OrphanedObjects, OrphanedObject{
+				ObjectKey:    object.Key,
+				Size:         object.Size,
+				LastModified: object.LastModified,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage objects: %w", err)
+	}
+
+	if err := s.db.WithContext(ctx).Order("created_at DESC").Find(&report.FailedDeletions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load failed deletions: %w", err)
+	}
+
+	return report, nil
+}
+
+// dedupStatsFor computes the savings of linking userID to existing content. It must run
+// before the new UserFile is created so the scope reflects the user's prior files.
+func dedupStatsFor(tx *gorm.DB, userID string, fileHash models.FileHash) (*DedupStats, error) {
+	var ownCopies int64
+	err := tx.Model(&models.UserFile{}).
+		Where("user_id = ? AND file_hash = ?", userID, fileHash.Hash).
+		Count(&ownCopies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	scope := DedupScopeCrossUser
+	if ownCopies > 0 {
+		scope = DedupScopeOwn
+	}
+
+	return &DedupStats{
+		BytesSaved:     fileHash.Size,
+		ReferenceCount: fileHash.ReferenceCount + 1,
+		Scope:          scope,
+	}, nil
+}
+
+// recordBandwidth charges served bytes to the file owner in the background
+func (s *FileService) recordBandwidth(ownerID string, bytes int64) {
+	go func() {
+		if err := s.userService.RecordBandwidthUsage(ownerID, bytes); err != nil {
+			slog.Warn("bandwidth_record_failed", slog.String("user_id", ownerID), slog.Int64("bytes", bytes), slog.Any("error", err))
+		}
+	}()
+}
+
+// RecordProxiedBytes counts bytes the server streamed for one of the owner's files, in
+// the background
+func (s *FileService) RecordProxiedBytes(ownerID string, bytes int64) {
+	go func() {
+		if err := s.userService.RecordProxiedBytes(ownerID, bytes); err != nil {
+			slog.Warn("proxied_bandwidth_record_failed", slog.String("user_id", ownerID), slog.Int64("bytes", bytes), slog.Any("error", err))
+		}
+	}()
+}
+
+// RecordActivity appends an entry to the user's activity feed in the background so
+// feed bookkeeping never slows down or fails the operation being recorded
+func (s *FileService) RecordActivity(activity models.UserActivity) {
+	if activity.ID == uuid.Nil {
+		activity.ID = uuid.New()
+	}
+	activity.CreatedAt = time.Now().UTC()
+
+	go func() {
+		if err := s.db.Create(&activity).Error; err != nil {
+			slog.Warn("activity_record_failed", slog.String("user_id", activity.UserID), slog.String("action", string(activity.Action)), slog.Any("error", err))
+		}
+	}()
 }
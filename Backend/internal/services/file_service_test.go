@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// createVault gives a new user count files and returns the user's ID and file IDs
+func createVault(t testing.TB, tx *gorm.DB, count int) (string, []uuid.UUID) {
+	t.Helper()
+
+	userID := "vault-user-" + uuid.New().String()
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 10, MimeType: "text/plain"}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	files := make([]models.UserFile, count)
+	for i := range files {
+		files[i] = models.UserFile{UserID: userID, FileHash: testSHA256, Filename: fmt.Sprintf("file-%04d.txt", i)}
+	}
+	if err := tx.CreateInBatches(files, 100).Error; err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+
+	ids := make([]uuid.UUID, count)
+	for i, file := range files {
+		ids[i] = file.ID
+	}
+	return userID, ids
+}
+
+func TestGetUserFileOnlyFindsOwnFiles(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	userID, ids := createVault(t, tx, 3)
+	file, err := s.GetUserFile(ctx, userID, ids[1])
+	if err != nil {
+		t.Fatalf("GetUserFile() error = %v", err)
+	}
+	if file.ID != ids[1] || file.Filename != "file-0001.txt" || file.Size != 10 {
+		t.Errorf("GetUserFile() = %+v, want file-0001.txt with its content's size", file)
+	}
+
+	if _, err := s.GetUserFile(ctx, "someone-else", ids[1]); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("GetUserFile() for another user error = %v, want ErrUserFileNotFound", err)
+	}
+	if _, err := s.GetUserFile(ctx, userID, uuid.New()); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("GetUserFile() for a missing file error = %v, want ErrUserFileNotFound", err)
+	}
+}
+
+func TestGetSharedFile(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{})
+	s := &FileService{db: tx, sharedFiles: newTTLCache[models.UserFile]("shared_files", time.Minute)}
+
+	_, ids := createVault(t, tx, 2)
+	shared, expired := ids[0], ids[1]
+	if err := tx.Model(&models.UserFile{}).Where("id = ?", shared).Update("is_public", true).Error; err != nil {
+		t.Fatalf("failed to make file public: %v", err)
+	}
+	publicUntil := time.Now().Add(-time.Minute)
+	err := tx.Model(&models.UserFile{}).Where("id = ?", expired).
+		Updates(map[string]interface{}{"is_public": true, "public_until": publicUntil}).Error
+	if err != nil {
+		t.Fatalf("failed to make file public: %v", err)
+	}
+	sharedLink := models.ShareLink{UserFileID: shared}
+	expiredLink := models.ShareLink{UserFileID: expired}
+	if err := tx.Create(&[]*models.ShareLink{&sharedLink, &expiredLink}).Error; err != nil {
+		t.Fatalf("failed to create share links: %v", err)
+	}
+
+	file, err := s.GetSharedFile(sharedLink.ID)
+	if err != nil {
+		t.Fatalf("GetSharedFile() error = %v", err)
+	}
+	if file.ID != shared || file.FileData.Size != 10 {
+		t.Errorf("GetSharedFile() = file %s of %d bytes, want %s with its content loaded", file.ID, file.FileData.Size, shared)
+	}
+
+	if _, err := s.GetSharedFile("missing1"); !errors.Is(err, ErrShareLinkNotFound) {
+		t.Errorf("GetSharedFile() for an unknown link error = %v, want ErrShareLinkNotFound", err)
+	}
+	// The file is still public until the expiry worker gets to it
+	if _, err := s.GetSharedFile(expiredLink.ID); !errors.Is(err, ErrShareLinkNotFound) {
+		t.Errorf("GetSharedFile() past public_until error = %v, want ErrShareLinkNotFound", err)
+	}
+}
+
+func TestDedupStatsFor(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ownCopies int
+		scope     DedupScope
+	}{
+		{"own", 2, DedupScopeOwn},
+		{"cross_user", 0, DedupScopeCrossUser},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := testTx(t, &models.FileHash{}, &models.UserFile{})
+
+			// Content three files already reference, tc.ownCopies of them the uploader's
+			fileHash := models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 2048, MimeType: "text/plain", ReferenceCount: 3}
+			if err := tx.Create(&fileHash).Error; err != nil {
+				t.Fatalf("failed to create file hash: %v", err)
+			}
+			userID := "dedup-user-" + uuid.New().String()
+			for i := range fileHash.ReferenceCount {
+				owner := "other-user-" + uuid.New().String()
+				if i < tc.ownCopies {
+					owner = userID
+				}
+				if err := tx.Create(&models.UserFile{UserID: owner, FileHash: testSHA256, Filename: fmt.Sprintf("copy-%d.txt", i)}).Error; err != nil {
+					t.Fatalf("failed to create file: %v", err)
+				}
+			}
+
+			stats, err := dedupStatsFor(tx, userID, fileHash)
+			if err != nil {
+				t.Fatalf("dedupStatsFor() error = %v", err)
+			}
+			want := DedupStats{BytesSaved: 2048, ReferenceCount: 4, Scope: tc.scope}
+			if *stats != want {
+				t.Errorf("dedupStatsFor() = %+v, want %+v", *stats, want)
+			}
+		})
+	}
+}
+
+// BenchmarkFileLookup compares finding one file in a 500-file vault by listing the
+// vault, as the share link and toggle handlers used to, with GetUserFile
+func BenchmarkFileLookup(b *testing.B) {
+	tx := testTx(b, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	userID, ids := createVault(b, tx, 500)
+	target := ids[len(ids)/2]
+
+	b.Run("scan", func(b *testing.B) {
+		for b.Loop() {
+			files, _, err := s.GetUserFiles(ctx, userID, FileListOptions{}, 0, 1000)
+			if err != nil {
+				b.Fatalf("GetUserFiles() error = %v", err)
+			}
+			found := false
+			for _, file := range files {
+				if file.ID == target {
+					found = true
+					break
+				}
+			}
+			if !found {
+				b.Fatal("file not found in the listing")
+			}
+		}
+	})
+
+	b.Run("direct", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := s.GetUserFile(ctx, userID, target); err != nil {
+				b.Fatalf("GetUserFile() error = %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ConflictPolicy decides what happens when an upload has the same name as one of the
+// user's existing files
+type ConflictPolicy string
+
+const (
+	// ConflictKeepBoth stores the upload beside the existing file under the same name
+	ConflictKeepBoth ConflictPolicy = "keep_both"
+	// ConflictRename stores the upload as "name (1).ext", "name (2).ext" and so on
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictReplace points the most recent file with that name at the new content,
+	// keeping its ID, share links and collection memberships
+	ConflictReplace ConflictPolicy = "replace"
+)
+
+// ErrInvalidConflictPolicy is returned for a conflict policy other than the supported ones
+var ErrInvalidConflictPolicy = errors.New(`conflict_policy must be "keep_both", "rename" or "replace"`)
+
+// ParseConflictPolicy validates a client-supplied conflict policy; empty means keep_both
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(value); policy {
+	case "":
+		return ConflictKeepBoth, nil
+	case ConflictKeepBoth, ConflictRename, ConflictReplace:
+		return policy, nil
+	default:
+		return "", ErrInvalidConflictPolicy
+	}
+}
+
+// storeUserFile stores a new file record for uploaded content, resolving a name clash
+// with the user's other top-level files by the policy. Under replace, userFile becomes
+// the replaced file. The owner's storage usage is adjusted to match. The returned
+// function cleans up after a replace and must be called once the transaction commits.
+func (s *FileService) storeUserFile(tx *gorm.DB, userFile *models.UserFile, policy ConflictPolicy) (func(), error) {
+	if err := s.chargeStoredFile(tx, userFile.UserID, userFile.FileHash); err != nil {
+		return nil, err
+	}
+
+	noop := func() {}
+	if policy == "" || policy == ConflictKeepBoth || userFile.RelativePath != "" {
+		if err := tx.Create(userFile).Error; err != nil {
+			return nil, fmt.Errorf("failed to create user file record: %w", err)
+		}
+		return noop, nil
+	}
+
+	// Names may legitimately repeat under keep_both, so there's no unique index to lean
+	// on; instead uploads of the same name by the same user are serialized here, which
+	// keeps two concurrent renames from picking the same suffix
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", userFile.UserID+"/"+userFile.Filename).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock filename: %w", err)
+	}
+
+	topLevel := tx.Model(&models.UserFile{}).Where("user_id = ? AND COALESCE(relative_path, '') = ''", userFile.UserID)
+
+	if policy == ConflictRename {
+		ext := path.Ext(userFile.Filename)
+		stem := strings.TrimSuffix(userFile.Filename, ext)
+
+		var taken []string
+		err := topLevel.Where("filename = ? OR filename LIKE ?", userFile.Filename, escapeLikePattern(stem)+" (%)"+escapeLikePattern(ext)).
+			Pluck("filename", &taken).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to check for existing filenames: %w", err)
+		}
+		userFile.Filename = nextFreeFilename(userFile.Filename, stem, ext, taken)
+
+		if err := tx.Create(userFile).Error; err != nil {
+			return nil, fmt.Errorf("failed to create user file record: %w", err)
+		}
+		return noop, nil
+	}
+
+	var existing models.UserFile
+	err := topLevel.Where("filename = ?", userFile.Filename).Order("uploaded_at DESC").First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		if err := tx.Create(userFile).Error; err != nil {
+			return nil, fmt.Errorf("failed to create user file record: %w", err)
+		}
+		return noop, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find file to replace: %w", err)
+	}
+	if existing.LegalHold {
+		return nil, ErrFileUnderLegalHold
+	}
+	if existing.Locked {
+		return nil, ErrFileLocked
+	}
+
+	previousHash := existing.FileHash
+	existing.FileHash = userFile.FileHash
+	existing.UpdatedAt = time.Now().UTC()
+	err = tx.Model(&existing).Updates(map[string]interface{}{
+		"file_hash":  existing.FileHash,
+		"updated_at": existing.UpdatedAt,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace file: %w", err)
+	}
+	*userFile = existing
+
+	if err := s.releaseStoredFiles(tx, existing.UserID, previousHash, 1); err != nil {
+		return nil, err
+	}
+	if err := s.releaseFileHash(tx, previousHash); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		s.forgetSharedFile(existing.ID)
+	}, nil
+}
+
+// nextFreeFilename returns filename if it isn't taken, otherwise the lowest numbered
+// "stem (n)ext" that isn't
+func nextFreeFilename(filename, stem, ext string, taken []string) string {
+	inUse := make(map[string]bool, len(taken))
+	for _, name := range taken {
+		inUse[name] = true
+	}
+	if !inUse[filename] {
+		return filename
+	}
+
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if !inUse[candidate] {
+			return candidate
+		}
+	}
+}
+
+// releaseFileHash resyncs a hash's reference count after a file stopped pointing at it,
+// deleting the record once nothing does and queueing its storage object for deletion
+// in the same transaction
+func (s *FileService) releaseFileHash(tx *gorm.DB, hash string) error {
+	var remainingRefs int64
+	if err := tx.Model(&models.UserFile{}).Where("file_hash = ?", hash).Count(&remainingRefs).Error; err != nil {
+		return fmt.Errorf("failed to count remaining file references: %w", err)
+	}
+
+	var fileHash models.FileHash
+	if err := tx.Where("hash = ?", hash).First(&fileHash).Error; err != nil {
+		return fmt.Errorf("failed to get file hash record: %w", err)
+	}
+
+	if remainingRefs > 0 {
+		if err := tx.Model(&fileHash).Update("reference_count", remainingRefs).Error; err != nil {
+			return fmt.Errorf("failed to update reference count: %w", err)
+		}
+		return nil
+	}
+
+	// Soft-deleted files would otherwise block deleting the record
+	if err := tx.Unscoped().Where("file_hash = ? AND deleted_at IS NOT NULL", hash).Delete(&models.UserFile{}).Error; err != nil {
+		return fmt.Errorf("failed to clean up deleted file records: %w", err)
+	}
+	if err := tx.Delete(&fileHash).Error; err != nil {
+		return fmt.Errorf("failed to delete file hash record: %w", err)
+	}
+	return s.deletionQueue.EnqueueContentTx(tx, fileHash)
+}
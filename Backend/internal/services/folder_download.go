@@ -0,0 +1,182 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+)
+
+var (
+	// ErrFolderNotFound is returned when none of the user's files were synced under a path
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrFolderTooLarge is returned for folders over the zip download limit
+	ErrFolderTooLarge = errors.New("folder is too large to download as one archive")
+)
+
+// FolderID returns the ID the folder routes use for a synced folder: its path, base64url
+// encoded without padding so it fits in one URL segment. Folders aren't stored on their
+// own; they exist while files are synced under them.
+func FolderID(folderPath string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(folderPath))
+}
+
+// ParseFolderID returns the normalized folder path for a folder ID, or
+// ErrInvalidRelativePath if it doesn't name a folder inside a synced tree
+func ParseFolderID(id string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return "", ErrInvalidRelativePath
+	}
+	cleaned, err := normalizeRelativePath(string(decoded))
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "" {
+		return "", ErrInvalidRelativePath
+	}
+	return cleaned, nil
+}
+
+// FolderArchive is a synced folder looked up for streaming as a zip archive
+type FolderArchive struct {
+	Path               string
+	ContentDisposition string
+	// Total size of the files before compression
+	Size  int64
+	files []models.UserFile
+}
+
+// PrepareFolderDownload looks up the user's files synced under folderPath, at any
+// depth, to stream as one zip. The total size is checked against the download limit
+// and the user's bandwidth up front, since nothing can be rejected once streaming
+// starts.
+func (s *FileService) PrepareFolderDownload(ctx context.Context, userID, folderPath string) (*FolderArchive, error) {
+	cleaned, err := normalizeRelativePath(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if cleaned == "" {
+		return nil, ErrInvalidRelativePath
+	}
+
+	files, err := s.listFolderFiles(ctx, userID, cleaned, true)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &FolderArchive{
+		Path:               cleaned,
+		ContentDisposition: storage.ContentDisposition("attachment", path.Base(cleaned)+".zip"),
+		files:              files,
+	}
+	for _, file := range files {
+		archive.Size += file.FileData.Size
+	}
+
+	if limit := s.cfg.FolderDownloadMaxMB * 1024 * 1024; archive.Size > limit {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d bytes", ErrFolderTooLarge, archive.Size, limit)
+	}
+	if err := s.userService.CheckBandwidthQuota(userID, archive.Size); err != nil {
+		return nil, err
+	}
+	if err := s.userService.CheckProxyBandwidth(userID); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// listFolderFiles returns the user's files synced under folderPath at any depth, in
+// path order. Without includePrivate, only files that are public right now are listed.
+func (s *FileService) listFolderFiles(ctx context.Context, userID, folderPath string, includePrivate bool) ([]models.UserFile, error) {
+	query := s.db.WithContext(ctx).Preload("FileData").
+		Where("user_id = ? AND relative_path LIKE ?", userID, escapeLikePattern(folderPath)+"/%")
+	if !includePrivate {
+		query = query.Scopes(publicNow)
+	}
+
+	var files []models.UserFile
+	if err := query.Order("relative_path").Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to list folder files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, ErrFolderNotFound
+	}
+	return files, nil
+}
+
+// WriteFolderArchive streams the folder's files into a zip written to w. Entries keep
+// their paths below the folder's parent, so the archive unpacks to the folder itself.
+// An error after the first entry leaves w holding a truncated archive.
+func (s *FileService) WriteFolderArchive(ctx context.Context, userID string, archive *FolderArchive, w io.Writer) error {
+	names := archiveEntryNames(archive.Path, archive.files)
+
+	zw := zip.NewWriter(w)
+	for i, file := range archive.files {
+		name := names[i]
+		if err := s.writeArchiveEntry(ctx, zw, name, file); err != nil {
+			slog.Warn("folder_archive_failed", slog.String("user_id", userID), slog.String("path", archive.Path), slog.Any("error", err))
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish folder archive: %w", err)
+	}
+
+	s.recordBandwidth(userID, archive.Size)
+	return nil
+}
+
+// archiveEntryNames returns each file's path below the folder's parent. Files synced
+// twice to the same path would overwrite each other when unpacked, so repeats get a
+// " (n)" suffix that no other entry uses, including real files named that way.
+func archiveEntryNames(folderPath string, files []models.UserFile) []string {
+	parent := path.Dir(folderPath)
+	used := make(map[string]bool, len(files))
+	names := make([]string, len(files))
+	for i, file := range files {
+		name := file.RelativePath
+		if parent != "." {
+			name = strings.TrimPrefix(name, parent+"/")
+		}
+		if used[name] {
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for n := 1; used[name]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+			}
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func (s *FileService) writeArchiveEntry(ctx context.Context, zw *zip.Writer, name string, file models.UserFile) error {
+	content, err := s.storage.GetObject(ctx, s.resolveObjectKey(ctx, file.FileData))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer content.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.UploadedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to folder archive: %w", name, err)
+	}
+	if _, err := io.Copy(entry, content); err != nil {
+		return fmt.Errorf("failed to write %s to folder archive: %w", name, err)
+	}
+	return nil
+}
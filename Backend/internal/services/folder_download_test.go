@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"filevault-backend/internal/models"
+)
+
+func TestFolderIDRoundTrips(t *testing.T) {
+	for _, folderPath := range []string{"photos", "photos/2024", "notes/drafts & ideas/?#%"} {
+		got, err := ParseFolderID(FolderID(folderPath))
+		if err != nil || got != folderPath {
+			t.Errorf("ParseFolderID(FolderID(%q)) = %q, %v", folderPath, got, err)
+		}
+	}
+
+	for _, id := range []string{"", "not base64!", FolderID("."), FolderID("../secrets"), FolderID("/etc")} {
+		if _, err := ParseFolderID(id); !errors.Is(err, ErrInvalidRelativePath) {
+			t.Errorf("ParseFolderID(%q) error = %v, want ErrInvalidRelativePath", id, err)
+		}
+	}
+}
+
+func TestArchiveEntryNamesDontCollide(t *testing.T) {
+	files := []models.UserFile{
+		{RelativePath: "photos/2024/a (1).jpg"},
+		{RelativePath: "photos/2024/a.jpg"},
+		{RelativePath: "photos/2024/a.jpg"},
+		{RelativePath: "photos/2024/a.jpg"},
+		{RelativePath: "photos/2024/sub/README"},
+		{RelativePath: "photos/2024/sub/README"},
+	}
+
+	got := archiveEntryNames("photos/2024", files)
+	want := []string{
+		"2024/a (1).jpg",
+		"2024/a.jpg",
+		// A real "a (1).jpg" is already in the archive
+		"2024/a (2).jpg",
+		"2024/a (3).jpg",
+		"2024/sub/README",
+		"2024/sub/README (1)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archiveEntryNames() = %q, want %q", got, want)
+	}
+}
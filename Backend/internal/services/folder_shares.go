@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrFolderShareNotFound is returned when a folder share link doesn't exist or isn't the user's
+var ErrFolderShareNotFound = errors.New("folder share link not found")
+
+// SharedFolderResponse is the public view of a shared folder
+type SharedFolderResponse struct {
+	ShareID string             `json:"share_id"`
+	Name    string             `json:"name"`
+	Files   []SharedFolderFile `json:"files"`
+}
+
+// SharedFolderFile is a file in a shared folder. Path is below the shared folder, so
+// visitors don't see where the folder sits in the owner's tree.
+type SharedFolderFile struct {
+	ID          uuid.UUID `json:"id"`
+	Filename    string    `json:"filename"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	MimeType    string    `json:"mime_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	DownloadURL string    `json:"download_url"`
+}
+
+// ShareFolder returns the share link for one of the user's synced folders, creating it
+// if needed. Private files under the folder are only shown through the link when
+// includePrivate is set; calling again changes the setting on the existing link.
+func (s *FileService) ShareFolder(ctx context.Context, userID, folderPath string, includePrivate bool) (*models.FolderShareLink, error) {
+	var files int64
+	err := s.db.WithContext(ctx).Model(&models.UserFile{}).
+		Where("user_id = ? AND relative_path LIKE ?", userID, escapeLikePattern(folderPath)+"/%").
+		Count(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check folder: %w", err)
+	}
+	if files == 0 {
+		return nil, ErrFolderNotFound
+	}
+
+	var link models.FolderShareLink
+	err = s.db.WithContext(ctx).Where("user_id = ? AND path = ?", userID, folderPath).First(&link).Error
+	switch {
+	case err == nil:
+		if link.IncludePrivate != includePrivate {
+			if err := s.db.WithContext(ctx).Model(&link).Update("include_private", includePrivate).Error; err != nil {
+				return nil, fmt.Errorf("failed to update folder share link: %w", err)
+			}
+		}
+		return &link, nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		link = models.FolderShareLink{UserID: userID, Path: folderPath, IncludePrivate: includePrivate}
+		if err := s.db.WithContext(ctx).Create(&link).Error; err != nil {
+			return nil, fmt.Errorf("failed to create folder share link: %w", err)
+		}
+		return &link, nil
+	default:
+		return nil, fmt.Errorf("failed to get folder share link: %w", err)
+	}
+}
+
+// UnshareFolder revokes the share link for one of the user's folders
+func (s *FileService) UnshareFolder(ctx context.Context, userID, folderPath string) error {
+	result := s.db.WithContext(ctx).Where("user_id = ? AND path = ?", userID, folderPath).Delete(&models.FolderShareLink{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke folder share link: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrFolderShareNotFound
+	}
+	return nil
+}
+
+// GetSharedFolder lists the files a folder share link shows, in path order. Files added
+// under the folder after it was shared are included.
+func (s *FileService) GetSharedFolder(ctx context.Context, shareID string) (*SharedFolderResponse, error) {
+	link, err := s.getFolderShare(ctx, shareID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.listFolderFiles(ctx, link.UserID, link.Path, link.IncludePrivate)
+	if err != nil && !errors.Is(err, ErrFolderNotFound) {
+		return nil, err
+	}
+
+	response := &SharedFolderResponse{
+		ShareID: link.ID,
+		Name:    path.Base(link.Path),
+		Files:   make([]SharedFolderFile, 0, len(files)),
+	}
+	for _, file := range files {
+		response.Files = append(response.Files, SharedFolderFile{
+			ID:          file.ID,
+			Filename:    file.Filename,
+			Path:        strings.TrimPrefix(file.RelativePath, link.Path+"/"),
+			Size:        file.FileData.Size,
+			MimeType:    file.FileData.MimeType,
+			UploadedAt:  file.UploadedAt,
+			DownloadURL: "/f/" + link.ID + "/files/" + file.ID.String(),
+		})
+	}
+	return response, nil
+}
+
+// GetFolderFileDownloadURL returns a short-lived download URL for a file shown by a
+// folder share link
+func (s *FileService) GetFolderFileDownloadURL(ctx context.Context, shareID string, fileID uuid.UUID) (string, error) {
+	link, err := s.getFolderShare(ctx, shareID)
+	if err != nil {
+		return "", err
+	}
+
+	userFile, err := s.getSharedFolderFile(ctx, link, fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, userFile.FileData.Size); err != nil {
+		return "", err
+	}
+
+	downloadURL, err := s.presignedDownloadURL(ctx, *userFile, time.Duration(s.cfg.PrivateURLTTLSeconds)*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		s.db.Model(userFile).Updates(downloadUpdates())
+	}()
+
+	s.recordBandwidth(userFile.UserID, userFile.FileData.Size)
+
+	s.RecordActivity(models.UserActivity{UserID: userFile.UserID, Action: models.ActivityDownload, FileID: &userFile.ID, Filename: userFile.Filename, ShareID: shareID})
+
+	return downloadURL, nil
+}
+
+// getSharedFolderFile returns a file under a shared folder, as long as the link shows it
+func (s *FileService) getSharedFolderFile(ctx context.Context, link *models.FolderShareLink, fileID uuid.UUID) (*models.UserFile, error) {
+	query := s.db.WithContext(ctx).Preload("FileData").
+		Where("id = ? AND user_id = ? AND relative_path LIKE ?", fileID, link.UserID, escapeLikePattern(link.Path)+"/%")
+	if !link.IncludePrivate {
+		query = query.Scopes(publicNow)
+	}
+
+	var userFile models.UserFile
+	if err := query.First(&userFile).Error; err != nil {
+		return nil, fmt.Errorf("file not found in folder: %w", err)
+	}
+	return &userFile, nil
+}
+
+// publicNow limits a user_files query to files that are public right now, as stillShared checks
+func publicNow(db *gorm.DB) *gorm.DB {
+	return db.Where("is_public = ? AND (public_until IS NULL OR public_until > ?)", true, time.Now())
+}
+
+func (s *FileService) getFolderShare(ctx context.Context, shareID string) (*models.FolderShareLink, error) {
+	var link models.FolderShareLink
+	err := s.db.WithContext(ctx).Where("id = ?", shareID).First(&link).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrFolderShareNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get folder share link: %w", err)
+	}
+	return &link, nil
+}
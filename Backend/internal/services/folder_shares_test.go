@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestFolderShareHidesPrivateFiles(t *testing.T) {
+	tx := testTx(t, &models.FileHash{}, &models.UserFile{}, &models.FolderShareLink{})
+	s := &FileService{db: tx}
+	ctx := context.Background()
+
+	userID := "folder-user-" + uuid.New().String()
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 10, MimeType: "text/plain"}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	files := map[string]*models.UserFile{
+		"public":  {UserID: userID, FileHash: testSHA256, Filename: "public.txt", RelativePath: "trip/public.txt", IsPublic: true},
+		"nested":  {UserID: userID, FileHash: testSHA256, Filename: "nested.txt", RelativePath: "trip/day 1/nested.txt", IsPublic: true},
+		"private": {UserID: userID, FileHash: testSHA256, Filename: "private.txt", RelativePath: "trip/private.txt"},
+		"expired": {UserID: userID, FileHash: testSHA256, Filename: "expired.txt", RelativePath: "trip/expired.txt", IsPublic: true, PublicUntil: &expired},
+		"outside": {UserID: userID, FileHash: testSHA256, Filename: "outside.txt", RelativePath: "trips/outside.txt", IsPublic: true},
+	}
+	for _, file := range files {
+		if err := tx.Create(file).Error; err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	link, err := s.ShareFolder(ctx, userID, "trip", false)
+	if err != nil {
+		t.Fatalf("ShareFolder() error = %v", err)
+	}
+	folder, err := s.GetSharedFolder(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("GetSharedFolder() error = %v", err)
+	}
+	var paths []string
+	for _, file := range folder.Files {
+		paths = append(paths, file.Path)
+	}
+	if len(paths) != 2 || paths[0] != "day 1/nested.txt" || paths[1] != "public.txt" {
+		t.Errorf("shared folder paths = %q, want only the public files under trip/", paths)
+	}
+	if _, err := s.getSharedFolderFile(ctx, link, files["private"].ID); err == nil {
+		t.Error("private file can be downloaded through a folder share that leaves private files out")
+	}
+
+	// Sharing again updates the existing link
+	again, err := s.ShareFolder(ctx, userID, "trip", true)
+	if err != nil {
+		t.Fatalf("ShareFolder() error = %v", err)
+	}
+	if again.ID != link.ID {
+		t.Errorf("ShareFolder() made a new link %s, want the existing %s", again.ID, link.ID)
+	}
+	folder, err = s.GetSharedFolder(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("GetSharedFolder() error = %v", err)
+	}
+	if len(folder.Files) != 4 {
+		t.Errorf("shared folder lists %d files with include_private, want 4", len(folder.Files))
+	}
+
+	if err := s.UnshareFolder(ctx, userID, "trip"); err != nil {
+		t.Fatalf("UnshareFolder() error = %v", err)
+	}
+	if _, err := s.GetSharedFolder(ctx, link.ID); !errors.Is(err, ErrFolderShareNotFound) {
+		t.Errorf("GetSharedFolder() after revoking error = %v, want ErrFolderShareNotFound", err)
+	}
+}
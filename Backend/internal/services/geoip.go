@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	geoIPUnknownCountry = "unknown"
+	geoIPCacheSize      = 10000
+)
+
+// GeoIPResolver maps client IPs to ISO country codes using an ip-api compatible
+// HTTP endpoint. Lookups are cached in memory; with no endpoint configured every
+// IP resolves to "unknown".
+type GeoIPResolver struct {
+	lookupURL string
+	client    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewGeoIPResolver creates a resolver. lookupURL must contain a %s placeholder for
+// the IP, e.g. "http://ip-api.com/json/%s?fields=countryCode".
+func NewGeoIPResolver(lookupURL string) *GeoIPResolver {
+	return &GeoIPResolver{
+		lookupURL: lookupURL,
+		client:    &http.Client{Timeout: 3 * time.Second},
+		cache:     make(map[string]string),
+	}
+}
+
+// Country returns the country code for ip, or "unknown" if it cannot be resolved
+func (g *GeoIPResolver) Country(ctx context.Context, ip string) string {
+	if g == nil || g.lookupURL == "" || ip == "" {
+		return geoIPUnknownCountry
+	}
+
+	g.mu.Lock()
+	country, ok := g.cache[ip]
+	g.mu.Unlock()
+	if ok {
+		return country
+	}
+
+	country, err := g.lookup(ctx, ip)
+	if err != nil {
+		// Don't cache failures so a flaky endpoint doesn't pin IPs to "unknown"
+		return geoIPUnknownCountry
+	}
+
+	g.mu.Lock()
+	if len(g.cache) >= geoIPCacheSize {
+		g.cache = make(map[string]string)
+	}
+	g.cache[ip] = country
+	g.mu.Unlock()
+
+	return country
+}
+
+func (g *GeoIPResolver) lookup(ctx context.Context, ip string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(g.lookupURL, ip), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("geoip lookup returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		CountryCode string `json:"countryCode"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.CountryCode == "" {
+		return geoIPUnknownCountry, nil
+	}
+	return strings.ToUpper(body.CountryCode), nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"filevault-backend/internal/models"
+)
+
+// HashCheckItem is content a client holds locally, identified by hash and size
+type HashCheckItem struct {
+	FileHash string `json:"file_hash"`
+	Size     int64  `json:"size"`
+}
+
+// HashCheckResult tells whether content is already stored, and nothing else about it
+type HashCheckResult struct {
+	FileHash string `json:"file_hash"`
+	Exists   bool   `json:"exists"`
+}
+
+// lookupExistingHashes loads the stored content among hashes with one IN query on the
+// hash primary key
+func (s *FileService) lookupExistingHashes(ctx context.Context, hashes []string) (map[string]models.FileHash, error) {
+	existing := make(map[string]models.FileHash)
+	if len(hashes) == 0 {
+		return existing, nil
+	}
+
+	var found []models.FileHash
+	if err := s.db.WithContext(ctx).Where("hash IN ?", hashes).Find(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up existing files: %w", err)
+	}
+	for _, hash := range found {
+		existing[hash.Hash] = hash
+	}
+	return existing, nil
+}
+
+// CheckHashes tells a sync client which of its files are already stored, so it can
+// skip reading and uploading them. Content only counts as stored when the client also
+// knows its exact size, a weak proof that it holds the file rather than just a hash.
+func (s *FileService) CheckHashes(ctx context.Context, items []HashCheckItem) ([]HashCheckResult, error) {
+	hashes := make([]string, len(items))
+	for i, item := range items {
+		hashes[i] = item.FileHash
+	}
+
+	existing, err := s.lookupExistingHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]HashCheckResult, len(items))
+	for i, item := range items {
+		stored, ok := existing[item.FileHash]
+		results[i] = HashCheckResult{
+			FileHash: item.FileHash,
+			Exists:   ok && stored.Size == item.Size,
+		}
+	}
+	return results, nil
+}
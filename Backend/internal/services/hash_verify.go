@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"golang.org/x/crypto/blake2b"
+	"gorm.io/gorm"
+)
+
+// ErrHashMismatch is returned when uploaded content doesn't match the declared hash
+var ErrHashMismatch = errors.New("uploaded content does not match the declared file hash")
+
+// canLinkExisting decides whether an upload declared as existingHash may be linked to the
+// stored copy without uploading it. A client that only knows the SHA-256 of someone
+// else's file must not gain access to it, so when the stored content has a secondary
+// hash the client has to present the same one. A missing or different secondary hash
+// proves nothing either way: the client uploads the content, and completion hashes it
+// and links it to the stored copy when it's the same.
+func (s *FileService) canLinkExisting(existing models.FileHash, secondaryHash string) bool {
+	if !s.cfg.CollisionDetectionEnabled || existing.SecondaryHash == "" {
+		return true
+	}
+	return strings.EqualFold(secondaryHash, existing.SecondaryHash)
+}
+
+// verifyStagedContent hashes a staged upload and checks it against the declared SHA-256.
+// It returns the secondary hash, or an empty string when detection is disabled.
+func (s *FileService) verifyStagedContent(ctx context.Context, objectKey, fileHash string) (string, error) {
+	if !s.cfg.CollisionDetectionEnabled {
+		return "", nil
+	}
+
+	primary, secondary, err := s.hashObject(ctx, objectKey)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(primary, fileHash) {
+		return "", ErrHashMismatch
+	}
+	return secondary, nil
+}
+
+// isCollision reports whether content the server just hashed shares record's SHA-256
+// but is different content. Without a secondary hash on both sides nothing is known,
+// which is not a collision.
+func (s *FileService) isCollision(record models.FileHash, secondaryHash string) bool {
+	if !s.cfg.CollisionDetectionEnabled || record.SecondaryHash == "" || secondaryHash == "" {
+		return false
+	}
+	if strings.EqualFold(record.SecondaryHash, secondaryHash) {
+		return false
+	}
+	slog.Error("hash_collision_detected", "hash", record.Hash)
+	return true
+}
+
+// storedContentHash returns the hash content is stored under. File hashes are unique, so
+// content whose SHA-256 collides with different stored content is stored independently
+// under its secondary hash, which has the same length.
+func (s *FileService) storedContentHash(tx *gorm.DB, fileHash, secondaryHash string) (string, error) {
+	var record models.FileHash
+	err := tx.Where("hash = ?", fileHash).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return fileHash, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query file hash: %w", err)
+	}
+	if s.isCollision(record, secondaryHash) {
+		return secondaryHash, nil
+	}
+	return fileHash, nil
+}
+
+// contentSHA256 returns the SHA-256 of stored content, which is its hash unless the
+// content was stored under its secondary hash after a collision
+func contentSHA256(fileHash models.FileHash) string {
+	if fileHash.CollidesWith != "" {
+		return fileHash.CollidesWith
+	}
+	return fileHash.Hash
+}
+
+// collidesWith returns what to record as CollidesWith for content stored as storedHash
+func collidesWith(storedHash, fileHash string) string {
+	if storedHash == fileHash {
+		return ""
+	}
+	return fileHash
+}
+
+// hashObject streams an object once and returns its SHA-256 and BLAKE2b-256 hashes
+func (s *FileService) hashObject(ctx context.Context, objectKey string) (string, string, error) {
+	return s.hashObjectThrottled(ctx, objectKey, 0)
+}
+
+// hashObjectThrottled is hashObject reading at most bytesPerSecond (0 = unthrottled)
+func (s *FileService) hashObjectThrottled(ctx context.Context, objectKey string, bytesPerSecond int64) (string, string, error) {
+	object, err := s.storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return "", "", err
+	}
+	defer object.Close()
+
+	var reader io.Reader = object
+	if bytesPerSecond > 0 {
+		reader = newThrottledReader(ctx, object, bytesPerSecond)
+	}
+
+	primary := sha256.New()
+	secondary, err := blake2b.New256(nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create hasher: %w", err)
+	}
+	if _, err := io.Copy(io.MultiWriter(primary, secondary), reader); err != nil {
+		return "", "", fmt.Errorf("failed to hash object: %w", err)
+	}
+
+	return hex.EncodeToString(primary.Sum(nil)), hex.EncodeToString(secondary.Sum(nil)), nil
+}
+
+// backfillSecondaryHash records the secondary hash on content stored before it was tracked
+func backfillSecondaryHash(tx *gorm.DB, record models.FileHash, secondaryHash string) error {
+	if record.SecondaryHash != "" || secondaryHash == "" {
+		return nil
+	}
+	if err := tx.Model(&models.FileHash{}).Where("hash = ?", record.Hash).Update("secondary_hash", secondaryHash).Error; err != nil {
+		return fmt.Errorf("failed to record secondary hash: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+)
+
+const (
+	testSHA256    = "1111111111111111111111111111111111111111111111111111111111111111"
+	testSecondary = "2222222222222222222222222222222222222222222222222222222222222222"
+	otherContent  = "3333333333333333333333333333333333333333333333333333333333333333"
+)
+
+func TestCanLinkExisting(t *testing.T) {
+	s := &FileService{cfg: &config.Config{CollisionDetectionEnabled: true}}
+	stored := models.FileHash{Hash: testSHA256, SecondaryHash: testSecondary}
+
+	tests := []struct {
+		name      string
+		existing  models.FileHash
+		secondary string
+		want      bool
+	}{
+		{"matching secondary hash", stored, testSecondary, true},
+		{"matching in upper case", stored, strings.ToUpper(testSecondary), true},
+		{"missing secondary hash has to upload", stored, "", false},
+		{"different secondary hash has to upload", stored, otherContent, false},
+		{"stored before secondary hashes were tracked", models.FileHash{Hash: testSHA256}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.canLinkExisting(tt.existing, tt.secondary); got != tt.want {
+				t.Errorf("canLinkExisting() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCollisionNeedsBothSecondaryHashes(t *testing.T) {
+	s := &FileService{cfg: &config.Config{CollisionDetectionEnabled: true}}
+	stored := models.FileHash{Hash: testSHA256, SecondaryHash: testSecondary}
+
+	if s.isCollision(stored, testSecondary) {
+		t.Error("isCollision() with the same content = true")
+	}
+	if s.isCollision(stored, "") {
+		t.Error("isCollision() without a secondary hash = true, but nothing is known about the content")
+	}
+	if s.isCollision(models.FileHash{Hash: testSHA256}, otherContent) {
+		t.Error("isCollision() against content stored before secondary hashes = true")
+	}
+	if !s.isCollision(stored, otherContent) {
+		t.Error("isCollision() with different content = false")
+	}
+
+	s.cfg.CollisionDetectionEnabled = false
+	if s.isCollision(stored, otherContent) {
+		t.Error("isCollision() with detection disabled = true")
+	}
+}
+
+func TestContentSHA256OfCollidingContent(t *testing.T) {
+	if got := contentSHA256(models.FileHash{Hash: testSHA256}); got != testSHA256 {
+		t.Errorf("contentSHA256() = %q, want the hash", got)
+	}
+
+	colliding := models.FileHash{Hash: otherContent, SecondaryHash: otherContent, CollidesWith: collidesWith(otherContent, testSHA256)}
+	if got := contentSHA256(colliding); got != testSHA256 {
+		t.Errorf("contentSHA256() of colliding content = %q, want the SHA-256 it collides on", got)
+	}
+	if got := collidesWith(testSHA256, testSHA256); got != "" {
+		t.Errorf("collidesWith() of content stored under its SHA-256 = %q, want empty", got)
+	}
+}
+
+func TestStoredContentHashKeepsCollidingContentApart(t *testing.T) {
+	tx := testTx(t, &models.FileHash{})
+	s := &FileService{db: tx, cfg: &config.Config{CollisionDetectionEnabled: true}}
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, SecondaryHash: testSecondary, MinIOKey: testSHA256}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		fileHash  string
+		secondary string
+		want      string
+	}{
+		{"new content", otherContent, otherContent, otherContent},
+		{"same content", testSHA256, testSecondary, testSHA256},
+		{"unknown secondary hash is linked", testSHA256, "", testSHA256},
+		{"collision is stored under its secondary hash", testSHA256, otherContent, otherContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.storedContentHash(tx, tt.fileHash, tt.secondary)
+			if err != nil {
+				t.Fatalf("storedContentHash() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("storedContentHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filevault-backend/internal/config"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DownloadTicketTTL is how long a signed download ticket stays valid
+	DownloadTicketTTL = 5 * time.Minute
+
+	downloadLimiterMaxEntries = 100000
+)
+
+var (
+	// ErrHotlinkForbidden is returned when a download's referrer or origin isn't allowlisted
+	ErrHotlinkForbidden = errors.New("downloads from this site are not allowed")
+	// ErrDownloadTicketInvalid is returned when a required download ticket is missing, forged or expired
+	ErrDownloadTicketInvalid = errors.New("download ticket is missing or expired")
+)
+
+// DownloadTicket is a signed timestamp the share landing page adds to download URLs
+type DownloadTicket struct {
+	Path      string    `json:"path"`
+	Timestamp int64     `json:"t"`
+	Signature string    `json:"sig"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HotlinkService guards backend-served public downloads against hotlinking and
+// scripted hammering: referrer/origin allowlisting, a per-IP download limiter that
+// is separate from the API limiter, and optional signed download tickets.
+type HotlinkService struct {
+	cfg      *config.Config
+	limiters map[string]*rate.Limiter
+	mu       sync.Mutex
+}
+
+func NewHotlinkService(cfg *config.Config) *HotlinkService {
+	return &HotlinkService{
+		cfg:      cfg,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// CheckReferrer allows requests whose Referer or Origin host is allowlisted. With no
+// allowlist configured every request passes.
+func (s *HotlinkService) CheckReferrer(referrer, origin string) error {
+	if len(s.cfg.HotlinkAllowedHosts) == 0 {
+		return nil
+	}
+
+	source := origin
+	if source == "" {
+		source = referrer
+	}
+	if source == "" {
+		if s.cfg.HotlinkAllowEmptyReferrer {
+			return nil
+		}
+		return ErrHotlinkForbidden
+	}
+
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Hostname() == "" {
+		return ErrHotlinkForbidden
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range s.cfg.HotlinkAllowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return ErrHotlinkForbidden
+}
+
+// AllowDownload applies the per-IP download rate limit
+func (s *HotlinkService) AllowDownload(clientIP string) bool {
+	if s.cfg.DownloadRateLimitPerMinute <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	limiter, exists := s.limiters[clientIP]
+	if !exists {
+		// Drop all state rather than grow without bound under a flood of distinct IPs
+		if len(s.limiters) >= downloadLimiterMaxEntries {
+			s.limiters = make(map[string]*rate.Limiter)
+		}
+		perMinute := s.cfg.DownloadRateLimitPerMinute
+		limiter = rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+		s.limiters[clientIP] = limiter
+	}
+	s.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// TicketsRequired reports whether downloads must carry a signed ticket
+func (s *HotlinkService) TicketsRequired() bool {
+	return s.cfg.DownloadSigningSecret != ""
+}
+
+// IssueTicket signs the current time for a download path
+func (s *HotlinkService) IssueTicket(path string) (*DownloadTicket, error) {
+	if !isProtectedDownloadPath(path) {
+		return nil, fmt.Errorf("path is not a public download path")
+	}
+
+	now := time.Now()
+	return &DownloadTicket{
+		Path:      path,
+		Timestamp: now.Unix(),
+		Signature: s.sign(path, now.Unix()),
+		ExpiresAt: now.Add(DownloadTicketTTL).UTC(),
+	}, nil
+}
+
+// VerifyTicket checks the t and sig query parameters for a download path
+func (s *HotlinkService) VerifyTicket(path, timestamp, signature string) error {
+	if !s.TicketsRequired() {
+		return nil
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrDownloadTicketInvalid
+	}
+
+	issued := time.Unix(t, 0)
+	if time.Since(issued) > DownloadTicketTTL || time.Until(issued) > time.Minute {
+		return ErrDownloadTicketInvalid
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.sign(path, t))) {
+		return ErrDownloadTicketInvalid
+	}
+	return nil
+}
+
+func (s *HotlinkService) sign(path string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.DownloadSigningSecret))
+	fmt.Fprintf(mac, "%s\n%d", path, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isProtectedDownloadPath limits tickets to the backend-served public download routes
+func isProtectedDownloadPath(path string) bool {
+	for _, prefix := range []string{"/share/", "/c/", "/f/", "/api/v1/public/files/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
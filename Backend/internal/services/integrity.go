@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+const (
+	// Objects verified per batch before the scrubber saves its position
+	integrityScrubBatchSize = 100
+	// Pause between full passes, so small deployments aren't re-read around the clock
+	integrityScrubPassInterval = 24 * time.Hour
+	// Pause after a batch fails, e.g. while the database is unreachable
+	integrityScrubRetryInterval = 5 * time.Minute
+	// The scrubber keeps its position in a single row
+	integrityScrubCursorID = 1
+	// Users tracked by the verification rate limit before its state is dropped
+	verifyLimiterMaxEntries = 100000
+)
+
+// Problems recorded on an IntegrityIssue
+const (
+	IntegrityProblemHashMismatch      = "hash_mismatch"
+	IntegrityProblemSecondaryMismatch = "secondary_hash_mismatch"
+	IntegrityProblemMissing           = "missing"
+)
+
+var (
+	// ErrIntegrityFileNotFound is returned when verifying a file the user doesn't own
+	ErrIntegrityFileNotFound = errors.New("file not found")
+	// ErrVerifyRateLimited is returned when a user verifies files faster than VerifyRateLimitPerHour
+	ErrVerifyRateLimited = errors.New("too many file verifications")
+)
+
+// IntegrityCheckResult is the outcome of re-hashing one stored object
+type IntegrityCheckResult struct {
+	FileHash   string `json:"file_hash"`
+	ActualHash string `json:"actual_hash,omitempty"`
+	Match      bool   `json:"match"`
+	Problem    string `json:"problem,omitempty"`
+}
+
+// IntegrityVerifyReport summarizes an admin verification run
+type IntegrityVerifyReport struct {
+	Checked int `json:"checked"`
+	// Objects that could not be read for reasons other than being missing
+	Failed     int                    `json:"failed"`
+	Mismatches []IntegrityCheckResult `json:"mismatches"`
+	// Pass as after to continue a walk; empty when sampling or once the walk is done
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// VerifyFile re-hashes the content behind one of the user's files. Each check reads the
+// whole object, so it's read at the scrub rate and users are limited to
+// VerifyRateLimitPerHour checks.
+func (s *FileService) VerifyFile(ctx context.Context, userID string, fileID uuid.UUID) (*IntegrityCheckResult, error) {
+	if !s.allowVerify(userID) {
+		return nil, ErrVerifyRateLimited
+	}
+
+	var userFile models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrIntegrityFileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return s.checkIntegrity(ctx, userFile.FileData, s.integrityScrubRate())
+}
+
+// allowVerify applies the per-user verification rate limit
+func (s *FileService) allowVerify(userID string) bool {
+	if s.cfg.VerifyRateLimitPerHour <= 0 {
+		return true
+	}
+
+	s.verifyLimitersMu.Lock()
+	limiter, exists := s.verifyLimiters[userID]
+	if !exists {
+		// Drop all state rather than grow without bound
+		if s.verifyLimiters == nil || len(s.verifyLimiters) >= verifyLimiterMaxEntries {
+			s.verifyLimiters = make(map[string]*rate.Limiter)
+		}
+		perHour := s.cfg.VerifyRateLimitPerHour
+		limiter = rate.NewLimiter(rate.Limit(float64(perHour)/3600), perHour)
+		s.verifyLimiters[userID] = limiter
+	}
+	s.verifyLimitersMu.Unlock()
+
+	return limiter.Allow()
+}
+
+// VerifyIntegrity re-hashes up to limit stored objects, either a random sample or the
+// next page of a walk in hash order starting after the given hash
+func (s *FileService) VerifyIntegrity(ctx context.Context, sample bool, after string, limit int) (*IntegrityVerifyReport, error) {
+	query := s.db.WithContext(ctx).Where("reference_count > 0")
+	if sample {
+		query = query.Order("RANDOM()")
+	} else {
+		query = query.Where("hash > ?", after).Order("hash")
+	}
+
+	var fileHashes []models.FileHash
+	if err := query.Limit(limit).Find(&fileHashes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list stored content: %w", err)
+	}
+
+	report := &IntegrityVerifyReport{Mismatches: []IntegrityCheckResult{}}
+	for _, fileHash := range fileHashes {
+		result, err := s.checkIntegrity(ctx, fileHash, s.integrityScrubRate())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		report.Checked++
+		if err != nil {
+			log.Printf("Failed to verify %s: %v", fileHash.Hash, err)
+			report.Failed++
+			continue
+		}
+		if !result.Match {
+			report.Mismatches = append(report.Mismatches, *result)
+		}
+	}
+
+	if !sample && len(fileHashes) == limit {
+		report.NextCursor = fileHashes[len(fileHashes)-1].Hash
+	}
+	return report, nil
+}
+
+// ListIntegrityIssues returns recorded integrity issues, most recently detected first
+func (s *FileService) ListIntegrityIssues(includeResolved bool, offset, limit int) ([]models.IntegrityIssue, int64, error) {
+	query := s.db.Model(&models.IntegrityIssue{})
+	if !includeResolved {
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count integrity issues: %w", err)
+	}
+
+	var issues []models.IntegrityIssue
+	if err := query.Order("detected_at DESC").Offset(offset).Limit(limit).Find(&issues).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list integrity issues: %w", err)
+	}
+	return issues, total, nil
+}
+
+// checkIntegrity re-hashes one stored object and records or resolves its integrity
+// issue. Read errors other than a missing object are returned without recording
+// anything, since they say nothing about the stored bytes.
+func (s *FileService) checkIntegrity(ctx context.Context, fileHash models.FileHash, bytesPerSecond int64) (*IntegrityCheckResult, error) {
+	objectKey := s.resolveObjectKey(ctx, fileHash)
+	result := &IntegrityCheckResult{FileHash: fileHash.Hash}
+
+	primary, secondary, err := s.hashObjectThrottled(ctx, objectKey, bytesPerSecond)
+	switch {
+	case storage.IsObjectNotFound(err):
+		result.Problem = IntegrityProblemMissing
+	case err != nil:
+		return nil, err
+	case !strings.EqualFold(primary, contentSHA256(fileHash)):
+		result.Problem = IntegrityProblemHashMismatch
+	case fileHash.SecondaryHash != "" && secondary != fileHash.SecondaryHash:
+		result.Problem = IntegrityProblemSecondaryMismatch
+	}
+	result.ActualHash = primary
+	result.Match = result.Problem == ""
+
+	if !result.Match {
+		slog.Error("integrity_issue_detected", "hash", fileHash.Hash, "object_key", objectKey, "problem", result.Problem)
+	}
+	if err := s.recordIntegrityResult(ctx, objectKey, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// recordIntegrityResult opens or refreshes the content's issue on a mismatch and
+// resolves it once the content checks out again, e.g. after a restore from backup
+func (s *FileService) recordIntegrityResult(ctx context.Context, objectKey string, result *IntegrityCheckResult) error {
+	now := time.Now().UTC()
+	open := s.db.WithContext(ctx).Model(&models.IntegrityIssue{}).Where("file_hash = ? AND resolved_at IS NULL", result.FileHash)
+
+	if result.Match {
+		if err := open.Update("resolved_at", now).Error; err != nil {
+			return fmt.Errorf("failed to resolve integrity issue: %w", err)
+		}
+		return nil
+	}
+
+	updated := open.Updates(map[string]interface{}{
+		"object_key":  objectKey,
+		"actual_hash": result.ActualHash,
+		"problem":     result.Problem,
+		"detected_at": now,
+	})
+	if updated.Error != nil {
+		return fmt.Errorf("failed to update integrity issue: %w", updated.Error)
+	}
+	if updated.RowsAffected > 0 {
+		return nil
+	}
+
+	issue := models.IntegrityIssue{
+		FileHash:   result.FileHash,
+		ObjectKey:  objectKey,
+		ActualHash: result.ActualHash,
+		Problem:    result.Problem,
+		DetectedAt: now,
+	}
+	if err := s.db.WithContext(ctx).Create(&issue).Error; err != nil {
+		return fmt.Errorf("failed to record integrity issue: %w", err)
+	}
+	return nil
+}
+
+// StartIntegrityScrubWorker walks all stored content in hash order, re-hashing it at
+// the configured rate. Its position is saved after every batch so a restart resumes
+// the pass, and a new pass starts a day after the previous one finished.
+func (s *FileService) StartIntegrityScrubWorker(ctx context.Context) {
+	if !s.cfg.IntegrityScrubEnabled {
+		return
+	}
+
+	go func() {
+		for {
+			wait, err := s.scrubIntegrityBatch(ctx)
+			if err != nil {
+				log.Printf("Integrity scrub failed: %v", err)
+				wait = integrityScrubRetryInterval
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// scrubIntegrityBatch verifies the next batch of the current pass and returns how long
+// to wait before the next one
+func (s *FileService) scrubIntegrityBatch(ctx context.Context) (time.Duration, error) {
+	cursor := models.IntegrityScrubCursor{ID: integrityScrubCursorID}
+	if err := s.db.WithContext(ctx).FirstOrCreate(&cursor).Error; err != nil {
+		return 0, fmt.Errorf("failed to load scrub position: %w", err)
+	}
+	if wait := time.Until(cursor.PassStartedAt); wait > 0 {
+		return wait, nil
+	}
+
+	var batch []models.FileHash
+	err := s.db.WithContext(ctx).
+		Where("hash > ? AND reference_count > 0", cursor.LastHash).
+		Order("hash").
+		Limit(integrityScrubBatchSize).
+		Find(&batch).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to list stored content: %w", err)
+	}
+
+	if len(batch) == 0 {
+		if cursor.LastHash != "" {
+			log.Printf("Integrity scrub pass complete")
+		}
+		cursor.LastHash = ""
+		cursor.PassStartedAt = time.Now().UTC().Add(integrityScrubPassInterval)
+		if err := s.db.Save(&cursor).Error; err != nil {
+			return 0, fmt.Errorf("failed to save scrub position: %w", err)
+		}
+		return integrityScrubPassInterval, nil
+	}
+
+	for _, fileHash := range batch {
+		if _, err := s.checkIntegrity(ctx, fileHash, s.integrityScrubRate()); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Failed to verify %s: %v", fileHash.Hash, err)
+		}
+		cursor.LastHash = fileHash.Hash
+	}
+
+	// Saved without the worker's context so progress survives shutdown
+	if err := s.db.Save(&cursor).Error; err != nil {
+		return 0, fmt.Errorf("failed to save scrub position: %w", err)
+	}
+	return 0, nil
+}
+
+// integrityScrubRate is the read bandwidth cap for scrubbing in bytes per second
+func (s *FileService) integrityScrubRate() int64 {
+	return s.cfg.IntegrityScrubMBPerSecond * 1024 * 1024
+}
+
+// throttledReader caps how fast an object is read, so scrubbing doesn't compete with
+// downloads for storage bandwidth
+type throttledReader struct {
+	ctx            context.Context
+	reader         io.Reader
+	bytesPerSecond int64
+	started        time.Time
+	read           int64
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, bytesPerSecond int64) *throttledReader {
+	return &throttledReader{ctx: ctx, reader: reader, bytesPerSecond: bytesPerSecond, started: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read in slices of a tenth of a second's budget so the rate stays smooth
+	if chunk := t.bytesPerSecond / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := t.reader.Read(p)
+	t.read += int64(n)
+
+	due := t.started.Add(time.Duration(float64(t.read) / float64(t.bytesPerSecond) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+	return n, err
+}
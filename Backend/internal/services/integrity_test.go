@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyFileIsRateLimitedPerUser(t *testing.T) {
+	s := &FileService{cfg: &config.Config{VerifyRateLimitPerHour: 2}}
+
+	for i := 0; i < 2; i++ {
+		if !s.allowVerify("user-a") {
+			t.Fatalf("allowVerify() call %d = false, want the hourly allowance", i+1)
+		}
+	}
+	if s.allowVerify("user-a") {
+		t.Error("allowVerify() past the hourly allowance = true")
+	}
+	if !s.allowVerify("user-b") {
+		t.Error("allowVerify() for another user = false, but limits are per user")
+	}
+
+	// Rejected before the file is even looked up
+	if _, err := s.VerifyFile(context.Background(), "user-a", uuid.New()); !errors.Is(err, ErrVerifyRateLimited) {
+		t.Errorf("VerifyFile() past the allowance error = %v, want ErrVerifyRateLimited", err)
+	}
+}
+
+func TestAllowVerifyWithoutLimit(t *testing.T) {
+	s := &FileService{cfg: &config.Config{}}
+	for i := 0; i < 100; i++ {
+		if !s.allowVerify("user-a") {
+			t.Fatal("allowVerify() with the limit disabled = false")
+		}
+	}
+}
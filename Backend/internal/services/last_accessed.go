@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// An access within this long of the last recorded one isn't written, so a player
+// streaming a file in many range requests costs at most one write an hour
+const lastAccessedResolution = time.Hour
+
+// lastAccessedColumn is when a file was last downloaded or streamed. Files accessed
+// before last_accessed_at existed fall back to their last counted download.
+const lastAccessedColumn = "COALESCE(user_files.last_accessed_at, user_files.last_download_at)"
+
+// lastTouchedColumn is when a file was last accessed or, if never, uploaded
+const lastTouchedColumn = "COALESCE(" + lastAccessedColumn + ", user_files.uploaded_at)"
+
+// FileSort is the order of a file listing
+type FileSort string
+
+const (
+	// FileSortUploaded lists the newest uploads first
+	FileSortUploaded FileSort = "uploaded"
+	// FileSortLastAccessed lists the files untouched the longest first, counting a file
+	// never accessed as touched when it was uploaded
+	FileSortLastAccessed FileSort = "last_accessed"
+)
+
+// ErrInvalidFileSort is returned for a listing order other than the supported ones
+var ErrInvalidFileSort = errors.New(`sort_by must be "uploaded" or "last_accessed"`)
+
+// ParseFileSort validates a client-supplied listing order; empty means uploaded
+func ParseFileSort(value string) (FileSort, error) {
+	switch sort := FileSort(value); sort {
+	case "":
+		return FileSortUploaded, nil
+	case FileSortUploaded, FileSortLastAccessed:
+		return sort, nil
+	default:
+		return "", ErrInvalidFileSort
+	}
+}
+
+// FileListOptions narrows and orders a listing of the user's files
+type FileListOptions struct {
+	// Only files whose MIME type falls in the category; empty lists all
+	Category MimeCategory
+	SortBy   FileSort
+	// Only files not downloaded or streamed since then, counting uploads as access
+	NotAccessedSince *time.Time
+	// Only files whose metadata has all of these pairs
+	Metadata map[string]string
+}
+
+// orderBy returns the listing's ORDER BY clause
+func (sort FileSort) orderBy() string {
+	if sort == FileSortLastAccessed {
+		return lastTouchedColumn + " ASC, user_files.id"
+	}
+	return "user_files.uploaded_at DESC"
+}
+
+// touchFile records an access to a file that isn't counted as a download, such as a
+// range request partway into a stream. Downloads record it with downloadUpdates.
+func (s *FileService) touchFile(fileID uuid.UUID) {
+	now := time.Now().UTC()
+	err := s.db.Model(&models.UserFile{}).
+		Where("id = ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)", fileID, now.Add(-lastAccessedResolution)).
+		UpdateColumn("last_accessed_at", now).Error
+	if err != nil {
+		slog.Warn("last_accessed_update_failed", slog.String("file_id", fileID.String()), slog.Any("error", err))
+	}
+}
+
+// lastAccessedAt returns when a loaded file was last downloaded or streamed
+func lastAccessedAt(file models.UserFile) *time.Time {
+	if file.LastAccessedAt != nil {
+		return file.LastAccessedAt
+	}
+	return file.LastDownloadAt
+}
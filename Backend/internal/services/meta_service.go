@@ -0,0 +1,97 @@
+package services
+
+import (
+	"filevault-backend/internal/config"
+)
+
+// S3 caps a single PUT at 5GB; larger files have to use multipart uploads
+const maxSingleUploadBytes = 5 * 1024 * 1024 * 1024
+
+// ServerMeta describes this deployment's limits and features, so a single frontend
+// build can adapt to differently configured instances
+type ServerMeta struct {
+	InstanceName  string       `json:"instance_name"`
+	PublicBaseURL string       `json:"public_base_url"`
+	Limits        MetaLimits   `json:"limits"`
+	Features      MetaFeatures `json:"features"`
+	// Empty when any MIME type may be uploaded
+	AllowedMimeTypes []string `json:"allowed_mime_types"`
+	// Set while a critical announcement is shown, so the frontend knows to fetch and
+	// display it as a banner
+	ActiveCriticalAnnouncement bool `json:"active_critical_announcement"`
+}
+
+type MetaLimits struct {
+	MaxFileSizeBytes     int64 `json:"max_file_size_bytes"`     // No file can be larger than the largest quota
+	MaxSingleUploadBytes int64 `json:"max_single_upload_bytes"` // Larger files must use multipart uploads
+	MaxMultipartParts    int   `json:"max_multipart_parts"`
+	MaxBatchFiles        int   `json:"max_batch_files"`
+	DefaultQuotaBytes    int64 `json:"default_quota_bytes"`
+	TrialQuotaBytes      int64 `json:"trial_quota_bytes,omitempty"` // Quota during the first 30 days, when trials are enabled
+	// How a user's files sharing content count toward their quota: "deduplicated" or "logical"
+	QuotaMode string `json:"quota_mode"`
+}
+
+type MetaFeatures struct {
+	// Not implemented by this server; reported so clients can rely on the keys
+	VirusScanning      bool `json:"virus_scanning"`
+	EmailNotifications bool `json:"email_notifications"`
+
+	Thumbnails         bool `json:"thumbnails"` // Images get thumbnails at /files/{id}/thumbnail
+	AdminWebhooks      bool `json:"admin_webhooks"`
+	FuzzySearch        bool `json:"fuzzy_search"` // Whether the trigram index could be created at startup
+	CollisionDetection bool `json:"collision_detection"`
+	DownloadLinks      bool `json:"download_links"`
+	DownloadTickets    bool `json:"download_tickets"` // Public downloads need a ticket from /public/download-ticket
+	CDN                bool `json:"cdn"`
+	StorageTiering     bool `json:"storage_tiering"`
+	WebDAV             bool `json:"webdav"` // Users can opt into a read-only WebDAV mount at /dav/
+}
+
+// MetaService assembles the capabilities reported by GET /api/v1/meta
+type MetaService struct {
+	cfg                  *config.Config
+	fuzzySearchAvailable bool
+	announcementService  *AnnouncementService
+}
+
+func NewMetaService(cfg *config.Config, fuzzySearchAvailable bool, announcementService *AnnouncementService) *MetaService {
+	return &MetaService{
+		cfg:                  cfg,
+		fuzzySearchAvailable: fuzzySearchAvailable,
+		announcementService:  announcementService,
+	}
+}
+
+// GetMeta returns the deployment's current limits and features
+func (s *MetaService) GetMeta() ServerMeta {
+	meta := ServerMeta{
+		InstanceName:  s.cfg.InstanceName,
+		PublicBaseURL: s.cfg.PublicBaseURL,
+		Limits: MetaLimits{
+			MaxFileSizeBytes:     s.cfg.MaxStorageQuotaMB * 1024 * 1024,
+			MaxSingleUploadBytes: maxSingleUploadBytes,
+			MaxMultipartParts:    maxMultipartParts,
+			MaxBatchFiles:        MaxBatchFiles,
+			DefaultQuotaBytes:    s.cfg.DefaultStorageQuotaMB * 1024 * 1024,
+			QuotaMode:            s.cfg.QuotaMode,
+		},
+		Features: MetaFeatures{
+			Thumbnails:         s.cfg.ThumbnailsEnabled,
+			AdminWebhooks:      s.cfg.AdminWebhookURL != "",
+			FuzzySearch:        s.fuzzySearchAvailable,
+			CollisionDetection: s.cfg.CollisionDetectionEnabled,
+			DownloadLinks:      s.cfg.DownloadLinkSecret != "",
+			DownloadTickets:    s.cfg.DownloadSigningSecret != "",
+			CDN:                s.cfg.CDNBaseURL != "",
+			StorageTiering:     s.cfg.TieringEnabled,
+			WebDAV:             s.cfg.WebDAVEnabled,
+		},
+		AllowedMimeTypes:           []string{},
+		ActiveCriticalAnnouncement: s.announcementService.HasActiveCritical(),
+	}
+	if s.cfg.TrialEnabled {
+		meta.Limits.TrialQuotaBytes = s.cfg.TrialStorageQuotaMB * 1024 * 1024
+	}
+	return meta
+}
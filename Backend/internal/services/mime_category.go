@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MimeCategory groups MIME types into the buckets shown by the media browser
+type MimeCategory string
+
+const (
+	MimeCategoryImage    MimeCategory = "image"
+	MimeCategoryVideo    MimeCategory = "video"
+	MimeCategoryAudio    MimeCategory = "audio"
+	MimeCategoryDocument MimeCategory = "document"
+	MimeCategoryArchive  MimeCategory = "archive"
+	MimeCategoryOther    MimeCategory = "other"
+)
+
+// MimeCategories lists every category in display order
+var MimeCategories = []MimeCategory{
+	MimeCategoryImage,
+	MimeCategoryVideo,
+	MimeCategoryAudio,
+	MimeCategoryDocument,
+	MimeCategoryArchive,
+	MimeCategoryOther,
+}
+
+var documentMimeTypes = []string{
+	"application/pdf",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/vnd.ms-excel",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.ms-powerpoint",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"application/vnd.oasis.opendocument.text",
+	"application/vnd.oasis.opendocument.spreadsheet",
+	"application/vnd.oasis.opendocument.presentation",
+	"application/rtf",
+}
+
+var archiveMimeTypes = []string{
+	"application/zip",
+	"application/x-zip-compressed",
+	"application/x-tar",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/x-bzip2",
+	"application/x-xz",
+}
+
+// ParseMimeCategory validates a category name from user input
+func ParseMimeCategory(value string) (MimeCategory, bool) {
+	for _, category := range MimeCategories {
+		if string(category) == value {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// mimeCategoryCondition returns a SQL condition on file_hashes.mime_type selecting the category
+func mimeCategoryCondition(category MimeCategory) (string, []interface{}) {
+	switch category {
+	case MimeCategoryImage, MimeCategoryVideo, MimeCategoryAudio:
+		return "file_hashes.mime_type LIKE ?", []interface{}{string(category) + "/%"}
+	case MimeCategoryDocument:
+		return "(file_hashes.mime_type IN ? OR file_hashes.mime_type LIKE ?)", []interface{}{documentMimeTypes, "text/%"}
+	case MimeCategoryArchive:
+		return "file_hashes.mime_type IN ?", []interface{}{archiveMimeTypes}
+	default:
+		return "NOT (file_hashes.mime_type LIKE 'image/%' OR file_hashes.mime_type LIKE 'video/%' OR " +
+				"file_hashes.mime_type LIKE 'audio/%' OR file_hashes.mime_type LIKE 'text/%' OR " +
+				"file_hashes.mime_type IN ? OR file_hashes.mime_type IN ?)",
+			[]interface{}{documentMimeTypes, archiveMimeTypes}
+	}
+}
+
+// mimeCategoryCaseSQL returns a CASE expression mapping file_hashes.mime_type to its category name.
+// The MIME lists are package constants, so inlining them as literals is safe.
+func mimeCategoryCaseSQL() string {
+	return fmt.Sprintf("CASE "+
+		"WHEN file_hashes.mime_type LIKE 'image/%%' THEN '%s' "+
+		"WHEN file_hashes.mime_type LIKE 'video/%%' THEN '%s' "+
+		"WHEN file_hashes.mime_type LIKE 'audio/%%' THEN '%s' "+
+		"WHEN file_hashes.mime_type LIKE 'text/%%' OR file_hashes.mime_type IN (%s) THEN '%s' "+
+		"WHEN file_hashes.mime_type IN (%s) THEN '%s' "+
+		"ELSE '%s' END",
+		MimeCategoryImage, MimeCategoryVideo, MimeCategoryAudio,
+		quoteSQLList(documentMimeTypes), MimeCategoryDocument,
+		quoteSQLList(archiveMimeTypes), MimeCategoryArchive,
+		MimeCategoryOther)
+}
+
+func quoteSQLList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	// S3 caps multipart uploads at 10,000 parts
+	maxMultipartParts      = 10000
+	multipartPartURLExpiry = 15 * time.Minute
+	// Large uploads get a day to finish, matching the staging area's expiry rule
+	multipartUploadExpiry = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidPartNumber is returned for part numbers outside 1 to the upload's total parts
+	ErrInvalidPartNumber = errors.New("part number out of range")
+	// ErrMultipartIncomplete is returned when completing before every part was uploaded and reported
+	ErrMultipartIncomplete = errors.New("not all parts have been uploaded")
+	// ErrMultipartMismatch is returned when the parts in storage don't add up to the declared
+	// upload. The upload is discarded.
+	ErrMultipartMismatch = errors.New("uploaded parts do not match the declared upload")
+)
+
+// MultipartUploadResponse identifies a multipart upload session
+type MultipartUploadResponse struct {
+	UploadID   uuid.UUID `json:"upload_id"`
+	TotalParts int       `json:"total_parts"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// StartMultipartUpload starts a server-hashed upload that the client sends in parts,
+// for files too large for a single PUT. The declared size is reserved against the
+// user's quota like any server-hashed upload.
+func (s *FileService) StartMultipartUpload(ctx context.Context, userID, filename string, size int64, mimeType string, totalParts int) (*MultipartUploadResponse, error) {
+	if totalParts < 1 || totalParts > maxMultipartParts {
+		return nil, ErrInvalidPartNumber
+	}
+	if err := s.userService.CheckFileCountQuota(userID); err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New()
+	objectKey := s.storage.StagingKey(userID, sessionID.String())
+	multipartUploadID, err := s.storage.NewMultipartUpload(ctx, objectKey, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.UploadSession{
+		ID:                sessionID,
+		UserID:            userID,
+		ObjectKey:         objectKey,
+		Filename:          filename,
+		MimeType:          mimeType,
+		ReservedBytes:     size,
+		Status:            models.UploadSessionPending,
+		ExpiresAt:         s.dbNow().Add(multipartUploadExpiry),
+		MultipartUploadID: multipartUploadID,
+		TotalParts:        totalParts,
+		CompletedParts:    models.IntList{},
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create upload session: %w", err)
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("storage_used", gorm.Expr("storage_used + ?", size)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultipartUploadResponse{
+		UploadID:   sessionID,
+		TotalParts: totalParts,
+		ExpiresAt:  session.ExpiresAt,
+	}, nil
+}
+
+func (s *FileService) getMultipartSession(ctx context.Context, userID string, uploadID uuid.UUID) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND expires_at > ? AND multipart_upload_id <> ''", uploadID, userID, s.dbNow()).
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	return &session, nil
+}
+
+// GetMultipartPartURL returns a presigned URL for uploading one part of the user's
+// multipart upload, valid for 15 minutes
+func (s *FileService) GetMultipartPartURL(ctx context.Context, userID string, uploadID uuid.UUID, partNumber int) (string, error) {
+	session, err := s.getMultipartSession(ctx, userID, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if partNumber < 1 || partNumber > session.TotalParts {
+		return "", ErrInvalidPartNumber
+	}
+
+	return s.storage.PresignPartUpload(ctx, session.ObjectKey, session.MultipartUploadID, partNumber, multipartPartURLExpiry)
+}
+
+// ReportMultipartPart records that the client finished uploading a part. Parts are
+// uploaded in parallel, so the number is appended in a single statement.
+func (s *FileService) ReportMultipartPart(ctx context.Context, userID string, uploadID uuid.UUID, partNumber int) error {
+	session, err := s.getMultipartSession(ctx, userID, uploadID)
+	if err != nil {
+		return err
+	}
+	if partNumber < 1 || partNumber > session.TotalParts {
+		return ErrInvalidPartNumber
+	}
+
+	err = s.db.WithContext(ctx).Model(&models.UploadSession{}).
+		Where("id = ? AND NOT (COALESCE(completed_parts, '[]'::jsonb) @> to_jsonb(?::int))", session.ID, partNumber).
+		Update("completed_parts", gorm.Expr("COALESCE(completed_parts, '[]'::jsonb) || to_jsonb(?::int)", partNumber)).Error
+	if err != nil {
+		return fmt.Errorf("failed to record uploaded part: %w", err)
+	}
+	return nil
+}
+
+// CompleteMultipartUpload assembles the parts once every one of them has been reported
+// and is present in storage, then completes the upload like any server-hashed upload.
+// Parts that don't add up to the declared count and size discard the upload.
+func (s *FileService) CompleteMultipartUpload(ctx context.Context, userID string, uploadID uuid.UUID) (*ServerHashUploadResult, error) {
+	session, err := s.getMultipartSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := make(map[int]bool, len(session.CompletedParts))
+	for _, part := range session.CompletedParts {
+		reported[part] = true
+	}
+	if len(reported) != session.TotalParts {
+		return nil, fmt.Errorf("%w: %d of %d parts reported", ErrMultipartIncomplete, len(reported), session.TotalParts)
+	}
+
+	parts, err := s.storage.ListUploadedParts(ctx, session.ObjectKey, session.MultipartUploadID)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < session.TotalParts {
+		return nil, fmt.Errorf("%w: storage has %d of %d parts", ErrMultipartIncomplete, len(parts), session.TotalParts)
+	}
+	if err := checkUploadedParts(*session, parts); err != nil {
+		s.discardUploadSession(ctx, *session)
+		return nil, err
+	}
+
+	if err := s.storage.CompleteMultipartUpload(ctx, session.ObjectKey, session.MultipartUploadID, parts); err != nil {
+		return nil, err
+	}
+
+	// Storage may assemble something other than what was listed if parts are replaced
+	// concurrently, so the object itself is checked before any records are created
+	assembled, err := s.storage.GetFileInfo(ctx, session.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAssembledObject(*session, assembled); err != nil {
+		// Nothing left to abort; discarding deletes the assembled object instead
+		completedAt := s.dbNow()
+		session.UploadedAt = &completedAt
+		s.discardUploadSession(ctx, *session)
+		return nil, err
+	}
+
+	// The object exists now; if completion fails below the cleanup worker finishes it
+	now := s.dbNow()
+	err = s.db.WithContext(ctx).Model(session).Updates(map[string]interface{}{
+		"status":      models.UploadSessionUploaded,
+		"uploaded_at": now,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update upload session: %w", err)
+	}
+
+	return s.completeUploadSession(ctx, *session)
+}
+
+// checkUploadedParts verifies storage holds exactly parts 1 to the declared total and that
+// together they're the declared size
+func checkUploadedParts(session models.UploadSession, parts []minio.ObjectPart) error {
+	if len(parts) != session.TotalParts {
+		return fmt.Errorf("%w: storage has %d parts, %d were declared", ErrMultipartMismatch, len(parts), session.TotalParts)
+	}
+
+	var total int64
+	for i, part := range parts {
+		if part.PartNumber != i+1 {
+			return fmt.Errorf("%w: expected part %d, storage has part %d", ErrMultipartMismatch, i+1, part.PartNumber)
+		}
+		total += part.Size
+	}
+	if total != session.ReservedBytes {
+		return fmt.Errorf("%w: parts total %d bytes, %d were declared", ErrUploadSizeMismatch, total, session.ReservedBytes)
+	}
+	return nil
+}
+
+// checkAssembledObject verifies the completed object is the declared size and that its
+// ETag, which for multipart objects ends in "-<part count>", names the declared parts
+func checkAssembledObject(session models.UploadSession, object *minio.ObjectInfo) error {
+	if object.Size != session.ReservedBytes {
+		return fmt.Errorf("%w: assembled object is %d bytes, %d were declared", ErrUploadSizeMismatch, object.Size, session.ReservedBytes)
+	}
+
+	etag := strings.Trim(object.ETag, `"`)
+	_, partCount, found := strings.Cut(etag, "-")
+	if !found || partCount != strconv.Itoa(session.TotalParts) {
+		return fmt.Errorf("%w: assembled object has ETag %q, expected one of %d parts", ErrMultipartMismatch, etag, session.TotalParts)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// startMultipartTest starts a multipart upload of size bytes in totalParts parts and
+// returns its session
+func startMultipartTest(t *testing.T, s *FileService, db *gorm.DB, userID string, size int64, totalParts int) models.UploadSession {
+	t.Helper()
+
+	response, err := s.StartMultipartUpload(context.Background(), userID, "video.mp4", size, "video/mp4", totalParts)
+	if err != nil {
+		t.Fatalf("StartMultipartUpload() error = %v", err)
+	}
+	var session models.UploadSession
+	if err := db.Where("id = ?", response.UploadID).First(&session).Error; err != nil {
+		t.Fatalf("failed to get upload session: %v", err)
+	}
+	return session
+}
+
+// uploadPart uploads content as one part through its presigned URL and reports it
+func uploadPart(t *testing.T, s *FileService, userID string, uploadID uuid.UUID, partNumber int, content []byte) {
+	t.Helper()
+
+	ctx := context.Background()
+	partURL, err := s.GetMultipartPartURL(ctx, userID, uploadID, partNumber)
+	if err != nil {
+		t.Fatalf("GetMultipartPartURL(%d) error = %v", partNumber, err)
+	}
+	putPresigned(t, partURL, content)
+	if err := s.ReportMultipartPart(ctx, userID, uploadID, partNumber); err != nil {
+		t.Fatalf("ReportMultipartPart(%d) error = %v", partNumber, err)
+	}
+}
+
+// checkDiscarded checks that a rejected upload's session and reservation are gone and
+// its object is queued for deletion
+func checkDiscarded(t *testing.T, s *FileService, db *gorm.DB, session models.UploadSession) {
+	t.Helper()
+
+	var sessions int64
+	db.Model(&models.UploadSession{}).Where("id = ?", session.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Error("upload session was kept, want it discarded")
+	}
+	if used := storageUsed(t, s, session.UserID); used != 0 {
+		t.Errorf("storage used = %d, want the reservation released", used)
+	}
+	var jobs int64
+	db.Model(&models.Job{}).Where("payload::text LIKE ?", "%"+session.ObjectKey+"%").Count(&jobs)
+	if jobs != 1 {
+		t.Errorf("%d deletion jobs for the staged object, want 1", jobs)
+	}
+}
+
+func TestMultipartUploadRejectsPartsOfTheWrongSize(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	// Both parts are uploaded, but they add up to less than was declared
+	session := startMultipartTest(t, s, db, userID, 30, 2)
+	uploadPart(t, s, userID, session.ID, 1, []byte("first part"))
+	uploadPart(t, s, userID, session.ID, 2, []byte("2nd part"))
+
+	if _, err := s.CompleteMultipartUpload(ctx, userID, session.ID); !errors.Is(err, ErrUploadSizeMismatch) {
+		t.Fatalf("CompleteMultipartUpload() error = %v, want %v", err, ErrUploadSizeMismatch)
+	}
+	if store.HasUpload(session.MultipartUploadID) {
+		t.Error("multipart upload is still in progress, want it aborted")
+	}
+	checkDiscarded(t, s, db, session)
+}
+
+func TestMultipartUploadRejectsAssembledObjectOfTheWrongSize(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	// The parts check out, but the object storage assembles is replaced before it's read
+	store.BeforeRequest = func(r *http.Request) {
+		if r.Method == http.MethodHead {
+			store.PutObject(strings.TrimPrefix(r.URL.Path, "/files/"), []byte("replaced"))
+		}
+	}
+
+	first, second := []byte("first part"), []byte("second part")
+	session := startMultipartTest(t, s, db, userID, int64(len(first)+len(second)), 2)
+	uploadPart(t, s, userID, session.ID, 1, first)
+	uploadPart(t, s, userID, session.ID, 2, second)
+
+	if _, err := s.CompleteMultipartUpload(ctx, userID, session.ID); !errors.Is(err, ErrUploadSizeMismatch) {
+		t.Fatalf("CompleteMultipartUpload() error = %v, want %v", err, ErrUploadSizeMismatch)
+	}
+	var files int64
+	db.Model(&models.UserFile{}).Where("user_id = ?", userID).Count(&files)
+	if files != 0 {
+		t.Errorf("%d files created from the mismatched object, want 0", files)
+	}
+	checkDiscarded(t, s, db, session)
+}
+
+func TestMultipartUploadWaitsForMissingParts(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	first, second := []byte("first part"), []byte("second part")
+	session := startMultipartTest(t, s, db, userID, int64(len(first)+len(second)), 2)
+	uploadPart(t, s, userID, session.ID, 1, first)
+
+	// Part 2 hasn't been reported
+	if _, err := s.CompleteMultipartUpload(ctx, userID, session.ID); !errors.Is(err, ErrMultipartIncomplete) {
+		t.Fatalf("CompleteMultipartUpload() with an unreported part error = %v, want %v", err, ErrMultipartIncomplete)
+	}
+
+	// Part 2 is reported but never reached storage
+	if err := s.ReportMultipartPart(ctx, userID, session.ID, 2); err != nil {
+		t.Fatalf("ReportMultipartPart(2) error = %v", err)
+	}
+	if _, err := s.CompleteMultipartUpload(ctx, userID, session.ID); !errors.Is(err, ErrMultipartIncomplete) {
+		t.Fatalf("CompleteMultipartUpload() with a part missing from storage error = %v, want %v", err, ErrMultipartIncomplete)
+	}
+
+	// Either way the upload can still be finished
+	if !store.HasUpload(session.MultipartUploadID) {
+		t.Fatal("multipart upload was aborted, want it kept for the missing part")
+	}
+	if used := storageUsed(t, s, userID); used != session.ReservedBytes {
+		t.Errorf("storage used = %d, want the %d declared bytes still reserved", used, session.ReservedBytes)
+	}
+	uploadPart(t, s, userID, session.ID, 2, second)
+	if _, err := s.CompleteMultipartUpload(ctx, userID, session.ID); err != nil {
+		t.Fatalf("CompleteMultipartUpload() once every part is uploaded error = %v", err)
+	}
+}
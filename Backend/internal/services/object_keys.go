@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	shardedKeyPrefix      = "files/"
+	keyMigrationBatchSize = 100
+)
+
+// shardedObjectKey spreads content across files/{hash[0:2]}/ prefixes
+func shardedObjectKey(hash string) string {
+	if len(hash) < 2 {
+		return shardedKeyPrefix + hash
+	}
+	return fmt.Sprintf("%s%s/%s", shardedKeyPrefix, hash[:2], hash)
+}
+
+// objectKeyForHash returns where new content with the given hash is stored
+func (s *FileService) objectKeyForHash(hash string) string {
+	if s.cfg.ShardObjectKeys {
+		return shardedObjectKey(hash)
+	}
+	return hash
+}
+
+// ErrInvalidObjectKey is returned when an upload is completed with a key outside the user's staging area
+var ErrInvalidObjectKey = errors.New("object key is not a staged upload for this user")
+
+// isOwnStagedObject reports whether key was issued to userID by one of the upload URL endpoints
+func (s *FileService) isOwnStagedObject(userID, key string) bool {
+	return strings.HasPrefix(key, s.storage.StagingKey(userID, "")) && !strings.Contains(key, "..")
+}
+
+// resolveObjectKey returns the key to serve a file from. While objects are being
+// moved to sharded keys, a record may briefly point at a key whose object has
+// already moved (or not yet), so the other layout is tried as a fallback.
+func (s *FileService) resolveObjectKey(ctx context.Context, fileHash models.FileHash) string {
+	if !s.keyMigrationActive.Load() {
+		return fileHash.MinIOKey
+	}
+
+	if _, err := s.storage.GetFileInfo(ctx, fileHash.MinIOKey); err == nil {
+		return fileHash.MinIOKey
+	}
+
+	alternate := shardedObjectKey(fileHash.Hash)
+	if fileHash.MinIOKey == alternate {
+		alternate = fileHash.Hash
+	}
+	if _, err := s.storage.GetFileInfo(ctx, alternate); err == nil {
+		return alternate
+	}
+
+	return fileHash.MinIOKey
+}
+
+// StartObjectKeyMigration moves objects stored at the bucket root to sharded keys in
+// the background: server-side copy, update FileHash.MinIOKey, then delete the old object.
+func (s *FileService) StartObjectKeyMigration(ctx context.Context) {
+	s.keyMigrationActive.Store(true)
+
+	go func() {
+		defer s.keyMigrationActive.Store(false)
+
+		migrated := 0
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var batch []models.FileHash
+			err := s.db.Where("min_io_key NOT LIKE ?", shardedKeyPrefix+"%").
+				Order("hash").
+				Limit(keyMigrationBatchSize).
+				Find(&batch).Error
+			if err != nil {
+				log.Printf("Object key migration stopped: failed to load batch: %v", err)
+				return
+			}
+			if len(batch) == 0 {
+				break
+			}
+
+			moved := 0
+			for _, fileHash := range batch {
+				if err := s.migrateObjectKey(ctx, fileHash); err != nil {
+					log.Printf("Failed to migrate object %s: %v", fileHash.MinIOKey, err)
+					continue
+				}
+				moved++
+			}
+			migrated += moved
+
+			// Every object in the batch failed; retrying would loop forever
+			if moved == 0 {
+				log.Printf("Object key migration stopped after %d objects: no progress in last batch", migrated)
+				return
+			}
+		}
+
+		if migrated > 0 {
+			log.Printf("Object key migration complete: %d objects moved to sharded keys", migrated)
+		}
+	}()
+}
+
+func (s *FileService) migrateObjectKey(ctx context.Context, fileHash models.FileHash) error {
+	newKey := shardedObjectKey(fileHash.Hash)
+	if err := s.storage.CopyObject(ctx, fileHash.MinIOKey, newKey); err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.FileHash{}).
+			Where("hash = ? AND min_io_key = ?", fileHash.Hash, fileHash.MinIOKey).
+			Update("min_io_key", newKey)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update object key: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			// The record changed or was deleted meanwhile; leave the old object to the orphan scan
+			return nil
+		}
+
+		return s.deletionQueue.EnqueueTx(tx, DeleteObjectJob{ObjectKey: fileHash.MinIOKey})
+	})
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"mime"
+	"net/url"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+)
+
+func TestPresignedDownloadKeepsFilename(t *testing.T) {
+	minioStorage, _ := newFakeStorage(t, nil)
+	s := &FileService{storage: minioStorage}
+
+	for _, tc := range []struct {
+		filename    string
+		mimeType    string
+		contentType string
+	}{
+		{"quarterly report.pdf", "application/pdf", "application/pdf"},
+		{"invoice, march.csv", "text/csv", "text/csv"},
+		{`the "final" draft.txt`, "text/plain", "text/plain"},
+		{"会议记录.txt", "text/plain", "text/plain"},
+		// A generic stored type is derived from the name instead
+		{"photo.png", "application/octet-stream", "image/png"},
+	} {
+		t.Run(tc.filename, func(t *testing.T) {
+			userFile := models.UserFile{
+				Filename: tc.filename,
+				FileData: models.FileHash{MinIOKey: "files/ab/abcdef", MimeType: tc.mimeType},
+			}
+			downloadURL, err := s.presignedDownloadURL(context.Background(), userFile, time.Hour)
+			if err != nil {
+				t.Fatalf("presignedDownloadURL() error = %v", err)
+			}
+			parsed, err := url.Parse(downloadURL)
+			if err != nil {
+				t.Fatalf("failed to parse download URL: %v", err)
+			}
+			query := parsed.Query()
+
+			_, params, err := mime.ParseMediaType(query.Get("response-content-disposition"))
+			if err != nil {
+				t.Fatalf("response-content-disposition = %q doesn't parse: %v", query.Get("response-content-disposition"), err)
+			}
+			if params["filename"] != tc.filename {
+				t.Errorf("saved filename = %q, want %q", params["filename"], tc.filename)
+			}
+			if got := query.Get("response-content-type"); got != tc.contentType {
+				t.Errorf("response-content-type = %q, want %q", got, tc.contentType)
+			}
+		})
+	}
+}
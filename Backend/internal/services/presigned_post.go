@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// PresignedPostResponse describes an HTML form upload. The form must POST to URL
+// with every entry of Fields followed by the file itself as the last field.
+type PresignedPostResponse struct {
+	URL          string            `json:"url"`
+	Fields       map[string]string `json:"fields"`
+	UploadToken  string            `json:"upload_token,omitempty"` // Pass back on completion
+	ExpiresAt    time.Time         `json:"expires_at"`
+	IsDuplicate  bool              `json:"is_duplicate"`
+	ExistingFile *models.UserFile  `json:"existing_file,omitempty"`
+	Dedup        *DedupStats       `json:"dedup,omitempty"`
+}
+
+// GeneratePresignedPostURL is the form POST counterpart of GeneratePresignedUploadURL.
+// The signed policy pins the object key, caps the body at the declared size and
+// requires the form's Content-Type to match the declared MIME type.
+func (s *FileService) GeneratePresignedPostURL(ctx context.Context, userID, filename, fileHash, secondaryHash string, size int64, mimeType string) (*PresignedPostResponse, error) {
+	if err := s.checkBannedHash(fileHash); err != nil {
+		return nil, err
+	}
+
+	if err := s.userService.CheckFileCountQuota(userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkStorageFor(ctx, userID, fileHash, size); err != nil {
+		return nil, err
+	}
+
+	// Check if file already exists (deduplication)
+	existing, err := s.lookupExistingHashes(ctx, []string{fileHash})
+	if err != nil {
+		return nil, err
+	}
+	if existingFileHash, ok := existing[fileHash]; ok && s.canLinkExisting(existingFileHash, secondaryHash) {
+		userFile, dedup, err := s.linkDuplicateUpload(ctx, userID, filename, existingFileHash, UploadOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		return &PresignedPostResponse{
+			Fields:       map[string]string{},
+			IsDuplicate:  true,
+			ExistingFile: userFile,
+			Dedup:        dedup,
+		}, nil
+	}
+
+	stagedKey := s.storage.StagingKey(userID, uuid.New().String())
+
+	policy, err := s.storage.GetPresignedPostPolicy(ctx, stagedKey, size, time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build upload policy: %w", err)
+	}
+	if err := policy.SetContentType(mimeType); err != nil {
+		return nil, fmt.Errorf("failed to set upload content type: %w", err)
+	}
+
+	url, fields, err := s.storage.PresignPostPolicy(ctx, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
+	}
+
+	return &PresignedPostResponse{
+		URL:         url,
+		Fields:      fields,
+		UploadToken: s.issueUploadToken(userID, stagedKey, fileHash, size, UploadOptions{}),
+		ExpiresAt:   time.Now().UTC().Add(time.Hour),
+		IsDuplicate: false,
+	}, nil
+}
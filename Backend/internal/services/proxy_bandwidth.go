@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrProxyBandwidthExceeded is returned when a file owner has streamed their monthly
+// cap through the server. Presigned downloads aren't affected.
+var ErrProxyBandwidthExceeded = errors.New("monthly proxied download bandwidth exceeded")
+
+// UserListEntry is a user in the admin listing along with this month's proxied bytes
+type UserListEntry struct {
+	models.User
+	ProxiedBytesThisMonth int64 `json:"proxied_bytes_this_month"`
+}
+
+// RecordProxiedBytes adds bytes the server streamed for one of the user's files to this
+// month's counter
+func (s *UserService) RecordProxiedBytes(userID string, bytes int64) error {
+	if bytes <= 0 {
+		return nil
+	}
+
+	usage := models.UserBandwidth{UserID: userID, Month: currentBandwidthPeriod(), BytesServed: bytes, UpdatedAt: time.Now().UTC()}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "month"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes_served": gorm.Expr("user_bandwidth.bytes_served + ?", bytes),
+			"updated_at":   usage.UpdatedAt,
+		}),
+	}).Create(&usage).Error
+	if err != nil {
+		return fmt.Errorf("failed to record proxied bandwidth: %w", err)
+	}
+	return nil
+}
+
+// GetProxiedBytes returns the bytes streamed through the server for the user's files
+// this month
+func (s *UserService) GetProxiedBytes(userID string) (int64, error) {
+	var usage models.UserBandwidth
+	err := s.db.Where("user_id = ? AND month = ?", userID, currentBandwidthPeriod()).First(&usage).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get proxied bandwidth: %w", err)
+	}
+	return usage.BytesServed, nil
+}
+
+// ProxyBandwidthCap returns the configured monthly proxied bandwidth cap in bytes (0 = no cap)
+func (s *UserService) ProxyBandwidthCap() int64 {
+	return s.cfg.ProxyBandwidthCapMB * 1024 * 1024
+}
+
+// CheckProxyBandwidth rejects streaming another of the user's files once they've
+// reached the monthly cap. The stream that crosses the cap is allowed to finish.
+func (s *UserService) CheckProxyBandwidth(userID string) error {
+	limit := s.ProxyBandwidthCap()
+	if limit == 0 {
+		return nil
+	}
+
+	used, err := s.GetProxiedBytes(userID)
+	if err != nil {
+		return err
+	}
+	if used >= limit {
+		return fmt.Errorf("%w: used %d of %d bytes", ErrProxyBandwidthExceeded, used, limit)
+	}
+	return nil
+}
+
+// proxiedBytesFor returns this month's proxied bytes for each of the users that has any
+func (s *UserService) proxiedBytesFor(userIDs []string) (map[string]int64, error) {
+	var usage []models.UserBandwidth
+	err := s.db.Where("user_id IN ? AND month = ?", userIDs, currentBandwidthPeriod()).Find(&usage).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get proxied bandwidth: %w", err)
+	}
+
+	byUser := make(map[string]int64, len(usage))
+	for _, entry := range usage {
+		byUser[entry.UserID] = entry.BytesServed
+	}
+	return byUser, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	publicExpiryCheckInterval = time.Minute
+	// Files reverted per pass; the rest are picked up on the next tick
+	publicExpiryBatchSize = 100
+)
+
+// ErrInvalidPublicUntil is returned for a public_until in the past or on a toggle that
+// makes the file private
+var ErrInvalidPublicUntil = errors.New("public_until must be in the future and only applies when making a file public")
+
+// PublicSecondsRemaining returns how long a file stays public under its schedule, or
+// nil when it isn't scheduled to go private
+func PublicSecondsRemaining(publicUntil *time.Time) *int64 {
+	if publicUntil == nil {
+		return nil
+	}
+	remaining := max(int64(time.Until(*publicUntil).Seconds()), 0)
+	return &remaining
+}
+
+// StartPublicExpiryWorker makes files private again once their public_until passes
+func (s *FileService) StartPublicExpiryWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(publicExpiryCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.revertExpiredPublicFiles(ctx)
+			}
+		}
+	}()
+}
+
+func (s *FileService) revertExpiredPublicFiles(ctx context.Context) {
+	var expired []models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").
+		Where("is_public = ? AND public_until <= ?", true, time.Now().UTC()).
+		Limit(publicExpiryBatchSize).
+		Find(&expired).Error
+	if err != nil {
+		slog.Error("public_expiry_list_failed", slog.Any("error", err))
+		return
+	}
+
+	for _, userFile := range expired {
+		if err := s.revertPublicFile(ctx, userFile); err != nil {
+			slog.Error("public_expiry_revert_failed", slog.String("file_id", userFile.ID.String()), slog.Any("error", err))
+		}
+	}
+}
+
+// revertPublicFile makes a file private for its expired schedule. A toggle since the
+// file was loaded bumps the schedule generation, which cancels the reversion.
+func (s *FileService) revertPublicFile(ctx context.Context, userFile models.UserFile) error {
+	reverted := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserFile{}).
+			Where("id = ? AND is_public = ? AND public_schedule_generation = ?", userFile.ID, true, userFile.PublicScheduleGeneration).
+			Updates(map[string]interface{}{
+				"is_public":                  false,
+				"public_until":               nil,
+				"public_schedule_generation": gorm.Expr("public_schedule_generation + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		reverted = true
+		return tx.Where("user_file_id = ?", userFile.ID).Delete(&models.ShareLink{}).Error
+	})
+	if err != nil || !reverted {
+		return err
+	}
+	s.forgetSharedFile(userFile.ID)
+
+	// The file is already private in the database, which is what downloads check
+	if err := s.storage.RemoveObjectTags(ctx, userFile.FileData.MinIOKey); err != nil {
+		slog.Warn("object_tag_update_failed", slog.String("object_key", userFile.FileData.MinIOKey), slog.Bool("public", false), slog.Any("error", err))
+	}
+
+	slog.Info("public_window_expired", slog.String("file_id", userFile.ID.String()), slog.String("user_id", userFile.UserID))
+	s.RecordActivity(models.UserActivity{UserID: userFile.UserID, Action: models.ActivityVisibilityChange, FileID: &userFile.ID, Filename: userFile.Filename, Details: "private"})
+	return nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const directReferrer = "direct"
+
+// PublicFileStats summarizes anonymous downloads of a public file
+type PublicFileStats struct {
+	TotalDownloads      int            `json:"total_downloads"`
+	UniqueIPs           int            `json:"unique_ips"`
+	DownloadsLast7Days  int            `json:"downloads_last_7_days"`
+	DownloadsLast30Days int            `json:"downloads_last_30_days"`
+	ReferrerBreakdown   map[string]int `json:"referrer_breakdown"`
+	CountryBreakdown    map[string]int `json:"country_breakdown"`
+}
+
+// RecordPublicDownload logs an anonymous download for the owner's link analytics.
+// The client IP is stored hashed; only its country is kept in the clear.
+func (s *FileService) RecordPublicDownload(fileID uuid.UUID, clientIP, referrer string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		event := models.FileDownloadEvent{
+			ID:           uuid.New(),
+			UserFileID:   fileID,
+			IPHash:       s.hashClientIP(clientIP),
+			ReferrerHost: referrerHost(referrer),
+			Country:      s.geoIP.Country(ctx, clientIP),
+			CreatedAt:    time.Now().UTC(),
+		}
+		if err := s.db.Create(&event).Error; err != nil {
+			slog.Warn("download_event_record_failed", slog.String("file_id", fileID.String()), slog.Any("error", err))
+		}
+	}()
+}
+
+// ipHashKey returns the configured key for hashing client IPs, or a random one when
+// none is set
+func ipHashKey(cfg *config.Config) []byte {
+	if cfg.IPHashSecret != "" {
+		return []byte(cfg.IPHashSecret)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate IP hash key: %v", err))
+	}
+	log.Println("IP_HASH_SECRET is not set; using a random key, so stored IP hashes won't match after a restart")
+	return key
+}
+
+// hashClientIP keys the hash with a server secret; the IPv4 space is small enough
+// that a plain hash could be reversed by trying every address
+func (s *FileService) hashClientIP(clientIP string) string {
+	mac := hmac.New(sha256.New, s.ipHashKey)
+	mac.Write([]byte(clientIP))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GetFilePublicStats returns download analytics for one of the user's files. Private
+// files get empty stats instead of an error so the response doesn't reveal visibility.
+func (s *FileService) GetFilePublicStats(userID string, fileID uuid.UUID) (*PublicFileStats, error) {
+	stats := &PublicFileStats{
+		ReferrerBreakdown: map[string]int{},
+		CountryBreakdown:  map[string]int{},
+	}
+
+	var userFile models.UserFile
+	err := s.db.Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
+	if err != nil {
+		return nil, fmt.Errorf("file not found or access denied: %w", err)
+	}
+
+	if !userFile.IsPublic {
+		return stats, nil
+	}
+
+	var totals struct {
+		Total     int
+		UniqueIPs int
+		Last7     int
+		Last30    int
+	}
+	now := time.Now().UTC()
+	err = s.db.Model(&models.FileDownloadEvent{}).Where("user_file_id = ?", fileID).Select(
+		"COUNT(*) AS total, COUNT(DISTINCT ip_hash) AS unique_ips, "+
+			"COUNT(*) FILTER (WHERE created_at >= ?) AS last7, "+
+			"COUNT(*) FILTER (WHERE created_at >= ?) AS last30",
+		now.AddDate(0, 0, -7), now.AddDate(0, 0, -30),
+	).Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get download totals: %w", err)
+	}
+
+	stats.TotalDownloads = totals.Total
+	stats.UniqueIPs = totals.UniqueIPs
+	stats.DownloadsLast7Days = totals.Last7
+	stats.DownloadsLast30Days = totals.Last30
+
+	var breakdown []struct {
+		Key   string
+		Count int
+	}
+
+	err = s.db.Model(&models.FileDownloadEvent{}).Where("user_file_id = ?", fileID).
+		Select("referrer_host AS key, COUNT(*) AS count").Group("referrer_host").Scan(&breakdown).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referrer breakdown: %w", err)
+	}
+	for _, row := range breakdown {
+		stats.ReferrerBreakdown[row.Key] = row.Count
+	}
+
+	breakdown = nil
+	err = s.db.Model(&models.FileDownloadEvent{}).Where("user_file_id = ?", fileID).
+		Select("country AS key, COUNT(*) AS count").Group("country").Scan(&breakdown).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get country breakdown: %w", err)
+	}
+	for _, row := range breakdown {
+		stats.CountryBreakdown[row.Key] = row.Count
+	}
+
+	return stats, nil
+}
+
+// referrerHost reduces a Referer header to its host so paths and query strings aren't stored
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	parsed, err := url.Parse(referrer)
+	if err != nil || parsed.Host == "" {
+		return directReferrer
+	}
+	return parsed.Hostname()
+}
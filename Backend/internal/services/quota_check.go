@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Per-file outcomes of an upload quota check
+const (
+	QuotaCheckUploadRequired = "upload_required"
+	QuotaCheckDuplicate      = "duplicate"
+	QuotaCheckBanned         = "banned"
+)
+
+// QuotaCheckFile is a file the client intends to upload
+type QuotaCheckFile struct {
+	Size     int64  `json:"size"`
+	FileHash string `json:"file_hash"`
+}
+
+type QuotaCheckFileStatus struct {
+	FileHash string `json:"file_hash"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status"` // "upload_required", "duplicate" or "banned"
+
+	// What the file adds to the user's usage
+	charge int64
+}
+
+// QuotaCheckResult tells whether a set of uploads fits in the user's remaining quota.
+// Banned content costs nothing. Other files cost what they'd add to the user's usage
+// under the quota mode, the same as single uploads: under deduplicated quotas content
+// the user already holds, or that repeats within the set, costs nothing.
+type QuotaCheckResult struct {
+	RequiredBytes  int64                  `json:"required_bytes"`
+	AvailableBytes int64                  `json:"available_bytes"`
+	QuotaBytes     int64                  `json:"quota_bytes"`
+	UsedBytes      int64                  `json:"used_bytes"`
+	Fits           bool                   `json:"fits"`
+	Files          []QuotaCheckFileStatus `json:"files"`
+
+	existingHashes map[string]models.FileHash
+}
+
+// CheckUploadQuota previews the storage a set of uploads would need. It only reads,
+// so clients can call it whenever their file selection changes.
+func (s *FileService) CheckUploadQuota(ctx context.Context, userID string, files []QuotaCheckFile) (*QuotaCheckResult, error) {
+	fileHashes := make([]string, len(files))
+	for i, file := range files {
+		fileHashes[i] = file.FileHash
+	}
+
+	bannedHashes, err := s.bannedHashSet(fileHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.lookupExistingHashes(ctx, fileHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	held := map[string]bool{}
+	if !s.logicalQuota() {
+		if held, err = s.heldHashes(ctx, userID, fileHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &QuotaCheckResult{
+		Files:          make([]QuotaCheckFileStatus, 0, len(files)),
+		existingHashes: existing,
+	}
+
+	counted := make(map[string]bool)
+	for _, file := range files {
+		status := QuotaCheckUploadRequired
+		if bannedHashes[strings.ToLower(file.FileHash)] {
+			status = QuotaCheckBanned
+		} else if _, ok := result.existingHashes[file.FileHash]; ok {
+			status = QuotaCheckDuplicate
+		}
+
+		var charge int64
+		if status != QuotaCheckBanned && (s.logicalQuota() || !held[file.FileHash] && !counted[file.FileHash]) {
+			counted[file.FileHash] = true
+			charge = file.Size
+			result.RequiredBytes += charge
+		}
+
+		result.Files = append(result.Files, QuotaCheckFileStatus{
+			FileHash: file.FileHash,
+			Size:     file.Size,
+			Status:   status,
+			charge:   charge,
+		})
+	}
+
+	quota, used, err := s.storageUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+	result.QuotaBytes = quota
+	result.UsedBytes = used
+	if used < quota {
+		result.AvailableBytes = quota - used
+	}
+	result.Fits = result.RequiredBytes <= result.AvailableBytes
+
+	return result, nil
+}
+
+// storageUsage returns the user's quota (the default quota for users not created yet)
+// and their storage usage, as kept for the quota mode and enforced by CheckStorageQuota
+func (s *FileService) storageUsage(userID string) (int64, int64, error) {
+	var user models.User
+	err := s.db.Select("storage_quota", "storage_used").Where("id = ?", userID).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return s.cfg.DefaultStorageQuotaMB * 1024 * 1024, 0, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("failed to get user storage usage: %w", err)
+	}
+	return user.StorageQuota, user.StorageUsed, nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// A user's storage usage depends on the quota mode. Under deduplicated quotas each piece
+// of content they hold counts once, however many of their files point at it; under
+// logical quotas every file counts its full size. Either way, content shared with other
+// users counts for each of them. storage_used is kept in step in the same transaction
+// that stores or removes a file.
+
+// lacksContent matches users with no file of the given hash
+const lacksContent = "NOT EXISTS (SELECT 1 FROM user_files WHERE user_files.user_id = users.id AND user_files.file_hash = ? AND user_files.deleted_at IS NULL)"
+
+// logicalQuota reports whether every file counts toward usage at its full size
+func (s *FileService) logicalQuota() bool {
+	return s.cfg.QuotaMode == config.QuotaModeLogical
+}
+
+// chargeStoredFile adds a new file of the content to its owner's usage. It runs before
+// the file's record is written, so only the owner's other files are looked at.
+func (s *FileService) chargeStoredFile(tx *gorm.DB, userID, hash string) error {
+	query := tx.Model(&models.User{}).Where("id = ?", userID)
+	if !s.logicalQuota() {
+		query = query.Where(lacksContent, hash)
+	}
+	err := query.Update("storage_used", gorm.Expr("storage_used + COALESCE((SELECT size FROM file_hashes WHERE hash = ?), 0)", hash)).Error
+	if err != nil {
+		return fmt.Errorf("failed to charge storage usage: %w", err)
+	}
+	return nil
+}
+
+// releaseStoredFiles takes files of the content off their owner's usage. It runs after
+// their records are gone and before the content's hash record is deleted.
+func (s *FileService) releaseStoredFiles(tx *gorm.DB, userID, hash string, files int) error {
+	query := tx.Model(&models.User{}).Where("id = ?", userID)
+	if !s.logicalQuota() {
+		// The content only stops counting once the last of the user's files is gone
+		query = query.Where(lacksContent, hash)
+		files = 1
+	}
+	err := query.Update("storage_used", gorm.Expr("GREATEST(storage_used - ? * COALESCE((SELECT size FROM file_hashes WHERE hash = ?), 0), 0)", files, hash)).Error
+	if err != nil {
+		return fmt.Errorf("failed to release storage usage: %w", err)
+	}
+	return nil
+}
+
+// heldHashes returns which of the hashes the user already has a file of
+func (s *FileService) heldHashes(ctx context.Context, userID string, hashes []string) (map[string]bool, error) {
+	var held []string
+	err := s.db.WithContext(ctx).Model(&models.UserFile{}).
+		Where("user_id = ? AND file_hash IN ?", userID, hashes).
+		Distinct().Pluck("file_hash", &held).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check held content: %w", err)
+	}
+
+	result := make(map[string]bool, len(held))
+	for _, hash := range held {
+		result[hash] = true
+	}
+	return result, nil
+}
+
+// checkStorageFor checks that another file of the content fits in the user's quota. It
+// adds nothing to their usage under deduplicated quotas when they already hold the
+// content, and then passes even for a user over quota.
+func (s *FileService) checkStorageFor(ctx context.Context, userID, hash string, size int64) error {
+	if !s.logicalQuota() {
+		held, err := s.heldHashes(ctx, userID, []string{hash})
+		if err != nil {
+			return err
+		}
+		if held[hash] {
+			return nil
+		}
+	}
+	return s.userService.CheckStorageQuota(userID, size)
+}
+
+// QuotaMode returns how files sharing content count toward storage usage
+func (s *UserService) QuotaMode() string {
+	return s.cfg.QuotaMode
+}
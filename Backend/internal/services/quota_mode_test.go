@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestDuplicateUploadAtQuota(t *testing.T) {
+	db := testDB(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{}, &models.UserActivity{}, &models.BannedHash{})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		quotaMode string
+		wantErr   error
+	}{
+		// Content the user already holds adds nothing to their usage
+		{config.QuotaModeDeduplicated, nil},
+		// Every file counts at its full size
+		{config.QuotaModeLogical, ErrStorageQuotaExceeded},
+	} {
+		t.Run(tc.quotaMode, func(t *testing.T) {
+			cfg := &config.Config{QuotaMode: tc.quotaMode}
+			s := &FileService{db: db, cfg: cfg, userService: &UserService{db: db, cfg: cfg}}
+
+			userID := "quota-user-" + uuid.New().String()
+			sum := sha256.Sum256([]byte(userID))
+			hash := hex.EncodeToString(sum[:])
+			t.Cleanup(func() {
+				db.Where("user_id = ?", userID).Delete(&models.UserActivity{})
+				db.Unscoped().Where("user_id = ?", userID).Delete(&models.UserFile{})
+				db.Where("hash = ?", hash).Delete(&models.FileHash{})
+				db.Where("id = ?", userID).Delete(&models.User{})
+			})
+
+			// The user's one file fills their quota exactly
+			if err := db.Create(&models.User{ID: userID, StorageQuota: 100, StorageUsed: 100}).Error; err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+			if err := db.Create(&models.FileHash{Hash: hash, MinIOKey: hash, Size: 100, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+				t.Fatalf("failed to create file hash: %v", err)
+			}
+			if err := db.Create(&models.UserFile{UserID: userID, FileHash: hash, Filename: "report.txt"}).Error; err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+
+			response, err := s.GeneratePresignedUploadURL(ctx, userID, "report copy.txt", hash, "", 100, "text/plain", UploadOptions{IsPublic: new(bool)})
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("GeneratePresignedUploadURL() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GeneratePresignedUploadURL() error = %v, want the copy linked at quota", err)
+			}
+			if !response.IsDuplicate || response.ExistingFile == nil {
+				t.Fatalf("GeneratePresignedUploadURL() = %+v, want the content linked without an upload", response)
+			}
+
+			var user models.User
+			if err := db.Select("storage_used").Where("id = ?", userID).First(&user).Error; err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if user.StorageUsed != 100 {
+				t.Errorf("storage used = %d after linking held content, want 100", user.StorageUsed)
+			}
+		})
+	}
+}
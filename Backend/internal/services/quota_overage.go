@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const quotaOverageCheckInterval = time.Hour
+
+// OverageState describes a user's use of the over-quota grace allowance
+type OverageState struct {
+	InOverage     bool       `json:"in_overage"`
+	Blocked       bool       `json:"blocked"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	GraceEndsAt   *time.Time `json:"grace_ends_at,omitempty"`
+	GraceCapBytes int64      `json:"grace_cap_bytes"`
+}
+
+// graceCap is the most a user may store while in overage
+func (s *UserService) graceCap(quota int64) int64 {
+	if s.cfg.QuotaGracePercent <= 0 {
+		return quota
+	}
+	return quota + quota*int64(s.cfg.QuotaGracePercent)/100
+}
+
+func (s *UserService) graceWindow() time.Duration {
+	return time.Duration(s.cfg.QuotaGraceDays) * 24 * time.Hour
+}
+
+// withinOverageGrace reports whether an upload that takes the user over quota is
+// still allowed by the grace percentage and period
+func (s *UserService) withinOverageGrace(user models.User, additionalSize int64) bool {
+	if user.OverageBlocked || s.cfg.QuotaGracePercent <= 0 || s.cfg.QuotaGraceDays <= 0 {
+		return false
+	}
+	if user.StorageUsed+additionalSize > s.graceCap(user.StorageQuota) {
+		return false
+	}
+	return user.OverageStartedAt == nil || time.Since(*user.OverageStartedAt) < s.graceWindow()
+}
+
+// GetOverageState returns the user's overage state for the storage info endpoint
+func (s *UserService) GetOverageState(userID string) (*OverageState, error) {
+	var user models.User
+	err := s.db.Select("storage_quota", "storage_used", "overage_started_at", "overage_blocked").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user storage info: %w", err)
+	}
+
+	state := &OverageState{
+		InOverage:     user.StorageUsed > user.StorageQuota,
+		Blocked:       user.OverageBlocked,
+		StartedAt:     user.OverageStartedAt,
+		GraceCapBytes: s.graceCap(user.StorageQuota),
+	}
+	if user.OverageStartedAt != nil {
+		graceEndsAt := user.OverageStartedAt.Add(s.graceWindow())
+		state.GraceEndsAt = &graceEndsAt
+	}
+	return state, nil
+}
+
+// StartQuotaOverageWorker starts the grace period of users who went over quota, blocks
+// uploads for users whose grace period ran out or whose usage passed the grace cap, and
+// clears the state of users back under quota. A grace period starts at the first check
+// after the user went over, so it can run up to quotaOverageCheckInterval long.
+func (s *UserService) StartQuotaOverageWorker(ctx context.Context) {
+	if s.cfg.QuotaGracePercent <= 0 || s.cfg.QuotaGraceDays <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(quotaOverageCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			s.checkQuotaOverage()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *UserService) checkQuotaOverage() {
+	result := s.db.Model(&models.User{}).
+		Where("storage_used <= storage_quota AND (overage_started_at IS NOT NULL OR overage_blocked = ?)", true).
+		Updates(map[string]interface{}{"overage_started_at": nil, "overage_blocked": false})
+	if result.Error != nil {
+		log.Printf("Failed to clear resolved storage overages: %v", result.Error)
+	}
+
+	started := s.db.Model(&models.User{}).
+		Where("storage_used > storage_quota AND overage_started_at IS NULL").
+		Update("overage_started_at", time.Now().UTC())
+	if started.Error != nil {
+		log.Printf("Failed to start storage overage grace periods: %v", started.Error)
+	}
+
+	graceExpired := time.Now().UTC().Add(-s.graceWindow())
+	capExpr := "storage_quota + storage_quota * ? / 100"
+
+	var users []models.User
+	err := s.db.Select("id", "storage_quota", "storage_used").
+		Where("storage_used > storage_quota AND overage_blocked = ?", false).
+		Where("overage_started_at <= ? OR storage_used > "+capExpr, graceExpired, s.cfg.QuotaGracePercent).
+		Find(&users).Error
+	if err != nil {
+		log.Printf("Failed to list expired storage overages: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			blocked := tx.Model(&models.User{}).Where("id = ? AND overage_blocked = ?", user.ID, false).
+				Update("overage_blocked", true)
+			if blocked.Error != nil || blocked.RowsAffected == 0 {
+				return blocked.Error
+			}
+			return tx.Create(&models.UserActivity{
+				UserID:  user.ID,
+				Action:  models.ActivityQuotaOverage,
+				Details: "Storage is over quota; uploads are blocked until you free up space",
+			}).Error
+		})
+		if err != nil {
+			log.Printf("Failed to block uploads for user %s over quota: %v", user.ID, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestStorageQuotaCheckDoesNotStartOverage(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.UserActivity{})
+	s := &UserService{db: tx, cfg: &config.Config{QuotaGracePercent: 10, QuotaGraceDays: 7}}
+
+	userID := "overage-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID, StorageQuota: 1000, StorageUsed: 950}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	overageStartedAt := func() *models.User {
+		var user models.User
+		if err := tx.Select("overage_started_at", "overage_blocked").Where("id = ?", userID).First(&user).Error; err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+		return &user
+	}
+
+	// Within the grace cap, but the upload may still fail or be abandoned
+	if err := s.CheckStorageQuota(userID, 100); err != nil {
+		t.Fatalf("CheckStorageQuota() error = %v, want the upload allowed within grace", err)
+	}
+	if user := overageStartedAt(); user.OverageStartedAt != nil {
+		t.Errorf("overage_started_at = %v after a quota check, want it unset until storage is charged", user.OverageStartedAt)
+	}
+
+	// Once charged, the overage worker starts the grace period
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("storage_used", 1050).Error; err != nil {
+		t.Fatalf("failed to charge storage: %v", err)
+	}
+	s.checkQuotaOverage()
+	user := overageStartedAt()
+	if user.OverageStartedAt == nil {
+		t.Error("overage_started_at is unset after the worker ran for a user over quota")
+	}
+	if user.OverageBlocked {
+		t.Error("user was blocked as soon as the grace period started")
+	}
+}
@@ -1,18 +1,65 @@
 package services
 
 import (
+	"context"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"filevault-backend/internal/config"
+	"filevault-backend/internal/metrics"
 
 	"golang.org/x/time/rate"
 )
 
+var rateLimitHits = metrics.NewCounterVec(
+	"filevault_rate_limit_hits_total",
+	"Requests rejected by the API rate limiter",
+	"policy",
+)
+
+// How often rate limit overrides are reloaded, picking up changes made on other instances
+const rateLimitOverrideRefreshInterval = time.Minute
+
+const (
+	// Per-identifier hit counts cover at most this window before starting over
+	rateLimitStatsWindow = time.Hour
+	// GetStats returns at most this many identifiers
+	rateLimitStatsTop = 50
+)
+
+// RateLimitOverride replaces the configured rate limit for a single identifier
+type RateLimitOverride struct {
+	PerSecond float64 `json:"per_second"`
+	BurstSize int     `json:"burst_size"`
+}
+
+// RateLimitStat is how many requests from one identifier were rejected in the current window
+type RateLimitStat struct {
+	Identifier string `json:"identifier"`
+	Hits       int64  `json:"hits"`
+}
+
+type atomicHitCounter struct {
+	hits atomic.Int64
+}
+
 type RateLimitService struct {
-	cfg      *config.Config
-	limiters map[string]*rate.Limiter
-	mu       sync.RWMutex
+	policy string
+	limits func(cfg *config.Config) (perSecond float64, burstSize int)
+
+	// Rejections per identifier (user ID or IP) since the last hourly reset
+	hits sync.Map
+	stop chan struct{}
+
+	enabled   bool
+	perSecond float64
+	burstSize int
+	limiters  map[string]*rate.Limiter
+	overrides map[string]RateLimitOverride
+	mu        sync.RWMutex
 }
 
 type RateLimitResult struct {
@@ -22,28 +69,185 @@ type RateLimitResult struct {
 }
 
 func NewRateLimitService(cfg *config.Config) *RateLimitService {
-	return &RateLimitService{
-		cfg:      cfg,
-		limiters: make(map[string]*rate.Limiter),
+	return newRateLimitService(cfg, "api", func(cfg *config.Config) (float64, int) {
+		return cfg.RateLimitPerSecond, cfg.RateLimitBurstSize
+	})
+}
+
+// NewPublicRateLimitService creates the stricter limiter for unauthenticated
+// /public routes, which are the ones worth probing for file IDs
+func NewPublicRateLimitService(cfg *config.Config) *RateLimitService {
+	return newRateLimitService(cfg, "public", func(cfg *config.Config) (float64, int) {
+		return cfg.PublicRateLimitPerSecond, cfg.PublicRateLimitBurstSize
+	})
+}
+
+func newRateLimitService(cfg *config.Config, policy string, limits func(cfg *config.Config) (float64, int)) *RateLimitService {
+	perSecond, burstSize := limits(cfg)
+	s := &RateLimitService{
+		policy:    policy,
+		limits:    limits,
+		stop:      make(chan struct{}),
+		enabled:   cfg.RateLimitEnabled,
+		perSecond: perSecond,
+		burstSize: burstSize,
+		limiters:  make(map[string]*rate.Limiter),
+		overrides: make(map[string]RateLimitOverride),
 	}
+	go s.resetHitsPeriodically()
+	return s
 }
 
 func (s *RateLimitService) Close() {
+	close(s.stop)
+}
+
+func (s *RateLimitService) resetHitsPeriodically() {
+	ticker := time.NewTicker(rateLimitStatsWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.hits.Range(func(key, _ any) bool {
+				s.hits.Delete(key)
+				return true
+			})
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// RecordHit counts a rejected request against its identifier
+func (s *RateLimitService) RecordHit(identifier string) {
+	rateLimitHits.Inc(s.policy)
+
+	counter, ok := s.hits.Load(identifier)
+	if !ok {
+		counter, _ = s.hits.LoadOrStore(identifier, &atomicHitCounter{})
+	}
+	counter.(*atomicHitCounter).hits.Add(1)
+}
+
+// GetStats returns the identifiers rejected most often in the current hour, most
+// hits first
+func (s *RateLimitService) GetStats() []RateLimitStat {
+	stats := []RateLimitStat{}
+	s.hits.Range(func(key, value any) bool {
+		stats = append(stats, RateLimitStat{
+			Identifier: key.(string),
+			Hits:       value.(*atomicHitCounter).hits.Load(),
+		})
+		return true
+	})
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Hits != stats[j].Hits {
+			return stats[i].Hits > stats[j].Hits
+		}
+		return stats[i].Identifier < stats[j].Identifier
+	})
+	if len(stats) > rateLimitStatsTop {
+		stats = stats[:rateLimitStatsTop]
+	}
+	return stats
+}
+
+// Reconfigure applies new rate limit settings. Existing limiters are dropped so every
+// client starts over with a full bucket at the new rate.
+func (s *RateLimitService) Reconfigure(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	perSecond, burstSize := s.limits(cfg)
+	log.Printf("Rate limit (%s) reconfigured: enabled %t -> %t, per second %.2f -> %.2f, burst %d -> %d",
+		s.policy, s.enabled, cfg.RateLimitEnabled, s.perSecond, perSecond, s.burstSize, burstSize)
+
+	s.enabled = cfg.RateLimitEnabled
+	s.perSecond = perSecond
+	s.burstSize = burstSize
+	s.limiters = make(map[string]*rate.Limiter)
+}
+
+// SetOverride applies a rate limit override for one identifier, or removes it when
+// override is nil. The identifier's current limiter is dropped so it takes effect
+// on the next request.
+func (s *RateLimitService) SetOverride(identifier string, override *RateLimitOverride) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if override == nil {
+		delete(s.overrides, identifier)
+	} else {
+		s.overrides[identifier] = *override
+	}
+	delete(s.limiters, identifier)
+}
+
+// StartOverrideRefreshWorker loads rate limit overrides now and then periodically, so
+// overrides changed by another instance are picked up without a restart
+func (s *RateLimitService) StartOverrideRefreshWorker(ctx context.Context, load func() (map[string]RateLimitOverride, error)) {
+	go func() {
+		ticker := time.NewTicker(rateLimitOverrideRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			overrides, err := load()
+			if err != nil {
+				log.Printf("Failed to load rate limit overrides: %v", err)
+			} else {
+				s.replaceOverrides(overrides)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// replaceOverrides swaps in a fresh set of overrides, dropping the limiters of any
+// identifier whose override changed
+func (s *RateLimitService) replaceOverrides(overrides map[string]RateLimitOverride) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for identifier, old := range s.overrides {
+		if current, ok := overrides[identifier]; !ok || current != old {
+			delete(s.limiters, identifier)
+		}
+	}
+	for identifier := range overrides {
+		if _, ok := s.overrides[identifier]; !ok {
+			delete(s.limiters, identifier)
+		}
+	}
+	s.overrides = overrides
 }
 
 func (s *RateLimitService) CheckRateLimit(identifier string) *RateLimitResult {
-	if !s.cfg.RateLimitEnabled {
+	s.mu.RLock()
+	enabled := s.enabled
+	s.mu.RUnlock()
+
+	if !enabled {
 		return &RateLimitResult{Allowed: true, Remaining: 999, ResetTime: time.Now().Add(time.Second)}
 	}
 
 	limiter := s.getLimiter(identifier)
 	allowed := limiter.Allow()
+	if !allowed {
+		s.RecordHit(identifier)
+	}
 	remaining := int(limiter.TokensAt(time.Now()))
 	if remaining < 0 {
 		remaining = 0
 	}
 
-	resetTime := time.Now().Add(time.Duration(float64(time.Second) / s.cfg.RateLimitPerSecond))
+	resetTime := time.Now().Add(time.Duration(float64(time.Second) / float64(limiter.Limit())))
 
 	return &RateLimitResult{
 		Allowed:   allowed,
@@ -69,8 +273,12 @@ func (s *RateLimitService) getLimiter(identifier string) *rate.Limiter {
 		return limiter
 	}
 
-	// Create new limiter with the configured rate
-	limiter = rate.NewLimiter(rate.Limit(s.cfg.RateLimitPerSecond), s.cfg.RateLimitBurstSize)
+	// Create new limiter with the identifier's override or the configured rate
+	perSecond, burstSize := s.perSecond, s.burstSize
+	if override, ok := s.overrides[identifier]; ok {
+		perSecond, burstSize = override.PerSecond, override.BurstSize
+	}
+	limiter = rate.NewLimiter(rate.Limit(perSecond), burstSize)
 	s.limiters[identifier] = limiter
 	return limiter
 }
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// allowedRequests counts how many of n back-to-back requests the limiter lets through
+func allowedRequests(s *RateLimitService, identifier string, n int) int {
+	allowed := 0
+	for range n {
+		if s.CheckRateLimit(identifier).Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestReconfigureAppliesNewLimits(t *testing.T) {
+	s := NewRateLimitService(&config.Config{RateLimitEnabled: true, RateLimitPerSecond: 0.001, RateLimitBurstSize: 2})
+	defer s.Close()
+
+	if allowed := allowedRequests(s, "client", 10); allowed != 2 {
+		t.Fatalf("allowed %d of 10 requests with burst 2, want 2", allowed)
+	}
+
+	// e.g. SIGHUP after raising RATE_LIMIT_BURST_SIZE
+	s.Reconfigure(&config.Config{RateLimitEnabled: true, RateLimitPerSecond: 0.001, RateLimitBurstSize: 5})
+	if allowed := allowedRequests(s, "client", 10); allowed != 5 {
+		t.Errorf("allowed %d of 10 requests after raising the burst to 5, want 5", allowed)
+	}
+
+	s.Reconfigure(&config.Config{RateLimitEnabled: false})
+	if allowed := allowedRequests(s, "client", 10); allowed != 10 {
+		t.Errorf("allowed %d of 10 requests with rate limiting disabled, want 10", allowed)
+	}
+}
+
+func TestRateLimitOverrideAppliesWithoutRestart(t *testing.T) {
+	s := NewRateLimitService(&config.Config{RateLimitEnabled: true, RateLimitPerSecond: 0.001, RateLimitBurstSize: 2})
+	defer s.Close()
+
+	if allowed := allowedRequests(s, "integration-user", 10); allowed != 2 {
+		t.Fatalf("allowed %d of 10 requests at the default burst, want 2", allowed)
+	}
+
+	// An admin raises the user's limit mid-window
+	s.SetOverride("integration-user", &RateLimitOverride{PerSecond: 0.001, BurstSize: 6})
+	if allowed := allowedRequests(s, "integration-user", 10); allowed != 6 {
+		t.Errorf("allowed %d of 10 requests after the override, want 6", allowed)
+	}
+	if allowed := allowedRequests(s, "other-user", 10); allowed != 2 {
+		t.Errorf("another user got %d of 10 requests, want the default 2", allowed)
+	}
+
+	// Clearing the override falls back to the default
+	s.SetOverride("integration-user", nil)
+	if allowed := allowedRequests(s, "integration-user", 10); allowed != 2 {
+		t.Errorf("allowed %d of 10 requests after clearing the override, want 2", allowed)
+	}
+}
+
+func TestRateLimitOverrideRefreshPicksUpChanges(t *testing.T) {
+	s := NewRateLimitService(&config.Config{RateLimitEnabled: true, RateLimitPerSecond: 0.001, RateLimitBurstSize: 2})
+	defer s.Close()
+
+	allowedRequests(s, "changed", 10)
+	allowedRequests(s, "unchanged", 10)
+	s.replaceOverrides(map[string]RateLimitOverride{"unchanged": {PerSecond: 0.001, BurstSize: 2}})
+	allowedRequests(s, "unchanged", 10)
+
+	// Another instance changed one user's override
+	s.replaceOverrides(map[string]RateLimitOverride{
+		"changed":   {PerSecond: 0.001, BurstSize: 4},
+		"unchanged": {PerSecond: 0.001, BurstSize: 2},
+	})
+	if allowed := allowedRequests(s, "changed", 10); allowed != 4 {
+		t.Errorf("allowed %d of 10 requests after a refreshed override, want 4", allowed)
+	}
+	// An unchanged override keeps its exhausted limiter
+	if allowed := allowedRequests(s, "unchanged", 10); allowed != 0 {
+		t.Errorf("allowed %d requests for an identifier whose override didn't change, want 0", allowed)
+	}
+}
+
+func TestRateLimitOverrideIsStoredAndCleared(t *testing.T) {
+	tx := testTx(t, &models.User{})
+	s := NewUserService(tx, &config.Config{})
+
+	userID := "override-user-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: userID}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	override := RateLimitOverride{PerSecond: 50, BurstSize: 100}
+	if err := s.UpdateRateLimitOverride(userID, &override); err != nil {
+		t.Fatalf("UpdateRateLimitOverride() error = %v", err)
+	}
+	overrides, err := s.GetRateLimitOverrides()
+	if err != nil {
+		t.Fatalf("GetRateLimitOverrides() error = %v", err)
+	}
+	if got, ok := overrides[userID]; !ok || got != override {
+		t.Errorf("stored override = %+v, %v, want %+v", got, ok, override)
+	}
+
+	if err := s.UpdateRateLimitOverride(userID, nil); err != nil {
+		t.Fatalf("UpdateRateLimitOverride(nil) error = %v", err)
+	}
+	overrides, err = s.GetRateLimitOverrides()
+	if err != nil {
+		t.Fatalf("GetRateLimitOverrides() error = %v", err)
+	}
+	if _, ok := overrides[userID]; ok {
+		t.Error("override is still listed after being cleared")
+	}
+
+	if err := s.UpdateRateLimitOverride("missing", &override); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateRateLimitOverride(missing) error = %v, want ErrUserNotFound", err)
+	}
+}
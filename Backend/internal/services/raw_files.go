@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// RawPublicFile is a public file looked up for streaming its content
+type RawPublicFile struct {
+	File               *models.UserFile
+	ContentDisposition string
+	ContentType        string
+}
+
+// GetRawPublicFile looks up a public file to stream. It is shown inline, so it can be
+// embedded in other sites, unless download is set.
+func (s *FileService) GetRawPublicFile(ctx context.Context, fileID uuid.UUID, download bool) (*RawPublicFile, error) {
+	var userFile models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").Where("id = ? AND is_public = ?", fileID, true).First(&userFile).Error
+	if err != nil {
+		return nil, fmt.Errorf("public file not found: %w", err)
+	}
+
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	return &RawPublicFile{
+		File:               &userFile,
+		ContentDisposition: storage.ContentDisposition(disposition, userFile.Filename),
+		ContentType:        storage.ContentType(userFile.FileData.MimeType, userFile.Filename),
+	}, nil
+}
+
+// OpenPublicFileRange streams length bytes of a public file from offset. Only the
+// bytes served count against the owner's bandwidth, so a player seeking through a
+// video isn't charged the whole file per jump; the download itself is counted once,
+// by the request that starts at the beginning.
+func (s *FileService) OpenPublicFileRange(ctx context.Context, userFile *models.UserFile, offset, length int64) (io.ReadCloser, error) {
+	if err := s.userService.CheckBandwidthQuota(userFile.UserID, length); err != nil {
+		return nil, err
+	}
+	if err := s.userService.CheckProxyBandwidth(userFile.UserID); err != nil {
+		return nil, err
+	}
+
+	content, err := s.storage.GetObjectRange(ctx, s.resolveObjectKey(ctx, userFile.FileData), offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset == 0 {
+		go func() {
+			s.db.Model(userFile).Updates(downloadUpdates())
+		}()
+	} else {
+		go s.touchFile(userFile.ID)
+	}
+	s.recordBandwidth(userFile.UserID, length)
+
+	return content, nil
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HashMode selects who computes the content hash used for deduplication
+type HashMode string
+
+const (
+	HashModeClient HashMode = "client"
+	HashModeServer HashMode = "server"
+)
+
+// UploadPath tells the client how a server-hashed upload was stored
+type UploadPath string
+
+const (
+	UploadPathStored       UploadPath = "stored"
+	UploadPathDeduplicated UploadPath = "deduplicated"
+)
+
+const (
+	stagingUploadExpiry    = time.Hour
+	stagingCleanupInterval = 15 * time.Minute
+
+	// How long an uploaded session waits for its client to call complete before
+	// it's completed on the client's behalf
+	uploadAutoCompleteGrace = 10 * time.Minute
+	// Uploaded sessions that still can't be completed are discarded after this long
+	uploadedSessionRetention = 24 * time.Hour
+)
+
+// ErrUploadSessionNotFound is returned when a staged upload does not exist, has expired or belongs to another user
+var ErrUploadSessionNotFound = errors.New("upload session not found or expired")
+
+// ServerHashUploadResult describes the outcome of completing a server-hashed upload
+type ServerHashUploadResult struct {
+	File     *models.UserFile `json:"file"`
+	FileHash string           `json:"file_hash"`
+	Path     UploadPath       `json:"path"`
+	Dedup    *DedupStats      `json:"dedup,omitempty"`
+}
+
+// GenerateServerHashUploadURL issues an upload URL to a staging key for clients that
+// can't hash large files themselves. The declared size is reserved against the
+// user's quota and settled once the real outcome is known at completion.
+func (s *FileService) GenerateServerHashUploadURL(ctx context.Context, userID, filename string, size int64, mimeType string, opts UploadOptions) (*PresignedUploadResponse, error) {
+	if err := s.userService.CheckFileCountQuota(userID); err != nil {
+		return nil, err
+	}
+	// The content isn't known until it's hashed, so the full size has to fit
+	if err := s.userService.CheckStorageQuota(userID, size); err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New()
+	session := models.UploadSession{
+		ID:             sessionID,
+		UserID:         userID,
+		ObjectKey:      s.storage.StagingKey(userID, sessionID.String()),
+		Filename:       filename,
+		MimeType:       mimeType,
+		ReservedBytes:  size,
+		ConflictPolicy: string(opts.ConflictPolicy),
+		IsPublic:       opts.IsPublic,
+		Status:         models.UploadSessionPending,
+		ExpiresAt:      s.dbNow().Add(stagingUploadExpiry),
+	}
+
+	uploadURL, err := s.storage.GetUploadURL(ctx, session.ObjectKey, stagingUploadExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create upload session: %w", err)
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("storage_used", gorm.Expr("storage_used + ?", size)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUploadResponse{
+		UploadURL:   uploadURL,
+		ObjectKey:   session.ObjectKey,
+		ExpiresAt:   session.ExpiresAt,
+		IsDuplicate: false,
+		HashMode:    HashModeServer,
+		UploadID:    &sessionID,
+	}, nil
+}
+
+// CompleteServerHashUpload hashes the staged object, then either links the user to
+// existing content (discarding the staged copy) or moves it to its hash-keyed location.
+// Options left unset fall back to those given when the upload was prepared.
+func (s *FileService) CompleteServerHashUpload(ctx context.Context, userID string, uploadID uuid.UUID, opts UploadOptions) (*ServerHashUploadResult, error) {
+	// Once storage confirmed the object, the session outlives its upload URL until completed
+	var session models.UploadSession
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ? AND (expires_at > ? OR status = ?)", uploadID, userID, s.dbNow(), models.UploadSessionUploaded).
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	if opts.ConflictPolicy != "" {
+		session.ConflictPolicy = string(opts.ConflictPolicy)
+	}
+	if opts.IsPublic != nil {
+		session.IsPublic = opts.IsPublic
+	}
+
+	return s.completeUploadSession(ctx, session)
+}
+
+func (s *FileService) completeUploadSession(ctx context.Context, session models.UploadSession) (*ServerHashUploadResult, error) {
+	userID := session.UserID
+
+	fileInfo, err := s.storage.GetFileInfo(ctx, session.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	// The reservation was made for the declared size, so anything else can't be settled
+	if fileInfo.Size != session.ReservedBytes {
+		s.discardUploadSession(ctx, session)
+		return nil, fmt.Errorf("%w: uploaded object is %d bytes, %d were declared", ErrUploadSizeMismatch, fileInfo.Size, session.ReservedBytes)
+	}
+
+	fileHash, secondaryHash, err := s.hashObject(ctx, session.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	if !s.cfg.CollisionDetectionEnabled {
+		secondaryHash = ""
+	}
+
+	if err := s.checkBannedHash(fileHash); err != nil {
+		if errors.Is(err, ErrHashBanned) {
+			s.discardUploadSession(ctx, session)
+		}
+		return nil, err
+	}
+
+	result := &ServerHashUploadResult{FileHash: fileHash, Path: UploadPathStored}
+	afterCommit := func() {}
+	var storedKey string
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Claim the session first so a client completing while the cleanup worker
+		// auto-completes can't create the file twice
+		claimed := tx.Delete(&session)
+		if claimed.Error != nil {
+			return fmt.Errorf("failed to claim upload session: %w", claimed.Error)
+		}
+		if claimed.RowsAffected == 0 {
+			return ErrUploadSessionNotFound
+		}
+
+		var fileHashRecord models.FileHash
+		storedHash := fileHash
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ?", fileHash).First(&fileHashRecord).Error
+		if err == nil && s.isCollision(fileHashRecord, secondaryHash) {
+			// Different content with the same SHA-256 is stored independently
+			storedHash = secondaryHash
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ?", storedHash).First(&fileHashRecord).Error
+		}
+		if err == gorm.ErrRecordNotFound {
+			// Copied only once the lookup misses, so content whose last reference was
+			// just deleted is stored again rather than linked to a removed object. If
+			// another upload of the same content wins the race the copy just rewrites
+			// identical bytes.
+			finalKey := s.objectKeyForHash(storedHash)
+			if err := s.storage.CopyObject(ctx, session.ObjectKey, finalKey); err != nil {
+				return err
+			}
+			fileHashRecord = models.FileHash{
+				Hash:           storedHash,
+				SecondaryHash:  secondaryHash,
+				CollidesWith:   collidesWith(storedHash, fileHash),
+				Size:           fileInfo.Size,
+				MimeType:       session.MimeType,
+				ReferenceCount: 1,
+				MinIOKey:       finalKey,
+				CreatedAt:      time.Now().UTC(),
+				UpdatedAt:      time.Now().UTC(),
+			}
+			if err := tx.Create(&fileHashRecord).Error; err != nil {
+				return fmt.Errorf("failed to create file hash record: %w", err)
+			}
+			if err := s.thumbnails.EnqueueTx(tx, fileHashRecord); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to query file hash: %w", err)
+		} else {
+			if err := backfillSecondaryHash(tx, fileHashRecord, secondaryHash); err != nil {
+				return err
+			}
+
+			dedup, err := dedupStatsFor(tx, userID, fileHashRecord)
+			if err != nil {
+				return fmt.Errorf("failed to compute deduplication stats: %w", err)
+			}
+			if err := tx.Model(&fileHashRecord).Update("reference_count", gorm.Expr("reference_count + 1")).Error; err != nil {
+				return fmt.Errorf("failed to update reference count: %w", err)
+			}
+			result.Path = UploadPathDeduplicated
+			result.Dedup = dedup
+		}
+		storedKey = fileHashRecord.MinIOKey
+
+		userFile := models.UserFile{
+			ID:         uuid.New(),
+			UserID:     userID,
+			FileHash:   fileHashRecord.Hash,
+			Filename:   session.Filename,
+			IsPublic:   s.uploadVisibility(userID, session.IsPublic),
+			UploadedAt: time.Now().UTC(),
+			UpdatedAt:  time.Now().UTC(),
+		}
+		afterCommit, err = s.createUserFile(tx, &userFile, ConflictPolicy(session.ConflictPolicy))
+		if err != nil {
+			return err
+		}
+		result.File = &userFile
+
+		// Storing the file charged what it actually adds to the user's usage, so the
+		// reservation is released in full
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("storage_used", gorm.Expr("storage_used - ?", session.ReservedBytes)).Error; err != nil {
+			return fmt.Errorf("failed to settle storage usage: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	afterCommit()
+	s.tagPublicUpload(ctx, result.File, storedKey)
+
+	s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: session.ObjectKey})
+
+	s.RecordActivity(models.UserActivity{UserID: userID, Action: models.ActivityUpload, FileID: &result.File.ID, Filename: result.File.Filename})
+
+	return result, nil
+}
+
+// StartUploadSessionCleanupWorker completes uploads whose client never called complete and
+// releases quota held by staged uploads that never arrived
+func (s *FileService) StartUploadSessionCleanupWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(stagingCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.syncDBClock(ctx)
+				s.cleanupExpiredUploadSessions(ctx)
+			}
+		}
+	}()
+}
+
+func (s *FileService) cleanupExpiredUploadSessions(ctx context.Context) {
+	now := s.dbNow()
+
+	var stalled []models.UploadSession
+	err := s.db.Where("status = ? AND uploaded_at <= ?", models.UploadSessionUploaded, now.Add(-uploadAutoCompleteGrace)).
+		Find(&stalled).Error
+	if err != nil {
+		log.Printf("Failed to list uploaded sessions: %v", err)
+	}
+	for _, session := range stalled {
+		if _, err := s.completeUploadSession(ctx, session); err != nil {
+			log.Printf("Failed to auto-complete upload session %s: %v", session.ID, err)
+		}
+	}
+
+	s.cleanupExpiredBatchSessions()
+	s.pruneUsedUploadTokens(now)
+
+	var sessions []models.UploadSession
+	err = s.db.Where("expires_at <= ? AND (status = ? OR uploaded_at <= ?)", now, models.UploadSessionPending, now.Add(-uploadedSessionRetention)).
+		Find(&sessions).Error
+	if err != nil {
+		log.Printf("Failed to list expired upload sessions: %v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		s.discardUploadSession(ctx, session)
+	}
+}
+
+// discardUploadSession deletes a staged upload and returns its reserved quota
+func (s *FileService) discardUploadSession(ctx context.Context, session models.UploadSession) {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&session)
+		if result.Error != nil || result.RowsAffected == 0 {
+			// Already completed or discarded elsewhere
+			return result.Error
+		}
+		return tx.Model(&models.User{}).Where("id = ?", session.UserID).
+			Update("storage_used", gorm.Expr("storage_used - ?", session.ReservedBytes)).Error
+	})
+	if err != nil {
+		log.Printf("Failed to discard upload session %s: %v", session.ID, err)
+		return
+	}
+
+	if session.MultipartUploadID != "" && session.UploadedAt == nil {
+		if err := s.storage.AbortMultipartUpload(ctx, session.ObjectKey, session.MultipartUploadID); err != nil {
+			log.Printf("Failed to abort multipart upload for session %s: %v", session.ID, err)
+		}
+	}
+	s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: session.ObjectKey})
+}
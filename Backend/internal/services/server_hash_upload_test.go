@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/jobs"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage/storagetest"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// newUploadTestService returns a service storing content in fake storage, for tests
+// of uploads that run their own transactions
+func newUploadTestService(t *testing.T) (*FileService, *gorm.DB, *storagetest.Server) {
+	t.Helper()
+
+	db := testDB(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.ShareLink{}, &models.UserActivity{}, &models.BannedHash{},
+		&models.UploadSession{}, &models.Job{})
+	minioStorage, store := newFakeStorage(t, nil)
+	cfg := &config.Config{QuotaMode: config.QuotaModeDeduplicated}
+	s := &FileService{
+		db:            db,
+		cfg:           cfg,
+		storage:       minioStorage,
+		userService:   &UserService{db: db, cfg: cfg},
+		deletionQueue: NewDeletionQueue(db, minioStorage, jobs.NewRunner(db)),
+	}
+	return s, db, store
+}
+
+// createUploadTestUser creates a user with the given quota whose rows are removed when
+// the test ends
+func createUploadTestUser(t *testing.T, db *gorm.DB, quota int64) string {
+	t.Helper()
+
+	userID := "upload-user-" + uuid.New().String()
+	t.Cleanup(func() {
+		db.Where("user_id = ?", userID).Delete(&models.UserActivity{})
+		db.Where("hash IN (?)", db.Model(&models.UserFile{}).Unscoped().Select("file_hash").Where("user_id = ?", userID)).Delete(&models.FileHash{})
+		db.Unscoped().Where("user_id = ?", userID).Delete(&models.UserFile{})
+		db.Where("user_id = ?", userID).Delete(&models.UploadSession{})
+		db.Where("payload::text LIKE ?", "%"+userID+"%").Delete(&models.Job{})
+		db.Where("id = ?", userID).Delete(&models.User{})
+	})
+
+	if err := db.Create(&models.User{ID: userID, StorageQuota: quota, FileCountQuota: 100}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return userID
+}
+
+// putPresigned uploads content to a presigned URL as a client would
+func putPresigned(t *testing.T, uploadURL string, content []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("failed to build upload request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestServerHashUploadSettlesStoredContent(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	content := []byte("new content from " + userID)
+	size := int64(len(content))
+	response, err := s.GenerateServerHashUploadURL(ctx, userID, "notes.txt", size, "text/plain", UploadOptions{IsPublic: new(bool)})
+	if err != nil {
+		t.Fatalf("GenerateServerHashUploadURL() error = %v", err)
+	}
+	if used := storageUsed(t, s, userID); used != size {
+		t.Errorf("storage used = %d while uploading, want the %d declared bytes reserved", used, size)
+	}
+	putPresigned(t, response.UploadURL, content)
+
+	result, err := s.CompleteServerHashUpload(ctx, userID, *response.UploadID, UploadOptions{})
+	if err != nil {
+		t.Fatalf("CompleteServerHashUpload() error = %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if result.Path != UploadPathStored || result.FileHash != hash {
+		t.Errorf("CompleteServerHashUpload() = path %q, hash %q; want %q, %q", result.Path, result.FileHash, UploadPathStored, hash)
+	}
+	if stored, ok := store.Object(s.objectKeyForHash(hash)); !ok || !bytes.Equal(stored, content) {
+		t.Errorf("content at its hash key = %q, %v; want the uploaded content", stored, ok)
+	}
+	var fileHash models.FileHash
+	if err := db.Where("hash = ?", hash).First(&fileHash).Error; err != nil {
+		t.Fatalf("failed to get file hash: %v", err)
+	}
+	if fileHash.ReferenceCount != 1 || fileHash.Size != size {
+		t.Errorf("file hash = %d references of %d bytes, want 1 of %d", fileHash.ReferenceCount, fileHash.Size, size)
+	}
+	// The reservation is swapped for the charge of the new content
+	if used := storageUsed(t, s, userID); used != size {
+		t.Errorf("storage used = %d after storing, want %d", used, size)
+	}
+}
+
+func TestServerHashUploadSettlesDeduplicatedContent(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	// The user already holds the content, so under deduplicated quotas another copy adds nothing
+	content := []byte("held content from " + userID)
+	size := int64(len(content))
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	store.PutObject(s.objectKeyForHash(hash), content)
+	if err := db.Create(&models.FileHash{Hash: hash, MinIOKey: s.objectKeyForHash(hash), Size: size, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	if err := db.Create(&models.UserFile{UserID: userID, FileHash: hash, Filename: "original.txt"}).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Update("storage_used", size).Error; err != nil {
+		t.Fatalf("failed to set storage used: %v", err)
+	}
+
+	response, err := s.GenerateServerHashUploadURL(ctx, userID, "copy.txt", size, "text/plain", UploadOptions{IsPublic: new(bool)})
+	if err != nil {
+		t.Fatalf("GenerateServerHashUploadURL() error = %v", err)
+	}
+	if used := storageUsed(t, s, userID); used != 2*size {
+		t.Errorf("storage used = %d while uploading, want %d with the declared size reserved", used, 2*size)
+	}
+	putPresigned(t, response.UploadURL, content)
+
+	result, err := s.CompleteServerHashUpload(ctx, userID, *response.UploadID, UploadOptions{})
+	if err != nil {
+		t.Fatalf("CompleteServerHashUpload() error = %v", err)
+	}
+	if result.Path != UploadPathDeduplicated || result.Dedup == nil {
+		t.Errorf("CompleteServerHashUpload() = path %q, dedup %v; want %q with stats", result.Path, result.Dedup, UploadPathDeduplicated)
+	}
+
+	var fileHash models.FileHash
+	if err := db.Where("hash = ?", hash).First(&fileHash).Error; err != nil {
+		t.Fatalf("failed to get file hash: %v", err)
+	}
+	if fileHash.ReferenceCount != 2 {
+		t.Errorf("reference count = %d, want 2", fileHash.ReferenceCount)
+	}
+	// The reservation is released and the held content isn't charged again
+	if used := storageUsed(t, s, userID); used != size {
+		t.Errorf("storage used = %d after deduplicating, want %d", used, size)
+	}
+}
+
+func TestServerHashUploadRejectsUndeclaredSize(t *testing.T) {
+	s, db, store := newUploadTestService(t)
+	ctx := context.Background()
+	userID := createUploadTestUser(t, db, 1000)
+
+	content := []byte("more content than declared from " + userID)
+	response, err := s.GenerateServerHashUploadURL(ctx, userID, "notes.txt", 10, "text/plain", UploadOptions{IsPublic: new(bool)})
+	if err != nil {
+		t.Fatalf("GenerateServerHashUploadURL() error = %v", err)
+	}
+	putPresigned(t, response.UploadURL, content)
+
+	_, err = s.CompleteServerHashUpload(ctx, userID, *response.UploadID, UploadOptions{})
+	if !errors.Is(err, ErrUploadSizeMismatch) {
+		t.Fatalf("CompleteServerHashUpload() error = %v, want %v", err, ErrUploadSizeMismatch)
+	}
+
+	sum := sha256.Sum256(content)
+	if _, ok := store.Object(s.objectKeyForHash(hex.EncodeToString(sum[:]))); ok {
+		t.Error("content was stored at its hash key despite the size mismatch")
+	}
+	var sessions int64
+	db.Model(&models.UploadSession{}).Where("id = ?", *response.UploadID).Count(&sessions)
+	if sessions != 0 {
+		t.Error("upload session was kept after the size mismatch, want it discarded")
+	}
+	if used := storageUsed(t, s, userID); used != 0 {
+		t.Errorf("storage used = %d after the size mismatch, want the reservation released", used)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// StorageBreakdown splits a user's storage by top-level MIME type (image, video,
+// application, ...). Sizes aren't deduplicated, so they add up to original storage.
+type StorageBreakdown struct {
+	UserID     string              `json:"user_id"`
+	TotalBytes int64               `json:"total_bytes"`
+	Categories []CategoryBreakdown `json:"categories"`
+}
+
+// CategoryBreakdown is one top-level MIME type's share of a user's storage
+type CategoryBreakdown struct {
+	Category   string  `json:"category"`
+	FileCount  int64   `json:"file_count"`
+	TotalBytes int64   `json:"total_bytes"`
+	Percent    float64 `json:"percent"`
+}
+
+// ComputeStorageBreakdown groups a user's files by the type part of their MIME type,
+// largest first. Files without a usable MIME type are reported as "unknown".
+func (s *FileService) ComputeStorageBreakdown(userID string) (*StorageBreakdown, error) {
+	categories, err := computeStorageBreakdown(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := &StorageBreakdown{UserID: userID, Categories: categories}
+	for _, category := range categories {
+		breakdown.TotalBytes += category.TotalBytes
+	}
+	return breakdown, nil
+}
+
+func computeStorageBreakdown(db *gorm.DB, userID string) ([]CategoryBreakdown, error) {
+	categories := make([]CategoryBreakdown, 0)
+	err := db.Model(&models.UserFile{}).
+		Select("COALESCE(NULLIF(LOWER(SPLIT_PART(COALESCE(file_hashes.mime_type, ''), '/', 1)), ''), 'unknown') AS category, "+
+			"COUNT(*) AS file_count, COALESCE(SUM(file_hashes.size), 0) AS total_bytes").
+		Joins("JOIN file_hashes ON file_hashes.hash = user_files.file_hash").
+		Where("user_files.user_id = ?", userID).
+		Group("category").
+		Order("total_bytes DESC").
+		Scan(&categories).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute storage breakdown: %w", err)
+	}
+
+	var total int64
+	for _, category := range categories {
+		total += category.TotalBytes
+	}
+	if total > 0 {
+		for i := range categories {
+			categories[i].Percent = float64(categories[i].TotalBytes) / float64(total) * 100
+		}
+	}
+
+	return categories, nil
+}
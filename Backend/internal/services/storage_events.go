@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7/pkg/notification"
+	"gorm.io/gorm"
+)
+
+// StorageEventPayload is the body MinIO posts to webhook notification targets
+type StorageEventPayload = notification.Info
+
+// HandleStorageEvents marks upload sessions as uploaded when storage reports their
+// object was written. Events for objects without a pending session are ignored.
+func (s *FileService) HandleStorageEvents(ctx context.Context, payload StorageEventPayload) error {
+	now := s.dbNow()
+
+	for _, record := range payload.Records {
+		if !strings.HasPrefix(record.EventName, "s3:ObjectCreated:") {
+			continue
+		}
+
+		// Keys arrive URL-encoded
+		objectKey, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			log.Printf("Ignoring storage event with malformed key %q: %v", record.S3.Object.Key, err)
+			continue
+		}
+		if !s.storage.IsStagingKey(objectKey) {
+			continue
+		}
+
+		err = s.db.WithContext(ctx).Model(&models.UploadSession{}).
+			Where("object_key = ? AND status = ?", objectKey, models.UploadSessionPending).
+			Updates(map[string]interface{}{
+				"status":      models.UploadSessionUploaded,
+				"uploaded_at": now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to mark upload session uploaded: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// GetUploadSession returns one of the user's upload sessions so clients can poll
+// whether storage has received the object
+func (s *FileService) GetUploadSession(ctx context.Context, userID string, uploadID uuid.UUID) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", uploadID, userID).First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	// Without notifications the status only changes on completion, so check storage directly
+	if session.Status == models.UploadSessionPending {
+		if _, err := s.storage.GetFileInfo(ctx, session.ObjectKey); err == nil {
+			session.Status = models.UploadSessionUploaded
+		}
+	}
+
+	return &session, nil
+}
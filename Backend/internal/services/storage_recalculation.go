@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/jobs"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Maintenance jobs share one worker so they never compete with each other for the database
+	maintenanceQueueName     = "maintenance"
+	maintenanceWorkers       = 1
+	recalculationJobType     = "recalculate_storage"
+	recalculationBatchSize   = 200
+	recalculationMaxAttempts = 3
+	recalculationBackoff     = 10 * time.Second
+)
+
+// ErrRecalculationNotFound is returned for unknown storage recalculation runs
+var ErrRecalculationNotFound = errors.New("storage recalculation not found")
+
+// StorageUsageChange is a user's recorded storage usage before and after recalculating it
+type StorageUsageChange struct {
+	UserID    string `json:"user_id"`
+	QuotaMode string `json:"quota_mode"`
+	Before    int64  `json:"before"`
+	After     int64  `json:"after"`
+}
+
+// RecalculateStorageUsed recomputes the user's storage usage from their files under the
+// quota mode, plus what their staged uploads have reserved, and stores it. The user's
+// row is locked while counting: uploads and deletes adjust usage in the same
+// transaction as the file change, so they wait and then apply on top of the result.
+func (s *UserService) RecalculateStorageUsed(ctx context.Context, userID string) (*StorageUsageChange, error) {
+	change := &StorageUsageChange{UserID: userID, QuotaMode: s.cfg.QuotaMode}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "storage_used").Where("id = ?", userID).First(&user).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		change.Before = user.StorageUsed
+
+		if err := tx.Raw(s.storageUsageQuery(), userID, userID, userID).Scan(&change.After).Error; err != nil {
+			return fmt.Errorf("failed to calculate storage usage: %w", err)
+		}
+		if change.After == change.Before {
+			return nil
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update("storage_used", change.After).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if change.After != change.Before {
+		s.users.delete(userID)
+		slog.Info("storage_usage_recalculated", slog.String("user_id", userID),
+			slog.Int64("before", change.Before), slog.Int64("after", change.After))
+	}
+	return change, nil
+}
+
+// storageUsageQuery sums a user's files the way the quota mode counts them, plus the
+// reservations of their upload sessions and batches. It takes the user ID three times.
+func (s *UserService) storageUsageQuery() string {
+	files := `SELECT SUM(file_hashes.size) FROM file_hashes
+		WHERE file_hashes.hash IN (SELECT file_hash FROM user_files WHERE user_id = ? AND deleted_at IS NULL)`
+	if s.cfg.QuotaMode == config.QuotaModeLogical {
+		files = `SELECT SUM(file_hashes.size) FROM user_files JOIN file_hashes ON file_hashes.hash = user_files.file_hash
+			WHERE user_files.user_id = ? AND user_files.deleted_at IS NULL`
+	}
+	return `SELECT COALESCE((` + files + `), 0)
+		+ COALESCE((SELECT SUM(reserved_bytes) FROM upload_sessions WHERE user_id = ?), 0)
+		+ COALESCE((SELECT SUM(reserved_bytes) FROM batch_uploads WHERE user_id = ? AND reservation_charged), 0)`
+}
+
+// StorageRecalculator recalculates every user's storage usage in the background, a
+// batch of users per job, so a run survives restarts and reports its progress
+type StorageRecalculator struct {
+	db          *gorm.DB
+	userService *UserService
+	runner      *jobs.Runner
+}
+
+type recalculationJob struct {
+	RunID uuid.UUID `json:"run_id"`
+	// Recalculate users with IDs after this one
+	After string `json:"after"`
+}
+
+// NewStorageRecalculator registers recalculation jobs with the job runner, before it starts
+func NewStorageRecalculator(db *gorm.DB, userService *UserService, runner *jobs.Runner) *StorageRecalculator {
+	r := &StorageRecalculator{
+		db:          db,
+		userService: userService,
+		runner:      runner,
+	}
+
+	runner.AddQueue(maintenanceQueueName, maintenanceWorkers)
+	runner.Register(jobs.Type{
+		Name:   recalculationJobType,
+		Queue:  maintenanceQueueName,
+		Retry:  jobs.RetryPolicy{MaxAttempts: recalculationMaxAttempts, Backoff: recalculationBackoff},
+		Run:    r.run,
+		Failed: r.failed,
+	})
+	return r
+}
+
+// Start begins recalculating every user's storage usage. Only one run goes at a time;
+// while one is in progress it's returned instead and started is false.
+func (r *StorageRecalculator) Start(ctx context.Context, startedBy string) (run *models.StorageRecalculation, started bool, err error) {
+	run = &models.StorageRecalculation{}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", recalculationJobType).Error; err != nil {
+			return fmt.Errorf("failed to lock storage recalculation: %w", err)
+		}
+
+		err := tx.Where("status = ?", models.RecalculationRunning).First(run).Error
+		if err == nil {
+			return nil
+		} else if err != gorm.ErrRecordNotFound {
+			return fmt.Errorf("failed to check for a running storage recalculation: %w", err)
+		}
+
+		var totalUsers int64
+		if err := tx.Model(&models.User{}).Count(&totalUsers).Error; err != nil {
+			return fmt.Errorf("failed to count users: %w", err)
+		}
+		*run = models.StorageRecalculation{
+			ID:         uuid.New(),
+			StartedBy:  startedBy,
+			QuotaMode:  r.userService.QuotaMode(),
+			Status:     models.RecalculationRunning,
+			TotalUsers: totalUsers,
+			StartedAt:  time.Now().UTC(),
+		}
+		if err := tx.Create(run).Error; err != nil {
+			return fmt.Errorf("failed to create storage recalculation: %w", err)
+		}
+		started = true
+		return r.runner.EnqueueTx(tx, recalculationJobType, recalculationJob{RunID: run.ID})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return run, started, nil
+}
+
+// Get returns a storage recalculation run
+func (r *StorageRecalculator) Get(ctx context.Context, id uuid.UUID) (*models.StorageRecalculation, error) {
+	var run models.StorageRecalculation
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&run).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrRecalculationNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get storage recalculation: %w", err)
+	}
+	return &run, nil
+}
+
+// run recalculates the next batch of users, then records the progress and queues the
+// following batch in one transaction. A retried batch whose progress was already
+// recorded stops there, so the run can't fork into two chains of jobs.
+func (r *StorageRecalculator) run(ctx context.Context, payload json.RawMessage) error {
+	var job recalculationJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid storage recalculation job: %w", err)
+	}
+
+	var userIDs []string
+	err := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id > ?", job.After).
+		Order("id").
+		Limit(recalculationBatchSize).
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	var changed, adjusted int64
+	for _, userID := range userIDs {
+		change, err := r.userService.RecalculateStorageUsed(ctx, userID)
+		if errors.Is(err, ErrUserNotFound) {
+			// Deleted since the batch was listed
+			continue
+		} else if err != nil {
+			return err
+		}
+		if change.After != change.Before {
+			changed++
+			adjusted += change.After - change.Before
+		}
+	}
+
+	done := len(userIDs) < recalculationBatchSize
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"processed_users": gorm.Expr("processed_users + ?", len(userIDs)),
+			"changed_users":   gorm.Expr("changed_users + ?", changed),
+			"bytes_adjusted":  gorm.Expr("bytes_adjusted + ?", adjusted),
+		}
+		if done {
+			updates["status"] = models.RecalculationCompleted
+			updates["finished_at"] = time.Now().UTC()
+		} else {
+			updates["last_user_id"] = userIDs[len(userIDs)-1]
+		}
+
+		result := tx.Model(&models.StorageRecalculation{}).
+			Where("id = ? AND status = ? AND last_user_id = ?", job.RunID, models.RecalculationRunning, job.After).
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record storage recalculation progress: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if done {
+			slog.Info("storage_recalculation_completed", slog.String("run_id", job.RunID.String()))
+			return nil
+		}
+		return r.runner.EnqueueTx(tx, recalculationJobType, recalculationJob{RunID: job.RunID, After: userIDs[len(userIDs)-1]})
+	})
+}
+
+// failed ends a run whose batch used up its attempts. Users already recalculated keep
+// their corrected usage, so starting a new run is safe.
+func (r *StorageRecalculator) failed(payload json.RawMessage, _ int, err error) {
+	var job recalculationJob
+	if json.Unmarshal(payload, &job) != nil {
+		return
+	}
+
+	updateErr := r.db.Model(&models.StorageRecalculation{}).
+		Where("id = ? AND status = ?", job.RunID, models.RecalculationRunning).
+		Updates(map[string]interface{}{
+			"status":      models.RecalculationFailed,
+			"error":       err.Error(),
+			"finished_at": time.Now().UTC(),
+		}).Error
+	if updateErr != nil {
+		slog.Error("storage_recalculation_fail_update_failed", slog.String("run_id", job.RunID.String()), slog.Any("error", updateErr))
+	}
+}
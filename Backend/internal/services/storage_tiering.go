@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	storageTieringInterval = 24 * time.Hour
+	// Most objects transitioned per run, so a large backlog is spread over several nights
+	storageTieringBatchSize = 500
+)
+
+// downloadUpdates counts a download of a file and records when it happened, which
+// storage tiering uses to find cold content. The row is written anyway, so the last
+// access time is set without the throttling touchFile applies.
+func downloadUpdates() map[string]interface{} {
+	now := time.Now().UTC()
+	return map[string]interface{}{
+		"download_count":   gorm.Expr("download_count + 1"),
+		"last_download_at": now,
+		"last_accessed_at": now,
+	}
+}
+
+// StartStorageTieringWorker moves cold content to the configured storage class once
+// at startup and then nightly. It does nothing when tiering is disabled.
+func (s *FileService) StartStorageTieringWorker(ctx context.Context) {
+	if !s.cfg.TieringEnabled {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(storageTieringInterval)
+		defer ticker.Stop()
+
+		for {
+			s.tierColdContent(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// tierColdContent transitions stored content that is older than the cold threshold
+// and that none of its files have downloaded since
+func (s *FileService) tierColdContent(ctx context.Context) {
+	storageClass := s.cfg.TieringStorageClass
+	cutoff := time.Now().UTC().AddDate(0, 0, -s.cfg.TieringColdAfterDays)
+
+	var cold []models.FileHash
+	err := s.db.WithContext(ctx).
+		Where("COALESCE(storage_class, '') <> ? AND reference_count > 0 AND created_at < ?", storageClass, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM user_files WHERE user_files.file_hash = file_hashes.hash AND user_files.last_download_at >= ?)", cutoff).
+		Order("created_at").
+		Limit(storageTieringBatchSize).
+		Find(&cold).Error
+	if err != nil {
+		log.Printf("Failed to find cold content for tiering: %v", err)
+		return
+	}
+
+	var transitioned int
+	var transitionedBytes int64
+	for _, fileHash := range cold {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := s.storage.TransitionObject(ctx, s.resolveObjectKey(ctx, fileHash), storageClass); err != nil {
+			log.Printf("Failed to move %s to storage class %s: %v", fileHash.Hash, storageClass, err)
+			continue
+		}
+		if err := s.db.Model(&fileHash).Update("storage_class", storageClass).Error; err != nil {
+			log.Printf("Failed to record storage class for %s: %v", fileHash.Hash, err)
+			continue
+		}
+		transitioned++
+		transitionedBytes += fileHash.Size
+	}
+
+	if transitioned > 0 {
+		log.Printf("Moved %d objects (%d bytes) to storage class %s", transitioned, transitionedBytes, storageClass)
+	}
+}
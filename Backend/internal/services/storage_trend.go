@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How often the daily snapshot worker checks whether today's snapshot was taken yet
+const dailySnapshotCheckInterval = time.Hour
+
+// StorageProjection extrapolates storage growth linearly from daily snapshots
+type StorageProjection struct {
+	GrowthBytesPerDay float64 `json:"growth_bytes_per_day"`
+	CapacityBytes     int64   `json:"capacity_bytes"`
+	// Nil when growth is zero or negative, or the capacity isn't configured
+	DaysUntilCapacity *float64 `json:"days_until_capacity"`
+}
+
+// StartDailySnapshotWorker records storage totals once per UTC day. It checks hourly,
+// so the snapshot is taken shortly after midnight and a restart can't skip a day.
+func (s *AdminService) StartDailySnapshotWorker(ctx context.Context) {
+	if !s.cfg.DailySnapshotEnabled {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(dailySnapshotCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.takeDailySnapshot(ctx); err != nil {
+				log.Printf("Failed to take daily storage snapshot: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *AdminService) takeDailySnapshot(ctx context.Context) error {
+	now := time.Now().UTC()
+	snapshot := models.DailyStorageSnapshot{
+		Date:      time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		CreatedAt: now,
+	}
+
+	var taken int64
+	if err := s.db.WithContext(ctx).Model(&models.DailyStorageSnapshot{}).Where("date = ?", snapshot.Date).Count(&taken).Error; err != nil {
+		return fmt.Errorf("failed to check daily snapshot: %w", err)
+	}
+	if taken > 0 {
+		return nil
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Count(&snapshot.TotalUsers).Error; err != nil {
+			return fmt.Errorf("failed to count users: %w", err)
+		}
+		if err := tx.Model(&models.UserFile{}).Count(&snapshot.TotalFiles).Error; err != nil {
+			return fmt.Errorf("failed to count files: %w", err)
+		}
+		if err := tx.Model(&models.FileHash{}).Select("COALESCE(SUM(size), 0)").Scan(&snapshot.TotalBytes).Error; err != nil {
+			return fmt.Errorf("failed to sum storage: %w", err)
+		}
+
+		// Another instance may have taken today's snapshot in the meantime
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshot).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Daily storage snapshot: %d bytes, %d files, %d users", snapshot.TotalBytes, snapshot.TotalFiles, snapshot.TotalUsers)
+	return nil
+}
+
+// GetStorageTrendReport returns up to the last days daily snapshots, oldest first
+func (s *AdminService) GetStorageTrendReport(days int) ([]models.DailyStorageSnapshot, error) {
+	var snapshots []models.DailyStorageSnapshot
+	err := s.db.Where("date > ?", time.Now().UTC().AddDate(0, 0, -days)).Order("date ASC").Find(&snapshots).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// ProjectStorageCapacity fits a least-squares line through the snapshots' total bytes
+// and estimates how many days remain until the configured capacity is reached
+func (s *AdminService) ProjectStorageCapacity(snapshots []models.DailyStorageSnapshot) StorageProjection {
+	projection := StorageProjection{CapacityBytes: s.cfg.StorageCapacityMB * 1024 * 1024}
+	if len(snapshots) < 2 {
+		return projection
+	}
+
+	first := snapshots[0].Date
+	n := float64(len(snapshots))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, snapshot := range snapshots {
+		x := snapshot.Date.Sub(first).Hours() / 24
+		y := float64(snapshot.TotalBytes)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return projection
+	}
+	projection.GrowthBytesPerDay = (n*sumXY - sumX*sumY) / denominator
+
+	if projection.CapacityBytes > 0 && projection.GrowthBytesPerDay > 0 {
+		remaining := float64(projection.CapacityBytes - snapshots[len(snapshots)-1].TotalBytes)
+		days := remaining / projection.GrowthBytesPerDay
+		if days < 0 {
+			days = 0
+		}
+		projection.DaysUntilCapacity = &days
+	}
+
+	return projection
+}
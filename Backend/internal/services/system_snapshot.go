@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const snapshotTimeout = 5 * time.Second
+
+// SystemSnapshot is a point-in-time view of the system's important counters for incident response
+type SystemSnapshot struct {
+	CapturedAt string `json:"captured_at"`
+
+	TotalUsers             int64 `json:"total_users"`
+	ActiveUsers24h         int64 `json:"active_users_24h"`
+	TotalFiles             int64 `json:"total_files"`
+	TotalStorageBytes      int64 `json:"total_storage_bytes"`
+	TotalDedupSavingsBytes int64 `json:"total_dedup_savings_bytes"`
+	PendingShareLinks      int64 `json:"pending_share_links"` // Share links still usable: their file is public
+	ActiveUploadSessions   int64 `json:"active_upload_sessions"`
+	FailedDeletionQueue    int64 `json:"failed_deletion_queue_size"`
+
+	RateLimitHitsSinceStart uint64 `json:"rate_limit_hit_count_since_start"`
+
+	// Stored bytes per storage class; content that was never tiered counts as STANDARD
+	StorageBytesByClass map[string]int64 `json:"storage_bytes_by_class"`
+
+	DBPoolStats  DBPoolStats  `json:"db_pool_stats"`
+	MinIOHealthy bool         `json:"minio_healthy"`
+	Runtime      RuntimeStats `json:"runtime"`
+}
+
+// DBPoolStats summarizes the database connection pool
+type DBPoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+}
+
+// RuntimeStats are Go runtime figures for the serving process
+type RuntimeStats struct {
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	GCPauseNsLast  uint64 `json:"gc_pause_ns_last"`
+}
+
+// GetSystemSnapshot collects the database counters in one read-only transaction so
+// they are consistent with each other. Every query shares a 5 second deadline.
+func (s *AdminService) GetSystemSnapshot(ctx context.Context) (*SystemSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	snapshot := &SystemSnapshot{
+		CapturedAt:              now.Format(time.RFC3339),
+		RateLimitHitsSinceStart: rateLimitHits.Total(),
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		counts := []struct {
+			name  string
+			query *gorm.DB
+			dest  *int64
+		}{
+			{"users", tx.Model(&models.User{}), &snapshot.TotalUsers},
+			{"active users", tx.Model(&models.UserActivity{}).Where("created_at > ?", now.Add(-24*time.Hour)).Distinct("user_id"), &snapshot.ActiveUsers24h},
+			{"files", tx.Model(&models.UserFile{}), &snapshot.TotalFiles},
+			{"share links", tx.Model(&models.ShareLink{}).Joins("JOIN user_files ON user_files.id = share_links.user_file_id").Where("user_files.is_public = ?", true), &snapshot.PendingShareLinks},
+			{"upload sessions", tx.Model(&models.UploadSession{}).Where("expires_at > ? OR status = ?", now, models.UploadSessionUploaded), &snapshot.ActiveUploadSessions},
+			{"failed deletions", tx.Model(&models.FailedDeletion{}), &snapshot.FailedDeletionQueue},
+		}
+		for _, count := range counts {
+			if err := count.query.Count(count.dest).Error; err != nil {
+				return fmt.Errorf("failed to count %s: %w", count.name, err)
+			}
+		}
+
+		var storage struct {
+			Total   int64
+			Savings int64
+		}
+		err := tx.Model(&models.FileHash{}).
+			Select("COALESCE(SUM(size), 0) AS total, COALESCE(SUM(size * GREATEST(reference_count - 1, 0)), 0) AS savings").
+			Scan(&storage).Error
+		if err != nil {
+			return fmt.Errorf("failed to sum storage: %w", err)
+		}
+		snapshot.TotalStorageBytes = storage.Total
+		snapshot.TotalDedupSavingsBytes = storage.Savings
+
+		var classes []struct {
+			StorageClass string
+			Total        int64
+		}
+		err = tx.Model(&models.FileHash{}).
+			Select("COALESCE(NULLIF(storage_class, ''), 'STANDARD') AS storage_class, SUM(size) AS total").
+			Group("COALESCE(NULLIF(storage_class, ''), 'STANDARD')").
+			Scan(&classes).Error
+		if err != nil {
+			return fmt.Errorf("failed to sum storage by class: %w", err)
+		}
+		snapshot.StorageBytesByClass = make(map[string]int64, len(classes))
+		for _, class := range classes {
+			snapshot.StorageBytesByClass[class.StorageClass] = class.Total
+		}
+
+		return nil
+	}, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+
+	if sqlDB, err := s.db.DB(); err == nil {
+		stats := sqlDB.Stats()
+		snapshot.DBPoolStats = DBPoolStats{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+		}
+	}
+
+	snapshot.MinIOHealthy = s.storage.Ping(ctx) == nil
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	snapshot.Runtime = RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		GCPauseNsLast:  memStats.PauseNs[(memStats.NumGC+255)%256],
+	}
+
+	return snapshot, nil
+}
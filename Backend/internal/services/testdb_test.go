@@ -0,0 +1,50 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB connects to the PostgreSQL database named by TEST_DATABASE_URL and migrates
+// the given models, skipping the test when no database is configured. Tests share the
+// database, so they work on rows with fresh IDs rather than expecting empty tables.
+func testDB(t testing.TB, tables ...interface{}) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get test database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// testTx is testDB inside a transaction that is rolled back when the test ends, for
+// tests that need tables to themselves
+func testTx(t testing.TB, tables ...interface{}) *gorm.DB {
+	t.Helper()
+
+	tx := testDB(t, tables...).Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin test transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers GIF decoding
+	"image/jpeg"
+	_ "image/png" // Registers PNG decoding
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/jobs"
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers WebP decoding
+	"gorm.io/gorm"
+)
+
+const (
+	// Thumbnails have their own queue so decoding large images can't hold up deletions
+	thumbnailQueueName   = "thumbnails"
+	thumbnailJobType     = "generate_thumbnail"
+	thumbnailWorkers     = 2
+	thumbnailMaxAttempts = 3
+	thumbnailBaseBackoff = 10 * time.Second
+
+	thumbnailKeyPrefix = "thumbnails/"
+	// Longest side of a generated thumbnail, in pixels
+	thumbnailMaxDimension = 256
+	thumbnailJPEGQuality  = 80
+	// Larger images are skipped: decoding holds the whole image in memory
+	thumbnailMaxSourceBytes  = 50 * 1024 * 1024
+	thumbnailMaxSourcePixels = 50_000_000
+)
+
+// Image types a thumbnail can be generated from, matching the registered decoders
+var thumbnailSourceTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ErrThumbnailNotFound is returned when a file has no thumbnail, either because it
+// isn't a supported image or because it hasn't been generated yet
+var ErrThumbnailNotFound = errors.New("thumbnail not found")
+
+// errThumbnailUnsupported marks content that will never get a thumbnail, so the job
+// isn't retried
+var errThumbnailUnsupported = errors.New("image can't be thumbnailed")
+
+// GenerateThumbnailJob renders a preview of stored image content
+type GenerateThumbnailJob struct {
+	Hash string `json:"hash"`
+}
+
+// ThumbnailQueue generates image thumbnails in the background, after the upload that
+// stored the content has committed
+type ThumbnailQueue struct {
+	db      *gorm.DB
+	storage *storage.MinIOStorage
+	runner  *jobs.Runner
+}
+
+// NewThumbnailQueue registers thumbnail generation with the job runner, before it starts
+func NewThumbnailQueue(db *gorm.DB, storage *storage.MinIOStorage, runner *jobs.Runner) *ThumbnailQueue {
+	q := &ThumbnailQueue{
+		db:      db,
+		storage: storage,
+		runner:  runner,
+	}
+
+	runner.AddQueue(thumbnailQueueName, thumbnailWorkers)
+	runner.Register(jobs.Type{
+		Name:  thumbnailJobType,
+		Queue: thumbnailQueueName,
+		Retry: jobs.RetryPolicy{MaxAttempts: thumbnailMaxAttempts, Backoff: thumbnailBaseBackoff},
+		Run:   q.run,
+		Failed: func(payload json.RawMessage, attempts int, err error) {
+			log.Printf("Failed to generate thumbnail after %d attempts: %v", attempts, err)
+		},
+	})
+	return q
+}
+
+// EnqueueTx schedules a thumbnail for newly stored content as part of tx. Content that
+// isn't a supported image is ignored, as is everything when thumbnails are disabled
+// (a nil queue).
+func (q *ThumbnailQueue) EnqueueTx(tx *gorm.DB, fileHash models.FileHash) error {
+	if q == nil || !thumbnailable(fileHash) {
+		return nil
+	}
+	return q.runner.EnqueueTx(tx, thumbnailJobType, GenerateThumbnailJob{Hash: fileHash.Hash})
+}
+
+func thumbnailable(fileHash models.FileHash) bool {
+	return thumbnailSourceTypes[strings.ToLower(fileHash.MimeType)] && fileHash.Size <= thumbnailMaxSourceBytes
+}
+
+// thumbnailObjectKey spreads thumbnails across prefixes the same way as content
+func thumbnailObjectKey(hash string) string {
+	return thumbnailKeyPrefix + strings.TrimPrefix(shardedObjectKey(hash), shardedKeyPrefix) + ".jpg"
+}
+
+func (q *ThumbnailQueue) run(ctx context.Context, payload json.RawMessage) error {
+	var job GenerateThumbnailJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid thumbnail job: %w", err)
+	}
+
+	var fileHash models.FileHash
+	err := q.db.WithContext(ctx).Where("hash = ?", job.Hash).First(&fileHash).Error
+	if err == gorm.ErrRecordNotFound {
+		// Deleted before its turn came
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get file hash: %w", err)
+	}
+	if fileHash.ThumbnailKey != "" || !thumbnailable(fileHash) {
+		return nil
+	}
+
+	thumbnail, err := q.render(ctx, fileHash.MinIOKey)
+	if errors.Is(err, errThumbnailUnsupported) {
+		log.Printf("Skipping thumbnail for %s: %v", fileHash.Hash, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	thumbnailKey := thumbnailObjectKey(fileHash.Hash)
+	if err := q.storage.UploadFile(ctx, thumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+		return fmt.Errorf("failed to store thumbnail: %w", err)
+	}
+
+	result := q.db.WithContext(ctx).Model(&models.FileHash{}).Where("hash = ?", fileHash.Hash).Update("thumbnail_key", thumbnailKey)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record thumbnail: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// The content was deleted while the thumbnail was rendered
+		return q.storage.DeleteFile(ctx, thumbnailKey)
+	}
+	return nil
+}
+
+// render decodes the stored image and encodes a JPEG no larger than
+// thumbnailMaxDimension on either side
+func (q *ThumbnailQueue) render(ctx context.Context, objectKey string) ([]byte, error) {
+	reader, err := q.storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	defer reader.Close()
+
+	source, err := io.ReadAll(io.LimitReader(reader, thumbnailMaxSourceBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return renderThumbnail(source)
+}
+
+func renderThumbnail(source []byte) ([]byte, error) {
+	if len(source) > thumbnailMaxSourceBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", errThumbnailUnsupported, thumbnailMaxSourceBytes)
+	}
+
+	// Check the dimensions first so a small file can't expand into a huge bitmap
+	config, _, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errThumbnailUnsupported, err)
+	}
+	if config.Width < 1 || config.Height < 1 || int64(config.Width)*int64(config.Height) > thumbnailMaxSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", errThumbnailUnsupported, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errThumbnailUnsupported, err)
+	}
+
+	width, height := thumbnailSize(config.Width, config.Height)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, scaled, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return encoded.Bytes(), nil
+}
+
+// thumbnailSize fits width x height within thumbnailMaxDimension, keeping the aspect
+// ratio and never enlarging
+func thumbnailSize(width, height int) (int, int) {
+	if width <= thumbnailMaxDimension && height <= thumbnailMaxDimension {
+		return width, height
+	}
+	if width >= height {
+		return thumbnailMaxDimension, max(1, height*thumbnailMaxDimension/width)
+	}
+	return max(1, width*thumbnailMaxDimension/height), thumbnailMaxDimension
+}
+
+// GetThumbnailURL returns a presigned URL for the thumbnail of one of the user's files
+func (s *FileService) GetThumbnailURL(ctx context.Context, userID string, fileID uuid.UUID) (string, error) {
+	var userFile models.UserFile
+	err := s.db.WithContext(ctx).Preload("FileData").Where("id = ? AND user_id = ?", fileID, userID).First(&userFile).Error
+	if err != nil {
+		return "", fmt.Errorf("file not found or access denied: %w", err)
+	}
+	if userFile.FileData.ThumbnailKey == "" {
+		return "", ErrThumbnailNotFound
+	}
+
+	return s.storage.GetFileURL(ctx, userFile.FileData.ThumbnailKey, time.Hour, storage.DownloadHeaders{ContentType: "image/jpeg"})
+}
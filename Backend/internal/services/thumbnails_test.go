@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"filevault-backend/internal/models"
+)
+
+func TestThumbnailSizeKeepsAspectRatio(t *testing.T) {
+	tests := []struct {
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{100, 50, 100, 50},    // Small images aren't enlarged
+		{1024, 512, 256, 128}, // Landscape
+		{600, 1200, 128, 256}, // Portrait
+		{4000, 1, 256, 1},     // Never collapses to zero
+	}
+	for _, tt := range tests {
+		width, height := thumbnailSize(tt.width, tt.height)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("thumbnailSize(%d, %d) = %d, %d, want %d, %d", tt.width, tt.height, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestRenderThumbnailScalesDown(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			source.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, source); err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnail, err := renderThumbnail(encoded.Bytes())
+	if err != nil {
+		t.Fatalf("renderThumbnail() error = %v", err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if config.Width != 256 || config.Height != 128 {
+		t.Errorf("thumbnail is %dx%d, want 256x128", config.Width, config.Height)
+	}
+}
+
+func TestRenderThumbnailRejectsUndecodableContent(t *testing.T) {
+	_, err := renderThumbnail([]byte("not an image"))
+	if !errors.Is(err, errThumbnailUnsupported) {
+		t.Errorf("renderThumbnail() error = %v, want errThumbnailUnsupported so the job isn't retried", err)
+	}
+}
+
+func TestThumbnailQueueIgnoresUnsupportedContent(t *testing.T) {
+	var disabled *ThumbnailQueue
+	if err := disabled.EnqueueTx(nil, models.FileHash{MimeType: "image/png", Size: 10}); err != nil {
+		t.Errorf("EnqueueTx() on a disabled queue error = %v", err)
+	}
+
+	for _, fileHash := range []models.FileHash{
+		{MimeType: "application/pdf", Size: 10},
+		{MimeType: "image/svg+xml", Size: 10},
+		{MimeType: "image/jpeg", Size: thumbnailMaxSourceBytes + 1},
+	} {
+		if thumbnailable(fileHash) {
+			t.Errorf("thumbnailable(%s, %d bytes) = true", fileHash.MimeType, fileHash.Size)
+		}
+	}
+	if !thumbnailable(models.FileHash{MimeType: "IMAGE/JPEG", Size: 10}) {
+		t.Error("thumbnailable() is case sensitive about the MIME type")
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// The top files report scans every file, so results are reused for this many seconds
+const topFilesCacheSeconds = 60
+
+// TopFilesDimension is what the top files report ranks by
+type TopFilesDimension string
+
+const (
+	// TopBySize ranks files by content size
+	TopBySize TopFilesDimension = "size"
+	// TopByDownloads ranks files by download count
+	TopByDownloads TopFilesDimension = "downloads"
+	// TopByReferences ranks stored content by how many files point at it
+	TopByReferences TopFilesDimension = "references"
+)
+
+// ErrInvalidTopFilesDimension is returned for a ranking other than the supported ones
+var ErrInvalidTopFilesDimension = errors.New(`by must be "size", "downloads" or "references"`)
+
+// TopFileEntry is one row of the top files report. Rankings by references describe
+// stored content rather than one file: FileID and OwnerID are empty, Filename is one
+// of the names the content is stored under and DownloadCount sums all its files.
+type TopFileEntry struct {
+	FileID         *uuid.UUID `json:"file_id,omitempty"`
+	OwnerID        string     `json:"owner_id,omitempty"`
+	Filename       string     `json:"filename"`
+	FileHash       string     `json:"file_hash"`
+	Size           int64      `json:"size"`
+	DownloadCount  int64      `json:"download_count"`
+	ReferenceCount int64      `json:"reference_count"`
+	// Users with a file of this content, for rankings by references
+	Owners int64 `json:"owners,omitempty"`
+}
+
+// GetTopFiles returns the files ranked highest by the dimension, optionally only the
+// given user's. Results may be up to a minute old.
+func (s *AdminService) GetTopFiles(by TopFilesDimension, limit int, userID string) ([]TopFileEntry, error) {
+	cacheKey := fmt.Sprintf("%s|%d|%s", by, limit, userID)
+	if entries, ok := s.topFiles.get(cacheKey); ok {
+		return entries, nil
+	}
+
+	var query string
+	switch by {
+	case TopBySize, TopByDownloads:
+		order := "file_hashes.size DESC"
+		if by == TopByDownloads {
+			order = "user_files.download_count DESC"
+		}
+		query = `
+			SELECT user_files.id AS file_id, user_files.user_id AS owner_id, user_files.filename,
+				user_files.file_hash, file_hashes.size, user_files.download_count, file_hashes.reference_count
+			FROM user_files JOIN file_hashes ON file_hashes.hash = user_files.file_hash
+			WHERE user_files.deleted_at IS NULL AND (@user_id = '' OR user_files.user_id = @user_id)
+			ORDER BY ` + order + `, user_files.id
+			LIMIT @limit`
+	case TopByReferences:
+		query = `
+			SELECT MIN(user_files.filename) AS filename, file_hashes.hash AS file_hash, file_hashes.size,
+				COALESCE(SUM(user_files.download_count), 0) AS download_count,
+				COUNT(*) AS reference_count, COUNT(DISTINCT user_files.user_id) AS owners
+			FROM file_hashes JOIN user_files ON user_files.file_hash = file_hashes.hash
+			WHERE user_files.deleted_at IS NULL
+				AND (@user_id = '' OR file_hashes.hash IN (SELECT file_hash FROM user_files WHERE user_id = @user_id AND deleted_at IS NULL))
+			GROUP BY file_hashes.hash, file_hashes.size
+			ORDER BY reference_count DESC, file_hashes.hash
+			LIMIT @limit`
+	default:
+		return nil, ErrInvalidTopFilesDimension
+	}
+
+	entries := []TopFileEntry{}
+	err := s.db.Raw(query, map[string]interface{}{"user_id": userID, "limit": limit}).Scan(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top files: %w", err)
+	}
+
+	s.topFiles.set(cacheKey, entries)
+	return entries, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"filevault-backend/internal/models"
+)
+
+const (
+	// How long new users keep the trial storage quota
+	trialQuotaDuration = 30 * 24 * time.Hour
+
+	trialExpiryCheckInterval = time.Hour
+)
+
+// GetUsersWithExpiredTrials returns users whose trial ended but who still have the
+// trial storage quota
+func (s *UserService) GetUsersWithExpiredTrials() ([]models.User, error) {
+	var users []models.User
+	err := s.db.Where("trial_quota_expires_at < ? AND storage_quota <> base_storage_quota", time.Now().UTC()).
+		Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired trials: %w", err)
+	}
+	return users, nil
+}
+
+// StartTrialExpiryWorker reverts expired trial quotas to each user's base quota and
+// reports each revert through the admin notifier
+func (s *UserService) StartTrialExpiryWorker(ctx context.Context, notifier *AdminNotifier) {
+	go func() {
+		ticker := time.NewTicker(trialExpiryCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			s.revertExpiredTrials(notifier)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *UserService) revertExpiredTrials(notifier *AdminNotifier) {
+	users, err := s.GetUsersWithExpiredTrials()
+	if err != nil {
+		log.Printf("Failed to check expired trials: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		// Re-check the expiry so an admin quota change since the query isn't undone
+		result := s.db.Model(&models.User{}).
+			Where("id = ? AND trial_quota_expires_at < ?", user.ID, time.Now().UTC()).
+			Update("storage_quota", user.BaseStorageQuota)
+		if result.Error != nil {
+			log.Printf("Failed to revert trial quota for user %s: %v", user.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		s.users.delete(user.ID)
+
+		log.Printf("Trial quota expired for user %s: %d -> %d bytes", user.ID, user.StorageQuota, user.BaseStorageQuota)
+		notifier.Notify("quota.trial_expired", fmt.Sprintf("Trial storage quota expired for user %s", user.ID), map[string]interface{}{
+			"user_id":       user.ID,
+			"trial_quota":   user.StorageQuota,
+			"storage_quota": user.BaseStorageQuota,
+			"storage_used":  user.StorageUsed,
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"filevault-backend/internal/models"
+)
+
+// Clock differences beyond this are logged when syncing with the database
+const clockSkewWarnThreshold = time.Minute
+
+// dbNow returns the current time on the database's clock, as of the last sync. Upload
+// and batch session deadlines are set and compared with it, so a container whose clock
+// is off doesn't mass-expire sessions created by others.
+func (s *FileService) dbNow() time.Time {
+	return time.Now().UTC().Add(time.Duration(s.dbClockOffset.Load()))
+}
+
+// syncDBClock measures how far the process clock is from the database's
+func (s *FileService) syncDBClock(ctx context.Context) {
+	var dbTime time.Time
+	before := time.Now()
+	if err := s.db.WithContext(ctx).Raw("SELECT NOW()").Scan(&dbTime).Error; err != nil {
+		slog.Warn("db_clock_sync_failed", slog.Any("error", err))
+		return
+	}
+	// Assume the database read its clock halfway through the round trip
+	offset := dbTime.Sub(before.Add(time.Since(before) / 2))
+	s.dbClockOffset.Store(int64(offset))
+
+	if offset > clockSkewWarnThreshold || offset < -clockSkewWarnThreshold {
+		slog.Warn("clock_skew_detected", slog.Duration("offset", offset))
+	}
+}
+
+// RecoverUploadSessions picks up upload state left by the previous process. Sessions
+// and batches live in the database and their quota reservations are applied there as
+// they're made, so nothing is lost on restart; this syncs the clock, charges batches
+// opened before their reservations counted toward usage, expires anything past its
+// deadline, repairs batch reservations that drifted from their pending files and logs
+// what is still open.
+func (s *FileService) RecoverUploadSessions(ctx context.Context) error {
+	s.syncDBClock(ctx)
+
+	// Before expiring anything, since expiring a batch releases its reservation
+	charged := s.db.WithContext(ctx).Exec(`
+		WITH charged AS (
+			UPDATE batch_uploads SET reservation_charged = true
+			WHERE NOT reservation_charged
+			RETURNING user_id, reserved_bytes
+		)
+		UPDATE users SET storage_used = storage_used + charged_users.total
+		FROM (SELECT user_id, SUM(reserved_bytes) AS total FROM charged GROUP BY user_id) charged_users
+		WHERE users.id = charged_users.user_id`)
+	if charged.Error != nil {
+		return fmt.Errorf("failed to charge batch reservations: %w", charged.Error)
+	}
+
+	s.cleanupExpiredUploadSessions(ctx)
+
+	now := s.dbNow()
+
+	// A batch reserves exactly the size of its files still waiting to be uploaded, and
+	// the user's usage moves with it
+	repaired := s.db.WithContext(ctx).Exec(`
+		WITH repaired AS (
+			UPDATE batch_uploads SET reserved_bytes = pending.total
+			FROM (
+				SELECT b.id, b.reserved_bytes, COALESCE(SUM(f.size), 0) AS total
+				FROM batch_uploads b LEFT JOIN batch_upload_files f ON f.batch_id = b.id
+				WHERE b.expires_at > ?
+				GROUP BY b.id
+			) pending
+			WHERE batch_uploads.id = pending.id AND batch_uploads.reserved_bytes <> pending.total
+			RETURNING batch_uploads.user_id, pending.total - pending.reserved_bytes AS delta
+		)
+		UPDATE users SET storage_used = storage_used + repaired_users.delta
+		FROM (SELECT user_id, SUM(delta) AS delta FROM repaired GROUP BY user_id) repaired_users
+		WHERE users.id = repaired_users.user_id`, now)
+	if repaired.Error != nil {
+		return fmt.Errorf("failed to repair batch reservations: %w", repaired.Error)
+	}
+
+	var sessions struct {
+		Count    int64
+		Reserved int64
+	}
+	err := s.db.WithContext(ctx).Model(&models.UploadSession{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(reserved_bytes), 0) AS reserved").
+		Where("expires_at > ? OR status = ?", now, models.UploadSessionUploaded).
+		Scan(&sessions).Error
+	if err != nil {
+		return fmt.Errorf("failed to count open upload sessions: %w", err)
+	}
+
+	var batches struct {
+		Count    int64
+		Reserved int64
+	}
+	err = s.db.WithContext(ctx).Model(&models.BatchUpload{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(reserved_bytes), 0) AS reserved").
+		Where("expires_at > ?", now).
+		Scan(&batches).Error
+	if err != nil {
+		return fmt.Errorf("failed to count open batch sessions: %w", err)
+	}
+
+	slog.Info("upload_sessions_recovered",
+		slog.Int64("open_sessions", sessions.Count),
+		slog.Int64("session_reserved_bytes", sessions.Reserved),
+		slog.Int64("open_batches", batches.Count),
+		slog.Int64("batch_reserved_bytes", batches.Reserved),
+		slog.Int64("batch_reservations_charged_users", charged.RowsAffected),
+		slog.Int64("batch_reservations_repaired_users", repaired.RowsAffected))
+	return nil
+}
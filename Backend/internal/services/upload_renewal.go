@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Batch upload URLs are short-lived; clients renew them per file when needed
+	batchUploadURLExpiry = 15 * time.Minute
+	// A session's deadline moves at most once per interval. Renewing again sooner still
+	// returns a fresh URL, so retried renewals are harmless.
+	uploadRenewInterval = time.Minute
+)
+
+// ErrUploadLifetimeExceeded is returned when renewing an upload older than the configured
+// maximum lifetime
+var ErrUploadLifetimeExceeded = errors.New("upload has reached its maximum lifetime and must be prepared again")
+
+// RenewedUploadURL is a fresh upload URL for an upload that is still in progress
+type RenewedUploadURL struct {
+	UploadURL string    `json:"upload_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// uploadLifetimeEnd returns when an upload prepared at createdAt can no longer be renewed
+func (s *FileService) uploadLifetimeEnd(createdAt time.Time) time.Time {
+	return createdAt.Add(time.Duration(s.cfg.UploadSessionMaxLifetimeSeconds) * time.Second)
+}
+
+// RenewUploadSession issues a new upload URL for a pending server-hashed upload whose
+// URL expired or is about to, and extends the session's deadline so the reservation
+// isn't released. Deadlines never move past the upload's maximum lifetime. Multipart
+// uploads request a URL per part instead.
+func (s *FileService) RenewUploadSession(ctx context.Context, userID string, uploadID uuid.UUID) (*RenewedUploadURL, error) {
+	now := s.dbNow()
+
+	var session models.UploadSession
+	err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND status = ? AND expires_at > ? AND COALESCE(multipart_upload_id, '') = ''", uploadID, userID, models.UploadSessionPending, now).
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+
+	lifetimeEnd := s.uploadLifetimeEnd(session.CreatedAt)
+	if !now.Before(lifetimeEnd) {
+		return nil, ErrUploadLifetimeExceeded
+	}
+
+	deadline := session.ExpiresAt
+	if session.RenewedAt == nil || now.Sub(*session.RenewedAt) >= uploadRenewInterval {
+		if extended := minTime(now.Add(stagingUploadExpiry), lifetimeEnd); extended.After(deadline) {
+			result := s.db.WithContext(ctx).Model(&models.UploadSession{}).
+				Where("id = ? AND status = ? AND expires_at > ?", session.ID, models.UploadSessionPending, now).
+				Updates(map[string]interface{}{"expires_at": extended, "renewed_at": now})
+			if result.Error != nil {
+				return nil, fmt.Errorf("failed to extend upload session: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return nil, ErrUploadSessionNotFound
+			}
+			deadline = extended
+		}
+	}
+
+	uploadURL, err := s.storage.GetUploadURL(ctx, session.ObjectKey, deadline.Sub(now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
+	}
+	return &RenewedUploadURL{UploadURL: uploadURL, ExpiresAt: deadline.UTC()}, nil
+}
+
+// RenewBatchUpload issues a new upload URL for a file prepared in the user's batch
+// session and not yet completed. The batch session keeps its own expiry; URLs are
+// capped by it and by the file's maximum lifetime.
+func (s *FileService) RenewBatchUpload(ctx context.Context, userID, batchID, uploadID string) (*RenewedUploadURL, error) {
+	session, err := s.getBatchSession(ctx, userID, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	var batchFile models.BatchUploadFile
+	err = s.db.WithContext(ctx).Where("upload_id = ? AND batch_id = ?", uploadID, session.ID).First(&batchFile).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadSessionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get batch file: %w", err)
+	}
+	if batchFile.Link {
+		// Duplicates have nothing to upload
+		return nil, ErrUploadSessionNotFound
+	}
+
+	now := s.dbNow()
+	lifetimeEnd := s.uploadLifetimeEnd(batchFile.CreatedAt)
+	if !now.Before(lifetimeEnd) {
+		return nil, ErrUploadLifetimeExceeded
+	}
+	deadline := minTime(minTime(now.Add(batchUploadURLExpiry), lifetimeEnd), session.ExpiresAt)
+
+	uploadURL, err := s.storage.GetUploadURL(ctx, s.storage.StagingKey(userID, batchFile.UploadID), deadline.Sub(now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload URL: %w", err)
+	}
+	return &RenewedUploadURL{UploadURL: uploadURL, ExpiresAt: deadline.UTC()}, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUploadRequestNotFound is returned when an upload request does not exist or was deleted
+	ErrUploadRequestNotFound = errors.New("upload request not found")
+	// ErrUploadRequestClosed is returned when an upload request has expired or received all its files
+	ErrUploadRequestClosed = errors.New("upload request is no longer accepting files")
+	// ErrUploadRequestRejected is returned when a file does not meet the request's constraints
+	ErrUploadRequestRejected = errors.New("file does not meet upload request constraints")
+)
+
+type CreateUploadRequestParams struct {
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	MaxFiles         int        `json:"max_files"`
+	MaxFileSizeBytes int64      `json:"max_file_size_bytes"`
+	AllowedMimeTypes []string   `json:"allowed_mime_types"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+// UploadRequestPublicResponse is what anonymous uploaders see; it never exposes the owner
+type UploadRequestPublicResponse struct {
+	ID               string     `json:"id"`
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	MaxFiles         int        `json:"max_files"`
+	FilesRemaining   int        `json:"files_remaining"`
+	MaxFileSizeBytes int64      `json:"max_file_size_bytes"`
+	AllowedMimeTypes []string   `json:"allowed_mime_types"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+// CreateUploadRequest creates a public link that lets others upload files into the user's vault
+func (s *FileService) CreateUploadRequest(userID string, req CreateUploadRequestParams) (*models.UploadRequest, error) {
+	if req.MaxFiles < 1 {
+		return nil, fmt.Errorf("max_files must be at least 1")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	uploadRequest := models.UploadRequest{
+		OwnerUserID:      userID,
+		Title:            req.Title,
+		Description:      req.Description,
+		MaxFiles:         req.MaxFiles,
+		MaxFileSizeBytes: req.MaxFileSizeBytes,
+		AllowedMimeTypes: models.StringList(req.AllowedMimeTypes),
+		ExpiresAt:        req.ExpiresAt,
+	}
+	if uploadRequest.AllowedMimeTypes == nil {
+		uploadRequest.AllowedMimeTypes = models.StringList{}
+	}
+
+	if err := s.db.Create(&uploadRequest).Error; err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+
+	return &uploadRequest, nil
+}
+
+// ListUploadRequests returns the user's upload requests, newest first
+func (s *FileService) ListUploadRequests(userID string) ([]models.UploadRequest, error) {
+	requests := make([]models.UploadRequest, 0)
+	err := s.db.Where("owner_user_id = ?", userID).Order("created_at DESC").Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upload requests: %w", err)
+	}
+	return requests, nil
+}
+
+// DeleteUploadRequest removes one of the user's upload requests; files already received stay in the vault
+func (s *FileService) DeleteUploadRequest(userID, requestID string) error {
+	result := s.db.Where("id = ? AND owner_user_id = ?", requestID, userID).Delete(&models.UploadRequest{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete upload request: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadRequestNotFound
+	}
+	return nil
+}
+
+// GetUploadRequest loads an upload request by its public ID
+func (s *FileService) GetUploadRequest(requestID string) (*models.UploadRequest, error) {
+	var uploadRequest models.UploadRequest
+	err := s.db.Where("id = ?", requestID).First(&uploadRequest).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUploadRequestNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get upload request: %w", err)
+	}
+	return &uploadRequest, nil
+}
+
+// ToUploadRequestPublicResponse strips owner details from an upload request
+func ToUploadRequestPublicResponse(r *models.UploadRequest) UploadRequestPublicResponse {
+	remaining := r.MaxFiles - r.FilesReceived
+	if remaining < 0 {
+		remaining = 0
+	}
+	return UploadRequestPublicResponse{
+		ID:               r.ID,
+		Title:            r.Title,
+		Description:      r.Description,
+		MaxFiles:         r.MaxFiles,
+		FilesRemaining:   remaining,
+		MaxFileSizeBytes: r.MaxFileSizeBytes,
+		AllowedMimeTypes: r.AllowedMimeTypes,
+		ExpiresAt:        r.ExpiresAt,
+	}
+}
+
+// PrepareUploadRequestFile validates an anonymous upload against the request's constraints,
+// reserves one of its file slots and issues an upload URL on the owner's account. The
+// content is uploaded even when it's already stored.
+func (s *FileService) PrepareUploadRequestFile(ctx context.Context, requestID, filename, fileHash string, size int64, mimeType string) (*PresignedUploadResponse, error) {
+	uploadRequest, err := s.GetUploadRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateUploadRequestFile(uploadRequest, size, mimeType); err != nil {
+		return nil, err
+	}
+
+	// Reserve a slot atomically so concurrent uploaders can't exceed max_files
+	result := s.db.WithContext(ctx).Model(&models.UploadRequest{}).
+		Where("id = ? AND files_received < max_files AND (expires_at IS NULL OR expires_at > ?)", requestID, time.Now().UTC()).
+		Update("files_received", gorm.Expr("files_received + 1"))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to reserve upload slot: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUploadRequestClosed
+	}
+
+	opts := uploadRequestOptions
+	opts.uploadRequestID = uploadRequest.ID
+	response, err := s.GeneratePresignedUploadURL(ctx, uploadRequest.OwnerUserID, filename, fileHash, "", size, mimeType, opts)
+	if err != nil {
+		// Release the reserved slot
+		s.db.Model(&models.UploadRequest{}).Where("id = ?", requestID).
+			Update("files_received", gorm.Expr("files_received - 1"))
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// CompleteUploadRequestFile finalizes an anonymous upload into the owner's vault. The
+// token issued when the file's slot was reserved names the staged object and content;
+// it completes one file, and only through the request it was issued for. The uploader
+// controls what was actually stored, so the object's size and type are checked against
+// the request again before the file is created.
+func (s *FileService) CompleteUploadRequestFile(ctx context.Context, requestID, token, filename string) (*models.UserFile, error) {
+	uploadRequest, err := s.GetUploadRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.verifyUploadToken(uploadRequest.OwnerUserID, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.RequestID != uploadRequest.ID {
+		return nil, ErrInvalidUploadToken
+	}
+
+	fileInfo, err := s.storage.GetFileInfo(ctx, claims.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	mimeType := storedMediaType(fileInfo.ContentType)
+	if err := checkUploadRequestFile(uploadRequest, fileInfo.Size, mimeType); err != nil {
+		s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: claims.ObjectKey})
+		return nil, err
+	}
+
+	userFile, _, err := s.completeUploadOnce(ctx, uploadRequest.OwnerUserID, claims, filename, mimeType)
+	return userFile, err
+}
+
+func validateUploadRequestFile(r *models.UploadRequest, size int64, mimeType string) error {
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return ErrUploadRequestClosed
+	}
+	if r.FilesReceived >= r.MaxFiles {
+		return ErrUploadRequestClosed
+	}
+	return checkUploadRequestFile(r, size, mimeType)
+}
+
+// checkUploadRequestFile checks a file against the request's size and type limits
+func checkUploadRequestFile(r *models.UploadRequest, size int64, mimeType string) error {
+	if r.MaxFileSizeBytes > 0 && size > r.MaxFileSizeBytes {
+		return fmt.Errorf("%w: file exceeds maximum size of %d bytes", ErrUploadRequestRejected, r.MaxFileSizeBytes)
+	}
+	if len(r.AllowedMimeTypes) > 0 && !mimeTypeAllowed(r.AllowedMimeTypes, mimeType) {
+		return fmt.Errorf("%w: file type %q is not allowed", ErrUploadRequestRejected, mimeType)
+	}
+	return nil
+}
+
+// storedMediaType returns a stored object's content type without parameters such as
+// charset
+func storedMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// mimeTypeAllowed matches exact types and wildcard patterns such as "image/*"
+func mimeTypeAllowed(allowed []string, mimeType string) bool {
+	for _, pattern := range allowed {
+		if pattern == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
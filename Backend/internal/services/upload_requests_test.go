@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckUploadRequestFile(t *testing.T) {
+	request := &models.UploadRequest{
+		MaxFileSizeBytes: 1000,
+		AllowedMimeTypes: models.StringList{"image/*", "application/pdf"},
+	}
+
+	tests := []struct {
+		name     string
+		size     int64
+		mimeType string
+		wantErr  bool
+	}{
+		{"allowed wildcard", 1000, "image/png", false},
+		{"allowed exact", 10, "application/pdf", false},
+		{"too large", 1001, "image/png", true},
+		{"type not allowed", 10, "application/zip", true},
+		{"prefix is not a wildcard match", 10, "imagex/png", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUploadRequestFile(request, tt.size, tt.mimeType)
+			if tt.wantErr != errors.Is(err, ErrUploadRequestRejected) {
+				t.Errorf("checkUploadRequestFile(%d, %q) = %v, want rejection %v", tt.size, tt.mimeType, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoredMediaType(t *testing.T) {
+	tests := map[string]string{
+		"text/plain; charset=utf-8": "text/plain",
+		"Image/PNG":                 "image/png",
+		"":                          "",
+	}
+	for contentType, want := range tests {
+		if got := storedMediaType(contentType); got != want {
+			t.Errorf("storedMediaType(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestCompleteSignedUploadRejectsUploadRequestTokens(t *testing.T) {
+	s := newTokenTestService()
+	opts := uploadRequestOptions
+	opts.uploadRequestID = "request-1"
+	token := s.issueUploadToken("owner", s.storage.StagingKey("owner", "upload-1"), "hash", 42, opts)
+
+	_, _, err := s.CompleteSignedUpload(context.Background(), "owner", token, "file.txt", "text/plain", UploadOptions{})
+	if !errors.Is(err, ErrInvalidUploadToken) {
+		t.Errorf("CompleteSignedUpload() error = %v, want ErrInvalidUploadToken", err)
+	}
+}
+
+func TestUploadRequestUploadsStoredContent(t *testing.T) {
+	tx := testTx(t, &models.User{}, &models.FileHash{}, &models.UserFile{}, &models.BannedHash{}, &models.UploadRequest{})
+	minioStorage, _ := newFakeStorage(t, nil)
+	cfg := &config.Config{}
+	s := &FileService{
+		db:             tx,
+		cfg:            cfg,
+		storage:        minioStorage,
+		userService:    &UserService{db: tx, cfg: cfg},
+		uploadTokenKey: []byte("test-upload-token-key-0123456789abcdef"),
+	}
+
+	ownerID := "request-owner-" + uuid.New().String()
+	if err := tx.Create(&models.User{ID: ownerID, StorageQuota: 1000}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	// Content someone else already stores, which an account could link without uploading
+	if err := tx.Create(&models.FileHash{Hash: testSHA256, MinIOKey: testSHA256, Size: 42, MimeType: "text/plain", ReferenceCount: 1}).Error; err != nil {
+		t.Fatalf("failed to create file hash: %v", err)
+	}
+	request := models.UploadRequest{OwnerUserID: ownerID, Title: "Documents", MaxFiles: 5}
+	if err := tx.Create(&request).Error; err != nil {
+		t.Fatalf("failed to create upload request: %v", err)
+	}
+
+	response, err := s.PrepareUploadRequestFile(context.Background(), request.ID, "notes.txt", testSHA256, 42, "text/plain")
+	if err != nil {
+		t.Fatalf("PrepareUploadRequestFile() error = %v", err)
+	}
+	if response.IsDuplicate || response.UploadURL == "" || response.UploadToken == "" {
+		t.Errorf("PrepareUploadRequestFile() = %+v, want an upload like for new content", response)
+	}
+
+	var files int64
+	tx.Model(&models.UserFile{}).Where("user_id = ?", ownerID).Count(&files)
+	if files != 0 {
+		t.Errorf("owner has %d files before the upload completed, want 0", files)
+	}
+}
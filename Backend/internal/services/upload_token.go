@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Upload tokens outlive the upload URL so large uploads can finish; staged objects
+// are removed after a day anyway
+const uploadTokenTTL = 24 * time.Hour
+
+const uploadTokenNonceLength = 24
+
+var (
+	// ErrInvalidUploadToken is returned for completion tokens that are malformed, tampered
+	// with, expired, issued to another user or already used
+	ErrInvalidUploadToken = errors.New("upload token is invalid or expired")
+	// ErrUploadSizeMismatch is returned when the uploaded object isn't the size declared when preparing it
+	ErrUploadSizeMismatch = errors.New("uploaded file size does not match the declared size")
+	// ErrLinkedContentGone is returned when completing a duplicate whose stored content
+	// was deleted after preparing; the file has to be prepared and uploaded again
+	ErrLinkedContentGone = errors.New("stored content is no longer available; prepare the file again")
+)
+
+// uploadTokenClaims bind a staged upload to the user, content and size it was prepared for
+type uploadTokenClaims struct {
+	UserID    string `json:"uid"`
+	ObjectKey string `json:"key"`
+	FileHash  string `json:"hash"`
+	Size      int64  `json:"size"`
+	ExpiresAt int64  `json:"exp"`
+	// Nonce makes each token single-use; completing a file spends it
+	Nonce string `json:"jti"`
+	// Set for anonymous uploads into an upload request, which complete through it
+	RequestID string `json:"req,omitempty"`
+	// Options chosen when the upload was prepared, unless completion overrides them
+	Policy   ConflictPolicy `json:"cp,omitempty"`
+	IsPublic *bool          `json:"pub,omitempty"`
+	// Set when the content was already stored and proven when preparing, so nothing
+	// is uploaded to ObjectKey and completion links the file instead
+	Link bool `json:"link,omitempty"`
+}
+
+// uploadTokenKey returns the configured signing secret, or a random per-process key
+// when none is set, in which case tokens don't survive restarts or work across instances
+func uploadTokenKey(cfg *config.Config) []byte {
+	if cfg.UploadTokenSecret != "" {
+		return []byte(cfg.UploadTokenSecret)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate upload token key: %v", err))
+	}
+	log.Println("UPLOAD_TOKEN_SECRET is not set; using a random key, so uploads must complete on the instance that prepared them")
+	return key
+}
+
+// issueUploadToken signs the completion token returned alongside an upload URL
+func (s *FileService) issueUploadToken(userID, objectKey, fileHash string, size int64, opts UploadOptions) string {
+	return s.signUploadClaims(uploadTokenClaims{
+		UserID:    userID,
+		ObjectKey: objectKey,
+		FileHash:  fileHash,
+		Size:      size,
+		ExpiresAt: time.Now().Add(uploadTokenTTL).Unix(),
+		Policy:    opts.ConflictPolicy,
+		IsPublic:  opts.IsPublic,
+		RequestID: opts.uploadRequestID,
+	})
+}
+
+// issueLinkToken signs a completion token for content that is already stored
+func (s *FileService) issueLinkToken(userID, objectKey, fileHash string, opts UploadOptions) string {
+	return s.signUploadClaims(uploadTokenClaims{
+		UserID:    userID,
+		ObjectKey: objectKey,
+		FileHash:  fileHash,
+		ExpiresAt: time.Now().Add(uploadTokenTTL).Unix(),
+		Policy:    opts.ConflictPolicy,
+		IsPublic:  opts.IsPublic,
+		Link:      true,
+	})
+}
+
+func (s *FileService) signUploadClaims(claims uploadTokenClaims) string {
+	claims.Nonce = models.GenerateRandomID(uploadTokenNonceLength)
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signUploadToken(encoded)
+}
+
+// verifyUploadToken checks a completion token's signature, expiry and owner
+func (s *FileService) verifyUploadToken(userID, token string) (*uploadTokenClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.signUploadToken(encoded))) {
+		return nil, ErrInvalidUploadToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+	var claims uploadTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+
+	if claims.UserID != userID || claims.Nonce == "" || time.Now().Unix() > claims.ExpiresAt || !s.isOwnStagedObject(userID, claims.ObjectKey) {
+		return nil, ErrInvalidUploadToken
+	}
+	return &claims, nil
+}
+
+// spendUploadToken marks a token used before its file is completed, so a replay or a
+// concurrent duplicate request can't complete a second file. It returns
+// ErrInvalidUploadToken when the token was already spent.
+func (s *FileService) spendUploadToken(ctx context.Context, claims *uploadTokenClaims) error {
+	used := models.UsedUploadToken{Nonce: claims.Nonce, ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC()}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&used)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record upload token use: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidUploadToken
+	}
+	return nil
+}
+
+// refundUploadToken makes a spent token usable again after its completion failed
+// without creating a file, so the client can retry
+func (s *FileService) refundUploadToken(claims *uploadTokenClaims) {
+	if err := s.db.Where("nonce = ?", claims.Nonce).Delete(&models.UsedUploadToken{}).Error; err != nil {
+		log.Printf("Failed to refund upload token: %v", err)
+	}
+}
+
+// completeUploadOnce completes the upload a verified token was issued for, spending
+// the token so it completes at most one file
+func (s *FileService) completeUploadOnce(ctx context.Context, userID string, claims *uploadTokenClaims, filename, mimeType string) (*models.UserFile, *DedupStats, error) {
+	if err := s.spendUploadToken(ctx, claims); err != nil {
+		return nil, nil, err
+	}
+
+	var userFile *models.UserFile
+	var dedup *DedupStats
+	var err error
+	if claims.Link {
+		userFile, dedup, err = s.completeLinkUpload(ctx, userID, claims, filename)
+	} else {
+		userFile, dedup, err = s.completeTokenUpload(ctx, userID, claims, filename, mimeType)
+	}
+	if err != nil {
+		s.refundUploadToken(claims)
+		return nil, nil, err
+	}
+	return userFile, dedup, nil
+}
+
+// pruneUsedUploadTokens forgets spent tokens that have expired, since they fail
+// verification anyway
+func (s *FileService) pruneUsedUploadTokens(now time.Time) {
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.UsedUploadToken{}).Error; err != nil {
+		log.Printf("Failed to prune used upload tokens: %v", err)
+	}
+}
+
+// applyOverrides replaces the options chosen when the upload was prepared with those
+// given at completion
+func (c *uploadTokenClaims) applyOverrides(opts UploadOptions) {
+	if opts.ConflictPolicy != "" {
+		c.Policy = opts.ConflictPolicy
+	}
+	if opts.IsPublic != nil {
+		c.IsPublic = opts.IsPublic
+	}
+}
+
+func (s *FileService) signUploadToken(encoded string) string {
+	mac := hmac.New(sha256.New, s.uploadTokenKey)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CompleteSignedUpload completes an upload prepared by GeneratePresignedUploadURL or
+// GeneratePresignedPostURL. The object key and hash come from the signed token, never
+// from the client, so a user can't complete someone else's upload or claim stored content.
+// Options left unset fall back to those given when the upload was prepared.
+func (s *FileService) CompleteSignedUpload(ctx context.Context, userID, token, filename, mimeType string, opts UploadOptions) (*models.UserFile, *DedupStats, error) {
+	claims, err := s.verifyUploadToken(userID, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.Link || claims.RequestID != "" {
+		// Link tokens are only issued to batches, which track them as batch files, and
+		// upload request tokens complete through their request
+		return nil, nil, ErrInvalidUploadToken
+	}
+	claims.applyOverrides(opts)
+	return s.completeUploadOnce(ctx, userID, claims, filename, mimeType)
+}
+
+func (s *FileService) completeTokenUpload(ctx context.Context, userID string, claims *uploadTokenClaims, filename, mimeType string) (*models.UserFile, *DedupStats, error) {
+	fileInfo, err := s.storage.GetFileInfo(ctx, claims.ObjectKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if fileInfo.Size != claims.Size {
+		s.deletionQueue.Enqueue(ctx, DeleteObjectJob{ObjectKey: claims.ObjectKey})
+		return nil, nil, ErrUploadSizeMismatch
+	}
+
+	return s.CompleteFileUpload(ctx, userID, claims.ObjectKey, filename, mimeType, claims.FileHash, UploadOptions{ConflictPolicy: claims.Policy, IsPublic: claims.IsPublic})
+}
+
+// completeLinkUpload links a file to content that was already stored when the upload
+// was prepared. The content may have been deleted or banned since.
+func (s *FileService) completeLinkUpload(ctx context.Context, userID string, claims *uploadTokenClaims, filename string) (*models.UserFile, *DedupStats, error) {
+	if err := s.checkBannedHash(claims.FileHash); err != nil {
+		return nil, nil, err
+	}
+
+	var existingFileHash models.FileHash
+	err := s.db.WithContext(ctx).Where("hash = ?", claims.FileHash).First(&existingFileHash).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, ErrLinkedContentGone
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up stored content: %w", err)
+	}
+
+	return s.linkDuplicateUpload(ctx, userID, filename, existingFileHash, UploadOptions{ConflictPolicy: claims.Policy, IsPublic: claims.IsPublic})
+}
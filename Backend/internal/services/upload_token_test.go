@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+)
+
+func newTokenTestService() *FileService {
+	return &FileService{
+		storage:        &storage.MinIOStorage{},
+		uploadTokenKey: []byte("test-upload-token-key-0123456789abcdef"),
+	}
+}
+
+func TestVerifyUploadTokenAcceptsIssuedToken(t *testing.T) {
+	s := newTokenTestService()
+	token := s.issueUploadToken("user-a", s.storage.StagingKey("user-a", "upload-1"), "hash", 42, UploadOptions{})
+
+	claims, err := s.verifyUploadToken("user-a", token)
+	if err != nil {
+		t.Fatalf("verifyUploadToken() error = %v", err)
+	}
+	if claims.Size != 42 || claims.FileHash != "hash" || claims.Nonce == "" {
+		t.Errorf("verifyUploadToken() claims = %+v", claims)
+	}
+}
+
+func TestVerifyUploadTokenRejectsTamperedToken(t *testing.T) {
+	s := newTokenTestService()
+	token := s.issueUploadToken("user-a", s.storage.StagingKey("user-a", "upload-1"), "hash", 42, UploadOptions{})
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	// Declare a bigger file under the original signature
+	payload, _ := base64.RawURLEncoding.DecodeString(encoded)
+	forged := strings.Replace(string(payload), `"size":42`, `"size":4200000`, 1)
+	if forged == string(payload) {
+		t.Fatal("test payload did not contain the size claim")
+	}
+
+	tests := map[string]string{
+		"payload":   base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + signature,
+		"signature": encoded + "." + strings.Repeat("A", len(signature)),
+		"unsigned":  encoded,
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.verifyUploadToken("user-a", tampered); !errors.Is(err, ErrInvalidUploadToken) {
+				t.Errorf("verifyUploadToken() error = %v, want ErrInvalidUploadToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyUploadTokenRejectsExpiredToken(t *testing.T) {
+	s := newTokenTestService()
+	token := s.signUploadClaims(uploadTokenClaims{
+		UserID:    "user-a",
+		ObjectKey: s.storage.StagingKey("user-a", "upload-1"),
+		FileHash:  "hash",
+		Size:      42,
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+
+	if _, err := s.verifyUploadToken("user-a", token); !errors.Is(err, ErrInvalidUploadToken) {
+		t.Errorf("verifyUploadToken() error = %v, want ErrInvalidUploadToken", err)
+	}
+}
+
+func TestVerifyUploadTokenRejectsCrossUserReplay(t *testing.T) {
+	s := newTokenTestService()
+	token := s.issueUploadToken("user-a", s.storage.StagingKey("user-a", "upload-1"), "hash", 42, UploadOptions{})
+
+	if _, err := s.verifyUploadToken("user-b", token); !errors.Is(err, ErrInvalidUploadToken) {
+		t.Errorf("verifyUploadToken() error = %v, want ErrInvalidUploadToken", err)
+	}
+
+	// A token claiming another user's staged object is rejected even when signed
+	token = s.issueUploadToken("user-b", s.storage.StagingKey("user-a", "upload-1"), "hash", 42, UploadOptions{})
+	if _, err := s.verifyUploadToken("user-b", token); !errors.Is(err, ErrInvalidUploadToken) {
+		t.Errorf("verifyUploadToken() error = %v, want ErrInvalidUploadToken", err)
+	}
+}
+
+func TestIssuedUploadTokensHaveDistinctNonces(t *testing.T) {
+	s := newTokenTestService()
+	key := s.storage.StagingKey("user-a", "upload-1")
+
+	first, _ := s.verifyUploadToken("user-a", s.issueUploadToken("user-a", key, "hash", 42, UploadOptions{}))
+	second, _ := s.verifyUploadToken("user-a", s.issueUploadToken("user-a", key, "hash", 42, UploadOptions{}))
+	if first == nil || second == nil || first.Nonce == second.Nonce {
+		t.Errorf("tokens share nonce: %+v, %+v", first, second)
+	}
+}
+
+func TestSpendUploadTokenIsSingleUse(t *testing.T) {
+	s := newTokenTestService()
+	s.db = testDB(t, &models.UsedUploadToken{})
+	ctx := context.Background()
+
+	claims, err := s.verifyUploadToken("user-a", s.issueUploadToken("user-a", s.storage.StagingKey("user-a", "upload-1"), "hash", 42, UploadOptions{}))
+	if err != nil {
+		t.Fatalf("verifyUploadToken() error = %v", err)
+	}
+	t.Cleanup(func() { s.refundUploadToken(claims) })
+
+	if err := s.spendUploadToken(ctx, claims); err != nil {
+		t.Fatalf("first spendUploadToken() error = %v", err)
+	}
+	if err := s.spendUploadToken(ctx, claims); !errors.Is(err, ErrInvalidUploadToken) {
+		t.Errorf("replayed spendUploadToken() error = %v, want ErrInvalidUploadToken", err)
+	}
+
+	// A failed completion hands the token back for a retry
+	s.refundUploadToken(claims)
+	if err := s.spendUploadToken(ctx, claims); err != nil {
+		t.Errorf("spendUploadToken() after refund error = %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+
+	"filevault-backend/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UploadOptions are a client's choices for how an upload is stored
+type UploadOptions struct {
+	ConflictPolicy ConflictPolicy
+	// IsPublic overrides the user's default visibility when set
+	IsPublic *bool
+	// DedupBehavior applies when preparing an upload of content the user already has
+	DedupBehavior DedupBehavior
+	// uploadRequestID binds the upload's token to the upload request it was prepared
+	// for, so only that request's completion accepts it
+	uploadRequestID string
+	// alwaysUpload issues an upload even for content that's already stored, which is
+	// then deduplicated once the upload is verified at completion
+	alwaysUpload bool
+}
+
+// Files dropped into a vault through an upload request come from strangers, so they
+// always start out private whatever the owner's default. Strangers must also not learn
+// whether anyone already stores their content, so they always upload it.
+var uploadRequestOptions = UploadOptions{IsPublic: new(bool), alwaysUpload: true}
+
+// uploadVisibility decides whether a new upload starts out public
+func (s *FileService) uploadVisibility(userID string, isPublic *bool) bool {
+	if isPublic != nil {
+		return *isPublic
+	}
+	return s.userService.DefaultUploadsPublic(userID)
+}
+
+// createUserFile stores a new upload's file record by the conflict policy and, when the
+// resulting file is public, gives it a share link in the same transaction. The returned
+// function must be called once the transaction commits.
+func (s *FileService) createUserFile(tx *gorm.DB, userFile *models.UserFile, policy ConflictPolicy) (func(), error) {
+	afterStore, err := s.storeUserFile(tx, userFile, policy)
+	if err != nil {
+		return nil, err
+	}
+	if !userFile.IsPublic {
+		return afterStore, nil
+	}
+
+	shareID, created, err := ensureShareLink(tx, userFile.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	file := *userFile
+	return func() {
+		afterStore()
+		if created {
+			s.RecordActivity(models.UserActivity{UserID: file.UserID, Action: models.ActivityShare, FileID: &file.ID, Filename: file.Filename, ShareID: shareID})
+		}
+	}, nil
+}
+
+// ensureShareLink returns the file's share link, creating one in tx if it has none.
+// created reports whether a new link was made.
+func ensureShareLink(tx *gorm.DB, fileID uuid.UUID) (string, bool, error) {
+	var shareLink models.ShareLink
+	err := tx.Where("user_file_id = ?", fileID).First(&shareLink).Error
+	if err == nil {
+		return shareLink.ID, false, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return "", false, fmt.Errorf("failed to check existing share link: %w", err)
+	}
+
+	for attempts := 0; attempts < 10; attempts++ {
+		shareLink = models.ShareLink{ID: models.GenerateRandomID(models.ShareIDLength), UserFileID: fileID}
+		// An ID collision must not raise an error, which would abort the whole transaction
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&shareLink)
+		if result.Error != nil {
+			return "", false, fmt.Errorf("failed to create share link: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			return shareLink.ID, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("failed to create share link after retries")
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"filevault-backend/internal/models"
+)
+
+// Stored preferences are capped well above what the known keys can reach, as a guard
+// against the schema growing a free-form field
+const maxPreferencesBytes = 4096
+
+// Allowed preference values; the first of each is the default
+var (
+	preferenceVisibilities = []string{"private", "public"}
+	preferenceSortOrders   = []string{"uploaded_at_desc", "uploaded_at_asc", "name_asc", "name_desc", "size_desc", "size_asc"}
+	preferenceViewModes    = []string{"list", "grid"}
+	// Notifications a user can opt out of
+	preferenceNotifications = []string{"upload_request_received", "share_link_accessed", "quota_warning"}
+)
+
+// ErrInvalidPreference is returned when a preference has a value outside its allowed set
+var ErrInvalidPreference = errors.New("invalid preference")
+
+// PreferencesUpdate is a partial update of a user's preferences; nil fields are left as they are
+type PreferencesUpdate struct {
+	DefaultVisibility   *string   `json:"default_visibility"`
+	SortOrder           *string   `json:"sort_order"`
+	ViewMode            *string   `json:"view_mode"`
+	NotificationOptOuts *[]string `json:"notification_opt_outs"`
+}
+
+// GetPreferences returns the user's preferences with defaults filled in
+func (s *UserService) GetPreferences(userID string) (*models.UserPreferences, error) {
+	var user models.User
+	if err := s.db.Select("id", "preferences").Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user preferences: %w", err)
+	}
+
+	preferences := withPreferenceDefaults(user.Preferences)
+	return &preferences, nil
+}
+
+// UpdatePreferences validates and merges a partial update into the user's preferences
+func (s *UserService) UpdatePreferences(userID string, update PreferencesUpdate) (*models.UserPreferences, error) {
+	var user models.User
+	if err := s.db.Select("id", "preferences").Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user preferences: %w", err)
+	}
+	preferences := user.Preferences
+
+	if update.DefaultVisibility != nil {
+		if !slices.Contains(preferenceVisibilities, *update.DefaultVisibility) {
+			return nil, fmt.Errorf("%w: default_visibility must be one of %v", ErrInvalidPreference, preferenceVisibilities)
+		}
+		preferences.DefaultVisibility = *update.DefaultVisibility
+	}
+	if update.SortOrder != nil {
+		if !slices.Contains(preferenceSortOrders, *update.SortOrder) {
+			return nil, fmt.Errorf("%w: sort_order must be one of %v", ErrInvalidPreference, preferenceSortOrders)
+		}
+		preferences.SortOrder = *update.SortOrder
+	}
+	if update.ViewMode != nil {
+		if !slices.Contains(preferenceViewModes, *update.ViewMode) {
+			return nil, fmt.Errorf("%w: view_mode must be one of %v", ErrInvalidPreference, preferenceViewModes)
+		}
+		preferences.ViewMode = *update.ViewMode
+	}
+	if update.NotificationOptOuts != nil {
+		optOuts := []string{}
+		for _, notification := range *update.NotificationOptOuts {
+			if !slices.Contains(preferenceNotifications, notification) {
+				return nil, fmt.Errorf("%w: notification_opt_outs may only contain %v", ErrInvalidPreference, preferenceNotifications)
+			}
+			if !slices.Contains(optOuts, notification) {
+				optOuts = append(optOuts, notification)
+			}
+		}
+		preferences.NotificationOptOuts = optOuts
+	}
+
+	if encoded, err := json.Marshal(preferences); err != nil || len(encoded) > maxPreferencesBytes {
+		return nil, fmt.Errorf("%w: preferences exceed %d bytes", ErrInvalidPreference, maxPreferencesBytes)
+	}
+
+	if err := s.db.Model(&user).Update("preferences", preferences).Error; err != nil {
+		return nil, fmt.Errorf("failed to save user preferences: %w", err)
+	}
+	s.users.delete(userID)
+
+	preferences = withPreferenceDefaults(preferences)
+	return &preferences, nil
+}
+
+// DefaultUploadsPublic reports whether the user wants new uploads to start out public.
+// Lookup failures fall back to private.
+func (s *UserService) DefaultUploadsPublic(userID string) bool {
+	preferences, err := s.GetPreferences(userID)
+	return err == nil && preferences.DefaultVisibility == "public"
+}
+
+func withPreferenceDefaults(preferences models.UserPreferences) models.UserPreferences {
+	if preferences.DefaultVisibility == "" {
+		preferences.DefaultVisibility = preferenceVisibilities[0]
+	}
+	if preferences.SortOrder == "" {
+		preferences.SortOrder = preferenceSortOrders[0]
+	}
+	if preferences.ViewMode == "" {
+		preferences.ViewMode = preferenceViewModes[0]
+	}
+	if preferences.NotificationOptOuts == nil {
+		preferences.NotificationOptOuts = []string{}
+	}
+	return preferences
+}
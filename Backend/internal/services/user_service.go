@@ -1,56 +1,114 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
 	"time"
 
 	"filevault-backend/internal/config"
 	"filevault-backend/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrBandwidthQuotaExceeded is returned when serving a file would exceed the owner's monthly bandwidth
+var ErrBandwidthQuotaExceeded = errors.New("monthly bandwidth quota exceeded")
+
+var (
+	// ErrUserNotFound is returned when an admin update targets a user that doesn't exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrQuotaBelowUsage is returned when an admin sets a storage quota smaller than what
+	// the user already stores
+	ErrQuotaBelowUsage = errors.New("storage quota cannot be less than current usage")
+	// ErrStorageQuotaExceeded is returned when new content wouldn't fit in the user's
+	// storage quota, even allowing for the overage grace
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
 )
 
 type UserService struct {
 	db  *gorm.DB
 	cfg *config.Config
+
+	// Recently loaded user rows. Usage counters in cached rows can lag; quota checks
+	// always read them from the database.
+	users *ttlCache[models.User]
 }
 
 func NewUserService(db *gorm.DB, cfg *config.Config) *UserService {
 	return &UserService{
-		db:  db,
-		cfg: cfg,
+		db:    db,
+		cfg:   cfg,
+		users: newTTLCache[models.User]("users", cacheTTL(cfg.CacheEnabled, cfg.CacheTTLSeconds)),
 	}
 }
 
-// GetOrCreateUser finds existing user or creates new one based on Clerk user ID
+// GetOrCreateUser finds existing user or creates new one based on Clerk user ID.
+// Concurrent first requests from a new user share one lookup, so only one creates the row.
 func (s *UserService) GetOrCreateUser(clerkUserID, email, firstName, lastName string) (*models.User, error) {
+	user, err := s.users.getOrLoad(clerkUserID, func() (models.User, error) {
+		return s.loadOrCreateUser(clerkUserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserService) loadOrCreateUser(clerkUserID string) (models.User, error) {
 	var user models.User
 
 	err := s.db.Where("id = ?", clerkUserID).First(&user).Error
 	if err == nil {
 		// User exists, update role in case it changed
-		return &user, nil
+		if err := s.applyInitialAdmin(&user); err != nil {
+			return models.User{}, err
+		}
+		return user, nil
 	}
 
 	if err != gorm.ErrRecordNotFound {
-		return nil, fmt.Errorf("failed to query user: %w", err)
+		return models.User{}, fmt.Errorf("failed to query user: %w", err)
 	}
 
-	// Create new user with configurable storage quota
-	user = models.User{
-		ID:           clerkUserID,
-		Role:         models.UserRoleUser,
-		StorageQuota: s.cfg.DefaultStorageQuotaMB * 1024 * 1024, // Convert MB to bytes
-		StorageUsed:  0,
-		CreatedAt:    time.Now().UTC(),
-		UpdatedAt:    time.Now().UTC(),
+	user = s.newUser(clerkUserID)
+	if s.isInitialAdmin(clerkUserID) {
+		user.Role = models.UserRoleAdmin
+		slog.Warn("initial_admin_granted", slog.String("user_id", clerkUserID), slog.String("source", "INITIAL_ADMIN_USER_IDS"))
 	}
 
 	if err := s.db.Create(&user).Error; err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return models.User{}, fmt.Errorf("failed to create user: %w", err)
 	}
+	return user, nil
+}
 
-	return &user, nil
+// newUser returns a regular user with the configured default quotas, starting a
+// signup trial when enabled
+func (s *UserService) newUser(clerkUserID string) models.User {
+	user := models.User{
+		ID:               clerkUserID,
+		Role:             models.UserRoleUser,
+		StorageQuota:     s.cfg.DefaultStorageQuotaMB * 1024 * 1024, // Convert MB to bytes
+		BaseStorageQuota: s.cfg.DefaultStorageQuotaMB * 1024 * 1024,
+		StorageUsed:      0,
+		FileCountQuota:   s.cfg.DefaultFileCountQuota,
+		CreatedAt:        time.Now().UTC(),
+		UpdatedAt:        time.Now().UTC(),
+
+		BandwidthQuotaBytes:  s.cfg.DefaultBandwidthQuotaMB * 1024 * 1024,
+		BandwidthPeriodStart: currentBandwidthPeriod(),
+	}
+
+	if s.cfg.TrialEnabled {
+		trialExpiresAt := time.Now().UTC().Add(trialQuotaDuration)
+		user.StorageQuota = s.cfg.TrialStorageQuotaMB * 1024 * 1024
+		user.TrialQuotaExpiresAt = &trialExpiresAt
+	}
+	return user
 }
 
 // GetUser retrieves user by ID
@@ -63,39 +121,74 @@ func (s *UserService) GetUser(userID string) (*models.User, error) {
 	return &user, nil
 }
 
-// UpdateUserRole updates user role (admin function)
-func (s *UserService) UpdateUserRole(userID string, role models.UserRole) error {
-	err := s.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
-	if err != nil {
-		return fmt.Errorf("failed to update user role: %w", err)
+// GetUserRole returns the user's role from the user cache or the database. Users
+// without a row yet (first request after sign-up) get the default role.
+func (s *UserService) GetUserRole(userID string) (models.UserRole, error) {
+	user, err := s.users.getOrLoad(userID, func() (models.User, error) {
+		var user models.User
+		err := s.db.Where("id = ?", userID).First(&user).Error
+		return user, err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.UserRoleUser, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get user role: %w", err)
 	}
-	return nil
+	return user.Role, nil
 }
 
-// UpdateStorageUsed updates user's storage usage
-func (s *UserService) UpdateStorageUsed(userID string, sizeDelta int64) error {
-	err := s.db.Model(&models.User{}).Where("id = ?", userID).
-		Update("storage_used", gorm.Expr("storage_used + ?", sizeDelta)).Error
+// UpdateUserRole updates user role (admin function). actorRole is the role of the admin
+// making the change; only super admins may grant the super role or change a super
+// admin's role.
+func (s *UserService) UpdateUserRole(userID string, role, actorRole models.UserRole) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "role").Where("id = ?", userID).First(&user).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserNotFound
+		} else if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		// Only super admins grant the super role or take it away
+		if (role == models.UserRoleSuper || user.Role == models.UserRoleSuper) && actorRole != models.UserRoleSuper {
+			return ErrSuperAdminRequired
+		}
+
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return fmt.Errorf("failed to update user role: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update storage used: %w", err)
+		return err
 	}
+	s.users.delete(userID)
 	return nil
 }
 
-// CheckStorageQuota checks if user has enough quota for additional storage
+// CheckStorageQuota checks if user has enough quota for additional storage. Callers pass
+// what an upload adds to the user's usage under the quota mode.
 func (s *UserService) CheckStorageQuota(userID string, additionalSize int64) error {
 	var user models.User
-	err := s.db.Select("storage_quota", "storage_used").Where("id = ?", userID).First(&user).Error
+	err := s.db.Select("storage_quota", "storage_used", "overage_started_at", "overage_blocked").Where("id = ?", userID).First(&user).Error
 	if err != nil {
 		return fmt.Errorf("failed to get user storage info: %w", err)
 	}
 
-	if user.StorageUsed+additionalSize > user.StorageQuota {
-		return fmt.Errorf("storage quota exceeded: have %d bytes, need %d bytes, quota is %d bytes",
-			user.StorageUsed, additionalSize, user.StorageQuota)
+	if user.StorageUsed+additionalSize <= user.StorageQuota {
+		return nil
 	}
 
-	return nil
+	// Allow going slightly over quota for a while so users aren't blocked right
+	// before cleaning up. The overage worker starts the grace period once the upload
+	// has been charged.
+	if s.withinOverageGrace(user, additionalSize) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: have %d bytes, need %d bytes, quota is %d bytes",
+		ErrStorageQuotaExceeded, user.StorageUsed, additionalSize, user.StorageQuota)
 }
 
 // GetUserStorageInfo returns user's storage usage and quota
@@ -108,8 +201,197 @@ func (s *UserService) GetUserStorageInfo(userID string) (used, quota int64, err
 	return user.StorageUsed, user.StorageQuota, nil
 }
 
+// currentBandwidthPeriod returns the start of the current monthly bandwidth period (UTC)
+func currentBandwidthPeriod() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// CheckBandwidthQuota checks if serving a file of the given size fits in the user's monthly bandwidth
+func (s *UserService) CheckBandwidthQuota(userID string, fileSize int64) error {
+	used, quota, err := s.GetBandwidthInfo(userID)
+	if err != nil {
+		return err
+	}
+
+	if quota > 0 && used+fileSize > quota {
+		return fmt.Errorf("%w: used %d bytes, need %d bytes, quota is %d bytes",
+			ErrBandwidthQuotaExceeded, used, fileSize, quota)
+	}
+
+	return nil
+}
+
+// GetBandwidthInfo returns the user's bandwidth usage for the current month and their quota
+func (s *UserService) GetBandwidthInfo(userID string) (used, quota int64, err error) {
+	var user models.User
+	err = s.db.Select("bandwidth_quota_bytes", "bandwidth_used_this_month", "bandwidth_period_start").
+		Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get user bandwidth info: %w", err)
+	}
+
+	// Usage from a previous month doesn't count even if the reset worker hasn't run yet
+	if user.BandwidthPeriodStart.Before(currentBandwidthPeriod()) {
+		return 0, user.BandwidthQuotaBytes, nil
+	}
+	return user.BandwidthUsedThisMonth, user.BandwidthQuotaBytes, nil
+}
+
+// RecordBandwidthUsage adds served bytes to the user's monthly counter and daily bandwidth events
+func (s *UserService) RecordBandwidthUsage(userID string, bytes int64) error {
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	period := currentBandwidthPeriod()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Start a fresh counter if the stored one belongs to a previous month
+		err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"bandwidth_used_this_month": gorm.Expr(
+					"CASE WHEN bandwidth_period_start IS NULL OR bandwidth_period_start < ? THEN ? ELSE bandwidth_used_this_month + ? END",
+					period, bytes, bytes),
+				"bandwidth_period_start": gorm.Expr("GREATEST(COALESCE(bandwidth_period_start, ?), ?)", period, period),
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update bandwidth used: %w", err)
+		}
+
+		event := models.BandwidthEvent{UserID: userID, Date: day, Bytes: bytes}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "date"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"bytes": gorm.Expr("bandwidth_events.bytes + ?", bytes)}),
+		}).Create(&event).Error
+		if err != nil {
+			return fmt.Errorf("failed to record bandwidth event: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// UpdateBandwidthQuota updates user's monthly bandwidth quota (admin function, 0 = unlimited)
+func (s *UserService) UpdateBandwidthQuota(userID string, quotaMB int64) error {
+	quotaBytes := quotaMB * 1024 * 1024
+	err := s.db.Model(&models.User{}).Where("id = ?", userID).Update("bandwidth_quota_bytes", quotaBytes).Error
+	if err != nil {
+		return fmt.Errorf("failed to update bandwidth quota: %w", err)
+	}
+	s.users.delete(userID)
+	return nil
+}
+
+// UpdateRateLimitOverride sets a user's API rate limit, or clears it back to the
+// configured defaults when override is nil
+func (s *UserService) UpdateRateLimitOverride(userID string, override *RateLimitOverride) error {
+	updates := map[string]interface{}{"rate_limit_per_second": nil, "rate_limit_burst_size": nil}
+	if override != nil {
+		updates["rate_limit_per_second"] = override.PerSecond
+		updates["rate_limit_burst_size"] = override.BurstSize
+	}
+
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update rate limit override: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	s.users.delete(userID)
+	return nil
+}
+
+// GetRateLimitOverrides returns every user's rate limit override keyed by user ID
+func (s *UserService) GetRateLimitOverrides() (map[string]RateLimitOverride, error) {
+	var users []models.User
+	err := s.db.Select("id", "rate_limit_per_second", "rate_limit_burst_size").
+		Where("rate_limit_per_second IS NOT NULL AND rate_limit_burst_size IS NOT NULL").
+		Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rate limit overrides: %w", err)
+	}
+
+	overrides := make(map[string]RateLimitOverride, len(users))
+	for _, user := range users {
+		overrides[user.ID] = RateLimitOverride{PerSecond: *user.RateLimitPerSecond, BurstSize: *user.RateLimitBurstSize}
+	}
+	return overrides, nil
+}
+
+// StartBandwidthResetWorker resets monthly bandwidth counters once a new month begins.
+// It checks hourly so a missed tick (e.g. a restart at midnight) is caught up on the next one.
+func (s *UserService) StartBandwidthResetWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			s.resetMonthlyBandwidth()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// StartActivityRetentionWorker prunes activity feed entries older than
+// Config.ActivityRetentionDays once a day
+func (s *UserService) StartActivityRetentionWorker(ctx context.Context) {
+	if s.cfg.ActivityRetentionDays <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			s.pruneActivity()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *UserService) pruneActivity() {
+	cutoff := time.Now().UTC().AddDate(0, 0, -s.cfg.ActivityRetentionDays)
+	result := s.db.Where("created_at < ?", cutoff).Delete(&models.UserActivity{})
+	if result.Error != nil {
+		log.Printf("Failed to prune activity feed: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Pruned %d activity entries older than %d days", result.RowsAffected, s.cfg.ActivityRetentionDays)
+	}
+}
+
+func (s *UserService) resetMonthlyBandwidth() {
+	period := currentBandwidthPeriod()
+	result := s.db.Model(&models.User{}).
+		Where("bandwidth_period_start < ? OR bandwidth_period_start IS NULL", period).
+		Updates(map[string]interface{}{
+			"bandwidth_used_this_month": 0,
+			"bandwidth_period_start":    period,
+		})
+	if result.Error != nil {
+		log.Printf("Failed to reset monthly bandwidth: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Reset monthly bandwidth for %d users", result.RowsAffected)
+	}
+}
+
 // ListUsers returns paginated list of users (admin function)
-func (s *UserService) ListUsers(offset, limit int) ([]models.User, int64, error) {
+func (s *UserService) ListUsers(offset, limit int) ([]UserListEntry, int64, error) {
 	var users []models.User
 	var total int64
 
@@ -124,7 +406,20 @@ func (s *UserService) ListUsers(offset, limit int) ([]models.User, int64, error)
 		return nil, 0, fmt.Errorf("failed to list users: %w", err)
 	}
 
-	return users, total, nil
+	userIDs := make([]string, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	proxied, err := s.proxiedBytesFor(userIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]UserListEntry, len(users))
+	for i, user := range users {
+		entries[i] = UserListEntry{User: user, ProxiedBytesThisMonth: proxied[user.ID]}
+	}
+	return entries, total, nil
 }
 
 // UpdateStorageQuota updates user's storage quota (admin function)
@@ -134,23 +429,84 @@ func (s *UserService) UpdateStorageQuota(userID string, quotaMB int64) error {
 		return fmt.Errorf("storage quota cannot exceed %d MB", s.cfg.MaxStorageQuotaMB)
 	}
 
+	// An admin-set quota replaces any running trial. Usage is compared in the same
+	// statement so an upload finishing meanwhile can't end up over the new quota.
 	quotaBytes := quotaMB * 1024 * 1024
-	err := s.db.Model(&models.User{}).Where("id = ?", userID).Update("storage_quota", quotaBytes).Error
-	if err != nil {
-		return fmt.Errorf("failed to update storage quota: %w", err)
+	result := s.db.Model(&models.User{}).Where("id = ? AND storage_used <= ?", userID, quotaBytes).Updates(map[string]interface{}{
+		"storage_quota":          quotaBytes,
+		"base_storage_quota":     quotaBytes,
+		"trial_quota_expires_at": nil,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update storage quota: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		user, err := s.GetUser(userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %d bytes are in use", ErrQuotaBelowUsage, user.StorageUsed)
 	}
+	s.users.delete(userID)
 	return nil
 }
 
 // DeleteUser soft deletes a user (admin function)
 func (s *UserService) DeleteUser(userID string) error {
-	err := s.db.Where("id = ?", userID).Delete(&models.User{}).Error
-	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+	result := s.db.Where("id = ?", userID).Delete(&models.User{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
 	}
+	s.users.delete(userID)
 	return nil
 }
 
+// ErrInvalidActivityAction is returned when the activity feed is filtered by an unknown action
+var ErrInvalidActivityAction = errors.New("invalid activity action")
+
+var activityActions = map[models.ActivityAction]bool{
+	models.ActivityUpload:           true,
+	models.ActivityDelete:           true,
+	models.ActivityVisibilityChange: true,
+	models.ActivityShare:            true,
+	models.ActivityDownload:         true,
+	models.ActivityQuotaOverage:     true,
+}
+
+// GetUserActivityFeed returns the user's activity feed, newest first. When since is set
+// only entries recorded after it are returned, which lets clients poll incrementally.
+// A non-empty actionFilter limits the feed to one kind of event.
+func (s *UserService) GetUserActivityFeed(userID string, since *time.Time, offset, limit int, actionFilter string) ([]models.UserActivity, int64, error) {
+	query := s.db.Model(&models.UserActivity{}).Where("user_id = ?", userID)
+	if since != nil {
+		query = query.Where("created_at > ?", since.UTC())
+	}
+	if actionFilter != "" {
+		if !activityActions[models.ActivityAction(actionFilter)] {
+			return nil, 0, ErrInvalidActivityAction
+		}
+		query = query.Where("action = ?", actionFilter)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count activity: %w", err)
+	}
+
+	activities := make([]models.UserActivity, 0)
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&activities).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get activity: %w", err)
+	}
+
+	return activities, total, nil
+}
+
 // StorageStatistics represents comprehensive storage statistics for a user
 type StorageStatistics struct {
 	TotalStorage    int64   `json:"total_storage"`    // Deduplicated storage used in bytes
@@ -159,6 +515,15 @@ type StorageStatistics struct {
 	FileCount       int     `json:"file_count"`       // Total number of files owned
 	DuplicateCount  int     `json:"duplicate_count"`  // Number of duplicate files avoided
 	Savings         Savings `json:"savings"`          // Savings from deduplication
+
+	CategoryCounts map[MimeCategory]CategoryCount `json:"category_counts"` // File count and bytes per MIME category
+	Breakdown      []CategoryBreakdown            `json:"breakdown"`       // File count and bytes per top-level MIME type
+}
+
+// CategoryCount summarizes a user's files within one MIME category
+type CategoryCount struct {
+	FileCount int64 `json:"file_count"`
+	Bytes     int64 `json:"bytes"`
 }
 
 type Savings struct {
@@ -260,5 +625,39 @@ func (s *UserService) GetStorageStatistics(userID string) (*StorageStatistics, e
 		stats.Savings.Percentage = 100
 	}
 
+	// Summarize files per MIME category, including empty categories so the UI can render all of them
+	type CategoryResult struct {
+		Category  string
+		FileCount int64
+		Bytes     int64
+	}
+	var categoryResults []CategoryResult
+
+	err = s.db.Model(&models.UserFile{}).
+		Select(mimeCategoryCaseSQL()+" as category, COUNT(*) as file_count, COALESCE(SUM(file_hashes.size), 0) as bytes").
+		Joins("JOIN file_hashes ON user_files.file_hash = file_hashes.hash").
+		Where("user_files.user_id = ?", userID).
+		Group("category").
+		Scan(&categoryResults).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate category counts: %w", err)
+	}
+
+	stats.CategoryCounts = make(map[MimeCategory]CategoryCount, len(MimeCategories))
+	for _, category := range MimeCategories {
+		stats.CategoryCounts[category] = CategoryCount{}
+	}
+	for _, result := range categoryResults {
+		stats.CategoryCounts[MimeCategory(result.Category)] = CategoryCount{
+			FileCount: result.FileCount,
+			Bytes:     result.Bytes,
+		}
+	}
+
+	stats.Breakdown, err = computeStorageBreakdown(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &stats, nil
 }
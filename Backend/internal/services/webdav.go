@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"filevault-backend/internal/models"
+	"filevault-backend/internal/storage"
+
+	"golang.org/x/net/webdav"
+	"gorm.io/gorm"
+)
+
+// WebDAV tokens are shown once when issued; only their SHA-256 is stored
+const webDAVTokenPrefix = "fvdav_"
+
+// ErrInvalidWebDAVToken is returned for tokens that aren't issued to any user
+var ErrInvalidWebDAVToken = errors.New("invalid WebDAV token")
+
+// EnableWebDAV opts the user into WebDAV and issues their access token, replacing any
+// earlier one
+func (s *UserService) EnableWebDAV(userID string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate WebDAV token: %w", err)
+	}
+	token := webDAVTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"webdav_token_hash": hashWebDAVToken(token),
+		"webdav_enabled_at": time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to enable WebDAV: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrUserNotFound
+	}
+	s.users.delete(userID)
+	return token, nil
+}
+
+// DisableWebDAV opts the user out of WebDAV, revoking their token
+func (s *UserService) DisableWebDAV(userID string) error {
+	err := s.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"webdav_token_hash": nil,
+		"webdav_enabled_at": nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to disable WebDAV: %w", err)
+	}
+	s.users.delete(userID)
+	return nil
+}
+
+// AuthenticateWebDAV returns the user a WebDAV token was issued to
+func (s *UserService) AuthenticateWebDAV(token string) (*models.User, error) {
+	if !strings.HasPrefix(token, webDAVTokenPrefix) {
+		return nil, ErrInvalidWebDAVToken
+	}
+
+	var user models.User
+	err := s.db.Select("id", "role").Where("webdav_token_hash = ?", hashWebDAVToken(token)).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidWebDAVToken
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up WebDAV token: %w", err)
+	}
+	return &user, nil
+}
+
+func hashWebDAVToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// WebDAVFileSystem is a read-only view of the user's files for one WebDAV request.
+// Files synced from a folder appear at their relative path and the rest at the top
+// level. When several files share a path the most recently uploaded one is shown.
+func (s *FileService) WebDAVFileSystem(userID string) webdav.FileSystem {
+	return &davFileSystem{s: s, userID: userID, seen: make(map[string]*davEntry)}
+}
+
+type davFileSystem struct {
+	s      *FileService
+	userID string
+	// Entries already looked up by this request, so a PROPFIND doesn't query each
+	// child of a listed folder again
+	seen map[string]*davEntry
+}
+
+// davEntry is a file or folder; file is nil for folders
+type davEntry struct {
+	name    string
+	modTime time.Time
+	file    *models.UserFile
+}
+
+// davPath turns a WebDAV name into a relative path; the root is ""
+func davPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func (fs *davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fs *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (fs *davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (fs *davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	entry, err := fs.lookup(ctx, davPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (fs *davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	p := davPath(name)
+	entry, err := fs.lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{fs: fs, ctx: ctx, path: p, entry: entry}, nil
+}
+
+// lookup finds the file at p, or else the folder
+func (fs *davFileSystem) lookup(ctx context.Context, p string) (*davEntry, error) {
+	if p == "" {
+		return &davEntry{name: "/"}, nil
+	}
+	if entry, ok := fs.seen[p]; ok {
+		return entry, nil
+	}
+
+	var files []models.UserFile
+	err := fs.s.db.WithContext(ctx).Preload("FileData").
+		Where("user_id = ? AND (relative_path = ? OR (COALESCE(relative_path, '') = '' AND filename = ?))", fs.userID, p, p).
+		Order("uploaded_at DESC").
+		Limit(1).
+		Find(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up WebDAV file: %w", err)
+	}
+	if len(files) > 0 {
+		entry := &davEntry{name: path.Base(p), modTime: files[0].UploadedAt, file: &files[0]}
+		fs.seen[p] = entry
+		return entry, nil
+	}
+
+	// A folder exists as long as some file is under it
+	var modified *time.Time
+	err = fs.s.db.WithContext(ctx).Model(&models.UserFile{}).
+		Where("user_id = ? AND relative_path LIKE ?", fs.userID, escapeLikePattern(p)+"/%").
+		Select("MAX(uploaded_at)").
+		Scan(&modified).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up WebDAV folder: %w", err)
+	}
+	if modified == nil {
+		return nil, os.ErrNotExist
+	}
+	entry := &davEntry{name: path.Base(p), modTime: *modified}
+	fs.seen[p] = entry
+	return entry, nil
+}
+
+// list returns the files and subfolders directly inside the folder at p
+func (fs *davFileSystem) list(ctx context.Context, p string) ([]os.FileInfo, error) {
+	db := fs.s.db.WithContext(ctx)
+	files := db.Preload("FileData").Where("user_id = ?", fs.userID)
+	folders := db.Model(&models.UserFile{}).Where("user_id = ?", fs.userID)
+	if p == "" {
+		files = files.Where("COALESCE(relative_path, '') NOT LIKE ?", "%/%")
+		folders = folders.Select("split_part(relative_path, '/', 1) AS name, MAX(uploaded_at) AS mod_time").
+			Where("relative_path LIKE ?", "%/%")
+	} else {
+		prefix := escapeLikePattern(p) + "/"
+		files = files.Where("relative_path LIKE ? AND relative_path NOT LIKE ?", prefix+"%", prefix+"%/%")
+		folders = folders.Select("split_part(substr(relative_path, ?), '/', 1) AS name, MAX(uploaded_at) AS mod_time", len(p)+2).
+			Where("relative_path LIKE ?", prefix+"%/%")
+	}
+
+	var userFiles []models.UserFile
+	if err := files.Order("uploaded_at DESC").Find(&userFiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV files: %w", err)
+	}
+	var subfolders []struct {
+		Name    string
+		ModTime time.Time
+	}
+	if err := folders.Group("1").Scan(&subfolders).Error; err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV folders: %w", err)
+	}
+
+	entries := make([]os.FileInfo, 0, len(userFiles)+len(subfolders))
+	listed := make(map[string]bool, cap(entries))
+	for i := range userFiles {
+		name := userFiles[i].Filename
+		if userFiles[i].RelativePath != "" {
+			name = path.Base(userFiles[i].RelativePath)
+		}
+		if listed[name] {
+			// An older file at the same path, hidden like lookup hides it
+			continue
+		}
+		listed[name] = true
+		entry := &davEntry{name: name, modTime: userFiles[i].UploadedAt, file: &userFiles[i]}
+		fs.seen[path.Join(p, name)] = entry
+		entries = append(entries, entry)
+	}
+	for _, folder := range subfolders {
+		if listed[folder.Name] {
+			continue
+		}
+		listed[folder.Name] = true
+		entry := &davEntry{name: folder.Name, modTime: folder.ModTime}
+		fs.seen[path.Join(p, folder.Name)] = entry
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (e *davEntry) Name() string       { return e.name }
+func (e *davEntry) ModTime() time.Time { return e.modTime }
+func (e *davEntry) IsDir() bool        { return e.file == nil }
+func (e *davEntry) Sys() any           { return nil }
+
+func (e *davEntry) Size() int64 {
+	if e.file == nil {
+		return 0
+	}
+	return e.file.FileData.Size
+}
+
+func (e *davEntry) Mode() os.FileMode {
+	if e.file == nil {
+		return os.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// ETag is the content hash, the same ETag the raw and share routes send
+func (e *davEntry) ETag(ctx context.Context) (string, error) {
+	if e.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + e.file.FileHash + `"`, nil
+}
+
+func (e *davEntry) ContentType(ctx context.Context) (string, error) {
+	if e.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return storage.ContentType(e.file.FileData.MimeType, e.name), nil
+}
+
+// davFile reads a file from storage from wherever the reader last seeked to, so ranged
+// requests only fetch the bytes they serve
+type davFile struct {
+	fs    *davFileSystem
+	ctx   context.Context
+	path  string
+	entry *davEntry
+
+	offset  int64
+	content io.ReadCloser
+	served  int64
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return f.entry, nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.entry.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	// The WebDAV handler always reads a folder in one call
+	return f.fs.list(f.ctx, f.path)
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.entry.IsDir() {
+		return 0, os.ErrInvalid
+	}
+	size := f.entry.Size()
+	if f.offset >= size {
+		return 0, io.EOF
+	}
+
+	if f.content == nil {
+		content, err := f.fs.s.storage.GetObjectRange(f.ctx, f.fs.s.resolveObjectKey(f.ctx, f.entry.file.FileData), f.offset, size-f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.content = content
+	}
+	n, err := f.content.Read(p)
+	f.offset += int64(n)
+	f.served += int64(n)
+	return n, err
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = f.offset + offset
+	case io.SeekEnd:
+		target = f.entry.Size() + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if target < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if target != f.offset && f.content != nil {
+		f.content.Close()
+		f.content = nil
+	}
+	f.offset = target
+	return target, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// Close counts the bytes read against the owner's bandwidth, like other streamed reads
+func (f *davFile) Close() error {
+	if f.content != nil {
+		f.content.Close()
+		f.content = nil
+	}
+	if f.served > 0 {
+		f.fs.s.recordBandwidth(f.fs.userID, f.served)
+		f.fs.s.RecordProxiedBytes(f.fs.userID, f.served)
+		go f.fs.s.touchFile(f.entry.file.ID)
+		f.served = 0
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"filevault-backend/internal/models"
+)
+
+func TestWebDAVFileReadsOnlyTheRequestedRange(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	minioStorage, store := newFakeStorage(t, map[string][]byte{"files/ab/abcdef": content})
+	s := &FileService{storage: minioStorage}
+
+	file := &davFile{
+		fs:  &davFileSystem{s: s, userID: "user-a"},
+		ctx: context.Background(),
+		entry: &davEntry{
+			name: "notes.txt",
+			file: &models.UserFile{FileData: models.FileHash{Size: int64(len(content)), MinIOKey: "files/ab/abcdef"}},
+		},
+	}
+
+	if _, err := file.Seek(10, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(file, got); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != "abcde" {
+		t.Errorf("Read() after Seek(10) = %q, want %q", got, "abcde")
+	}
+
+	// Seeking back drops the open stream and starts a new ranged read
+	if _, err := file.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	tail, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(tail) != "hij" {
+		t.Errorf("Read() after Seek(-3, end) = %q, want %q", tail, "hij")
+	}
+	file.content.Close()
+
+	if want := []string{"bytes=10-19", "bytes=17-19"}; !reflect.DeepEqual(store.Ranges(), want) {
+		t.Errorf("storage reads = %v, want %v", store.Ranges(), want)
+	}
+}
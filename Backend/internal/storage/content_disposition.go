@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ContentDisposition builds a Content-Disposition value of the given type ("attachment"
+// or "inline") for filename. Non-ASCII names are sent RFC 5987 encoded in filename*,
+// with an ASCII approximation in filename for clients that don't understand it.
+func ContentDisposition(dispositionType, filename string) string {
+	if filename == "" {
+		return dispositionType
+	}
+
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteRune('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			ascii = false
+			fallback.WriteRune('_')
+		case r > 0x7e:
+			ascii = false
+			fallback.WriteRune('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf(`%s; filename="%s"`, dispositionType, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes everything outside the attr-char set
+func encodeRFC5987(value string) string {
+	const attrChars = "!#$&+-.^_`|~"
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
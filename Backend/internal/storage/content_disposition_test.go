@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestContentDispositionRoundTrips(t *testing.T) {
+	for _, filename := range []string{
+		"report.pdf",
+		"quarterly report.pdf",
+		"invoice, march.pdf",
+		`the "final" draft.docx`,
+		`back\slash.txt`,
+		"会议记录.txt",
+		"résumé 2024.pdf",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			value := ContentDisposition("attachment", filename)
+
+			dispositionType, params, err := mime.ParseMediaType(value)
+			if err != nil {
+				t.Fatalf("ContentDisposition() = %q doesn't parse: %v", value, err)
+			}
+			if dispositionType != "attachment" {
+				t.Errorf("disposition type = %q, want attachment", dispositionType)
+			}
+			// mime prefers filename* when present, as browsers do
+			if params["filename"] != filename {
+				t.Errorf("ContentDisposition() = %q parses to filename %q, want %q", value, params["filename"], filename)
+			}
+		})
+	}
+}
+
+func TestContentDispositionASCIIFallback(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		want     string
+	}{
+		{"report.pdf", `inline; filename="report.pdf"`},
+		{"a, b.txt", `inline; filename="a, b.txt"`},
+		{`say "hi".txt`, `inline; filename="say \"hi\".txt"`},
+		{"会议.txt", `inline; filename="__.txt"; filename*=UTF-8''%E4%BC%9A%E8%AE%AE.txt`},
+		{"line\nbreak.txt", `inline; filename="line_break.txt"; filename*=UTF-8''line%0Abreak.txt`},
+		{"", "inline"},
+	} {
+		if got := ContentDisposition("inline", tc.filename); got != tc.want {
+			t.Errorf("ContentDisposition(%q) = %q, want %q", tc.filename, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// genericContentType is what browsers and most upload clients send when they don't
+// know a file's type
+const genericContentType = "application/octet-stream"
+
+// Types for common extensions that the system MIME table often lacks, checked first so
+// downloads get the same type on every host
+var extensionContentTypes = map[string]string{
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".rtf":  "application/rtf",
+	".csv":  "text/csv; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".zip":  "application/zip",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".heic": "image/heic",
+}
+
+// ContentType returns the type to serve a file with. The stored type wins unless it is
+// missing or generic, in which case one is derived from the filename's extension.
+func ContentType(storedType, filename string) string {
+	if storedType != "" && !strings.EqualFold(storedType, genericContentType) {
+		return storedType
+	}
+
+	ext := strings.ToLower(path.Ext(filename))
+	if contentType, ok := extensionContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); ext != "" && contentType != "" {
+		return contentType
+	}
+	return genericContentType
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "testing"
+
+func TestContentType(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		storedType string
+		filename   string
+		want       string
+	}{
+		{"empty type from extension", "", "notes.txt", "text/plain; charset=utf-8"},
+		{"empty type without extension", "", "README", "application/octet-stream"},
+		{"empty type with unknown extension", "", "data.unknownext", "application/octet-stream"},
+		{"octet-stream from extension", "application/octet-stream", "clip.mp4", "video/mp4"},
+		{"octet-stream in any case", "Application/Octet-Stream", "song.mp3", "audio/mpeg"},
+		{"octet-stream without extension", "application/octet-stream", "blob", "application/octet-stream"},
+		{"word document", "", "report.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"spreadsheet", "application/octet-stream", "budget.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"presentation", "", "slides.pptx", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+		{"legacy office", "", "old.doc", "application/msword"},
+		{"uppercase extension", "", "SCAN.PDF", "application/pdf"},
+		{"stored type wins", "image/png", "photo.jpg", "image/png"},
+		{"stored type wins over office extension", "text/plain", "report.docx", "text/plain"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ContentType(tc.storedType, tc.filename); got != tc.want {
+				t.Errorf("ContentType(%q, %q) = %q, want %q", tc.storedType, tc.filename, got, tc.want)
+			}
+		})
+	}
+}
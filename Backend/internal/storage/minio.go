@@ -2,57 +2,98 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"filevault-backend/internal/config"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/notification"
 	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
+const (
+	stagingLifecycleRuleID = "filevault-staging-expiry"
+	stagingExpiryDays      = 1
+)
+
+// MinIOStorage keeps final content in bucket and in-progress uploads under
+// stagingPrefix, which lives in stagingBucket. Every method routes a key to the
+// right bucket by its prefix, so callers only ever deal in object keys.
 type MinIOStorage struct {
-	client   *minio.Client
-	bucket   string
-	useSSL   bool
-	endpoint string
+	client        *minio.Client
+	bucket        string
+	stagingBucket string
+	stagingPrefix string
+	region        string
+	cdnBaseURL    string
 }
 
 func NewMinIOStorage(cfg *config.Config) (*MinIOStorage, error) {
 	// Initialize MinIO client
+	bucketLookup := minio.BucketLookupAuto
+	if cfg.MinIOPathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
 	client, err := minio.New(cfg.MinIOEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.MinIOAccessKey, cfg.MinIOSecretKey, ""),
-		Secure: cfg.MinIOUseSSL,
+		Creds:        credentials.NewStaticV4(cfg.MinIOAccessKey, cfg.MinIOSecretKey, ""),
+		Secure:       cfg.MinIOUseSSL,
+		Region:       cfg.MinIORegion,
+		BucketLookup: bucketLookup,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
+	stagingBucket := cfg.StagingBucket
+	if stagingBucket == "" {
+		stagingBucket = cfg.MinIOBucket
+	}
+
 	storage := &MinIOStorage{
-		client:   client,
-		bucket:   cfg.MinIOBucket,
-		useSSL:   cfg.MinIOUseSSL,
-		endpoint: cfg.MinIOEndpoint,
+		client:        client,
+		bucket:        cfg.MinIOBucket,
+		stagingBucket: stagingBucket,
+		stagingPrefix: cfg.StagingPrefix,
+		region:        cfg.MinIORegion,
+		cdnBaseURL:    strings.TrimSuffix(cfg.CDNBaseURL, "/"),
 	}
 
 	// Ensure bucket exists
-	if err := storage.ensureBucket(context.Background()); err != nil {
+	ctx := context.Background()
+	if err := storage.ensureBucket(ctx, storage.bucket); err != nil {
 		return nil, fmt.Errorf("failed to ensure bucket exists: %w", err)
 	}
+	if err := storage.ensureBucket(ctx, storage.stagingBucket); err != nil {
+		return nil, fmt.Errorf("failed to ensure staging bucket exists: %w", err)
+	}
+
+	// The expiry rule is a backstop for the in-process sweepers; not every
+	// S3-compatible backend supports lifecycle rules, so failure isn't fatal
+	if err := storage.ensureStagingLifecycle(ctx); err != nil {
+		log.Printf("Warning: failed to configure staging expiry rule: %v", err)
+	}
 
 	return storage, nil
 }
 
-func (m *MinIOStorage) ensureBucket(ctx context.Context) error {
-	exists, err := m.client.BucketExists(ctx, m.bucket)
+func (m *MinIOStorage) ensureBucket(ctx context.Context, bucket string) error {
+	exists, err := m.client.BucketExists(ctx, bucket)
 	if err != nil {
 		return fmt.Errorf("failed to check if bucket exists: %w", err)
 	}
 
 	if !exists {
-		err = m.client.MakeBucket(ctx, m.bucket, minio.MakeBucketOptions{})
+		err = m.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: m.region})
 		if err != nil {
 			return fmt.Errorf("failed to create bucket: %w", err)
 		}
@@ -61,9 +102,101 @@ func (m *MinIOStorage) ensureBucket(ctx context.Context) error {
 	return nil
 }
 
+// ensureStagingLifecycle expires staged uploads after a day. The bucket may be
+// shared with final content, so existing rules are kept and only ours is replaced.
+func (m *MinIOStorage) ensureStagingLifecycle(ctx context.Context) error {
+	config, err := m.client.GetBucketLifecycle(ctx, m.stagingBucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("failed to get bucket lifecycle: %w", err)
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	rules := make([]lifecycle.Rule, 0, len(config.Rules)+1)
+	for _, rule := range config.Rules {
+		if rule.ID != stagingLifecycleRuleID {
+			rules = append(rules, rule)
+		}
+	}
+	rules = append(rules, lifecycle.Rule{
+		ID:         stagingLifecycleRuleID,
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: m.stagingPrefix},
+		Expiration: lifecycle.Expiration{Days: stagingExpiryDays},
+		AbortIncompleteMultipartUpload: lifecycle.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: stagingExpiryDays,
+		},
+	})
+	config.Rules = rules
+
+	if err := m.client.SetBucketLifecycle(ctx, m.stagingBucket, config); err != nil {
+		return fmt.Errorf("failed to set bucket lifecycle: %w", err)
+	}
+
+	return nil
+}
+
+// EnableUploadNotifications subscribes the webhook target arn to object-created events
+// in the staging area. Other notification targets on the bucket are left alone.
+func (m *MinIOStorage) EnableUploadNotifications(ctx context.Context, arn string) error {
+	targetArn, err := notification.NewArnFromString(arn)
+	if err != nil {
+		return fmt.Errorf("invalid notification target ARN: %w", err)
+	}
+
+	config, err := m.client.GetBucketNotification(ctx, m.stagingBucket)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket notification config: %w", err)
+	}
+
+	queue := notification.NewConfig(targetArn)
+	queue.AddEvents(notification.ObjectCreatedAll)
+	queue.AddFilterPrefix(m.stagingPrefix)
+
+	config.RemoveQueueByArn(targetArn)
+	config.AddQueue(queue)
+
+	if err := m.client.SetBucketNotification(ctx, m.stagingBucket, config); err != nil {
+		return fmt.Errorf("failed to set bucket notification config: %w", err)
+	}
+
+	return nil
+}
+
+// Ping checks that storage is reachable and the content bucket exists
+func (m *MinIOStorage) Ping(ctx context.Context) error {
+	exists, err := m.client.BucketExists(ctx, m.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach storage: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", m.bucket)
+	}
+	return nil
+}
+
+// StagingKey builds a key in the staging area from path segments, e.g.
+// StagingKey(userID, uploadID). StagingKey(userID, "") is the user's staging prefix.
+func (m *MinIOStorage) StagingKey(parts ...string) string {
+	return m.stagingPrefix + strings.Join(parts, "/")
+}
+
+// IsStagingKey reports whether objectKey is an in-progress upload
+func (m *MinIOStorage) IsStagingKey(objectKey string) bool {
+	return strings.HasPrefix(objectKey, m.stagingPrefix)
+}
+
+func (m *MinIOStorage) bucketFor(objectKey string) string {
+	if m.IsStagingKey(objectKey) {
+		return m.stagingBucket
+	}
+	return m.bucket
+}
+
 // UploadFile uploads a file to MinIO and returns the object key
 func (m *MinIOStorage) UploadFile(ctx context.Context, objectKey string, reader io.Reader, size int64, contentType string) error {
-	_, err := m.client.PutObject(ctx, m.bucket, objectKey, reader, size, minio.PutObjectOptions{
+	_, err := m.client.PutObject(ctx, m.bucketFor(objectKey), objectKey, reader, size, minio.PutObjectOptions{
 		ContentType: contentType,
 	})
 	if err != nil {
@@ -73,19 +206,54 @@ func (m *MinIOStorage) UploadFile(ctx context.Context, objectKey string, reader
 	return nil
 }
 
+// DownloadHeaders override the headers storage answers a presigned download with.
+// Objects are keyed by hash, so without them browsers save files under the hash.
+type DownloadHeaders struct {
+	ContentDisposition string
+	ContentType        string
+}
+
 // GetFileURL generates a presigned URL for file download
-func (m *MinIOStorage) GetFileURL(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
-	url, err := m.client.PresignedGetObject(ctx, m.bucket, objectKey, expiry, nil)
+func (m *MinIOStorage) GetFileURL(ctx context.Context, objectKey string, expiry time.Duration, headers DownloadHeaders) (string, error) {
+	reqParams := url.Values{}
+	if headers.ContentDisposition != "" {
+		reqParams.Set("response-content-disposition", headers.ContentDisposition)
+	}
+	if headers.ContentType != "" {
+		reqParams.Set("response-content-type", headers.ContentType)
+	}
+
+	presigned, err := m.client.PresignedGetObject(ctx, m.bucketFor(objectKey), objectKey, expiry, reqParams)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
 	}
 
-	return url.String(), nil
+	return presigned.String(), nil
 }
 
-// GetUploadURL generates a presigned URL for file upload
+// GetCDNFileURL presigns a download like GetFileURL and serves it from the CDN base URL.
+// The CDN forwards the signed query string to the bucket, so the URL expires like any
+// presigned URL rather than being a permanent link to the object.
+func (m *MinIOStorage) GetCDNFileURL(ctx context.Context, objectKey string, expiry time.Duration, headers DownloadHeaders) (string, error) {
+	presigned, err := m.GetFileURL(ctx, objectKey, expiry, headers)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := url.Parse(presigned)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse presigned URL: %w", err)
+	}
+	return fmt.Sprintf("%s/%s?%s", m.cdnBaseURL, objectKey, parsed.RawQuery), nil
+}
+
+// GetUploadURL generates a presigned URL for file upload. Clients only ever write to
+// the staging area; content reaches its final key through a server-side copy.
 func (m *MinIOStorage) GetUploadURL(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
-	url, err := m.client.PresignedPutObject(ctx, m.bucket, objectKey, expiry)
+	if !m.IsStagingKey(objectKey) {
+		return "", fmt.Errorf("upload URLs must target the staging area, got %q", objectKey)
+	}
+
+	url, err := m.client.PresignedPutObject(ctx, m.stagingBucket, objectKey, expiry)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned upload URL: %w", err)
 	}
@@ -93,9 +261,114 @@ func (m *MinIOStorage) GetUploadURL(ctx context.Context, objectKey string, expir
 	return url.String(), nil
 }
 
+// NewMultipartUpload starts a multipart upload to a staging key and returns its upload ID
+func (m *MinIOStorage) NewMultipartUpload(ctx context.Context, objectKey, contentType string) (string, error) {
+	if !m.IsStagingKey(objectKey) {
+		return "", fmt.Errorf("multipart uploads must target the staging area, got %q", objectKey)
+	}
+
+	core := minio.Core{Client: m.client}
+	uploadID, err := core.NewMultipartUpload(ctx, m.stagingBucket, objectKey, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	return uploadID, nil
+}
+
+// PresignPartUpload generates a presigned URL for uploading one part of a multipart upload
+func (m *MinIOStorage) PresignPartUpload(ctx context.Context, objectKey, uploadID string, partNumber int, expiry time.Duration) (string, error) {
+	reqParams := url.Values{}
+	reqParams.Set("partNumber", strconv.Itoa(partNumber))
+	reqParams.Set("uploadId", uploadID)
+
+	presigned, err := m.client.Presign(ctx, http.MethodPut, m.stagingBucket, objectKey, expiry, reqParams)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned part URL: %w", err)
+	}
+	return presigned.String(), nil
+}
+
+// ListUploadedParts returns every part storage has received for a multipart upload,
+// in part number order
+func (m *MinIOStorage) ListUploadedParts(ctx context.Context, objectKey, uploadID string) ([]minio.ObjectPart, error) {
+	core := minio.Core{Client: m.client}
+
+	var parts []minio.ObjectPart
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, m.stagingBucket, objectKey, uploadID, marker, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list uploaded parts: %w", err)
+		}
+		parts = append(parts, result.ObjectParts...)
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the staged object
+func (m *MinIOStorage) CompleteMultipartUpload(ctx context.Context, objectKey, uploadID string, parts []minio.ObjectPart) error {
+	completeParts := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		completeParts[i] = minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag}
+	}
+
+	core := minio.Core{Client: m.client}
+	if _, err := core.CompleteMultipartUpload(ctx, m.stagingBucket, objectKey, uploadID, completeParts, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards the parts of an unfinished multipart upload
+func (m *MinIOStorage) AbortMultipartUpload(ctx context.Context, objectKey, uploadID string) error {
+	core := minio.Core{Client: m.client}
+	if err := core.AbortMultipartUpload(ctx, m.stagingBucket, objectKey, uploadID); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// GetPresignedPostPolicy builds a POST policy for a browser form upload of a single
+// object no larger than maxSize. Callers may add further conditions before presigning.
+func (m *MinIOStorage) GetPresignedPostPolicy(ctx context.Context, objectKey string, maxSize int64, expiry time.Duration) (*minio.PostPolicy, error) {
+	if !m.IsStagingKey(objectKey) {
+		return nil, fmt.Errorf("upload policies must target the staging area, got %q", objectKey)
+	}
+
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(m.stagingBucket); err != nil {
+		return nil, fmt.Errorf("failed to set policy bucket: %w", err)
+	}
+	if err := policy.SetKey(objectKey); err != nil {
+		return nil, fmt.Errorf("failed to set policy key: %w", err)
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expiry)); err != nil {
+		return nil, fmt.Errorf("failed to set policy expiry: %w", err)
+	}
+	if err := policy.SetContentLengthRange(0, maxSize); err != nil {
+		return nil, fmt.Errorf("failed to set policy size limit: %w", err)
+	}
+
+	return policy, nil
+}
+
+// PresignPostPolicy signs a POST policy and returns the form action URL with the
+// fields the form must include
+func (m *MinIOStorage) PresignPostPolicy(ctx context.Context, policy *minio.PostPolicy) (string, map[string]string, error) {
+	url, fields, err := m.client.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to presign post policy: %w", err)
+	}
+
+	return url.String(), fields, nil
+}
+
 // DeleteFile deletes a file from MinIO
 func (m *MinIOStorage) DeleteFile(ctx context.Context, objectKey string) error {
-	err := m.client.RemoveObject(ctx, m.bucket, objectKey, minio.RemoveObjectOptions{})
+	err := m.client.RemoveObject(ctx, m.bucketFor(objectKey), objectKey, minio.RemoveObjectOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
@@ -103,9 +376,123 @@ func (m *MinIOStorage) DeleteFile(ctx context.Context, objectKey string) error {
 	return nil
 }
 
+// GetObject opens an object for streaming reads; the caller must close it
+func (m *MinIOStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	object, err := m.client.GetObject(ctx, m.bucketFor(objectKey), objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	return object, nil
+}
+
+// GetObjectRange streams length bytes of an object starting at offset
+func (m *MinIOStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	// An empty range can't be expressed as a Range header
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("invalid object range: %w", err)
+	}
+	object, err := m.client.GetObject(ctx, m.bucketFor(objectKey), objectKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+
+	return object, nil
+}
+
+// IsObjectNotFound reports whether err, possibly wrapped, means the object doesn't exist.
+// GetObject is lazy, so this error usually surfaces from the first read.
+func IsObjectNotFound(err error) bool {
+	var response minio.ErrorResponse
+	return errors.As(err, &response) && response.Code == "NoSuchKey"
+}
+
+// CopyObject copies an object server-side, across buckets when one side is staged.
+// ComposeObject is used so sources larger than the 5GB single-copy limit are copied in parts.
+func (m *MinIOStorage) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	srcBucket, dstBucket := m.bucketFor(srcKey), m.bucketFor(dstKey)
+	_, err := m.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: dstBucket, Object: dstKey},
+		minio.CopySrcOptions{Bucket: srcBucket, Object: srcKey},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	// Tags drive public access, so they must follow the object
+	objectTags, err := m.client.GetObjectTagging(ctx, srcBucket, srcKey, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read object tags: %w", err)
+	}
+	if len(objectTags.ToMap()) > 0 {
+		if err := m.client.PutObjectTagging(ctx, dstBucket, dstKey, objectTags, minio.PutObjectTaggingOptions{}); err != nil {
+			return fmt.Errorf("failed to copy object tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// MoveObject copies an object server-side and then removes the source. If the
+// removal fails the copy is kept, so the content is never lost.
+func (m *MinIOStorage) MoveObject(ctx context.Context, srcKey, dstKey string) error {
+	if err := m.CopyObject(ctx, srcKey, dstKey); err != nil {
+		return err
+	}
+
+	if err := m.client.RemoveObject(ctx, m.bucketFor(srcKey), srcKey, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove source object after copy: %w", err)
+	}
+
+	return nil
+}
+
+// TransitionObject rewrites an object in place under another storage class, keeping
+// its content type, metadata and tags
+func (m *MinIOStorage) TransitionObject(ctx context.Context, objectKey, storageClass string) error {
+	bucket := m.bucketFor(objectKey)
+	info, err := m.client.StatObject(ctx, bucket, objectKey, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	objectTags, err := m.client.GetObjectTagging(ctx, bucket, objectKey, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read object tags: %w", err)
+	}
+
+	metadata := map[string]string{
+		"Content-Type":        info.ContentType,
+		"X-Amz-Storage-Class": storageClass,
+	}
+	for key, value := range info.UserMetadata {
+		metadata[key] = value
+	}
+
+	_, err = m.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: objectKey, UserMetadata: metadata, ReplaceMetadata: true},
+		minio.CopySrcOptions{Bucket: bucket, Object: objectKey},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to transition object: %w", err)
+	}
+
+	if len(objectTags.ToMap()) > 0 {
+		if err := m.client.PutObjectTagging(ctx, bucket, objectKey, objectTags, minio.PutObjectTaggingOptions{}); err != nil {
+			return fmt.Errorf("failed to restore object tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // GetFileInfo returns information about a file
 func (m *MinIOStorage) GetFileInfo(ctx context.Context, objectKey string) (*minio.ObjectInfo, error) {
-	info, err := m.client.StatObject(ctx, m.bucket, objectKey, minio.StatObjectOptions{})
+	info, err := m.client.StatObject(ctx, m.bucketFor(objectKey), objectKey, minio.StatObjectOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
@@ -113,9 +500,27 @@ func (m *MinIOStorage) GetFileInfo(ctx context.Context, objectKey string) (*mini
 	return &info, nil
 }
 
-// ListFiles lists files with a prefix
+// ListFiles lists files with a prefix in the content bucket. Everything is held in
+// memory, so use ListFilesWithCallback for prefixes that may hold many objects.
 func (m *MinIOStorage) ListFiles(ctx context.Context, prefix string) ([]minio.ObjectInfo, error) {
 	var objects []minio.ObjectInfo
+	err := m.ListFilesWithCallback(ctx, prefix, func(object minio.ObjectInfo) error {
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ListFilesWithCallback calls callback for each file with a prefix in the content bucket
+// as the listing is paged in, without holding them all. Listing stops at the first
+// error callback returns, which is passed back.
+func (m *MinIOStorage) ListFilesWithCallback(ctx context.Context, prefix string, callback func(minio.ObjectInfo) error) error {
+	// Cancelling stops the client's listing goroutine when we return early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	objectCh := m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{
 		Prefix:    prefix,
@@ -124,12 +529,27 @@ func (m *MinIOStorage) ListFiles(ctx context.Context, prefix string) ([]minio.Ob
 
 	for object := range objectCh {
 		if object.Err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", object.Err)
+			return fmt.Errorf("failed to list objects: %w", object.Err)
+		}
+		if err := callback(object); err != nil {
+			return err
 		}
-		objects = append(objects, object)
 	}
+	return ctx.Err()
+}
 
-	return objects, nil
+// CountObjects counts the files with a prefix in the content bucket. Listing stops as
+// soon as ctx is done.
+func (m *MinIOStorage) CountObjects(ctx context.Context, prefix string) (int64, error) {
+	var count int64
+	err := m.ListFilesWithCallback(ctx, prefix, func(minio.ObjectInfo) error {
+		count++
+		return ctx.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 // SetObjectTags sets tags on an object
@@ -140,7 +560,7 @@ func (m *MinIOStorage) SetObjectTags(ctx context.Context, objectKey string, tagM
 		return fmt.Errorf("failed to create tags: %w", err)
 	}
 
-	err = m.client.PutObjectTagging(ctx, m.bucket, objectKey, objectTags, minio.PutObjectTaggingOptions{})
+	err = m.client.PutObjectTagging(ctx, m.bucketFor(objectKey), objectKey, objectTags, minio.PutObjectTaggingOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to set object tags: %w", err)
 	}
@@ -149,7 +569,7 @@ func (m *MinIOStorage) SetObjectTags(ctx context.Context, objectKey string, tagM
 
 // GetObjectTags gets tags from an object
 func (m *MinIOStorage) GetObjectTags(ctx context.Context, objectKey string) (map[string]string, error) {
-	tags, err := m.client.GetObjectTagging(ctx, m.bucket, objectKey, minio.GetObjectTaggingOptions{})
+	tags, err := m.client.GetObjectTagging(ctx, m.bucketFor(objectKey), objectKey, minio.GetObjectTaggingOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object tags: %w", err)
 	}
@@ -158,19 +578,9 @@ func (m *MinIOStorage) GetObjectTags(ctx context.Context, objectKey string) (map
 
 // RemoveObjectTags removes all tags from an object
 func (m *MinIOStorage) RemoveObjectTags(ctx context.Context, objectKey string) error {
-	err := m.client.RemoveObjectTagging(ctx, m.bucket, objectKey, minio.RemoveObjectTaggingOptions{})
+	err := m.client.RemoveObjectTagging(ctx, m.bucketFor(objectKey), objectKey, minio.RemoveObjectTaggingOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to remove object tags: %w", err)
 	}
 	return nil
 }
-
-// GetPublicFileURL generates a clean public URL for tagged objects
-func (m *MinIOStorage) GetPublicFileURL(objectKey string) string {
-	// Generate clean public URL without authentication
-	scheme := "http"
-	if m.useSSL {
-		scheme = "https"
-	}
-	return fmt.Sprintf("%s://%s/%s/%s", scheme, m.endpoint, m.bucket, objectKey)
-}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"filevault-backend/internal/config"
+	"filevault-backend/internal/storage/storagetest"
+)
+
+func newTestStorage(t *testing.T, objects map[string][]byte) (*MinIOStorage, *storagetest.Server) {
+	t.Helper()
+
+	server := storagetest.NewServer(t, objects)
+	storage, err := NewMinIOStorage(&config.Config{
+		MinIOEndpoint:  server.Endpoint,
+		MinIOAccessKey: "test",
+		MinIOSecretKey: "test-secret",
+		MinIOBucket:    "files",
+		MinIORegion:    "us-east-1",
+		MinIOPathStyle: true,
+		StagingPrefix:  "staging/",
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to fake storage: %v", err)
+	}
+	return storage, server
+}
+
+func TestCopyObjectKeepsSource(t *testing.T) {
+	content := []byte("staged content")
+	storage, server := newTestStorage(t, map[string][]byte{"staging/user/upload": content})
+	if err := storage.SetObjectTags(context.Background(), "staging/user/upload", map[string]string{"public": "true"}); err != nil {
+		t.Fatalf("SetObjectTags() error = %v", err)
+	}
+
+	if err := storage.CopyObject(context.Background(), "staging/user/upload", "final"); err != nil {
+		t.Fatalf("CopyObject() error = %v", err)
+	}
+
+	if copied, ok := server.Object("final"); !ok || !bytes.Equal(copied, content) {
+		t.Errorf("destination = %q, %v; want the source content", copied, ok)
+	}
+	if server.Tags("final")["public"] != "true" {
+		t.Errorf("destination tags = %v, want the source's tags", server.Tags("final"))
+	}
+	if _, ok := server.Object("staging/user/upload"); !ok {
+		t.Error("source is gone after a copy")
+	}
+}
+
+func TestMoveObjectRemovesSource(t *testing.T) {
+	content := []byte("staged content")
+	storage, server := newTestStorage(t, map[string][]byte{"staging/user/upload": content})
+
+	if err := storage.MoveObject(context.Background(), "staging/user/upload", "final"); err != nil {
+		t.Fatalf("MoveObject() error = %v", err)
+	}
+
+	if moved, ok := server.Object("final"); !ok || !bytes.Equal(moved, content) {
+		t.Errorf("destination = %q, %v; want the source content", moved, ok)
+	}
+	if _, ok := server.Object("staging/user/upload"); ok {
+		t.Error("source still exists after a move")
+	}
+}
+
+func TestMoveObjectOfMissingObjectFails(t *testing.T) {
+	storage, server := newTestStorage(t, nil)
+
+	if err := storage.MoveObject(context.Background(), "staging/user/missing", "final"); err == nil {
+		t.Fatal("MoveObject() of a missing object succeeded")
+	}
+	if _, ok := server.Object("final"); ok {
+		t.Error("destination was created from a missing source")
+	}
+}
@@ -0,0 +1,499 @@
+// Package storagetest provides an in-memory S3 endpoint for testing code that talks
+// to object storage. It implements the requests MinIOStorage makes: object reads,
+// writes, copies and deletes, tagging and multipart uploads. Objects are keyed by
+// object key alone; bucket names are accepted and ignored.
+package storagetest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every object was last modified at the Unix epoch
+var lastModified = time.Unix(0, 0).UTC().Format("2006-01-02T15:04:05.000Z")
+
+type object struct {
+	content []byte
+	etag    string
+	tags    map[string]string
+}
+
+// Server is a fake S3 endpoint serving objects from memory
+type Server struct {
+	// Endpoint is the server's host and port, as storage configuration expects it
+	Endpoint string
+
+	// BeforeRequest, when set, is called with every object request before it's served
+	BeforeRequest func(r *http.Request)
+
+	mu      sync.Mutex
+	objects map[string]*object
+	uploads map[string]map[int]*object
+	// Range headers of object reads, in order
+	ranges []string
+	// When set, object requests hang until the client gives up
+	stall bool
+}
+
+// NewServer starts a fake S3 endpoint holding objects, which is closed when the test ends
+func NewServer(t testing.TB, objects map[string][]byte) *Server {
+	t.Helper()
+
+	s := &Server{objects: map[string]*object{}, uploads: map[string]map[int]*object{}}
+	for key, content := range objects {
+		s.PutObject(key, content)
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	s.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	return s
+}
+
+// PutObject stores content under key, replacing any object already there
+func (s *Server) PutObject(key string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = newObject(content)
+}
+
+// Object returns the content stored under key
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return obj.content, true
+}
+
+// Tags returns the tags of the object stored under key
+func (s *Server) Tags(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.objects[key]; ok {
+		return obj.tags
+	}
+	return nil
+}
+
+// PutPart stores content as one part of a multipart upload, as a client uploading it would
+func (s *Server) PutPart(uploadID string, partNumber int, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parts, ok := s.uploads[uploadID]; ok {
+		parts[partNumber] = newObject(content)
+	}
+}
+
+// HasUpload reports whether a multipart upload is still in progress
+func (s *Server) HasUpload(uploadID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.uploads[uploadID]
+	return ok
+}
+
+// Ranges returns the Range header of every object read so far, in order
+func (s *Server) Ranges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+// SetStall makes object requests hang until the client gives up
+func (s *Server) SetStall(stall bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stall = stall
+}
+
+func newObject(content []byte) *object {
+	sum := md5.Sum(content)
+	return &object{content: content, etag: hex.EncodeToString(sum[:])}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		// Buckets have no lifecycle rules, and checks and configuration succeed without effect
+		if r.Method == http.MethodGet && r.URL.Query().Has("lifecycle") {
+			writeError(w, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if s.BeforeRequest != nil {
+		s.BeforeRequest(r)
+	}
+
+	s.mu.Lock()
+	stall := s.stall
+	s.mu.Unlock()
+	if stall {
+		<-r.Context().Done()
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case query.Has("uploads"):
+		s.startUpload(w, key)
+	case query.Has("uploadId"):
+		s.serveUpload(w, r, bucket, key, query.Get("uploadId"))
+	case query.Has("tagging"):
+		s.serveTags(w, r, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, key)
+	case r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.getObject(w, r, key)
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	if r.Method == http.MethodGet {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	content := obj.content
+	start, end := int64(0), int64(len(content))-1
+	status := http.StatusOK
+	if spec, found := strings.CutPrefix(r.Header.Get("Range"), "bytes="); found {
+		from, to, _ := strings.Cut(spec, "-")
+		start, _ = strconv.ParseInt(from, 10, 64)
+		if to != "" {
+			end, _ = strconv.ParseInt(to, 10, 64)
+		}
+		end = min(end, int64(len(content))-1)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("ETag", `"`+obj.etag+`"`)
+	w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(content[start : end+1])
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	content, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	s.PutObject(key, content)
+	s.mu.Lock()
+	etag := s.objects[key].etag
+	s.mu.Unlock()
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// copySource returns the content named by a copy request's source and range headers
+func (s *Server) copySource(r *http.Request) ([]byte, bool) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return nil, false
+	}
+	_, sourceKey, _ := strings.Cut(source, "/")
+	content, ok := s.Object(sourceKey)
+	if !ok {
+		return nil, false
+	}
+
+	if spec, found := strings.CutPrefix(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes="); found {
+		from, to, _ := strings.Cut(spec, "-")
+		start, _ := strconv.ParseInt(from, 10, 64)
+		end, _ := strconv.ParseInt(to, 10, 64)
+		content = content[start : end+1]
+	}
+	return content, true
+}
+
+type copyResult struct {
+	ETag         string
+	LastModified string
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, key string) {
+	content, ok := s.copySource(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	// Tags are copied by a separate request, as on S3 when a tagging directive isn't given
+	s.PutObject(key, content)
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		copyResult
+	}{copyResult: copyResult{ETag: `"` + newObject(content).etag + `"`, LastModified: lastModified}})
+}
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []tag    `xml:"TagSet>Tag"`
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request, key string) {
+	var body tagging
+	if r.Method == http.MethodPut {
+		if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	if ok {
+		switch r.Method {
+		case http.MethodPut:
+			obj.tags = map[string]string{}
+			for _, t := range body.Tags {
+				obj.tags[t.Key] = t.Value
+			}
+		case http.MethodDelete:
+			obj.tags = nil
+		default:
+			for k, v := range obj.tags {
+				body.Tags = append(body.Tags, tag{Key: k, Value: v})
+			}
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeXML(w, body)
+	}
+}
+
+func (s *Server) startUpload(w http.ResponseWriter, key string) {
+	uploadID := uuid.New().String()
+	s.mu.Lock()
+	s.uploads[uploadID] = map[int]*object{}
+	s.mu.Unlock()
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{Key: key, UploadID: uploadID})
+}
+
+type part struct {
+	PartNumber   int
+	ETag         string
+	LastModified string `xml:",omitempty"`
+	Size         int64  `xml:",omitempty"`
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	s.mu.Lock()
+	_, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.putPart(w, r, uploadID)
+	case http.MethodPost:
+		s.completeUpload(w, r, bucket, key, uploadID)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.uploads, uploadID)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.listParts(w, key, uploadID)
+	}
+}
+
+func (s *Server) putPart(w http.ResponseWriter, r *http.Request, uploadID string) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		content, ok := s.copySource(r)
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		s.PutPart(uploadID, partNumber, content)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			copyResult
+		}{copyResult: copyResult{ETag: `"` + newObject(content).etag + `"`, LastModified: lastModified}})
+		return
+	}
+
+	content, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	s.PutPart(uploadID, partNumber, content)
+	w.Header().Set("ETag", `"`+newObject(content).etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listParts(w http.ResponseWriter, key, uploadID string) {
+	s.mu.Lock()
+	var parts []part
+	for number, p := range s.uploads[uploadID] {
+		parts = append(parts, part{
+			PartNumber:   number,
+			ETag:         `"` + p.etag + `"`,
+			LastModified: lastModified,
+			Size:         int64(len(p.content)),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	writeXML(w, struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Key         string
+		UploadID    string `xml:"UploadId"`
+		MaxParts    int
+		IsTruncated bool
+		Parts       []part `xml:"Part"`
+	}{Key: key, UploadID: uploadID, MaxParts: 1000, Parts: parts})
+}
+
+// completeUpload assembles the listed parts. Like S3 the ETag is the MD5 of the
+// parts' MD5s followed by the part count.
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	var body struct {
+		Parts []part `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	s.mu.Lock()
+	uploaded := s.uploads[uploadID]
+	var content, sums []byte
+	for _, listed := range body.Parts {
+		p, ok := uploaded[listed.PartNumber]
+		if !ok || `"`+p.etag+`"` != listed.ETag {
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		content = append(content, p.content...)
+		sum, _ := hex.DecodeString(p.etag)
+		sums = append(sums, sum...)
+	}
+	sum := md5.Sum(sums)
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(body.Parts))
+	s.objects[key] = &object{content: content, etag: etag}
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: `"` + etag + `"`})
+}
+
+// readBody returns the request's content, decoding the aws-chunked encoding the
+// SDK uses for streaming uploads
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return body, err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(body))
+	var content []byte
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return content, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		content = append(content, chunk[:size]...)
+	}
+}
+
+func writeXML(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
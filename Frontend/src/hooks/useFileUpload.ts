@@ -122,7 +122,9 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
       setBatchId(batchResponse.batch_id)
 
       // Phase 3: Update file statuses based on server response
-      const filesToUpload: { fileEntry: UploadingFile; uploadId: string; presignedUrl: string }[] = []
+      const filesToUpload: { fileEntry: UploadingFile; uploadId: string; uploadToken: string; presignedUrl: string }[] = []
+      // Duplicates need no upload but only become files once completed
+      const filesToLink: { fileEntry: UploadingFile; uploadToken: string }[] = []
       
       for (const responseFile of batchResponse.files) {
         const fileEntry = fileEntries.find(entry => entry.sha256 === responseFile.file_hash)
@@ -130,10 +132,9 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
 
         switch (responseFile.status) {
           case 'duplicate':
-            updateFileStatus(fileEntry.id, { 
-              status: 'duplicate', 
-              existingFile: responseFile.existing_file 
-            })
+            if (responseFile.upload_token) {
+              filesToLink.push({ fileEntry, uploadToken: responseFile.upload_token })
+            }
             break
           
           case 'quota_exceeded':
@@ -144,7 +145,7 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
             break
           
           case 'upload_required':
-            if (responseFile.upload_id && responseFile.presigned_url) {
+            if (responseFile.upload_id && responseFile.upload_token && responseFile.presigned_url) {
               updateFileStatus(fileEntry.id, { 
                 status: 'uploading',
                 uploadId: responseFile.upload_id 
@@ -152,6 +153,7 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
               filesToUpload.push({
                 fileEntry,
                 uploadId: responseFile.upload_id,
+                uploadToken: responseFile.upload_token,
                 presignedUrl: responseFile.presigned_url
               })
             }
@@ -167,29 +169,28 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
       }
 
       // Phase 4: Upload files that need uploading
-      const uploadPromises = filesToUpload.map(async ({ fileEntry, uploadId, presignedUrl }) => {
+      const uploadPromises = filesToUpload.map(async ({ fileEntry, uploadId, uploadToken, presignedUrl }) => {
         try {
           await uploadFileToMinio(presignedUrl, fileEntry.file, (progress) => {
             updateFileStatus(fileEntry.id, { progress })
           })
-          return { fileEntry, uploadId, success: true }
+          return { fileEntry, uploadId, uploadToken, success: true }
         } catch (error: any) {
           updateFileStatus(fileEntry.id, { 
             status: 'failed', 
             error: error.message || 'Upload failed' 
           })
-          return { fileEntry, uploadId, success: false }
+          return { fileEntry, uploadId, uploadToken, success: false }
         }
       })
 
       const uploadResults = await Promise.all(uploadPromises)
 
-      // Phase 5: Complete successful uploads
-      const completedUploads: BatchCompletedUpload[] = uploadResults
-        .filter(result => result.success)
+      // Phase 5: Complete successful uploads and link duplicates
+      const uploadedFiles = [...uploadResults.filter(result => result.success), ...filesToLink]
+      const completedUploads: BatchCompletedUpload[] = uploadedFiles
         .map(result => ({
-          upload_id: result.uploadId,
-          file_hash: result.fileEntry.sha256,
+          upload_token: result.uploadToken,
           filename: result.fileEntry.file.name,
           mime_type: result.fileEntry.file.type || 'application/octet-stream'
         }))
@@ -203,15 +204,21 @@ export const useFileUpload = (maxFileSize: number = 10 * 1024 * 1024) => {
 
         // Update completed files
         for (let i = 0; i < completedUploads.length; i++) {
-          const completedUpload = completedUploads[i]
-          const fileEntry = fileEntries.find(entry => entry.sha256 === completedUpload.file_hash)
+          const fileEntry = uploadedFiles[i].fileEntry
           const completedFile = completeResponse.completed_files[i]
           
           if (fileEntry && completedFile) {
-            updateFileStatus(fileEntry.id, { 
-              status: 'completed', 
-              fileInfo: completedFile 
-            })
+            if (i >= uploadedFiles.length - filesToLink.length) {
+              updateFileStatus(fileEntry.id, {
+                status: 'duplicate',
+                existingFile: completedFile
+              })
+            } else {
+              updateFileStatus(fileEntry.id, { 
+                status: 'completed', 
+                fileInfo: completedFile 
+              })
+            }
           }
         }
       }
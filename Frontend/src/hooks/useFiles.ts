@@ -74,7 +74,7 @@ export const useFiles = (): UseFilesReturn => {
     // Optimistic update - update UI immediately
     setFiles(prev => prev.map(file => 
       file.id === fileId 
-        ? { ...file, isPublic: newVisibility }
+        ? { ...file, isPublic: newVisibility, shareId: undefined }
         : file
     ))
     
@@ -117,6 +117,13 @@ export const useFiles = (): UseFilesReturn => {
     if (!currentFile.isPublic) throw new Error('File is not public')
     
     try {
+      // The file list already carries the share ID once a link exists
+      if (currentFile.shareId) {
+        const shareLink = getFullShareUrl(`/share/${currentFile.shareId}`)
+        await copyToClipboard(shareLink)
+        return shareLink
+      }
+
       // Get share link without toggling visibility
       const response = await apiClient.getShareLink(getToken, fileId)
       
@@ -10,6 +10,7 @@ export interface FileInfo {
   isPublic: boolean
   downloadCount?: number
   sha256?: string
+  shareId?: string
 }
 
 export interface UserFilesResponse {
@@ -62,6 +63,7 @@ export interface BatchFileResponse {
   file_hash: string
   status: 'upload_required' | 'duplicate' | 'quota_exceeded' | 'error'
   upload_id?: string
+  upload_token?: string
   presigned_url?: string
   existing_file?: FileInfo
   error?: string
@@ -78,8 +80,7 @@ export interface BatchPrepareResponse {
 }
 
 export interface BatchCompletedUpload {
-  upload_id: string
-  file_hash: string
+  upload_token: string
   filename: string
   mime_type: string
 }
@@ -133,7 +134,8 @@ export const apiClient = {
         uploadDate: file.uploaded_at,
         isPublic: file.is_public,
         downloadCount: file.download_count || 0,
-        sha256: file.file_hash
+        sha256: file.file_hash,
+        shareId: file.share_id || undefined
       })),
       totalCount: data.total || 0,
       hasMore: data.has_more || false
@@ -166,7 +168,7 @@ export const apiClient = {
 
     const data = await response.json()
     return {
-      uploadId: data.object_key || data.upload_id,
+      uploadId: data.upload_token || data.upload_id,
       presignedUrl: data.upload_url || data.presigned_url,
       isDuplicate: data.is_duplicate || false,
       existingFile: data.existing_file,
@@ -177,20 +179,18 @@ export const apiClient = {
   // Complete file upload
   completeUpload: async (
     getToken: () => Promise<string | null>,
-    objectKey: string,
+    uploadToken: string,
     filename: string,
-    mimeType: string,
-    sha256: string
+    mimeType: string
   ): Promise<CompleteUploadResponse> => {
     const headers = await getAuthHeaders(getToken)
     const response = await fetch(`${API_BASE_URL}/files/complete`, {
       method: 'POST',
       headers,
       body: JSON.stringify({
-        object_key: objectKey,
+        upload_token: uploadToken,
         filename,
         mime_type: mimeType,
-        file_hash: sha256,
       }),
     })
 